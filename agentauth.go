@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/djavorszky/ddn-api/registry"
+)
+
+const (
+	signatureHeader = "X-DDN-Signature"
+	timestampHeader = "X-DDN-Timestamp"
+	signaturePrefix = "sha256="
+
+	// maxSignatureAge is how far the timestamp of a signed request may be
+	// from the server's clock, which limits replaying captured requests.
+	maxSignatureAge = 5 * time.Minute
+
+	// maxAgentBody limits the size of the requests agents send us.
+	maxAgentBody = 1 << 20
+)
+
+// checkEnrollment verifies that a registration request carries the
+// enrollment secret that agents were configured with. Without a secret
+// configured, no agent can register.
+func checkEnrollment(r *http.Request) error {
+	if config.AgentSecret == "" {
+		return fmt.Errorf("no enrollment secret configured")
+	}
+
+	secret, err := bearerValue(r)
+	if err != nil {
+		return err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(config.AgentSecret)) != 1 {
+		return fmt.Errorf("invalid enrollment secret")
+	}
+
+	return nil
+}
+
+// authenticateAgent resolves the agent that sent the request from the
+// agent token it carries, and verifies the signature of the body if there
+// is one, or if signatures are required. The body is read and replaced
+// with an in-memory copy, so it can still be decoded by the handler.
+func authenticateAgent(r *http.Request) (registry.Agent, error) {
+	token, err := bearerValue(r)
+	if err != nil {
+		return registry.Agent{}, err
+	}
+
+	agent, ok := agentByToken(token)
+	if !ok {
+		return registry.Agent{}, fmt.Errorf("unknown agent token")
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxAgentBody+1))
+	if err != nil {
+		return registry.Agent{}, fmt.Errorf("reading body: %v", err)
+	}
+
+	if len(body) > maxAgentBody {
+		return registry.Agent{}, fmt.Errorf("body too large")
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	signature := r.Header.Get(signatureHeader)
+	if signature == "" && !config.AgentRequireSignature {
+		return agent, nil
+	}
+
+	err = verifySignature(token, r.Header.Get(timestampHeader), signature, body, time.Now())
+	if err != nil {
+		return registry.Agent{}, err
+	}
+
+	return agent, nil
+}
+
+// agentByToken returns the registered agent that was issued the token.
+func agentByToken(token string) (registry.Agent, bool) {
+	hash := hashToken(token)
+
+	for _, agent := range registry.List() {
+		if agent.Token == "" {
+			continue
+		}
+
+		if subtle.ConstantTimeCompare([]byte(agent.Token), []byte(hash)) == 1 {
+			return agent, true
+		}
+	}
+
+	return registry.Agent{}, false
+}
+
+// signBody returns the signature of a body sent at the given timestamp,
+// as it is expected in the X-DDN-Signature header.
+func signBody(token, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+func verifySignature(token, timestamp, signature string, body []byte, now time.Time) error {
+	if signature == "" || timestamp == "" {
+		return fmt.Errorf("missing request signature")
+	}
+
+	if !strings.HasPrefix(signature, signaturePrefix) {
+		return fmt.Errorf("unsupported signature %q", signature)
+	}
+
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid signature timestamp %q", timestamp)
+	}
+
+	age := now.Sub(time.Unix(sec, 0))
+	if age > maxSignatureAge || age < -maxSignatureAge {
+		return fmt.Errorf("signature timestamp outside of the allowed window")
+	}
+
+	if !hmac.Equal([]byte(signature), []byte(signBody(token, timestamp, body))) {
+		return fmt.Errorf("invalid request signature")
+	}
+
+	return nil
+}
+
+// bearerValue returns the value of the "Authorization: Bearer" header.
+func bearerValue(r *http.Request) (string, error) {
+	parts := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") || strings.TrimSpace(parts[1]) == "" {
+		return "", fmt.Errorf("missing bearer token")
+	}
+
+	return strings.TrimSpace(parts[1]), nil
+}
+
+// redactAgent removes the secrets of the agent before it's sent to users.
+func redactAgent(agent registry.Agent) registry.Agent {
+	agent.Token = ""
+
+	return agent
+}
+
+func redactAgents(agents []registry.Agent) []registry.Agent {
+	for i := range agents {
+		agents[i] = redactAgent(agents[i])
+	}
+
+	return agents
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/djavorszky/ddn-api/registry"
+	"github.com/djavorszky/ddn-common/model"
+)
+
+func Test_checkEnrollment(t *testing.T) {
+	defer func(orig string) { config.AgentSecret = orig }(config.AgentSecret)
+
+	tests := []struct {
+		name    string
+		secret  string
+		header  string
+		wantErr bool
+	}{
+		{"valid", "enroll-me", "Bearer enroll-me", false},
+		{"wrong secret", "enroll-me", "Bearer something-else", true},
+		{"missing", "enroll-me", "", true},
+		{"no secret configured", "", "Bearer ", true},
+		{"no secret configured, no header", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.AgentSecret = tt.secret
+
+			r, _ := http.NewRequest(http.MethodPost, "/register", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+
+			if err := checkEnrollment(r); (err != nil) != tt.wantErr {
+				t.Errorf("checkEnrollment() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_authenticateAgent(t *testing.T) {
+	defer func(orig bool) { config.AgentRequireSignature = orig }(config.AgentRequireSignature)
+
+	registry.Store(registry.Agent{Agent: model.Agent{ShortName: "signed-agent", Token: hashToken("agent-token")}})
+	defer registry.Remove("signed-agent")
+
+	body := []byte(`{"ID":1,"StatusID":200}`)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+
+	tests := []struct {
+		name      string
+		token     string
+		timestamp string
+		signature string
+		required  bool
+		wantErr   bool
+	}{
+		{"unsigned", "agent-token", "", "", false, false},
+		{"unsigned but required", "agent-token", "", "", true, true},
+		{"signed", "agent-token", now, signBody("agent-token", now, body), true, false},
+		{"bad signature", "agent-token", now, signBody("other-token", now, body), false, true},
+		{"stale signature", "agent-token", old, signBody("agent-token", old, body), false, true},
+		{"unknown token", "other-token", "", "", false, true},
+		{"no token", "", "", "", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.AgentRequireSignature = tt.required
+
+			r, _ := http.NewRequest(http.MethodPost, "/upd8", bytes.NewReader(body))
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			if tt.signature != "" {
+				r.Header.Set(timestampHeader, tt.timestamp)
+				r.Header.Set(signatureHeader, tt.signature)
+			}
+
+			agent, err := authenticateAgent(r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("authenticateAgent() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if agent.ShortName != "signed-agent" {
+				t.Errorf("authenticateAgent() = %q, want %q", agent.ShortName, "signed-agent")
+			}
+
+			read, _ := ioutil.ReadAll(r.Body)
+			if !bytes.Equal(read, body) {
+				t.Errorf("authenticateAgent() did not preserve the body, got %q", read)
+			}
+		})
+	}
+}
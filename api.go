@@ -107,9 +107,9 @@ func apiSaveSubscription(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userCookie, err := r.Cookie("user")
-	if err != nil {
-		logger.Error("getting user cookie failed: %v", err)
+	user := getUser(r)
+	if user == "" {
+		logger.Error("saving push subscription without a logged in user")
 		inet.SendResponse(w, http.StatusBadRequest, inet.Message{
 			Status:  http.StatusBadRequest,
 			Message: errs.MissingUserCookie,
@@ -117,7 +117,7 @@ func apiSaveSubscription(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = db.InsertPushSubscription(&subscription, userCookie.Value)
+	err = db.InsertPushSubscription(&subscription, user)
 	if err != nil {
 		inet.SendResponse(w, http.StatusInternalServerError, inet.Message{
 			Status:  http.StatusInternalServerError,
@@ -160,17 +160,17 @@ func apiRemoveSubscription(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userCookie, err := r.Cookie("user")
-	if err != nil {
-		logger.Error("getting user cookie failed: " + err.Error())
-		inet.SendResponse(w, http.StatusInternalServerError, inet.Message{
+	user := getUser(r)
+	if user == "" {
+		logger.Error("removing push subscription without a logged in user")
+		inet.SendResponse(w, http.StatusBadRequest, inet.Message{
 			Status:  http.StatusBadRequest,
 			Message: errs.MissingUserCookie,
 		})
 		return
 	}
 
-	err = db.DeletePushSubscription(&subscription, userCookie.Value)
+	err = db.DeletePushSubscription(&subscription, user)
 	if err != nil {
 		logger.Error("failed deleting push subscription: %v", err)
 
@@ -3,10 +3,7 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -25,12 +22,17 @@ import (
 )
 
 func apiSetLogLevel(w http.ResponseWriter, r *http.Request) {
-	_, err := getAPIUser(r)
+	p, err := getAPIPrincipal(r)
 	if err != nil {
 		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
 		return
 	}
 
+	if !p.can(actAdminister) {
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return
+	}
+
 	var lvl logger.LogLevel
 
 	level := mux.Vars(r)["level"]
@@ -61,17 +63,24 @@ func apiSetLogLevel(w http.ResponseWriter, r *http.Request) {
 
 	logger.Level = lvl
 
+	audit(r, data.AuditEntry{Actor: p.Email, Action: auditLogLevel, Details: msg})
+
 	inet.SendSuccess(w, http.StatusOK, msg)
 	return
 }
 
 func getAPIAgents(w http.ResponseWriter, r *http.Request) {
-	_, err := getAPIUser(r)
+	p, err := getAPIPrincipal(r)
 	if err != nil {
 		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
 		return
 	}
 
+	if !p.can(actListAgents) {
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return
+	}
+
 	agents := registry.List()
 
 	if len(agents) == 0 {
@@ -79,17 +88,22 @@ func getAPIAgents(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	inet.SendSuccess(w, http.StatusOK, agents)
+	inet.SendSuccess(w, http.StatusOK, redactAgents(agents))
 }
 
 func getAPIActiveAgents(w http.ResponseWriter, r *http.Request) {
-	_, err := getAPIUser(r)
+	p, err := getAPIPrincipal(r)
 	if err != nil {
 		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
 		return
 	}
 
-	result := make([]model.Agent, 0)
+	if !p.can(actListAgents) {
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return
+	}
+
+	result := make([]registry.Agent, 0)
 
 	agents := registry.List()
 	for _, agent := range agents {
@@ -97,7 +111,7 @@ func getAPIActiveAgents(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 
-		result = append(result, agent)
+		result = append(result, redactAgent(agent))
 	}
 
 	if len(result) == 0 {
@@ -110,12 +124,17 @@ func getAPIActiveAgents(w http.ResponseWriter, r *http.Request) {
 
 // apiAgentByName returns an agent by its shortname
 func getAPIAgentByName(w http.ResponseWriter, r *http.Request) {
-	_, err := getAPIUser(r)
+	p, err := getAPIPrincipal(r)
 	if err != nil {
 		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
 		return
 	}
 
+	if !p.can(actListAgents) {
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return
+	}
+
 	vars := mux.Vars(r)
 
 	shortname := vars["agent"]
@@ -126,49 +145,49 @@ func getAPIAgentByName(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	inet.SendSuccess(w, http.StatusOK, agent)
+	inet.SendSuccess(w, http.StatusOK, redactAgent(agent))
 }
 
 func getAPIDatabases(w http.ResponseWriter, r *http.Request) {
-	user, err := getAPIUser(r)
+	p, err := getAPIPrincipal(r)
 	if err != nil {
 		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
 		return
 	}
 
-	// Get private ones
-	metas, err := db.FetchByCreator(user)
+	query, err := databaseQueryFrom(r)
 	if err != nil {
-		inet.SendFailure(w, http.StatusInternalServerError, errs.QueryFailed, err.Error())
-
-		logger.Error("Fetching private dbs failed: %v", err)
+		inet.SendFailure(w, http.StatusBadRequest, errs.UnknownParameter, err.Error())
 		return
 	}
+	p.restrict(&query)
 
-	databases := make([]data.Row, 0, len(metas))
-
-	for _, meta := range metas {
-		databases = append(databases, meta)
-	}
+	// One more than the page is fetched to know whether there's a next one.
+	perPage := query.Limit
+	query.Limit++
 
-	// Get public ones
-	metas, err = db.FetchPublic()
+	databases, err := db.QueryDatabases(query)
 	if err != nil {
 		inet.SendFailure(w, http.StatusInternalServerError, errs.QueryFailed, err.Error())
 
-		logger.Error("Fetching public dbs failed: %v", err)
+		logger.Error("Fetching dbs failed: %v", err)
 		return
 	}
 
-	for _, meta := range metas {
-		databases = append(databases, meta)
+	if len(databases) > perPage {
+		databases = databases[:perPage]
+		w.Header().Set("Link", nextPageLink(r, encodeCursor(query, databases[perPage-1])))
+	}
+
+	if databases == nil {
+		databases = make([]data.Row, 0)
 	}
 
-	inet.SendSuccess(w, http.StatusOK, databases)
+	inet.SendSuccess(w, http.StatusOK, redactDatabases(databases))
 }
 
 func getAPIDatabaseByID(w http.ResponseWriter, r *http.Request) {
-	user, err := getAPIUser(r)
+	p, err := getAPIPrincipal(r)
 	if err != nil {
 		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
 		return
@@ -181,16 +200,16 @@ func getAPIDatabaseByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !hasAccess(meta, user) {
+	if !p.canRead(meta) {
 		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
 		return
 	}
 
-	inet.SendSuccess(w, http.StatusOK, meta)
+	inet.SendSuccess(w, http.StatusOK, redactDatabase(meta))
 }
 
 func getAPIDatabaseByAgentDBName(w http.ResponseWriter, r *http.Request) {
-	user, err := getAPIUser(r)
+	p, err := getAPIPrincipal(r)
 	if err != nil {
 		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
 		return
@@ -203,16 +222,16 @@ func getAPIDatabaseByAgentDBName(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !hasAccess(meta, user) {
+	if !p.canRead(meta) {
 		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
 		return
 	}
 
-	inet.SendSuccess(w, http.StatusOK, meta)
+	inet.SendSuccess(w, http.StatusOK, redactDatabase(meta))
 }
 
 func dropAPIDatabaseByID(w http.ResponseWriter, r *http.Request) {
-	user, err := getAPIUser(r)
+	p, err := getAPIPrincipal(r)
 	if err != nil {
 		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
 		return
@@ -225,28 +244,35 @@ func dropAPIDatabaseByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !hasAccess(meta, user) {
+	if !p.canManage(meta) {
 		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
 		return
 	}
 
-	agent, ok := registry.Get(meta.AgentName)
+	_, ok := registry.Get(meta.AgentName)
 	if !ok {
 		inet.SendFailure(w, http.StatusForbidden, errs.AgentNotFound)
 		return
 	}
 
+	before := meta.Status
 	meta.Status = status.DropInProgress
 
 	db.Update(&meta)
 
-	go dropAsync(agent, meta.ID, meta.DBName, meta.DBUser)
+	auditDatabase(r, p.Email, auditDrop, meta, before, "")
+
+	_, err = enqueueJob(data.JobDrop, meta, p.Email, "")
+	if err != nil {
+		inet.SendFailure(w, http.StatusInternalServerError, errs.PersistFailed, err.Error())
+		return
+	}
 
 	inet.SendSuccess(w, http.StatusOK, "Started dropping database")
 }
 
 func dropAPIDatabaseByAgentDBName(w http.ResponseWriter, r *http.Request) {
-	user, err := getAPIUser(r)
+	p, err := getAPIPrincipal(r)
 	if err != nil {
 		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
 		return
@@ -264,34 +290,46 @@ func dropAPIDatabaseByAgentDBName(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !hasAccess(meta, user) {
+	if !p.canManage(meta) {
 		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
 		return
 	}
 
-	agent, ok := registry.Get(meta.AgentName)
+	_, ok := registry.Get(meta.AgentName)
 	if !ok {
 		inet.SendFailure(w, http.StatusForbidden, errs.AgentNotFound)
 		return
 	}
 
+	before := meta.Status
 	meta.Status = status.DropInProgress
 
 	db.Update(&meta)
 
-	go dropAsync(agent, meta.ID, meta.DBName, meta.DBUser)
+	auditDatabase(r, p.Email, auditDrop, meta, before, "")
+
+	_, err = enqueueJob(data.JobDrop, meta, p.Email, "")
+	if err != nil {
+		inet.SendFailure(w, http.StatusInternalServerError, errs.PersistFailed, err.Error())
+		return
+	}
 
 	inet.SendSuccess(w, http.StatusOK, "Started dropping database")
 }
 
 func importAPIDB(w http.ResponseWriter, r *http.Request) {
-	user, err := getAPIUser(r)
+	p, err := getAPIPrincipal(r)
 	if err != nil {
 		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
 		return
 	}
 
-	var req model.ClientRequest
+	if !p.can(actCreate) {
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return
+	}
+
+	var req apiDatabaseRequest
 
 	err = json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
@@ -301,20 +339,38 @@ func importAPIDB(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.AgentIdentifier == "" {
-		inet.SendFailure(w, http.StatusBadRequest, errs.MissingParameters, "agent_identifier")
+	if req.DumpLocation == "" {
+		inet.SendFailure(w, http.StatusBadRequest, errs.MissingParameters, "dumpfile_location")
 		return
 	}
 
-	if req.DumpLocation == "" {
-		inet.SendFailure(w, http.StatusBadRequest, errs.MissingParameters, "dumpfile_location")
+	if strings.HasPrefix(req.DumpLocation, "/") && config.MountLoc != "" {
+		_, err = mountedPath(req.DumpLocation)
+		if err != nil {
+			inet.SendFailure(w, http.StatusBadRequest, errOutsideMount, req.DumpLocation)
+			return
+		}
+	}
+
+	size := dumpSize(req.DumpLocation)
+
+	req.DumpSize, req.Import = size, true
+
+	agent, errr := requestedAgent(&req)
+	if errr.httpStatus != 0 {
+		inet.SendFailure(w, errr.httpStatus, errr.errors...)
 		return
 	}
 
-	agent, ok := registry.Get(req.AgentIdentifier)
-	if !ok {
-		inet.SendFailure(w, http.StatusBadRequest, errs.AgentNotFound, req.AgentIdentifier)
+	err = checkCapacity(agent, size)
+	if err != nil {
+		sendCapacityFailure(w, err)
+		return
+	}
 
+	err = checkQuota(quotaRequest{User: p.Email, Agent: agent.ShortName, DumpSize: size, Import: true})
+	if err != nil {
+		sendQuotaFailure(w, err)
 		return
 	}
 
@@ -327,14 +383,16 @@ func importAPIDB(w http.ResponseWriter, r *http.Request) {
 		DBSID:      agent.DBSID,
 		AgentName:  req.AgentIdentifier,
 		Dumpfile:   req.DumpLocation,
-		Creator:    user,
+		DumpSize:   size,
+		Creator:    p.Email,
 		CreateDate: time.Now(),
-		ExpiryDate: time.Now().AddDate(0, 1, 0),
 		DBAddress:  agent.DBAddr,
 		DBVendor:   agent.DBVendor,
 		Status:     status.Accepted,
 	}
 
+	dbe.ExpiryDate = expiryPolicyOf(dbe).expiry(dbe.CreateDate)
+
 	err = db.Insert(&dbe)
 	if err != nil {
 		inet.SendFailure(w, http.StatusInternalServerError, errs.PersistFailed, err.Error())
@@ -350,77 +408,30 @@ func importAPIDB(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	go startImport(agent, dbe)
-
-	inet.SendSuccess(w, http.StatusAccepted, dbe)
-}
-
-func startImport(agent model.Agent, dbe data.Row) {
-	defer db.Update(&dbe)
-
-	url := dbe.Dumpfile
-	if strings.HasPrefix(dbe.Dumpfile, "/") {
-		_, filename := filepath.Split(dbe.Dumpfile)
-		logger.Debug("Starting to copy %s to %s/web/dumps/", filename, workdir)
-		dst, err := os.OpenFile(fmt.Sprintf("%s/web/dumps/%s", workdir, filename), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
-		if err != nil {
-			errMsg := fmt.Sprintf("Failed creating downloadable file at web/dumps: %v", err)
-
-			logger.Error(errMsg)
-			dbe.Status = status.ImportFailed
-			dbe.Message = errMsg
-			return
-		}
-		defer dst.Close()
-
-		src, err := os.Open(filepath.Join(config.MountLoc, dbe.Dumpfile))
-		if err != nil {
-			errMsg := fmt.Sprintf("Failed opening dumpfile at %v: %v", dbe.Dumpfile, err)
-
-			logger.Error(errMsg)
-			dbe.Status = status.ImportFailed
-			dbe.Message = errMsg
-			return
-		}
-		defer src.Close()
-
-		_, err = io.Copy(dst, src)
-		if err != nil {
-			errMsg := fmt.Sprintf("Failed copying dumpfile %s -> %s: %v", src.Name(), dst.Name(), err)
-
-			logger.Error(errMsg)
-			dbe.Status = status.ImportFailed
-			dbe.Message = errMsg
-			return
-		}
-
-		logger.Debug("Copy successful, starting import")
+	auditDatabase(r, p.Email, auditImport, dbe, 0, dbe.Dumpfile)
 
-		url = fmt.Sprintf("http://%s/dumps/%s", config.ServerHost, filename)
-	}
-
-	_, err := agent.ImportDatabase(dbe.ID, dbe.DBName, dbe.DBUser, dbe.DBPass, url)
+	_, err = enqueueJob(data.JobImport, dbe, p.Email, dbe.Dumpfile)
 	if err != nil {
-		errMsg := fmt.Sprintf("Import failed: %v", err)
-
-		logger.Error(errMsg)
-
-		dbe.Status = status.ImportFailed
-		dbe.Message = errMsg
+		inet.SendFailure(w, http.StatusInternalServerError, errs.PersistFailed, err.Error())
 		return
 	}
 
-	dbe.Status = status.ImportInProgress
+	inet.SendSuccess(w, http.StatusAccepted, redactDatabase(dbe))
 }
 
 func createAPIDB(w http.ResponseWriter, r *http.Request) {
-	user, err := getAPIUser(r)
+	p, err := getAPIPrincipal(r)
 	if err != nil {
 		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
 		return
 	}
 
-	var req model.ClientRequest
+	if !p.can(actCreate) {
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return
+	}
+
+	var req apiDatabaseRequest
 
 	err = json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
@@ -430,16 +441,15 @@ func createAPIDB(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.AgentIdentifier == "" {
-		inet.SendFailure(w, http.StatusBadRequest, errs.MissingParameters, "agent_identifier")
-
+	agent, errr := requestedAgent(&req)
+	if errr.httpStatus != 0 {
+		inet.SendFailure(w, errr.httpStatus, errr.errors...)
 		return
 	}
 
-	agent, ok := registry.Get(req.AgentIdentifier)
-	if !ok {
-		inet.SendFailure(w, http.StatusBadRequest, errs.AgentNotFound, req.AgentIdentifier)
-
+	err = checkQuota(quotaRequest{User: p.Email, Agent: agent.ShortName})
+	if err != nil {
+		sendQuotaFailure(w, err)
 		return
 	}
 
@@ -452,14 +462,15 @@ func createAPIDB(w http.ResponseWriter, r *http.Request) {
 		DBPass:     req.Password,
 		DBSID:      agent.DBSID,
 		AgentName:  req.AgentIdentifier,
-		Creator:    user,
+		Creator:    p.Email,
 		CreateDate: time.Now(),
-		ExpiryDate: time.Now().AddDate(0, 1, 0),
 		DBAddress:  agent.DBAddr,
 		DBVendor:   agent.DBVendor,
 		Status:     status.Success,
 	}
 
+	dbe.ExpiryDate = expiryPolicyOf(dbe).expiry(dbe.CreateDate)
+
 	err = db.Insert(&dbe)
 	if err != nil {
 		inet.SendFailure(w, http.StatusInternalServerError, errs.PersistFailed, err.Error())
@@ -476,11 +487,13 @@ func createAPIDB(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	inet.SendSuccess(w, http.StatusOK, dbe)
+	auditDatabase(r, p.Email, auditCreate, dbe, 0, "")
+
+	inet.SendSuccess(w, http.StatusOK, redactDatabase(dbe))
 }
 
 func exportAPIDB(w http.ResponseWriter, r *http.Request) {
-	user, err := getAPIUser(r)
+	p, err := getAPIPrincipal(r)
 	if err != nil {
 		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
 		return
@@ -493,31 +506,46 @@ func exportAPIDB(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !hasAccess(meta, user) {
+	if !p.canManage(meta) {
 		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
 		return
 	}
 
-	agent, ok := registry.Get(meta.AgentName)
+	_, ok := registry.Get(meta.AgentName)
 	if !ok {
 		inet.SendFailure(w, http.StatusInternalServerError, errs.AgentNotFound, meta.AgentName)
 		return
 	}
 
-	resp, err := agent.ExportDatabase(meta.ID, meta.DBName, meta.DBUser, meta.DBPass)
-	if err != nil {
-		meta.Status = status.ExportFailed
-		db.Update(&meta)
+	before := meta.Status
+	meta.Status = status.ExportInProgress
+
+	db.Update(&meta)
 
-		inet.SendFailure(w, http.StatusInternalServerError, errs.ExportFailed)
+	auditDatabase(r, p.Email, auditExport, meta, before, "")
+
+	job, err := enqueueJob(data.JobExport, meta, p.Email, "")
+	if err != nil {
+		inet.SendFailure(w, http.StatusInternalServerError, errs.ExportFailed, err.Error())
 		return
 	}
 
-	inet.SendSuccess(w, http.StatusOK, resp)
+	inet.WriteHeader(w, http.StatusOK)
+	json.NewEncoder(w).Encode(exportResponse{
+		Response: inet.Response{Success: true, Data: "Understood request, starting export process."},
+		JobID:    job.ID,
+	})
+}
+
+// exportResponse answers an export with the message it always did, so
+// clients that only check that keep working, and the job running it.
+type exportResponse struct {
+	inet.Response
+	JobID int `json:"job_id"`
 }
 
 func recreateAPIDB(w http.ResponseWriter, r *http.Request) {
-	user, err := getAPIUser(r)
+	p, err := getAPIPrincipal(r)
 	if err != nil {
 		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
 		return
@@ -530,7 +558,7 @@ func recreateAPIDB(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !hasAccess(meta, user) {
+	if !p.canManage(meta) {
 		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
 		return
 	}
@@ -541,11 +569,15 @@ func recreateAPIDB(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	before := meta.Status
+
 	_, err = agent.DropDatabase(meta.ID, meta.DBName, meta.DBUser)
 	if err != nil {
 		meta.Status = status.DropDatabaseFailed
 		db.Update(&meta)
 
+		auditDatabase(r, p.Email, auditRecreate, meta, before, err.Error())
+
 		inet.SendFailure(w, http.StatusInternalServerError, errs.DropFailed)
 		return
 	}
@@ -555,20 +587,29 @@ func recreateAPIDB(w http.ResponseWriter, r *http.Request) {
 		meta.Status = status.CreateDatabaseFailed
 		db.Update(&meta)
 
+		auditDatabase(r, p.Email, auditRecreate, meta, before, err.Error())
+
 		inet.SendFailure(w, http.StatusInternalServerError, errs.CreateFailed)
 		return
 	}
 
-	inet.SendSuccess(w, http.StatusOK, meta)
+	auditDatabase(r, p.Email, auditRecreate, meta, before, "")
+
+	inet.SendSuccess(w, http.StatusOK, redactDatabase(meta))
 }
 
 func browseAPI(w http.ResponseWriter, r *http.Request) {
-	_, err := getAPIUser(r)
+	p, err := getAPIPrincipal(r)
 	if err != nil {
 		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
 		return
 	}
 
+	if !p.can(actCreate) {
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return
+	}
+
 	if config.MountLoc == "" {
 		inet.SendFailure(w, http.StatusFailedDependency, errs.NoFoldersMounted)
 		return
@@ -592,7 +633,7 @@ func browseAPI(w http.ResponseWriter, r *http.Request) {
 }
 
 func apiSetVisibility(w http.ResponseWriter, r *http.Request) {
-	user, err := getAPIUser(r)
+	p, err := getAPIPrincipal(r)
 	if err != nil {
 		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
 		return
@@ -605,7 +646,7 @@ func apiSetVisibility(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !isOwner(meta, user) {
+	if !p.canManage(meta) {
 		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
 		return
 	}
@@ -618,6 +659,13 @@ func apiSetVisibility(w http.ResponseWriter, r *http.Request) {
 		visibilityNum = vis.Public
 	case "private":
 		visibilityNum = vis.Private
+	case "group":
+		if meta.Group == "" {
+			inet.SendFailure(w, http.StatusBadRequest, errs.MissingParameters, "group")
+			return
+		}
+
+		visibilityNum = data.GroupVisibility
 	default:
 		inet.SendFailure(w, http.StatusBadRequest, errs.MissingParameters, visibility)
 		return
@@ -639,11 +687,13 @@ func apiSetVisibility(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	auditDatabase(r, p.Email, auditVisibility, meta, meta.Status, visibility)
+
 	inet.SendSuccess(w, http.StatusOK, "Visibility updated successfully")
 }
 
 func apiExtendExpiry(w http.ResponseWriter, r *http.Request) {
-	user, err := getAPIUser(r)
+	p, err := getAPIPrincipal(r)
 	if err != nil {
 		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
 		return
@@ -656,7 +706,7 @@ func apiExtendExpiry(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !hasAccess(meta, user) {
+	if !p.canManage(meta) {
 		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
 		return
 	}
@@ -669,20 +719,34 @@ func apiExtendExpiry(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Expired databases awaiting the drop are extended from now, like on
+	// the web interface.
+	from := meta.ExpiryDate
+	if expired(meta, time.Now()) {
+		from = time.Now()
+	}
+
 	var newExpiry time.Time
 	switch vars["unit"] {
 	case "days":
-		newExpiry = meta.ExpiryDate.AddDate(0, 0, amount)
+		newExpiry = from.AddDate(0, 0, amount)
 	case "months":
-		newExpiry = meta.ExpiryDate.AddDate(0, amount, 0)
-	case "year":
-		newExpiry = meta.ExpiryDate.AddDate(amount, 0, 0)
+		newExpiry = from.AddDate(0, amount, 0)
+	case "years", "year":
+		// "year" is how the unit used to be spelled.
+		newExpiry = from.AddDate(amount, 0, 0)
 	default:
 		inet.SendFailure(w, http.StatusBadRequest, errs.UnknownParameter, vars["unit"])
 		return
 	}
 
-	meta.ExpiryDate = newExpiry
+	before := meta.Status
+
+	err = extendExpiry(&meta, newExpiry)
+	if err != nil {
+		inet.SendFailure(w, http.StatusConflict, errs.UpdateFailed, err.Error())
+		return
+	}
 
 	err = db.Update(&meta)
 	if err != nil {
@@ -692,11 +756,13 @@ func apiExtendExpiry(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	auditDatabase(r, p.Email, auditExtend, meta, before, "expires "+meta.ExpiryDate.Format("2006-01-02"))
+
 	inet.SendSuccess(w, http.StatusOK, meta.ExpiryDate)
 }
 
 func apiAccessInfoByAgentDB(w http.ResponseWriter, r *http.Request) {
-	user, err := getAPIUser(r)
+	p, err := getAPIPrincipal(r)
 	if err != nil {
 		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
 		return
@@ -709,7 +775,7 @@ func apiAccessInfoByAgentDB(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !hasAccess(meta, user) {
+	if !p.canRead(meta) {
 		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
 		return
 	}
@@ -718,7 +784,7 @@ func apiAccessInfoByAgentDB(w http.ResponseWriter, r *http.Request) {
 }
 
 func apiAccessInfoByID(w http.ResponseWriter, r *http.Request) {
-	user, err := getAPIUser(r)
+	p, err := getAPIPrincipal(r)
 	if err != nil {
 		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
 		return
@@ -731,7 +797,7 @@ func apiAccessInfoByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !hasAccess(meta, user) {
+	if !p.canRead(meta) {
 		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
 		return
 	}
@@ -788,31 +854,24 @@ func getDBAccess(meta data.Row) dbAccess {
 	return dba
 }
 
-func getAPIUser(r *http.Request) (string, error) {
-	auth := r.Header.Get("Authorization")
-	if auth == "" {
-		return "", fmt.Errorf("unauthorized request")
-	}
+// redactDatabase removes the password of the database, which is only
+// handed out by the access info endpoints.
+func redactDatabase(meta data.Row) data.Row {
+	meta.DBPass = ""
 
-	return auth, nil
+	return meta
 }
 
-func hasResult(meta data.Row) bool {
-	if meta.Creator == "" {
-		return false
+func redactDatabases(rows []data.Row) []data.Row {
+	for i := range rows {
+		rows[i] = redactDatabase(rows[i])
 	}
-	return true
-}
 
-func hasAccess(meta data.Row, user string) bool {
-	if meta.Public == vis.Private && meta.Creator != user {
-		return false
-	}
-	return true
+	return rows
 }
 
-func isOwner(meta data.Row, user string) bool {
-	return meta.Creator == user
+func hasResult(meta data.Row) bool {
+	return meta.ID != 0
 }
 
 type errResult struct {
@@ -820,6 +879,43 @@ type errResult struct {
 	errors     []string
 }
 
+// apiDatabaseRequest is a request to create or import a database. If it
+// doesn't name the agent, the agent is placed by the rest of it.
+type apiDatabaseRequest struct {
+	model.ClientRequest
+	placementRequest
+}
+
+// requestedAgent returns the agent the request names, or places the
+// database on one and names it in the request.
+func requestedAgent(req *apiDatabaseRequest) (registry.Agent, errResult) {
+	if req.AgentIdentifier == "" {
+		agent, errr := placeDatabase(req.placementRequest)
+		if errr.httpStatus == 0 {
+			req.AgentIdentifier = agent.ShortName
+		}
+
+		return agent, errr
+	}
+
+	agent, ok := registry.Get(req.AgentIdentifier)
+	if !ok {
+		return registry.Agent{}, errResult{
+			httpStatus: http.StatusBadRequest,
+			errors:     []string{errs.AgentNotFound, req.AgentIdentifier},
+		}
+	}
+
+	if !agent.Accepting() {
+		return registry.Agent{}, errResult{
+			httpStatus: http.StatusConflict,
+			errors:     []string{errAgentUnavailable, req.AgentIdentifier, agent.Mode},
+		}
+	}
+
+	return agent, errResult{}
+}
+
 func getDatabaseByIDFrom(vars map[string]string) (data.Row, errResult) {
 	id, err := strconv.Atoi(vars["id"])
 	if err != nil {
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/djavorszky/ddn-api/database/data"
+	"github.com/djavorszky/ddn-common/errs"
+	"github.com/djavorszky/ddn-common/inet"
+	"github.com/djavorszky/ddn-common/logger"
+)
+
+// Actions recorded in the audit log.
+const (
+	auditCreate     = "database.create"
+	auditImport     = "database.import"
+	auditDrop       = "database.drop"
+	auditExport     = "database.export"
+	auditRecreate   = "database.recreate"
+	auditVisibility = "database.visibility"
+	auditExtend     = "database.extend"
+	auditTransfer   = "database.transfer"
+	auditStatus     = "database.status"
+	auditExpire     = "database.expire"
+	auditReconcile  = "database.reconcile"
+	auditInventory  = "database.inventory"
+
+	auditTokenCreate     = "token.create"
+	auditTokenRevoke     = "token.revoke"
+	auditRoleChange      = "user.role"
+	auditGroupCreate     = "group.create"
+	auditGroupMember     = "group.member"
+	auditAgentRegister   = "agent.register"
+	auditAgentUnregister = "agent.unregister"
+	auditAgentMode       = "agent.mode"
+	auditAgentLabels     = "agent.labels"
+	auditLogLevel        = "server.loglevel"
+	auditJobCancel       = "job.cancel"
+	auditJobRetry        = "job.retry"
+)
+
+// systemActor is the actor of the actions the server takes on its own.
+const systemActor = "system"
+
+const (
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 1000
+)
+
+// agentActor is the actor of the actions taken by an agent.
+func agentActor(shortName string) string {
+	return "agent:" + shortName
+}
+
+// auditDatabase records an action on a database. before is the status
+// the database had before the action, the current one is taken from row.
+func auditDatabase(r *http.Request, actor, action string, row data.Row, before int, details string) {
+	audit(r, data.AuditEntry{
+		Actor:        actor,
+		Action:       action,
+		Target:       row.ID,
+		TargetName:   row.DBName,
+		Agent:        row.AgentName,
+		StatusBefore: before,
+		StatusAfter:  row.Status,
+		Details:      details,
+	})
+}
+
+// audit appends the entry to the audit log. The request is used for the
+// source IP, and can be nil for actions the server takes on its own.
+// Failing to record an entry doesn't fail the action itself.
+func audit(r *http.Request, entry data.AuditEntry) {
+	entry.Time = time.Now()
+
+	if r != nil {
+		entry.SourceIP = sourceIP(r)
+	}
+
+	err := db.InsertAuditEntry(&entry)
+	if err != nil {
+		logger.Error("failed recording %s of %s in the audit log: %v", entry.Action, entry.Actor, err)
+	}
+}
+
+func sourceIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// apiAudit lists the entries of the audit log, filtered and paged by the
+// query parameters, as JSON or as CSV with format=csv.
+func apiAudit(w http.ResponseWriter, r *http.Request) {
+	p, err := getAPIPrincipal(r)
+	if err != nil {
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return
+	}
+
+	if !p.can(actAdminister) {
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return
+	}
+
+	filter, page, err := auditFilterFrom(r)
+	if err != nil {
+		inet.SendFailure(w, http.StatusBadRequest, errs.UnknownParameter, err.Error())
+		return
+	}
+
+	entries, err := db.FetchAuditEntries(filter)
+	if err != nil {
+		inet.SendFailure(w, http.StatusInternalServerError, errs.QueryFailed, err.Error())
+
+		logger.Error("Fetching audit log failed: %v", err)
+		return
+	}
+
+	if entries == nil {
+		entries = make([]data.AuditEntry, 0)
+	}
+
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		inet.SendSuccess(w, http.StatusOK, struct {
+			Page    int               `json:"page"`
+			PerPage int               `json:"per_page"`
+			Entries []data.AuditEntry `json:"entries"`
+		}{page, filter.Limit, entries})
+	case "csv":
+		writeAuditCSV(w, entries)
+	default:
+		inet.SendFailure(w, http.StatusBadRequest, errs.UnknownParameter, "format")
+	}
+}
+
+// auditFilterFrom reads the filter and the page of the audit log from
+// the query parameters of the request.
+func auditFilterFrom(r *http.Request) (data.AuditFilter, int, error) {
+	query := r.URL.Query()
+
+	filter := data.AuditFilter{
+		Actor:  query.Get("actor"),
+		Action: query.Get("action"),
+		Agent:  query.Get("agent"),
+		Limit:  defaultAuditPageSize,
+	}
+
+	var err error
+
+	if v := query.Get("target"); v != "" {
+		filter.Target, err = strconv.Atoi(v)
+		if err != nil {
+			return filter, 0, errors.New("target")
+		}
+	}
+
+	if v := query.Get("from"); v != "" {
+		filter.From, err = parseAuditTime(v)
+		if err != nil {
+			return filter, 0, errors.New("from")
+		}
+	}
+
+	if v := query.Get("to"); v != "" {
+		filter.To, err = parseAuditTime(v)
+		if err != nil {
+			return filter, 0, errors.New("to")
+		}
+	}
+
+	if v := query.Get("per_page"); v != "" {
+		filter.Limit, err = strconv.Atoi(v)
+		if err != nil || filter.Limit < 1 || filter.Limit > maxAuditPageSize {
+			return filter, 0, errors.New("per_page")
+		}
+	}
+
+	page := 1
+	if v := query.Get("page"); v != "" {
+		page, err = strconv.Atoi(v)
+		if err != nil || page < 1 {
+			return filter, 0, errors.New("page")
+		}
+	}
+
+	filter.Offset = (page - 1) * filter.Limit
+
+	return filter, page, nil
+}
+
+// parseAuditTime accepts either a date or a full RFC 3339 timestamp.
+func parseAuditTime(v string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", v); err == nil {
+		return t, nil
+	}
+
+	return time.Parse(time.RFC3339, v)
+}
+
+func writeAuditCSV(w http.ResponseWriter, entries []data.AuditEntry) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit.csv"`)
+	w.WriteHeader(http.StatusOK)
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "time", "actor", "action", "target", "target_name", "agent", "status_before", "status_after", "source_ip", "details"})
+
+	for _, e := range entries {
+		cw.Write([]string{
+			strconv.Itoa(e.ID),
+			e.Time.Format(time.RFC3339),
+			csvSafe(e.Actor),
+			e.Action,
+			strconv.Itoa(e.Target),
+			csvSafe(e.TargetName),
+			csvSafe(e.Agent),
+			strconv.Itoa(e.StatusBefore),
+			strconv.Itoa(e.StatusAfter),
+			e.SourceIP,
+			csvSafe(e.Details),
+		})
+	}
+
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		logger.Error("failed writing audit csv: %v", err)
+	}
+}
+
+// csvSafe keeps spreadsheets from evaluating user supplied values that
+// look like formulas.
+func csvSafe(v string) string {
+	if v != "" && strings.ContainsAny(v[:1], "=+-@") {
+		return "'" + v
+	}
+
+	return v
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/djavorszky/ddn-api/database/data"
+)
+
+func Test_auditFilterFrom(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    data.AuditFilter
+		page    int
+		wantErr string
+	}{
+		{"", data.AuditFilter{Limit: defaultAuditPageSize}, 1, ""},
+		{"actor=jane@example.com&action=database.drop&agent=mysql-55&target=12", data.AuditFilter{Actor: "jane@example.com", Action: "database.drop", Agent: "mysql-55", Target: 12, Limit: defaultAuditPageSize}, 1, ""},
+		{"page=3&per_page=20", data.AuditFilter{Limit: 20, Offset: 40}, 3, ""},
+		{"from=2018-03-01&to=2018-03-02T10:00:00Z", data.AuditFilter{
+			From:  time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC),
+			To:    time.Date(2018, 3, 2, 10, 0, 0, 0, time.UTC),
+			Limit: defaultAuditPageSize,
+		}, 1, ""},
+		{"target=db", data.AuditFilter{}, 0, "target"},
+		{"from=yesterday", data.AuditFilter{}, 0, "from"},
+		{"to=03/01/2018", data.AuditFilter{}, 0, "to"},
+		{"per_page=5000", data.AuditFilter{}, 0, "per_page"},
+		{"per_page=0", data.AuditFilter{}, 0, "per_page"},
+		{"page=0", data.AuditFilter{}, 0, "page"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api/audit?"+tt.query, nil)
+
+			got, page, err := auditFilterFrom(r)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("auditFilterFrom() error = %v, expected %s", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("auditFilterFrom() failed: %v", err)
+			}
+
+			if got != tt.want || page != tt.page {
+				t.Errorf("auditFilterFrom() = %+v, %d, expected %+v, %d", got, page, tt.want, tt.page)
+			}
+		})
+	}
+}
+
+func Test_writeAuditCSV(t *testing.T) {
+	w := httptest.NewRecorder()
+
+	writeAuditCSV(w, []data.AuditEntry{{
+		ID:          1,
+		Time:        time.Date(2018, 3, 1, 10, 0, 0, 0, time.UTC),
+		Actor:       "jane@example.com",
+		Action:      auditCreate,
+		Target:      12,
+		TargetName:  "=HYPERLINK(\"http://example.com\")",
+		StatusAfter: 100,
+	}})
+
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+		t.Errorf("Content-Type = %q, expected text/csv", ct)
+	}
+
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, expected 2: %q", len(lines), w.Body.String())
+	}
+
+	want := `1,2018-03-01T10:00:00Z,jane@example.com,database.create,12,"'=HYPERLINK(""http://example.com"")",,0,100,,`
+	if lines[1] != want {
+		t.Errorf("csv row = %s, expected %s", lines[1], want)
+	}
+}
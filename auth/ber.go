@@ -0,0 +1,166 @@
+package auth
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// The LDAP protocol is encoded with a small subset of ASN.1 BER, which is
+// implemented below instead of pulling in a full LDAP client library.
+
+const (
+	berBoolean     = 0x01
+	berInteger     = 0x02
+	berOctetString = 0x04
+	berEnumerated  = 0x0a
+	berSequence    = 0x30
+	berSet         = 0x31
+
+	// maxPacketLen limits the size of the responses we are willing to read.
+	maxPacketLen = 1 << 20
+)
+
+type berPacket struct {
+	tag   byte
+	value []byte
+}
+
+func berEncode(tag byte, children ...[]byte) []byte {
+	var value []byte
+	for _, c := range children {
+		value = append(value, c...)
+	}
+
+	return append(append([]byte{tag}, berLength(len(value))...), value...)
+}
+
+func berLength(n int) []byte {
+	if n < 0x80 {
+		return []byte{byte(n)}
+	}
+
+	var b []byte
+	for ; n > 0; n >>= 8 {
+		b = append([]byte{byte(n)}, b...)
+	}
+
+	return append([]byte{0x80 | byte(len(b))}, b...)
+}
+
+func berString(tag byte, s string) []byte {
+	return berEncode(tag, []byte(s))
+}
+
+func berInt(tag byte, n int) []byte {
+	b := []byte{byte(n)}
+	for n >>= 8; n > 0; n >>= 8 {
+		b = append([]byte{byte(n)}, b...)
+	}
+
+	// keep the number positive if the high bit happens to be set
+	if b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+
+	return berEncode(tag, b)
+}
+
+func berBool(b bool) []byte {
+	if b {
+		return berEncode(berBoolean, []byte{0xff})
+	}
+
+	return berEncode(berBoolean, []byte{0x00})
+}
+
+// readPacket reads a single TLV from r.
+func readPacket(r *bufio.Reader) (berPacket, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return berPacket{}, err
+	}
+
+	first, err := r.ReadByte()
+	if err != nil {
+		return berPacket{}, err
+	}
+
+	length := int(first)
+	if first&0x80 != 0 {
+		n := int(first &^ 0x80)
+		if n == 0 || n > 4 {
+			return berPacket{}, fmt.Errorf("unsupported length encoding")
+		}
+
+		length = 0
+		for i := 0; i < n; i++ {
+			b, err := r.ReadByte()
+			if err != nil {
+				return berPacket{}, err
+			}
+
+			length = length<<8 | int(b)
+		}
+	}
+
+	if length > maxPacketLen {
+		return berPacket{}, fmt.Errorf("packet of %d bytes too large", length)
+	}
+
+	value := make([]byte, length)
+
+	_, err = io.ReadFull(r, value)
+	if err != nil {
+		return berPacket{}, err
+	}
+
+	return berPacket{tag: tag, value: value}, nil
+}
+
+// children parses the value of a constructed packet into its elements.
+func (p berPacket) children() ([]berPacket, error) {
+	var (
+		packets []berPacket
+		value   = p.value
+	)
+
+	for len(value) > 0 {
+		if len(value) < 2 {
+			return nil, fmt.Errorf("truncated packet")
+		}
+
+		tag, length, header := value[0], int(value[1]), 2
+		if value[1]&0x80 != 0 {
+			n := int(value[1] &^ 0x80)
+			if n == 0 || n > 4 || len(value) < 2+n {
+				return nil, fmt.Errorf("invalid length encoding")
+			}
+
+			length = 0
+			for _, b := range value[2 : 2+n] {
+				length = length<<8 | int(b)
+			}
+
+			header += n
+		}
+
+		if length < 0 || len(value) < header+length {
+			return nil, fmt.Errorf("truncated packet")
+		}
+
+		packets = append(packets, berPacket{tag: tag, value: value[header : header+length]})
+		value = value[header+length:]
+	}
+
+	return packets, nil
+}
+
+func (p berPacket) int() int {
+	var n int
+	for _, b := range p.value {
+		n = n<<8 | int(b)
+	}
+
+	return n
+}
@@ -0,0 +1,322 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	ldapVersion = 3
+
+	appBindRequest   = 0x60
+	appBindResponse  = 0x61
+	appUnbindRequest = 0x42
+	appSearchRequest = 0x63
+	appSearchEntry   = 0x64
+	appSearchDone    = 0x65
+	appSearchRef     = 0x73
+
+	ctxSimpleAuth     = 0x80
+	ctxPresentFilter  = 0x87
+	scopeBaseObject   = 0
+	derefNever        = 0
+	resultSuccess     = 0
+	resultInvalidCred = 49
+
+	defaultLDAPTimeout = 10 * time.Second
+)
+
+// LDAP authenticates users with a simple bind against a directory server
+// and reads their email address from their own entry.
+type LDAP struct {
+	// Addr is the host:port of the directory server.
+	Addr string
+
+	// TLS makes the connection use LDAPS. TLSConfig is used if set.
+	TLS       bool
+	TLSConfig *tls.Config
+
+	// UserDN is the template of the DN users bind as, with a single %s
+	// that is replaced by the escaped username, e.g.
+	// "uid=%s,ou=people,dc=example,dc=com".
+	UserDN string
+
+	// MailAttr is the attribute holding the email address, "mail" if empty.
+	MailAttr string
+
+	// Timeout applies to the whole exchange with the server.
+	Timeout time.Duration
+}
+
+// Name returns "ldap".
+func (l *LDAP) Name() string {
+	return "ldap"
+}
+
+// LoginURL returns an empty string, as the credentials are posted
+// to the login form.
+func (l *LDAP) LoginURL(state string) string {
+	return ""
+}
+
+// Login authenticates the username and password posted to the login form.
+func (l *LDAP) Login(r *http.Request) (Identity, error) {
+	return l.Authenticate(r.PostFormValue("username"), r.PostFormValue("password"))
+}
+
+// Verify authenticates the HTTP basic credentials of the request.
+func (l *LDAP) Verify(r *http.Request) (Identity, error) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return Identity{}, ErrNoCredentials
+	}
+
+	return l.Authenticate(username, password)
+}
+
+// Authenticate binds as the user with the password and returns the
+// identity read from the user's entry.
+func (l *LDAP) Authenticate(username, password string) (Identity, error) {
+	// An empty password would be an unauthenticated bind, which most
+	// servers happily accept for any DN.
+	if username == "" || password == "" {
+		return Identity{}, ErrInvalidCredentials
+	}
+
+	conn, err := l.dial()
+	if err != nil {
+		return Identity{}, fmt.Errorf("connecting to ldap server: %v", err)
+	}
+	defer conn.Close()
+
+	timeout := l.Timeout
+	if timeout == 0 {
+		timeout = defaultLDAPTimeout
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	c := ldapConn{conn: conn, r: bufio.NewReader(conn)}
+	defer c.send(berEncode(appUnbindRequest))
+
+	dn := fmt.Sprintf(l.UserDN, escapeDN(username))
+
+	err = c.bind(dn, password)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	mailAttr := l.MailAttr
+	if mailAttr == "" {
+		mailAttr = "mail"
+	}
+
+	attrs, err := c.read(dn, mailAttr, "cn")
+	if err != nil {
+		return Identity{}, fmt.Errorf("reading entry of %s: %v", dn, err)
+	}
+
+	identity := Identity{Email: attrs[strings.ToLower(mailAttr)], Name: attrs["cn"]}
+	if identity.Email == "" {
+		if !strings.Contains(username, "@") {
+			return Identity{}, fmt.Errorf("entry %s has no %s attribute", dn, mailAttr)
+		}
+
+		identity.Email = username
+	}
+
+	return identity, nil
+}
+
+func (l *LDAP) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: defaultLDAPTimeout}
+	if l.Timeout != 0 {
+		dialer.Timeout = l.Timeout
+	}
+
+	if !l.TLS {
+		return dialer.Dial("tcp", l.Addr)
+	}
+
+	conf := l.TLSConfig
+	if conf == nil {
+		host, _, _ := net.SplitHostPort(l.Addr)
+		conf = &tls.Config{ServerName: host}
+	}
+
+	return tls.DialWithDialer(dialer, "tcp", l.Addr, conf)
+}
+
+type ldapConn struct {
+	conn  net.Conn
+	r     *bufio.Reader
+	msgID int
+}
+
+func (c *ldapConn) send(op []byte) error {
+	c.msgID++
+
+	_, err := c.conn.Write(berEncode(berSequence, berInt(berInteger, c.msgID), op))
+
+	return err
+}
+
+// receive reads the next message and returns its protocol operation.
+func (c *ldapConn) receive() (berPacket, error) {
+	msg, err := readPacket(c.r)
+	if err != nil {
+		return berPacket{}, err
+	}
+
+	parts, err := msg.children()
+	if err != nil {
+		return berPacket{}, err
+	}
+
+	if msg.tag != berSequence || len(parts) < 2 || parts[0].int() != c.msgID {
+		return berPacket{}, fmt.Errorf("unexpected message from server")
+	}
+
+	return parts[1], nil
+}
+
+func (c *ldapConn) bind(dn, password string) error {
+	err := c.send(berEncode(appBindRequest,
+		berInt(berInteger, ldapVersion),
+		berString(berOctetString, dn),
+		berString(ctxSimpleAuth, password),
+	))
+	if err != nil {
+		return fmt.Errorf("sending bind request: %v", err)
+	}
+
+	op, err := c.receive()
+	if err != nil {
+		return fmt.Errorf("reading bind response: %v", err)
+	}
+
+	if op.tag != appBindResponse {
+		return fmt.Errorf("unexpected response to bind request")
+	}
+
+	return ldapResult(op)
+}
+
+// read returns the requested attributes of the entry, keyed by their
+// lowercased name. Only the first value of each attribute is kept.
+func (c *ldapConn) read(dn string, attributes ...string) (map[string]string, error) {
+	var attrList [][]byte
+	for _, a := range attributes {
+		attrList = append(attrList, berString(berOctetString, a))
+	}
+
+	err := c.send(berEncode(appSearchRequest,
+		berString(berOctetString, dn),
+		berInt(berEnumerated, scopeBaseObject),
+		berInt(berEnumerated, derefNever),
+		berInt(berInteger, 1),
+		berInt(berInteger, 0),
+		berBool(false),
+		berString(ctxPresentFilter, "objectClass"),
+		berEncode(berSequence, attrList...),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("sending search request: %v", err)
+	}
+
+	result := make(map[string]string)
+	for {
+		op, err := c.receive()
+		if err != nil {
+			return nil, fmt.Errorf("reading search response: %v", err)
+		}
+
+		switch op.tag {
+		case appSearchEntry:
+			err = readEntry(op, result)
+			if err != nil {
+				return nil, err
+			}
+		case appSearchRef:
+			continue
+		case appSearchDone:
+			return result, ldapResult(op)
+		default:
+			return nil, fmt.Errorf("unexpected response to search request")
+		}
+	}
+}
+
+func readEntry(op berPacket, result map[string]string) error {
+	parts, err := op.children()
+	if err != nil || len(parts) < 2 {
+		return fmt.Errorf("malformed search entry")
+	}
+
+	attrs, err := parts[1].children()
+	if err != nil {
+		return fmt.Errorf("malformed search entry: %v", err)
+	}
+
+	for _, attr := range attrs {
+		typeAndVals, err := attr.children()
+		if err != nil || len(typeAndVals) < 2 {
+			return fmt.Errorf("malformed attribute")
+		}
+
+		vals, err := typeAndVals[1].children()
+		if err != nil {
+			return fmt.Errorf("malformed attribute values")
+		}
+
+		name := strings.ToLower(string(typeAndVals[0].value))
+		if len(vals) > 0 && result[name] == "" {
+			result[name] = string(vals[0].value)
+		}
+	}
+
+	return nil
+}
+
+// ldapResult converts the LDAPResult of a response into an error.
+func ldapResult(op berPacket) error {
+	parts, err := op.children()
+	if err != nil || len(parts) < 3 {
+		return fmt.Errorf("malformed ldap result")
+	}
+
+	switch code := parts[0].int(); code {
+	case resultSuccess:
+		return nil
+	case resultInvalidCred:
+		return ErrInvalidCredentials
+	default:
+		return fmt.Errorf("ldap error %d: %s", code, parts[2].value)
+	}
+}
+
+// escapeDN escapes the special characters of an attribute value that is
+// used within a distinguished name, as described in RFC 4514.
+func escapeDN(value string) string {
+	var b strings.Builder
+
+	for i, c := range []byte(value) {
+		switch {
+		case c == 0:
+			b.WriteString(`\00`)
+		case strings.IndexByte(`"+,;<>\=`, c) >= 0,
+			i == 0 && (c == ' ' || c == '#'),
+			i == len(value)-1 && c == ' ':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String()
+}
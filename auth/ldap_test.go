@@ -0,0 +1,236 @@
+package auth
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+type ldapUser struct {
+	password string
+	mail     string
+	cn       string
+}
+
+// fakeLDAP is a minimal in-process directory server that understands
+// simple binds and base object searches.
+type fakeLDAP struct {
+	ln    net.Listener
+	users map[string]ldapUser
+
+	// binds records the DNs that were sent in bind requests
+	binds chan string
+}
+
+func newFakeLDAP(t *testing.T, users map[string]ldapUser) *fakeLDAP {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed starting fake ldap server: %v", err)
+	}
+
+	f := &fakeLDAP{ln: ln, users: users, binds: make(chan string, 10)}
+	go f.serve()
+
+	return f
+}
+
+func (f *fakeLDAP) Close() {
+	f.ln.Close()
+}
+
+func (f *fakeLDAP) serve() {
+	for {
+		conn, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeLDAP) handle(conn net.Conn) {
+	defer conn.Close()
+
+	var (
+		r     = bufio.NewReader(conn)
+		bound string
+	)
+
+	for {
+		msg, err := readPacket(r)
+		if err != nil {
+			return
+		}
+
+		parts, err := msg.children()
+		if err != nil || len(parts) < 2 {
+			return
+		}
+
+		id, op := parts[0].int(), parts[1]
+		fields, _ := op.children()
+
+		switch op.tag {
+		case appBindRequest:
+			dn, password := string(fields[1].value), string(fields[2].value)
+			f.binds <- dn
+
+			code := resultInvalidCred
+			if user, ok := f.users[dn]; ok && user.password == password {
+				code = resultSuccess
+				bound = dn
+			}
+
+			conn.Write(ldapMessage(id, ldapResultOp(appBindResponse, code)))
+		case appSearchRequest:
+			base := string(fields[0].value)
+
+			user, ok := f.users[base]
+			if !ok || bound != base {
+				conn.Write(ldapMessage(id, ldapResultOp(appSearchDone, 32)))
+				continue
+			}
+
+			var attrs [][]byte
+			if user.mail != "" {
+				attrs = append(attrs, ldapAttr("mail", user.mail))
+			}
+			attrs = append(attrs, ldapAttr("cn", user.cn))
+
+			conn.Write(ldapMessage(id, berEncode(appSearchEntry,
+				berString(berOctetString, base),
+				berEncode(berSequence, attrs...),
+			)))
+			conn.Write(ldapMessage(id, ldapResultOp(appSearchDone, resultSuccess)))
+		case appUnbindRequest:
+			return
+		}
+	}
+}
+
+func ldapMessage(id int, op []byte) []byte {
+	return berEncode(berSequence, berInt(berInteger, id), op)
+}
+
+func ldapResultOp(tag byte, code int) []byte {
+	return berEncode(tag,
+		berInt(berEnumerated, code),
+		berString(berOctetString, ""),
+		berString(berOctetString, "diagnostic"),
+	)
+}
+
+func ldapAttr(name, value string) []byte {
+	return berEncode(berSequence,
+		berString(berOctetString, name),
+		berEncode(berSet, berString(berOctetString, value)),
+	)
+}
+
+func TestLDAPAuthenticate(t *testing.T) {
+	server := newFakeLDAP(t, map[string]ldapUser{
+		"uid=jdoe,ou=people,dc=example,dc=com":   {"secret", "john.doe@example.com", "John Doe"},
+		"uid=nomail,ou=people,dc=example,dc=com": {"secret", "", "No Mail"},
+	})
+	defer server.Close()
+
+	l := &LDAP{Addr: server.ln.Addr().String(), UserDN: "uid=%s,ou=people,dc=example,dc=com"}
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		want     Identity
+		wantErr  bool
+	}{
+		{"valid", "jdoe", "secret", Identity{Email: "john.doe@example.com", Name: "John Doe"}, false},
+		{"wrong password", "jdoe", "wrong", Identity{}, true},
+		{"unknown user", "nobody", "secret", Identity{}, true},
+		{"empty password", "jdoe", "", Identity{}, true},
+		{"no mail attribute", "nomail", "secret", Identity{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := l.Authenticate(tt.username, tt.password)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Authenticate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("Authenticate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLDAPAuthenticateEscapesUsername(t *testing.T) {
+	server := newFakeLDAP(t, map[string]ldapUser{})
+	defer server.Close()
+
+	l := &LDAP{Addr: server.ln.Addr().String(), UserDN: "uid=%s,ou=people,dc=example,dc=com"}
+
+	_, err := l.Authenticate("admin,ou=admins", "secret")
+	if err != ErrInvalidCredentials {
+		t.Errorf("Authenticate() error = %v, want %v", err, ErrInvalidCredentials)
+	}
+
+	if dn := <-server.binds; dn != `uid=admin\,ou\=admins,ou=people,dc=example,dc=com` {
+		t.Errorf("bound as %q, username was not escaped", dn)
+	}
+}
+
+func TestLDAPLoginAndVerify(t *testing.T) {
+	server := newFakeLDAP(t, map[string]ldapUser{
+		"uid=jdoe,ou=people,dc=example,dc=com": {"secret", "john.doe@example.com", "John Doe"},
+	})
+	defer server.Close()
+
+	l := &LDAP{Addr: server.ln.Addr().String(), UserDN: "uid=%s,ou=people,dc=example,dc=com"}
+
+	form := url.Values{"username": {"jdoe"}, "password": {"secret"}}
+	r, _ := http.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	identity, err := l.Login(r)
+	if err != nil || identity.Email != "john.doe@example.com" {
+		t.Errorf("Login() = %v, %v", identity, err)
+	}
+
+	r, _ = http.NewRequest(http.MethodGet, "/api/databases", nil)
+
+	_, err = l.Verify(r)
+	if err != ErrNoCredentials {
+		t.Errorf("Verify() without credentials error = %v, want %v", err, ErrNoCredentials)
+	}
+
+	r.SetBasicAuth("jdoe", "secret")
+
+	identity, err = l.Verify(r)
+	if err != nil || identity.Email != "john.doe@example.com" {
+		t.Errorf("Verify() = %v, %v", identity, err)
+	}
+}
+
+func Test_escapeDN(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"jdoe", "jdoe"},
+		{"doe, john", `doe\, john`},
+		{" lead", `\ lead`},
+		{"trail ", `trail\ `},
+		{"#hash", `\#hash`},
+		{"a+b=c", `a\+b\=c`},
+		{"nul\x00", `nul\00`},
+	}
+	for _, tt := range tests {
+		if got := escapeDN(tt.value); got != tt.want {
+			t.Errorf("escapeDN(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// OIDC authenticates users with the OpenID Connect authorization code
+// flow. API requests can present an ID token issued to the same client
+// as a bearer token.
+type OIDC struct {
+	// Issuer is the URL of the provider, used for discovery and checked
+	// against the "iss" claim of the ID tokens.
+	Issuer string
+
+	ClientID     string
+	ClientSecret string
+
+	// RedirectURL is the callback address registered with the provider.
+	RedirectURL string
+
+	// Scopes are requested in addition to "openid", defaults to "email profile".
+	Scopes []string
+
+	// Client is used to talk to the provider, http.DefaultClient if nil.
+	Client *http.Client
+
+	mu        sync.Mutex
+	provider  oidcProvider
+	keys      map[string]*rsa.PublicKey
+	keysFetch time.Time
+}
+
+type oidcProvider struct {
+	Issuer        string `json:"issuer"`
+	AuthEndpoint  string `json:"authorization_endpoint"`
+	TokenEndpoint string `json:"token_endpoint"`
+	JWKSURI       string `json:"jwks_uri"`
+}
+
+// minKeyRefresh limits how often the keys are refetched when an ID token
+// is signed with a key we don't know about.
+const minKeyRefresh = time.Minute
+
+// Name returns "oidc".
+func (o *OIDC) Name() string {
+	return "oidc"
+}
+
+// Discover reads the configuration and the signing keys of the provider.
+// It has to be called before the authenticator is used.
+func (o *OIDC) Discover() error {
+	var provider oidcProvider
+
+	err := o.getJSON(strings.TrimSuffix(o.Issuer, "/")+"/.well-known/openid-configuration", &provider)
+	if err != nil {
+		return fmt.Errorf("discovering provider: %v", err)
+	}
+
+	if provider.Issuer != o.Issuer {
+		return fmt.Errorf("provider reports issuer %q, expected %q", provider.Issuer, o.Issuer)
+	}
+
+	if provider.AuthEndpoint == "" || provider.TokenEndpoint == "" || provider.JWKSURI == "" {
+		return fmt.Errorf("provider configuration is missing endpoints")
+	}
+
+	o.mu.Lock()
+	o.provider = provider
+	o.mu.Unlock()
+
+	return o.refreshKeys()
+}
+
+// LoginURL returns the authorization endpoint of the provider.
+func (o *OIDC) LoginURL(state string) string {
+	scopes := o.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"email", "profile"}
+	}
+
+	params := url.Values{
+		"response_type": {"code"},
+		"client_id":     {o.ClientID},
+		"redirect_uri":  {o.RedirectURL},
+		"scope":         {"openid " + strings.Join(scopes, " ")},
+		"state":         {state},
+	}
+
+	o.mu.Lock()
+	endpoint := o.provider.AuthEndpoint
+	o.mu.Unlock()
+
+	sep := "?"
+	if strings.Contains(endpoint, "?") {
+		sep = "&"
+	}
+
+	return endpoint + sep + params.Encode()
+}
+
+// Login exchanges the authorization code of the callback for an ID token
+// and returns the identity it was issued for.
+func (o *OIDC) Login(r *http.Request) (Identity, error) {
+	if e := r.FormValue("error"); e != "" {
+		return Identity{}, fmt.Errorf("provider returned error: %s %s", e, r.FormValue("error_description"))
+	}
+
+	code := r.FormValue("code")
+	if code == "" {
+		return Identity{}, ErrInvalidCredentials
+	}
+
+	o.mu.Lock()
+	endpoint := o.provider.TokenEndpoint
+	o.mu.Unlock()
+
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {o.RedirectURL},
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Identity{}, fmt.Errorf("creating token request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(o.ClientID), url.QueryEscape(o.ClientSecret))
+
+	resp, err := o.client().Do(req)
+	if err != nil {
+		return Identity{}, fmt.Errorf("exchanging code: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Identity{}, fmt.Errorf("exchanging code: token endpoint returned %s", resp.Status)
+	}
+
+	var token struct {
+		IDToken string `json:"id_token"`
+	}
+
+	err = json.NewDecoder(resp.Body).Decode(&token)
+	if err != nil {
+		return Identity{}, fmt.Errorf("decoding token response: %v", err)
+	}
+
+	if token.IDToken == "" {
+		return Identity{}, fmt.Errorf("token response has no id_token")
+	}
+
+	return o.verifyIDToken(token.IDToken)
+}
+
+// Verify checks the ID token sent as the bearer token of the request.
+func (o *OIDC) Verify(r *http.Request) (Identity, error) {
+	auth := r.Header.Get("Authorization")
+
+	parts := strings.SplitN(auth, " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		return Identity{}, ErrNoCredentials
+	}
+
+	raw := strings.TrimSpace(parts[1])
+	if strings.Count(raw, ".") != 2 {
+		return Identity{}, ErrNoCredentials
+	}
+
+	return o.verifyIDToken(raw)
+}
+
+func (o *OIDC) verifyIDToken(raw string) (Identity, error) {
+	claims := jwt.MapClaims{}
+
+	_, err := jwt.ParseWithClaims(raw, claims, o.key)
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+
+	if !claims.VerifyIssuer(o.Issuer, true) {
+		return Identity{}, fmt.Errorf("%w: unexpected issuer", ErrInvalidCredentials)
+	}
+
+	if !hasAudience(claims["aud"], o.ClientID) {
+		return Identity{}, fmt.Errorf("%w: token was issued to another client", ErrInvalidCredentials)
+	}
+
+	if _, ok := claims["exp"]; !ok {
+		return Identity{}, fmt.Errorf("%w: token has no expiry", ErrInvalidCredentials)
+	}
+
+	email, _ := claims["email"].(string)
+	if email == "" {
+		return Identity{}, fmt.Errorf("%w: token has no email claim", ErrInvalidCredentials)
+	}
+
+	if verified, ok := claims["email_verified"].(bool); ok && !verified {
+		return Identity{}, fmt.Errorf("%w: email %s is not verified", ErrInvalidCredentials, email)
+	}
+
+	name, _ := claims["name"].(string)
+
+	return Identity{Email: email, Name: name}, nil
+}
+
+// key returns the public key the token was signed with.
+func (o *OIDC) key(token *jwt.Token) (interface{}, error) {
+	if token.Method != jwt.SigningMethodRS256 {
+		return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+	}
+
+	kid, _ := token.Header["kid"].(string)
+
+	o.mu.Lock()
+	key, ok := o.keys[kid]
+	stale := time.Since(o.keysFetch) > minKeyRefresh
+	o.mu.Unlock()
+
+	if ok {
+		return key, nil
+	}
+
+	if !stale {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	// The provider might have rotated its keys.
+	err := o.refreshKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	o.mu.Lock()
+	key, ok = o.keys[kid]
+	o.mu.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	return key, nil
+}
+
+func (o *OIDC) refreshKeys() error {
+	o.mu.Lock()
+	jwksURI := o.provider.JWKSURI
+	o.keysFetch = time.Now()
+	o.mu.Unlock()
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+
+	err := o.getJSON(jwksURI, &jwks)
+	if err != nil {
+		return fmt.Errorf("fetching signing keys: %v", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return fmt.Errorf("decoding modulus of key %q: %v", k.Kid, err)
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return fmt.Errorf("decoding exponent of key %q: %v", k.Kid, err)
+		}
+
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	o.mu.Lock()
+	o.keys = keys
+	o.mu.Unlock()
+
+	return nil
+}
+
+func (o *OIDC) getJSON(url string, v interface{}) error {
+	resp, err := o.client().Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (o *OIDC) client() *http.Client {
+	if o.Client != nil {
+		return o.Client
+	}
+
+	return http.DefaultClient
+}
+
+func hasAudience(aud interface{}, clientID string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == clientID
+	case []interface{}:
+		for _, a := range aud {
+			if a == clientID {
+				return true
+			}
+		}
+	}
+
+	return false
+}
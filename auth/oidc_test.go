@@ -0,0 +1,255 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+const (
+	testClientID     = "ddn"
+	testClientSecret = "client-secret"
+	testCode         = "valid-code"
+)
+
+// fakeIssuer is an OpenID Connect provider that issues ID tokens for
+// a single authorization code.
+type fakeIssuer struct {
+	*httptest.Server
+
+	key    *rsa.PrivateKey
+	claims jwt.MapClaims
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed generating key: %v", err)
+	}
+
+	f := &fakeIssuer{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 f.URL,
+			"authorization_endpoint": f.URL + "/authorize",
+			"token_endpoint":         f.URL + "/token",
+			"jwks_uri":               f.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test-key",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if id != testClientID || secret != testClientSecret || r.PostFormValue("code") != testCode {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "access",
+			"id_token":     f.sign(t, "test-key", f.claims),
+		})
+	})
+
+	f.Server = httptest.NewServer(mux)
+	f.claims = f.validClaims()
+
+	return f
+}
+
+func (f *fakeIssuer) validClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":            f.URL,
+		"aud":            testClientID,
+		"sub":            "1234",
+		"email":          "john.doe@example.com",
+		"email_verified": true,
+		"name":           "John Doe",
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func (f *fakeIssuer) sign(t *testing.T, kid string, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+
+	signed, err := token.SignedString(f.key)
+	if err != nil {
+		t.Fatalf("failed signing token: %v", err)
+	}
+
+	return signed
+}
+
+func newTestOIDC(t *testing.T, issuer *fakeIssuer) *OIDC {
+	o := &OIDC{
+		Issuer:       issuer.URL,
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		RedirectURL:  "http://ddn.example.com/login/callback",
+	}
+
+	err := o.Discover()
+	if err != nil {
+		t.Fatalf("Discover() failed: %v", err)
+	}
+
+	return o
+}
+
+func TestOIDCLoginURL(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	defer issuer.Close()
+
+	o := newTestOIDC(t, issuer)
+
+	u, err := url.Parse(o.LoginURL("some-state"))
+	if err != nil {
+		t.Fatalf("LoginURL() is not a valid url: %v", err)
+	}
+
+	if got := u.Scheme + "://" + u.Host + u.Path; got != issuer.URL+"/authorize" {
+		t.Errorf("LoginURL() points to %q", got)
+	}
+
+	q := u.Query()
+	if q.Get("state") != "some-state" || q.Get("client_id") != testClientID || q.Get("response_type") != "code" {
+		t.Errorf("LoginURL() has unexpected parameters: %v", q)
+	}
+
+	if !strings.HasPrefix(q.Get("scope"), "openid") {
+		t.Errorf("LoginURL() does not request the openid scope: %q", q.Get("scope"))
+	}
+}
+
+func TestOIDCLogin(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	defer issuer.Close()
+
+	o := newTestOIDC(t, issuer)
+
+	callback := func(code string) *http.Request {
+		r, _ := http.NewRequest(http.MethodGet, "/login/callback?state=x&code="+code, nil)
+		return r
+	}
+
+	identity, err := o.Login(callback(testCode))
+	if err != nil {
+		t.Fatalf("Login() failed: %v", err)
+	}
+
+	if identity.Email != "john.doe@example.com" || identity.Name != "John Doe" {
+		t.Errorf("Login() = %v", identity)
+	}
+
+	_, err = o.Login(callback("invalid-code"))
+	if err == nil {
+		t.Errorf("Login() with invalid code succeeded")
+	}
+
+	r, _ := http.NewRequest(http.MethodGet, "/login/callback?error=access_denied", nil)
+
+	_, err = o.Login(r)
+	if err == nil {
+		t.Errorf("Login() with provider error succeeded")
+	}
+}
+
+func TestOIDCVerify(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	defer issuer.Close()
+
+	o := newTestOIDC(t, issuer)
+
+	modified := func(key string, value interface{}) jwt.MapClaims {
+		claims := issuer.validClaims()
+		if value == nil {
+			delete(claims, key)
+		} else {
+			claims[key] = value
+		}
+
+		return claims
+	}
+
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+	forged := jwt.NewWithClaims(jwt.SigningMethodRS256, issuer.validClaims())
+	forged.Header["kid"] = "test-key"
+	forgedToken, _ := forged.SignedString(other)
+
+	hmacToken, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, issuer.validClaims()).SignedString([]byte("secret"))
+
+	tests := []struct {
+		name    string
+		header  string
+		wantErr error
+	}{
+		{"valid", "Bearer " + issuer.sign(t, "test-key", issuer.validClaims()), nil},
+		{"audience list", "Bearer " + issuer.sign(t, "test-key", modified("aud", []string{"other", testClientID})), nil},
+		{"no header", "", ErrNoCredentials},
+		{"ddn token", "Bearer ddn_abc", ErrNoCredentials},
+		{"expired", "Bearer " + issuer.sign(t, "test-key", modified("exp", time.Now().Add(-time.Minute).Unix())), ErrInvalidCredentials},
+		{"no expiry", "Bearer " + issuer.sign(t, "test-key", modified("exp", nil)), ErrInvalidCredentials},
+		{"wrong issuer", "Bearer " + issuer.sign(t, "test-key", modified("iss", "https://evil.example.com")), ErrInvalidCredentials},
+		{"wrong audience", "Bearer " + issuer.sign(t, "test-key", modified("aud", "other")), ErrInvalidCredentials},
+		{"unverified email", "Bearer " + issuer.sign(t, "test-key", modified("email_verified", false)), ErrInvalidCredentials},
+		{"unknown key", "Bearer " + issuer.sign(t, "other-key", issuer.validClaims()), ErrInvalidCredentials},
+		{"forged signature", "Bearer " + forgedToken, ErrInvalidCredentials},
+		{"hmac signed", "Bearer " + hmacToken, ErrInvalidCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := http.NewRequest(http.MethodGet, "/api/databases", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+
+			identity, err := o.Verify(r)
+			if tt.wantErr == nil {
+				if err != nil || identity.Email != "john.doe@example.com" {
+					t.Errorf("Verify() = %v, %v", identity, err)
+				}
+				return
+			}
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Verify() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOIDCDiscoverIssuerMismatch(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	defer issuer.Close()
+
+	o := &OIDC{Issuer: issuer.URL + "/", ClientID: testClientID}
+
+	err := o.Discover()
+	if err == nil {
+		t.Errorf("Discover() accepted a provider reporting a different issuer")
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/djavorszky/ddn-api/registry"
+	"github.com/djavorszky/ddn-common/errs"
+	"github.com/djavorszky/ddn-common/inet"
+	"github.com/djavorszky/ddn-common/logger"
+)
+
+// What to do with imports whose dump doesn't fit in the free space of the
+// agent.
+const (
+	capacityRefuse = "refuse"
+	capacityWarn   = "warn"
+)
+
+// capacityError is returned if the dump of an import is larger than the
+// free space the agent reported.
+type capacityError struct {
+	Agent    string
+	DumpSize int64
+	DiskFree int64
+}
+
+func (e capacityError) Error() string {
+	return fmt.Sprintf("dump of %d MB doesn't fit in the %d MB free on agent %s", e.DumpSize/megabyte, e.DiskFree/megabyte, e.Agent)
+}
+
+// exceedsCapacity tells whether the dump is larger than the free space the
+// agent reported. Agents that never reported their capacity, and dumps of
+// unknown size, are given the benefit of the doubt.
+func exceedsCapacity(agent registry.Agent, size int64) bool {
+	return agent.Capacity != nil && size > 0 && size > agent.Capacity.DiskFree
+}
+
+// refusingCapacity tells whether imports that exceed the capacity of their
+// agent are refused, or only warned about.
+func refusingCapacity() bool {
+	switch config.CapacityCheck {
+	case "", capacityRefuse:
+		return true
+	case capacityWarn:
+		return false
+	}
+
+	logger.Warn("Invalid capacity-check %q, using %q", config.CapacityCheck, capacityRefuse)
+
+	return true
+}
+
+// checkCapacity returns a capacityError if the dump doesn't fit on the
+// agent and capacity-check refuses such imports. Otherwise it only logs
+// it.
+func checkCapacity(agent registry.Agent, size int64) error {
+	if !exceedsCapacity(agent, size) {
+		return nil
+	}
+
+	err := capacityError{Agent: agent.ShortName, DumpSize: size, DiskFree: agent.Capacity.DiskFree}
+
+	if refusingCapacity() {
+		return err
+	}
+
+	logger.Warn("Importing anyway: %v", err)
+
+	return nil
+}
+
+// sendCapacityFailure responds to an API request that failed
+// checkCapacity.
+func sendCapacityFailure(w http.ResponseWriter, err error) {
+	if ce, ok := err.(capacityError); ok {
+		inet.SendFailure(w, http.StatusInsufficientStorage, errInsufficientSpace, ce.Agent, fmt.Sprintf("%d", ce.DiskFree))
+		return
+	}
+
+	inet.SendFailure(w, http.StatusInternalServerError, errs.QueryFailed, err.Error())
+}
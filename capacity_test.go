@@ -0,0 +1,113 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/djavorszky/ddn-api/database/data"
+	"github.com/djavorszky/ddn-api/registry"
+	"github.com/djavorszky/ddn-common/model"
+)
+
+func Test_exceedsCapacity(t *testing.T) {
+	reported := registry.Agent{Capacity: &registry.Capacity{DiskFree: 10 * megabyte}}
+
+	tests := []struct {
+		name  string
+		agent registry.Agent
+		size  int64
+		want  bool
+	}{
+		{"fits", reported, 5 * megabyte, false},
+		{"exactly fits", reported, 10 * megabyte, false},
+		{"too large", reported, 11 * megabyte, true},
+		{"unknown size", reported, 0, false},
+		{"never reported", registry.Agent{}, 11 * megabyte, false},
+	}
+	for _, tt := range tests {
+		if got := exceedsCapacity(tt.agent, tt.size); got != tt.want {
+			t.Errorf("%s: exceedsCapacity() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCapacity(t *testing.T) {
+	ts := newTestServer(t)
+
+	dir, err := ioutil.TempDir("", "capacity")
+	if err != nil {
+		t.Fatalf("creating mount folder: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "dump.sql"), make([]byte, 2048), 0644); err != nil {
+		t.Fatalf("writing dump: %v", err)
+	}
+
+	config.MountLoc = dir
+	config.HealthInterval = "10ms"
+
+	small := ts.startAgent("small")
+	small.reports(registry.Capacity{DiskFree: 1024, Databases: 3, ActiveImports: 1, ServerVersion: "5.7.21"})
+
+	large := ts.startAgent("large")
+	large.reports(registry.Capacity{DiskFree: 1 << 30, ServerVersion: "5.6.40"})
+
+	stop := startHealthChecks()
+	defer stop()
+
+	token := ts.login("user@example.com").issueToken("capacity")
+
+	waitFor(t, "the capacity reports", func() bool {
+		var agents []registry.Agent
+
+		ts.api(http.MethodGet, "/api/agents", token, nil, &agents)
+
+		for _, agent := range agents {
+			if agent.Capacity == nil {
+				return false
+			}
+		}
+
+		return len(agents) == 2
+	})
+
+	a, _ := registry.Get("small")
+	if c := a.Capacity; c.DiskFree != 1024 || c.Databases != 3 || c.ActiveImports != 1 || c.Reported.IsZero() {
+		t.Errorf("capacity of the small agent = %+v", c)
+	}
+
+	importOn := func(req apiDatabaseRequest) int {
+		req.DumpLocation = "/dump.sql"
+
+		return ts.api(http.MethodPost, "/api/databases/import", token, req, nil)
+	}
+
+	if code := importOn(apiDatabaseRequest{ClientRequest: model.ClientRequest{AgentIdentifier: "small"}}); code != http.StatusInsufficientStorage {
+		t.Errorf("importing on a full agent: got %d, want %d", code, http.StatusInsufficientStorage)
+	}
+
+	// Placement goes by the server version the agents reported, and skips
+	// the ones the dump doesn't fit on.
+	if code := importOn(apiDatabaseRequest{placementRequest: placementRequest{Vendor: "mysql", Version: "5.7"}}); code != http.StatusServiceUnavailable {
+		t.Errorf("placing on a full agent: got %d, want %d", code, http.StatusServiceUnavailable)
+	}
+
+	var row data.Row
+
+	if code := ts.api(http.MethodPost, "/api/databases/import", token, apiDatabaseRequest{
+		ClientRequest:    model.ClientRequest{DumpLocation: "/dump.sql"},
+		placementRequest: placementRequest{Vendor: "mysql", Strategy: placeMostFree},
+	}, &row); code != http.StatusAccepted || row.AgentName != "large" {
+		t.Errorf("placing on the most free agent: got %d on %q, want %d on %q", code, row.AgentName, http.StatusAccepted, "large")
+	}
+
+	config.CapacityCheck = capacityWarn
+
+	if code := importOn(apiDatabaseRequest{ClientRequest: model.ClientRequest{AgentIdentifier: "small"}}); code != http.StatusAccepted {
+		t.Errorf("importing on a full agent with warnings only: got %d, want %d", code, http.StatusAccepted)
+	}
+}
@@ -6,30 +6,81 @@ import (
 
 // Config to hold the database server and ddn server configuration
 type Config struct {
-	DBAddress         string   `toml:"db-addr" required:"true"`
-	DBUser            string   `toml:"db-username" required:"true"`
-	DBPass            string   `toml:"db-userpass"`
-	DBName            string   `toml:"db-name" required:"true"`
-	ServerHost        string   `toml:"server-host" required:"true"`
-	SMTPAddr          string   `toml:"smtp-host"`
-	SMTPUser          string   `toml:"smtp-user"`
-	SMTPPass          string   `toml:"smtp-password"`
-	EmailSender       string   `toml:"email-sender"`
-	AdminEmail        []string `toml:"admin-emails"`
-	MountLoc          string   `toml:"mount-loc"`
-	WebPushEnabled    bool     `toml:"webpush-enabled"`
-	WebPushSubscriber string   `toml:"webpush-subscriber"`
-	VAPIDPrivateKey   string   `toml:"vapid-private-key"`
-	GoogleAnalyticsID string   `toml:"google-analytics-id"`
-	LogLevel          string   `toml:"log-level"`
-	StartupDelay      string   `toml:"startup-delay"`
+	DBProvider            string            `toml:"db-provider"`
+	DBAddress             string            `toml:"db-addr" required:"true"`
+	DBUser                string            `toml:"db-username"`
+	DBPass                string            `toml:"db-userpass"`
+	DBName                string            `toml:"db-name"`
+	DBSSLMode             string            `toml:"db-sslmode"`
+	ServerHost            string            `toml:"server-host" required:"true"`
+	SMTPAddr              string            `toml:"smtp-host"`
+	SMTPUser              string            `toml:"smtp-user"`
+	SMTPPass              string            `toml:"smtp-password"`
+	EmailSender           string            `toml:"email-sender"`
+	AdminEmail            []string          `toml:"admin-emails"`
+	MountLoc              string            `toml:"mount-loc"`
+	WebPushEnabled        bool              `toml:"webpush-enabled"`
+	WebPushSubscriber     string            `toml:"webpush-subscriber"`
+	VAPIDPrivateKey       string            `toml:"vapid-private-key"`
+	GoogleAnalyticsID     string            `toml:"google-analytics-id"`
+	LogLevel              string            `toml:"log-level"`
+	StartupDelay          string            `toml:"startup-delay"`
+	APITokenLifetime      string            `toml:"api-token-lifetime"`
+	SessionSecret         string            `toml:"session-secret"`
+	SessionLifetime       string            `toml:"session-lifetime"`
+	SecureCookies         bool              `toml:"secure-cookies"`
+	AuthProvider          string            `toml:"auth-provider"`
+	InsecureEmailLogin    bool              `toml:"insecure-email-login"`
+	LDAPAddr              string            `toml:"ldap-addr"`
+	LDAPTLS               bool              `toml:"ldap-tls"`
+	LDAPUserDN            string            `toml:"ldap-user-dn"`
+	LDAPMailAttr          string            `toml:"ldap-mail-attr"`
+	OIDCIssuer            string            `toml:"oidc-issuer"`
+	OIDCClientID          string            `toml:"oidc-client-id"`
+	OIDCClientSecret      string            `toml:"oidc-client-secret"`
+	OIDCRedirectURL       string            `toml:"oidc-redirect-url"`
+	AgentSecret           string            `toml:"agent-secret"`
+	AgentRequireSignature bool              `toml:"agent-require-signature"`
+	DefaultRole           string            `toml:"default-role"`
+	QuotaUserDatabases    int               `toml:"quota-user-databases"`
+	QuotaUserImports      int               `toml:"quota-user-imports"`
+	QuotaUserDumpSize     int64             `toml:"quota-user-dump-size-mb"`
+	QuotaGroupDatabases   int               `toml:"quota-group-databases"`
+	QuotaGroupImports     int               `toml:"quota-group-imports"`
+	QuotaGroupDumpSize    int64             `toml:"quota-group-dump-size-mb"`
+	QuotaAgentDatabases   int               `toml:"quota-agent-databases"`
+	QuotaAgentImports     int               `toml:"quota-agent-imports"`
+	QuotaAgentDumpSize    int64             `toml:"quota-agent-dump-size-mb"`
+	CredentialKeys        []string          `toml:"credential-keys"`
+	JobWorkers            int               `toml:"job-workers"`
+	JobAttempts           int               `toml:"job-attempts"`
+	StuckTimeouts         map[string]string `toml:"stuck-timeouts"`
+	InventoryIgnore       []string          `toml:"inventory-ignore"`
+	InventoryRemoveGhosts bool              `toml:"inventory-remove-ghosts"`
+	MaintenanceTime       string            `toml:"maintenance-time"`
+	Placement             string            `toml:"placement"`
+	HealthInterval        string            `toml:"health-interval"`
+	HealthTimeout         string            `toml:"health-timeout"`
+	HealthFailures        int               `toml:"health-failures"`
+	HealthRecoveries      int               `toml:"health-recoveries"`
+	HealthAlertCooldown   string            `toml:"health-alert-cooldown"`
+	CapacityCheck         string            `toml:"capacity-check"`
+	ExpiryPolicies        []ExpiryPolicy    `toml:"expiry-policy"`
 }
 
 // Print prints the configuration to the log.
 func (c Config) Print() {
-	logger.Info("Database Address:\t\t%s", c.DBAddress)
-	logger.Info("Database User:\t\t%s", c.DBUser)
-	logger.Info("Database Name:\t\t%s", c.DBName)
+	if c.DBProvider != "" {
+		logger.Info("Database Provider:\t\t%s", c.DBProvider)
+	}
+
+	if c.DBProvider == "sqlite" {
+		logger.Info("Database File:\t\t%s", c.DBAddress)
+	} else {
+		logger.Info("Database Address:\t\t%s", c.DBAddress)
+		logger.Info("Database User:\t\t%s", c.DBUser)
+		logger.Info("Database Name:\t\t%s", c.DBName)
+	}
 
 	logger.Info("Server Host:\t\t%s", c.ServerHost)
 
@@ -38,6 +89,10 @@ func (c Config) Print() {
 		logger.Info("Server configured to send emails.")
 	}
 
+	if c.AuthProvider != "" {
+		logger.Info("Authentication:\t\t%s", c.AuthProvider)
+	}
+
 	if c.GoogleAnalyticsID != "" {
 		logger.Info("Google analytics enabled.")
 	}
@@ -0,0 +1,31 @@
+package data
+
+import "github.com/djavorszky/ddn-common/model"
+
+// Modes of the agents. Agents that are draining or in maintenance take no
+// new databases, but finish the work they have.
+const (
+	ModeActive      = "active"
+	ModeDraining    = "draining"
+	ModeMaintenance = "maintenance"
+)
+
+// Sources of the labels of the agents.
+const (
+	LabelSourceAgent = "agent"
+	LabelSourceAdmin = "admin"
+)
+
+// Agent is a registered agent as persisted, along with the state the
+// server keeps about it across restarts.
+type Agent struct {
+	model.Agent
+
+	Mode string `json:"agent_mode"`
+
+	// Labels are the ones the agent registered with. LabelOverrides are
+	// set by the admins and take precedence, an empty value removing the
+	// label.
+	Labels         map[string]string `json:"labels"`
+	LabelOverrides map[string]string `json:"label_overrides"`
+}
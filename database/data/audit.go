@@ -0,0 +1,33 @@
+package data
+
+import "time"
+
+// AuditEntry is a record of a state-changing action. The status fields
+// are only set for actions on databases.
+type AuditEntry struct {
+	ID           int       `json:"id"`
+	Time         time.Time `json:"time"`
+	Actor        string    `json:"actor"`
+	Action       string    `json:"action"`
+	Target       int       `json:"target"`
+	TargetName   string    `json:"target_name"`
+	Agent        string    `json:"agent"`
+	StatusBefore int       `json:"status_before"`
+	StatusAfter  int       `json:"status_after"`
+	SourceIP     string    `json:"source_ip"`
+	Details      string    `json:"details"`
+}
+
+// AuditFilter selects the audit entries to fetch. Empty fields match
+// every entry.
+type AuditFilter struct {
+	Actor  string
+	Action string
+	Agent  string
+	Target int
+	From   time.Time
+	To     time.Time
+
+	Offset int
+	Limit  int
+}
@@ -12,11 +12,12 @@ type Row struct {
 	DBVendor   string    `json:"vendor"`
 	DBName     string    `json:"dbname"`
 	DBUser     string    `json:"dbuser"`
-	DBPass     string    `json:"dbpass"`
+	DBPass     string    `json:"dbpass,omitempty"`
 	DBSID      string    `json:"sid"`
 	Dumpfile   string    `json:"dumplocation"`
 	CreateDate time.Time `json:"createdate"`
 	ExpiryDate time.Time `json:"expirydate"`
+	UpdateDate time.Time `json:"updatedate"`
 	Creator    string    `json:"creator"`
 	AgentName  string    `json:"agent"`
 	DBAddress  string    `json:"dbaddress"`
@@ -26,6 +27,10 @@ type Row struct {
 	Comment    string    `json:"comment"`
 	Message    string    `json:"message"`
 	Public     int       `json:"public"`
+	Group      string    `json:"group"`
+	DumpSize   int64     `json:"dumpsize"`
+	Extensions int       `json:"extensions"`
+	Warned     int       `json:"warned"`
 }
 
 // InProgress returns true if the DBEntry's status denotes that something's in progress.
@@ -0,0 +1,27 @@
+package data
+
+import "time"
+
+// GroupVisibility is the visibility of databases that are shared with the
+// members of their group. It extends the private and public levels of
+// ddn-common/visibility.
+const GroupVisibility = 2
+
+// Group is a named set of users that databases can be shared with.
+type Group struct {
+	Name       string    `json:"name"`
+	CreateDate time.Time `json:"createdate"`
+	CreatedBy  string    `json:"createdby"`
+	Members    []string  `json:"members"`
+}
+
+// HasMember returns true if the user is a member of the group.
+func (g Group) HasMember(email string) bool {
+	for _, member := range g.Members {
+		if member == email {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,56 @@
+package data
+
+import "time"
+
+// Kinds of jobs.
+const (
+	JobImport   = "import"
+	JobDrop     = "drop"
+	JobRecreate = "recreate"
+	JobExport   = "export"
+)
+
+// Statuses of jobs. Queued jobs wait for their next run, running ones are
+// executed by a worker right now, the others are done.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// Job is an operation on a database that runs in the background. It is
+// persisted so that it survives restarts of the server, and is retried
+// until it succeeds or runs out of attempts.
+type Job struct {
+	ID          int       `json:"id"`
+	Kind        string    `json:"kind"`
+	Target      int       `json:"target"`
+	TargetName  string    `json:"target_name"`
+	Payload     string    `json:"payload"`
+	Creator     string    `json:"creator"`
+	Status      string    `json:"status"`
+	Attempts    int       `json:"attempts"`
+	MaxAttempts int       `json:"max_attempts"`
+	LastError   string    `json:"last_error"`
+	NextRun     time.Time `json:"next_run"`
+	CreateDate  time.Time `json:"createdate"`
+	UpdateDate  time.Time `json:"updatedate"`
+}
+
+// Done returns true if the job will not run again unless it's retried.
+func (job Job) Done() bool {
+	return job.Status == JobSucceeded || job.Status == JobFailed || job.Status == JobCancelled
+}
+
+// JobFilter selects the jobs to fetch. Empty fields match every job.
+type JobFilter struct {
+	Creator string
+	Kind    string
+	Status  string
+	Target  int
+
+	Offset int
+	Limit  int
+}
@@ -0,0 +1,73 @@
+package data
+
+import "time"
+
+// Fields the databases can be sorted by.
+const (
+	SortByID         = "id"
+	SortByName       = "dbname"
+	SortByCreateDate = "createdate"
+	SortByExpiryDate = "expirydate"
+)
+
+// Classes of the statuses of the databases, the same ranges the status
+// methods of Row check.
+const (
+	StatusClassInProgress  = "in_progress"
+	StatusClassOK          = "ok"
+	StatusClassClientError = "client_error"
+	StatusClassServerError = "server_error"
+	StatusClassError       = "error"
+	StatusClassWarning     = "warning"
+)
+
+// StatusRange returns the statuses in the class as the [min, max) range.
+// A max of 0 means there is no upper bound. ok is false for unknown
+// classes.
+func StatusRange(class string) (min, max int, ok bool) {
+	switch class {
+	case StatusClassInProgress:
+		return 0, 100, true
+	case StatusClassOK:
+		return 100, 200, true
+	case StatusClassClientError:
+		return 200, 300, true
+	case StatusClassServerError:
+		return 300, 400, true
+	case StatusClassError:
+		return 200, 400, true
+	case StatusClassWarning:
+		return 400, 0, true
+	}
+
+	return 0, 0, false
+}
+
+// DatabaseQuery selects, orders and pages the databases to fetch. Empty
+// fields match every entry.
+type DatabaseQuery struct {
+	// Restricted limits the entries to the ones readable by a user who
+	// isn't an admin: the public ones, the ones created by Reader, unless
+	// it's empty, and the ones shared with ReaderGroups.
+	Restricted   bool
+	Reader       string
+	ReaderGroups []string
+
+	Agent         string
+	Vendor        string
+	StatusClass   string
+	Creator       string
+	NameContains  string
+	ExpiresAfter  time.Time
+	ExpiresBefore time.Time
+
+	// Sort is one of the SortBy fields, SortByID by default. Entries with
+	// the same value are ordered by their ID, in the same direction.
+	Sort       string
+	Descending bool
+
+	// After continues a listing with the entries that come after it in
+	// the sort order. Only its ID and the sorted field are used.
+	After *Row
+	Limit int
+}
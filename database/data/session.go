@@ -0,0 +1,18 @@
+package data
+
+import "time"
+
+// Session represents a logged in web session. The ID is the hash of the
+// value stored in the user's cookie, never the value itself.
+type Session struct {
+	ID         string    `json:"-"`
+	User       string    `json:"user"`
+	CSRFToken  string    `json:"-"`
+	CreateDate time.Time `json:"createdate"`
+	ExpiryDate time.Time `json:"expirydate"`
+}
+
+// Expired returns true if the session can no longer be used.
+func (s Session) Expired() bool {
+	return time.Now().After(s.ExpiryDate)
+}
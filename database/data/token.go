@@ -0,0 +1,21 @@
+package data
+
+import "time"
+
+// Token represents an API token issued to a user. Only the hash of the
+// token is ever persisted, the plaintext value is shown once on creation.
+type Token struct {
+	ID         int       `json:"id"`
+	Name       string    `json:"name"`
+	Owner      string    `json:"owner"`
+	Hash       string    `json:"-"`
+	Prefix     string    `json:"prefix"`
+	CreateDate time.Time `json:"createdate"`
+	ExpiryDate time.Time `json:"expirydate"`
+	LastUsed   time.Time `json:"lastused"`
+}
+
+// Expired returns true if the token can no longer be used.
+func (t Token) Expired() bool {
+	return !t.ExpiryDate.IsZero() && time.Now().After(t.ExpiryDate)
+}
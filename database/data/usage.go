@@ -0,0 +1,16 @@
+package data
+
+// Scopes that the usage of databases can be counted in.
+const (
+	ScopeUser  = "user"
+	ScopeGroup = "group"
+	ScopeAgent = "agent"
+)
+
+// Usage is the number of databases and the size of their dumps that
+// belong to a user, a group or an agent.
+type Usage struct {
+	Databases int   `json:"databases"`
+	Imports   int   `json:"imports"`
+	DumpSize  int64 `json:"dump_size"`
+}
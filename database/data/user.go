@@ -0,0 +1,12 @@
+package data
+
+import "time"
+
+// User represents a user whose role was set explicitly. Users that are
+// not persisted get the default role.
+type User struct {
+	Email      string    `json:"email"`
+	Role       string    `json:"role"`
+	UpdateDate time.Time `json:"updatedate"`
+	UpdatedBy  string    `json:"updatedby"`
+}
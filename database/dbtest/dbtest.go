@@ -0,0 +1,1037 @@
+// Package dbtest is a conformance suite for the implementations of
+// database.BackendConnection, so that the server behaves the same no
+// matter which backend it runs on.
+package dbtest
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/djavorszky/ddn-api/database"
+	"github.com/djavorszky/ddn-api/database/data"
+	"github.com/djavorszky/ddn-api/database/dbutil"
+	"github.com/djavorszky/ddn-common/model"
+	webpush "github.com/sherclockholmes/webpush-go"
+)
+
+var gmt, _ = time.LoadLocation("GMT")
+
+// TestEntry is the database entry the suite starts from.
+var TestEntry = data.Row{
+	ID:         1,
+	DBName:     "testDB",
+	DBUser:     "testUser",
+	DBPass:     "testPass",
+	DBSID:      "testsid",
+	Dumpfile:   "testloc",
+	CreateDate: time.Now().In(gmt),
+	ExpiryDate: time.Now().In(gmt).AddDate(0, 0, 30),
+	Creator:    "test@gmail.com",
+	AgentName:  "mysql-55",
+	DBAddress:  "localhost",
+	DBPort:     "3306",
+	DBVendor:   "mysql",
+	Comment:    "This is just a comment somewhere",
+	Message:    "",
+	Status:     100,
+}
+
+type suite struct {
+	conn  database.BackendConnection
+	entry data.Row
+}
+
+// Run runs the suite against conn, which has to be connected to a freshly
+// prepared, empty database. The tests build on the data of the ones before
+// them, so they always run in order.
+func Run(t *testing.T, conn database.BackendConnection) {
+	s := &suite{conn: conn, entry: TestEntry}
+
+	tests := []struct {
+		name string
+		test func(t *testing.T)
+	}{
+		{"FetchByID", s.fetchByID},
+		{"FetchByDBNameAgent", s.fetchByDBNameAgent},
+		{"FetchByCreator", s.fetchByCreator},
+		{"Insert", s.insert},
+		{"Update", s.update},
+		{"Delete", s.delete},
+		{"FetchPublic", s.fetchPublic},
+		{"FetchAll", s.fetchAll},
+		{"InsertPushSubscription", s.insertPushSubscription},
+		{"FetchUserPushSubscriptions", s.fetchUserPushSubscriptions},
+		{"DeleteUserPushNotification", s.deleteUserPushNotification},
+		{"Tokens", s.tokens},
+		{"Sessions", s.sessions},
+		{"Agents", s.agents},
+		{"Sequences", s.sequences},
+		{"Users", s.users},
+		{"Groups", s.groups},
+		{"FetchByCreatorGroups", s.fetchByCreatorGroups},
+		{"FetchUsage", s.fetchUsage},
+		{"AuditLog", s.auditLog},
+		{"QueryDatabases", s.queryDatabases},
+		{"Jobs", s.jobs},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, tt.test)
+	}
+}
+
+func (s *suite) fetchByID(t *testing.T) {
+	s.entry.DBName = "fetchByID"
+	s.conn.Insert(&s.entry)
+
+	res, err := s.conn.FetchByID(s.entry.ID)
+	if err != nil {
+		t.Errorf("FetchById(%d) failed with error: %v", s.entry.ID, err)
+	}
+
+	if err := dbutil.CompareRows(res, s.entry); err != nil {
+		t.Errorf("Fetched result not the same as queried: %v", err)
+	}
+}
+
+func (s *suite) fetchByDBNameAgent(t *testing.T) {
+	s.conn.Insert(&s.entry)
+
+	res, err := s.conn.FetchByDBNameAgent(s.entry.DBName, s.entry.AgentName)
+	if err != nil {
+		t.Errorf("FetchByDBNameAgent(%s, %s) failed with error: %v", s.entry.DBName, s.entry.AgentName, err)
+	}
+
+	if err := dbutil.CompareRows(res, s.entry); err != nil {
+		t.Errorf("Fetched result not the same as queried: %v", err)
+	}
+}
+
+func (s *suite) fetchByCreator(t *testing.T) {
+	creator := "someone@somewhere.com"
+
+	s.entry.Creator = creator
+
+	s.entry.DBName = "fetchByCreator_1"
+	s.conn.Insert(&s.entry)
+
+	s.entry.DBName = "fetchByCreator_2"
+	s.conn.Insert(&s.entry)
+
+	results, err := s.conn.FetchByCreator(creator)
+	if err != nil {
+		t.Errorf("failed to fetch by creator: %v", err)
+	}
+
+	if len(results) != 2 {
+		t.Errorf("Expected resultset to have 2 results, %d instead", len(results))
+	}
+
+	for _, res := range results {
+		if res.Creator != creator {
+			t.Errorf("Creator mismatch: Got %q, expected %q", res.Creator, creator)
+		}
+	}
+}
+
+func (s *suite) insert(t *testing.T) {
+	s.entry.DBName = "insert"
+	err := s.conn.Insert(&s.entry)
+	if err != nil {
+		t.Errorf("s.conn.Insert(s.entry) failed with error: %v", err)
+	}
+
+	if s.entry.ID == 0 {
+		t.Errorf("s.conn.Insert(s.entry) resulted in id of 0")
+	}
+
+	result, err := s.conn.FetchByID(s.entry.ID)
+	if err != nil {
+		t.Errorf("FetchById(%d) resulted in error: %v", s.entry.ID, err)
+	}
+
+	if err = dbutil.CompareRows(s.entry, result); err != nil {
+		t.Errorf("Persisted and read results not the same: %v", err)
+	}
+}
+
+func (s *suite) update(t *testing.T) {
+	s.conn.Insert(&s.entry)
+
+	// We're updating by ID - this should updated the row for "s.entry"
+	updatedEntry := data.Row{
+		ID:         s.entry.ID,
+		DBName:     "updatedtestDB",
+		DBUser:     "updatedtestUser",
+		DBPass:     "updatedtestPass",
+		DBSID:      "updatedtestsid",
+		Dumpfile:   "updatedtestloc",
+		CreateDate: time.Now().In(gmt),
+		ExpiryDate: time.Now().In(gmt).AddDate(0, 0, 30),
+		Creator:    "updatedtest@gmail.com",
+		AgentName:  "updatedysql-55",
+		DBAddress:  "updatedlocalhost",
+		DBPort:     "updated3306",
+		DBVendor:   "updatedmysql",
+		Comment:    "This is just a comment somewhere",
+		Message:    "updated",
+		Status:     200,
+		Group:      "updatedgroup",
+		DumpSize:   2048,
+		Extensions: 2,
+		Warned:     7,
+	}
+
+	err := s.conn.Update(&updatedEntry)
+	if err != nil {
+		t.Errorf("Update(updatedEntry) failed: %v", err)
+	}
+
+	readEntry, _ := s.conn.FetchByID(s.entry.ID)
+
+	if err := dbutil.CompareRows(updatedEntry, readEntry); err != nil {
+		t.Errorf("Updated and read entries not the same: %v", err)
+	}
+}
+
+func (s *suite) delete(t *testing.T) {
+	s.conn.Insert(&s.entry)
+
+	err := s.conn.Delete(s.entry)
+	if err != nil {
+		t.Errorf("Delete failed: %v", err)
+	}
+
+	row, _ := s.conn.FetchByID(s.entry.ID)
+	if row.ID == s.entry.ID {
+		t.Errorf("Row was not deleted, managed to fetch it back")
+	}
+}
+
+func (s *suite) fetchPublic(t *testing.T) {
+	res, err := s.conn.FetchPublic()
+	if err != nil {
+		t.Errorf("FetchPublic() error: %v", err)
+	}
+
+	if len(res) != 0 {
+		t.Errorf("FetchPublic() returned with entries, shouldn't have")
+	}
+
+	s.entry.Public = 1
+
+	s.conn.Insert(&s.entry)
+
+	res, err = s.conn.FetchPublic()
+	if err != nil {
+		t.Errorf("FetchPublic() error: %v", err)
+		return
+	}
+
+	if len(res) != 1 {
+		t.Errorf("FetchPublic() expected 1 result, got %d instead", len(res))
+		return
+	}
+
+	if err := dbutil.CompareRows(res[0], s.entry); err != nil {
+		t.Errorf("Read and persisted mismatch: %v", err)
+	}
+}
+
+func (s *suite) fetchAll(t *testing.T) {
+	before, err := s.conn.FetchAll()
+	if err != nil {
+		t.Errorf("FetchAll() encountered error: %v", err)
+	}
+
+	s.entry.DBName = "fetchAll"
+	s.conn.Insert(&s.entry)
+
+	entries, err := s.conn.FetchAll()
+	if err != nil {
+		t.Errorf("FetchAll() encountered error: %v", err)
+	}
+
+	if len(entries) != len(before)+1 {
+		t.Errorf("Expected size %d, got %d instead", len(before)+1, len(entries))
+	}
+}
+
+func (s *suite) insertPushSubscription(t *testing.T) {
+	type args struct {
+		subscription *model.PushSubscription
+		subscriber   string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantErr bool
+	}{
+		{"Success", args{
+			subscriber: "test@example.com",
+			subscription: &model.PushSubscription{
+				Endpoint:       "testEndpoint",
+				ExpirationTime: "testExpirationTime",
+				Keys: webpush.Keys{
+					P256dh: "randomTestKey",
+					Auth:   "randomTestAuth",
+				},
+			},
+		}, false},
+		{"Missing Subscriber", args{
+			subscription: &model.PushSubscription{
+				Endpoint:       "testEndpoint",
+				ExpirationTime: "testExpirationTime",
+				Keys: webpush.Keys{
+					P256dh: "randomTestKey",
+					Auth:   "randomTestAuth",
+				},
+			},
+		}, true},
+		{"Missing Endpoint", args{
+			subscriber: "test@example.com",
+			subscription: &model.PushSubscription{
+				ExpirationTime: "testExpirationTime",
+				Keys: webpush.Keys{
+					P256dh: "randomTestKey",
+					Auth:   "randomTestAuth",
+				},
+			},
+		}, true},
+		{"Missing ExpirationTime", args{
+			subscriber: "test@example.com",
+			subscription: &model.PushSubscription{
+				Endpoint: "testEndpoint",
+				Keys: webpush.Keys{
+					P256dh: "randomTestKey",
+					Auth:   "randomTestAuth",
+				},
+			},
+		}, true},
+		{"Missing Keys", args{
+			subscriber: "test@example.com",
+			subscription: &model.PushSubscription{
+				Endpoint:       "testEndpoint",
+				ExpirationTime: "testExpirationTime",
+			},
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := s.conn.InsertPushSubscription(tt.args.subscription, tt.args.subscriber); (err != nil) != tt.wantErr {
+				t.Errorf("DB.InsertPushSubscription() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr {
+				return
+			}
+
+			read, _ := s.conn.FetchUserPushSubscriptions(tt.args.subscriber)
+			if len(read) == 0 {
+				t.Errorf("Did not find inserted data after DB.InsertPushSubscription")
+				return
+			}
+
+			s := read[0]
+			if s.Endpoint != tt.args.subscription.Endpoint {
+				t.Errorf("endpoint mismatch; expected %v, got %v", tt.args.subscription.Endpoint, s.Endpoint)
+			}
+
+			if s.Keys.Auth != tt.args.subscription.Keys.Auth {
+				t.Errorf("auth mismatch; expected %v, got %v", tt.args.subscription.Keys.Auth, s.Keys.Auth)
+			}
+
+			if s.Keys.P256dh != tt.args.subscription.Keys.P256dh {
+				t.Errorf("P256Dh mismatch; expected %v, got %v", tt.args.subscription.Keys.P256dh, s.Keys.P256dh)
+			}
+		})
+	}
+}
+
+func (s *suite) fetchUserPushSubscriptions(t *testing.T) {
+	testUser := "test@example.com"
+	testSubscription := &model.PushSubscription{
+		Endpoint:       "testEndpoint",
+		ExpirationTime: "testExpirationTime",
+		Keys: webpush.Keys{
+			P256dh: "randomTestKey",
+			Auth:   "randomTestAuth",
+		},
+	}
+
+	tests := []struct {
+		name          string
+		subscriber    string
+		expectedCount int
+		wantErr       bool
+	}{
+		{"Success", testUser, 1, false},
+		{"No subscription for user", "random@user.com", 0, false},
+		{"No user specified", "", 0, true},
+	}
+
+	s.conn.InsertPushSubscription(testSubscription, testUser)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				read []webpush.Subscription
+				err  error
+			)
+
+			if read, err = s.conn.FetchUserPushSubscriptions(tt.subscriber); (err != nil) != tt.wantErr {
+				t.Errorf("DB.FetchUserPushSubscriptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr || tt.expectedCount == 0 {
+				return
+			}
+
+			if len(read) != tt.expectedCount {
+				t.Errorf("Wrong number of results returned. Expected %v, got %v", tt.expectedCount, len(read))
+				return
+			}
+
+			s := read[0]
+			if s.Endpoint != testSubscription.Endpoint {
+				t.Errorf("endpoint mismatch; expected %v, got %v", testSubscription.Endpoint, s.Endpoint)
+			}
+
+			if s.Keys.Auth != testSubscription.Keys.Auth {
+				t.Errorf("auth mismatch; expected %v, got %v", testSubscription.Keys.Auth, s.Keys.Auth)
+			}
+
+			if s.Keys.P256dh != testSubscription.Keys.P256dh {
+				t.Errorf("P256Dh mismatch; expected %v, got %v", testSubscription.Keys.P256dh, s.Keys.P256dh)
+			}
+		})
+	}
+}
+
+func (s *suite) deleteUserPushNotification(t *testing.T) {
+	testUser := "test@example.com"
+	testSubscription := &model.PushSubscription{
+		Endpoint:       "testEndpoint",
+		ExpirationTime: "testExpirationTime",
+		Keys: webpush.Keys{
+			P256dh: "randomTestKey",
+			Auth:   "randomTestAuth",
+		},
+	}
+
+	tests := []struct {
+		name       string
+		subscriber string
+		endpoint   string
+		wantErr    bool
+	}{
+		{"Success", testUser, testSubscription.Endpoint, false},
+		{"No Subscription for user", "random@user.com", testSubscription.Endpoint, false},
+		{"No User specified", "", testSubscription.Endpoint, true},
+		{"No Endpoint specified", testUser, "", true},
+	}
+
+	s.conn.InsertPushSubscription(testSubscription, testUser)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpSub := &model.PushSubscription{Endpoint: tt.endpoint}
+
+			if err := s.conn.DeletePushSubscription(tmpSub, tt.subscriber); (err != nil) != tt.wantErr {
+				t.Errorf("DB.FetchUserPushSubscriptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func (s *suite) tokens(t *testing.T) {
+	token := data.Token{
+		Name:       "ci",
+		Owner:      "tokens@example.com",
+		Hash:       "a3f1c0ffee",
+		Prefix:     "ddn_abcd",
+		CreateDate: time.Now().In(gmt),
+		ExpiryDate: time.Now().In(gmt).AddDate(0, 0, 30),
+	}
+
+	err := s.conn.InsertToken(&token)
+	if err != nil {
+		t.Fatalf("InsertToken() failed: %v", err)
+	}
+
+	if token.ID == 0 {
+		t.Errorf("InsertToken() resulted in id of 0")
+	}
+
+	read, err := s.conn.FetchTokenByHash(token.Hash)
+	if err != nil {
+		t.Errorf("FetchTokenByHash() failed: %v", err)
+	}
+
+	if read.ID != token.ID || read.Owner != token.Owner || read.Name != token.Name {
+		t.Errorf("FetchTokenByHash() = %v, expected %v", read, token)
+	}
+
+	if !read.LastUsed.IsZero() {
+		t.Errorf("Fresh token should not have been used yet, got %v", read.LastUsed)
+	}
+
+	missing, err := s.conn.FetchTokenByHash("nonexistent")
+	if err != nil {
+		t.Errorf("FetchTokenByHash(nonexistent) failed: %v", err)
+	}
+
+	if missing.ID != 0 {
+		t.Errorf("FetchTokenByHash(nonexistent) returned a token")
+	}
+
+	err = s.conn.UpdateTokenLastUsed(token.ID, time.Now())
+	if err != nil {
+		t.Errorf("UpdateTokenLastUsed() failed: %v", err)
+	}
+
+	tokens, err := s.conn.FetchTokensByOwner(token.Owner)
+	if err != nil {
+		t.Errorf("FetchTokensByOwner() failed: %v", err)
+	}
+
+	if len(tokens) != 1 {
+		t.Fatalf("FetchTokensByOwner() expected 1 result, got %d", len(tokens))
+	}
+
+	if tokens[0].LastUsed.IsZero() {
+		t.Errorf("UpdateTokenLastUsed() did not update the last use")
+	}
+
+	err = s.conn.DeleteToken(token)
+	if err != nil {
+		t.Errorf("DeleteToken() failed: %v", err)
+	}
+
+	read, _ = s.conn.FetchTokenByHash(token.Hash)
+	if read.ID != 0 {
+		t.Errorf("Token was not deleted, managed to fetch it back")
+	}
+}
+
+func (s *suite) sessions(t *testing.T) {
+	now := time.Now().In(gmt)
+
+	live := data.Session{
+		ID:         "live-session",
+		User:       "sessions@example.com",
+		CSRFToken:  "csrf",
+		CreateDate: now,
+		ExpiryDate: now.Add(time.Hour),
+	}
+
+	expired := data.Session{
+		ID:         "expired-session",
+		User:       "sessions@example.com",
+		CSRFToken:  "csrf",
+		CreateDate: now.Add(-2 * time.Hour),
+		ExpiryDate: now.Add(-time.Hour),
+	}
+
+	for _, session := range []data.Session{live, expired} {
+		err := s.conn.InsertSession(&session)
+		if err != nil {
+			t.Fatalf("InsertSession() failed: %v", err)
+		}
+	}
+
+	read, err := s.conn.FetchSession(live.ID)
+	if err != nil {
+		t.Errorf("FetchSession() failed: %v", err)
+	}
+
+	if read.User != live.User || read.CSRFToken != live.CSRFToken {
+		t.Errorf("FetchSession() = %v, expected %v", read, live)
+	}
+
+	err = s.conn.DeleteExpiredSessions(now)
+	if err != nil {
+		t.Errorf("DeleteExpiredSessions() failed: %v", err)
+	}
+
+	read, _ = s.conn.FetchSession(expired.ID)
+	if read.ID != "" {
+		t.Errorf("DeleteExpiredSessions() did not remove the expired session")
+	}
+
+	err = s.conn.DeleteSession(live.ID)
+	if err != nil {
+		t.Errorf("DeleteSession() failed: %v", err)
+	}
+
+	read, _ = s.conn.FetchSession(live.ID)
+	if read.ID != "" {
+		t.Errorf("Session was not deleted, managed to fetch it back")
+	}
+}
+
+func (s *suite) agents(t *testing.T) {
+	agent := data.Agent{
+		Agent: model.Agent{
+			ID:         4,
+			ShortName:  "mysql-57",
+			LongName:   "MySQL 5.7",
+			Identifier: "mysql-57-agent",
+			DBVendor:   "mysql",
+			DBAddr:     "localhost:3306",
+			Version:    "1.0",
+			Address:    "http://localhost:7000",
+			Token:      "hash",
+		},
+		Mode:   data.ModeActive,
+		Labels: map[string]string{"purpose": "ci", "disk": "small"},
+	}
+
+	err := s.conn.SaveAgent(agent)
+	if err != nil {
+		t.Fatalf("SaveAgent() failed: %v", err)
+	}
+
+	agent.Address = "http://localhost:7001"
+	agent.Mode = data.ModeDraining
+	agent.Labels = map[string]string{"purpose": "ci"}
+	agent.LabelOverrides = map[string]string{"disk": "large", "purpose": ""}
+
+	err = s.conn.SaveAgent(agent)
+	if err != nil {
+		t.Fatalf("SaveAgent() overwrite failed: %v", err)
+	}
+
+	agents, err := s.conn.FetchAgents()
+	if err != nil {
+		t.Fatalf("FetchAgents() failed: %v", err)
+	}
+
+	if len(agents) != 1 || !reflect.DeepEqual(agents[0], agent) {
+		t.Errorf("FetchAgents() = %v, expected [%v]", agents, agent)
+	}
+
+	err = s.conn.DeleteAgent(agent.ShortName)
+	if err != nil {
+		t.Errorf("DeleteAgent() failed: %v", err)
+	}
+
+	agents, _ = s.conn.FetchAgents()
+	if len(agents) != 0 {
+		t.Errorf("Agent was not deleted, managed to fetch it back")
+	}
+}
+
+func (s *suite) sequences(t *testing.T) {
+	value, err := s.conn.FetchSequence("test")
+	if err != nil || value != 0 {
+		t.Errorf("FetchSequence() of new sequence = %d, %v, expected 0", value, err)
+	}
+
+	for _, v := range []int{5, 6} {
+		err = s.conn.UpdateSequence("test", v)
+		if err != nil {
+			t.Errorf("UpdateSequence(%d) failed: %v", v, err)
+		}
+
+		value, err = s.conn.FetchSequence("test")
+		if err != nil || value != v {
+			t.Errorf("FetchSequence() = %d, %v, expected %d", value, err, v)
+		}
+	}
+}
+
+func (s *suite) users(t *testing.T) {
+	user, err := s.conn.FetchUser("nobody@example.com")
+	if err != nil || user.Role != "" {
+		t.Errorf("FetchUser() of unknown user = %+v, %v, expected empty user", user, err)
+	}
+
+	for _, role := range []string{"viewer", "admin"} {
+		err = s.conn.SaveUser(data.User{Email: "someone@example.com", Role: role, UpdateDate: time.Now(), UpdatedBy: "admin@example.com"})
+		if err != nil {
+			t.Errorf("SaveUser(%q) failed: %v", role, err)
+		}
+
+		user, err = s.conn.FetchUser("someone@example.com")
+		if err != nil || user.Role != role {
+			t.Errorf("FetchUser() = %+v, %v, expected role %q", user, err, role)
+		}
+	}
+
+	users, err := s.conn.FetchUsers()
+	if err != nil || len(users) != 1 {
+		t.Errorf("FetchUsers() = %d users, %v, expected 1", len(users), err)
+	}
+
+	if err = s.conn.SaveUser(data.User{Email: "someone@example.com"}); err == nil {
+		t.Errorf("SaveUser() without role succeeded")
+	}
+}
+
+func (s *suite) groups(t *testing.T) {
+	group := data.Group{
+		Name:       "testers",
+		CreateDate: time.Now().In(gmt),
+		CreatedBy:  "creator@example.com",
+		Members:    []string{"creator@example.com", "member@example.com"},
+	}
+
+	err := s.conn.InsertGroup(&group)
+	if err != nil {
+		t.Fatalf("InsertGroup() failed: %v", err)
+	}
+
+	read, err := s.conn.FetchGroup(group.Name)
+	if err != nil || read.Name != group.Name || len(read.Members) != 2 {
+		t.Errorf("FetchGroup() = %+v, %v, expected group with 2 members", read, err)
+	}
+
+	err = s.conn.AddGroupMember(group.Name, "new@example.com")
+	if err != nil {
+		t.Errorf("AddGroupMember() failed: %v", err)
+	}
+
+	err = s.conn.RemoveGroupMember(group.Name, "member@example.com")
+	if err != nil {
+		t.Errorf("RemoveGroupMember() failed: %v", err)
+	}
+
+	names, err := s.conn.FetchGroupNames("new@example.com")
+	if err != nil || len(names) != 1 || names[0] != group.Name {
+		t.Errorf("FetchGroupNames() = %v, %v, expected [%s]", names, err, group.Name)
+	}
+
+	names, _ = s.conn.FetchGroupNames("member@example.com")
+	if len(names) != 0 {
+		t.Errorf("FetchGroupNames() of removed member = %v, expected none", names)
+	}
+
+	missing, err := s.conn.FetchGroup("missing")
+	if err != nil || missing.Name != "" {
+		t.Errorf("FetchGroup() of missing group = %+v, %v, expected empty group", missing, err)
+	}
+}
+
+func (s *suite) fetchByCreatorGroups(t *testing.T) {
+	shared := s.entry
+	shared.DBName = "fetchByCreator_shared"
+	shared.Creator = "owner@example.com"
+	shared.Group = "sharers"
+	shared.Public = data.GroupVisibility
+	s.conn.Insert(&shared)
+
+	private := shared
+	private.DBName = "fetchByCreator_private"
+	private.Public = 0
+	s.conn.Insert(&private)
+
+	results, err := s.conn.FetchByCreator("member@example.com", "sharers")
+	if err != nil {
+		t.Errorf("failed to fetch by creator: %v", err)
+	}
+
+	if len(results) != 1 || results[0].ID != shared.ID {
+		t.Errorf("Expected only the shared database, got %d results", len(results))
+	}
+}
+
+func (s *suite) fetchUsage(t *testing.T) {
+	entry := s.entry
+	entry.Creator = "usage@example.com"
+	entry.AgentName = "usage-agent"
+
+	entry.DBName = "usage_created"
+	entry.Dumpfile = ""
+	entry.Status = 100
+	entry.DumpSize = 0
+	s.conn.Insert(&entry)
+
+	entry.DBName = "usage_importing"
+	entry.Dumpfile = "http://example.com/dump.sql"
+	entry.Status = 10
+	entry.DumpSize = 1024
+	s.conn.Insert(&entry)
+
+	usage, err := s.conn.FetchUsage(data.ScopeUser, "usage@example.com")
+	if err != nil {
+		t.Fatalf("FetchUsage() failed: %v", err)
+	}
+
+	expected := data.Usage{Databases: 2, Imports: 1, DumpSize: 1024}
+	if usage != expected {
+		t.Errorf("FetchUsage() = %+v, expected %+v", usage, expected)
+	}
+
+	usage, err = s.conn.FetchUsage(data.ScopeAgent, "usage-agent")
+	if err != nil || usage != expected {
+		t.Errorf("FetchUsage() of agent = %+v, %v, expected %+v", usage, err, expected)
+	}
+
+	if _, err = s.conn.FetchUsage("planet", "earth"); err == nil {
+		t.Errorf("FetchUsage() with unknown scope succeeded")
+	}
+}
+
+func (s *suite) auditLog(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+
+	entries := []data.AuditEntry{
+		{Time: now.Add(-time.Hour), Actor: "jane@example.com", Action: "database.create", Target: 1, Agent: "mysql-55", StatusAfter: 100},
+		{Time: now, Actor: "jane@example.com", Action: "database.drop", Target: 1, Agent: "mysql-55", StatusBefore: 100, StatusAfter: 401},
+		{Time: now, Actor: "agent:mysql-55", Action: "agent.register", Agent: "mysql-55", SourceIP: "10.0.0.1"},
+	}
+
+	for i := range entries {
+		err := s.conn.InsertAuditEntry(&entries[i])
+		if err != nil || entries[i].ID == 0 {
+			t.Fatalf("InsertAuditEntry() = %d, %v", entries[i].ID, err)
+		}
+	}
+
+	if err := s.conn.InsertAuditEntry(&data.AuditEntry{Time: now}); err == nil {
+		t.Errorf("InsertAuditEntry() without actor succeeded")
+	}
+
+	tests := []struct {
+		name   string
+		filter data.AuditFilter
+		want   []int
+	}{
+		{"all", data.AuditFilter{}, []int{entries[2].ID, entries[1].ID, entries[0].ID}},
+		{"actor", data.AuditFilter{Actor: "jane@example.com"}, []int{entries[1].ID, entries[0].ID}},
+		{"action", data.AuditFilter{Action: "agent.register"}, []int{entries[2].ID}},
+		{"target", data.AuditFilter{Target: 1, Action: "database.drop"}, []int{entries[1].ID}},
+		{"from", data.AuditFilter{Actor: "jane@example.com", From: now.Add(-time.Minute)}, []int{entries[1].ID}},
+		{"to", data.AuditFilter{Actor: "jane@example.com", To: now.Add(-time.Minute)}, []int{entries[0].ID}},
+		{"page", data.AuditFilter{Limit: 1, Offset: 1}, []int{entries[1].ID}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.conn.FetchAuditEntries(tt.filter)
+			if err != nil {
+				t.Fatalf("FetchAuditEntries() failed: %v", err)
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("FetchAuditEntries() returned %d entries, expected %d", len(got), len(tt.want))
+			}
+
+			for i, entry := range got {
+				if entry.ID != tt.want[i] {
+					t.Errorf("FetchAuditEntries()[%d].ID = %d, expected %d", i, entry.ID, tt.want[i])
+				}
+			}
+		})
+	}
+
+	got, _ := s.conn.FetchAuditEntries(data.AuditFilter{Action: "database.drop"})
+	if len(got) == 1 && (got[0].StatusBefore != 100 || got[0].StatusAfter != 401 || !got[0].Time.Equal(now)) {
+		t.Errorf("FetchAuditEntries() = %+v, expected %+v", got[0], entries[1])
+	}
+}
+
+func (s *suite) queryDatabases(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+
+	entry := s.entry
+	entry.AgentName = "query-agent"
+	entry.Group = ""
+
+	rows := make([]data.Row, 4)
+	for i, set := range []func(*data.Row){
+		func(row *data.Row) {
+			row.DBName, row.Creator, row.DBVendor, row.Status = "query_alpha", "first@example.com", "mysql", 100
+			row.ExpiryDate, row.Public = now.AddDate(0, 0, 1), 1
+		},
+		func(row *data.Row) {
+			row.DBName, row.Creator, row.DBVendor, row.Status = "query_beta", "second@example.com", "postgres", 10
+			row.ExpiryDate, row.Public, row.Group = now.AddDate(0, 0, 10), 0, "queriers"
+		},
+		func(row *data.Row) {
+			row.DBName, row.Creator, row.DBVendor, row.Status = "query_gamma", "second@example.com", "mysql", 201
+			row.ExpiryDate, row.Public, row.Group = now.AddDate(0, 0, 20), data.GroupVisibility, "queriers"
+		},
+		func(row *data.Row) {
+			row.DBName, row.Creator, row.DBVendor, row.Status = "query_50%off", "first@example.com", "mysql", 401
+			row.ExpiryDate, row.Public = now.AddDate(0, 0, 10), 0
+		},
+	} {
+		rows[i] = entry
+		set(&rows[i])
+
+		if err := s.conn.Insert(&rows[i]); err != nil {
+			t.Fatalf("Insert() failed: %v", err)
+		}
+	}
+	a, b, c, d := rows[0], rows[1], rows[2], rows[3]
+
+	tests := []struct {
+		name  string
+		query data.DatabaseQuery
+		want  []data.Row
+	}{
+		{"all", data.DatabaseQuery{}, []data.Row{a, b, c, d}},
+		{"descending", data.DatabaseQuery{Descending: true}, []data.Row{d, c, b, a}},
+		{"vendor", data.DatabaseQuery{Vendor: "mysql"}, []data.Row{a, c, d}},
+		{"in progress", data.DatabaseQuery{StatusClass: data.StatusClassInProgress}, []data.Row{b}},
+		{"ok", data.DatabaseQuery{StatusClass: data.StatusClassOK}, []data.Row{a}},
+		{"error", data.DatabaseQuery{StatusClass: data.StatusClassError}, []data.Row{c}},
+		{"warning", data.DatabaseQuery{StatusClass: data.StatusClassWarning}, []data.Row{d}},
+		{"creator", data.DatabaseQuery{Creator: "second@example.com"}, []data.Row{b, c}},
+		{"name", data.DatabaseQuery{NameContains: "GAMMA"}, []data.Row{c}},
+		{"name wildcard", data.DatabaseQuery{NameContains: "%"}, []data.Row{d}},
+		{"expiry", data.DatabaseQuery{ExpiresAfter: now.AddDate(0, 0, 2), ExpiresBefore: now.AddDate(0, 0, 15)}, []data.Row{b, d}},
+		{"shared", data.DatabaseQuery{Restricted: true, ReaderGroups: []string{"queriers"}}, []data.Row{a, c}},
+		{"own", data.DatabaseQuery{Restricted: true, Reader: "second@example.com"}, []data.Row{a, b, c}},
+		{"by expiry", data.DatabaseQuery{Sort: data.SortByExpiryDate}, []data.Row{a, b, d, c}},
+		{"by expiry descending", data.DatabaseQuery{Sort: data.SortByExpiryDate, Descending: true}, []data.Row{c, d, b, a}},
+		{"by name", data.DatabaseQuery{Sort: data.SortByName}, []data.Row{d, a, b, c}},
+		{"limit", data.DatabaseQuery{Limit: 2}, []data.Row{a, b}},
+		{"after", data.DatabaseQuery{After: &c}, []data.Row{d}},
+		{"after same expiry", data.DatabaseQuery{Sort: data.SortByExpiryDate, After: &b, Limit: 2}, []data.Row{d, c}},
+		{"after by name descending", data.DatabaseQuery{Sort: data.SortByName, Descending: true, After: &b}, []data.Row{a, d}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.query.Agent = "query-agent"
+
+			got, err := s.conn.QueryDatabases(tt.query)
+			if err != nil {
+				t.Fatalf("QueryDatabases() failed: %v", err)
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("QueryDatabases() returned %d entries, expected %d", len(got), len(tt.want))
+			}
+
+			for i, row := range got {
+				if row.ID != tt.want[i].ID {
+					t.Errorf("QueryDatabases()[%d] = %s, expected %s", i, row.DBName, tt.want[i].DBName)
+				}
+			}
+		})
+	}
+
+	if _, err := s.conn.QueryDatabases(data.DatabaseQuery{Sort: "size"}); err == nil {
+		t.Errorf("QueryDatabases() with unknown sort field succeeded")
+	}
+
+	if _, err := s.conn.QueryDatabases(data.DatabaseQuery{StatusClass: "great"}); err == nil {
+		t.Errorf("QueryDatabases() with unknown status class succeeded")
+	}
+}
+
+func (s *suite) jobs(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+
+	jobs := []data.Job{
+		{Kind: data.JobDrop, Target: 7, TargetName: "jobs_db", Creator: "jane@example.com", NextRun: now.Add(-time.Minute)},
+		{Kind: data.JobImport, Target: 8, Payload: "/dumps/jobs.sql", Creator: "john@example.com", NextRun: now.Add(-time.Hour)},
+		{Kind: data.JobExport, Target: 7, Creator: "jane@example.com", NextRun: now.Add(time.Hour)},
+	}
+
+	for i := range jobs {
+		jobs[i].Status = data.JobQueued
+		jobs[i].MaxAttempts = 3
+		jobs[i].CreateDate = now
+		jobs[i].UpdateDate = now
+
+		err := s.conn.InsertJob(&jobs[i])
+		if err != nil || jobs[i].ID == 0 {
+			t.Fatalf("InsertJob() = %d, %v", jobs[i].ID, err)
+		}
+	}
+
+	if err := s.conn.InsertJob(&data.Job{Kind: data.JobDrop}); err == nil {
+		t.Errorf("InsertJob() without status succeeded")
+	}
+
+	got, err := s.conn.FetchJob(jobs[1].ID)
+	if err != nil || got.Payload != jobs[1].Payload || got.Creator != jobs[1].Creator || !got.NextRun.Equal(jobs[1].NextRun) {
+		t.Errorf("FetchJob() = %+v, %v, expected %+v", got, err, jobs[1])
+	}
+
+	if got, err := s.conn.FetchJob(1000); err != nil || got.ID != 0 {
+		t.Errorf("FetchJob() of missing job = %+v, %v", got, err)
+	}
+
+	// The import is due the longest, then the drop. The export isn't due.
+	for _, want := range []data.Job{jobs[1], jobs[0]} {
+		claimed, err := s.conn.ClaimJob(now)
+		if err != nil {
+			t.Fatalf("ClaimJob() failed: %v", err)
+		}
+
+		if claimed.ID != want.ID || claimed.Status != data.JobRunning || claimed.Attempts != 1 {
+			t.Errorf("ClaimJob() = %+v, expected job %d running its first attempt", claimed, want.ID)
+		}
+	}
+
+	if claimed, err := s.conn.ClaimJob(now); err != nil || claimed.ID != 0 {
+		t.Errorf("ClaimJob() without due jobs = %+v, %v", claimed, err)
+	}
+
+	got, _ = s.conn.FetchJob(jobs[0].ID)
+	if got.Status != data.JobRunning || got.Attempts != 1 {
+		t.Errorf("claimed job persisted as %+v", got)
+	}
+
+	got.Status = data.JobQueued
+	got.LastError = "agent down"
+	got.NextRun = now.Add(-time.Second)
+
+	if err := s.conn.UpdateJob(&got); err != nil {
+		t.Fatalf("UpdateJob() failed: %v", err)
+	}
+
+	claimed, err := s.conn.ClaimJob(now)
+	if err != nil || claimed.ID != jobs[0].ID || claimed.Attempts != 2 || claimed.LastError != "agent down" {
+		t.Errorf("ClaimJob() of retried job = %+v, %v", claimed, err)
+	}
+
+	tests := []struct {
+		name   string
+		filter data.JobFilter
+		want   []int
+	}{
+		{"all", data.JobFilter{}, []int{jobs[2].ID, jobs[1].ID, jobs[0].ID}},
+		{"creator", data.JobFilter{Creator: "jane@example.com"}, []int{jobs[2].ID, jobs[0].ID}},
+		{"kind", data.JobFilter{Kind: data.JobImport}, []int{jobs[1].ID}},
+		{"status", data.JobFilter{Status: data.JobQueued}, []int{jobs[2].ID}},
+		{"target", data.JobFilter{Target: 7, Status: data.JobRunning}, []int{jobs[0].ID}},
+		{"page", data.JobFilter{Limit: 1, Offset: 1}, []int{jobs[1].ID}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.conn.FetchJobs(tt.filter)
+			if err != nil {
+				t.Fatalf("FetchJobs() failed: %v", err)
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("FetchJobs() returned %d jobs, expected %d", len(got), len(tt.want))
+			}
+
+			for i, job := range got {
+				if job.ID != tt.want[i] {
+					t.Errorf("FetchJobs()[%d].ID = %d, expected %d", i, job.ID, tt.want[i])
+				}
+			}
+		})
+	}
+
+	if cancelled, err := s.conn.CancelJob(jobs[0].ID, now); err != nil || cancelled {
+		t.Errorf("CancelJob() of running job = %t, %v", cancelled, err)
+	}
+
+	if cancelled, err := s.conn.CancelJob(jobs[2].ID, now); err != nil || !cancelled {
+		t.Errorf("CancelJob() of queued job = %t, %v", cancelled, err)
+	}
+
+	if got, _ := s.conn.FetchJob(jobs[2].ID); got.Status != data.JobCancelled {
+		t.Errorf("cancelled job persisted as %+v", got)
+	}
+
+	if claimed, err := s.conn.ClaimJob(now.Add(2 * time.Hour)); err != nil || claimed.ID != 0 {
+		t.Errorf("ClaimJob() claimed the cancelled job: %+v, %v", claimed, err)
+	}
+}
@@ -3,6 +3,7 @@ package dbutil
 import (
 	"database/sql"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/djavorszky/ddn-api/database/data"
@@ -49,6 +50,19 @@ func CompareRows(first, second data.Row) error {
 		return fmt.Errorf("ExpiryDate mismatch. First: %q vs Second: %q", first.ExpiryDate.Round(time.Second).Format(time.ANSIC), second.ExpiryDate.Round(time.Second).Format(time.ANSIC))
 	}
 
+	delta = first.UpdateDate.Sub(second.UpdateDate)
+	if delta < -1*time.Second || delta > 1*time.Second {
+		return fmt.Errorf("UpdateDate mismatch. First: %q vs Second: %q", first.UpdateDate.Round(time.Second).Format(time.ANSIC), second.UpdateDate.Round(time.Second).Format(time.ANSIC))
+	}
+
+	if first.Extensions != second.Extensions {
+		return fmt.Errorf("Extensions mismatch. First: %d vs Second: %d", first.Extensions, second.Extensions)
+	}
+
+	if first.Warned != second.Warned {
+		return fmt.Errorf("Warned mismatch. First: %d vs Second: %d", first.Warned, second.Warned)
+	}
+
 	if first.Creator != second.Creator {
 		return fmt.Errorf("Creator mismatch. First: %q vs Second: %q", first.Creator, second.Creator)
 	}
@@ -78,6 +92,14 @@ func CompareRows(first, second data.Row) error {
 		return fmt.Errorf("Public mismatch. First: %q vs Second: %q", first.Public, second.Public)
 	}
 
+	if first.Group != second.Group {
+		return fmt.Errorf("Group mismatch. First: %q vs Second: %q", first.Group, second.Group)
+	}
+
+	if first.DumpSize != second.DumpSize {
+		return fmt.Errorf("DumpSize mismatch. First: %d vs Second: %d", first.DumpSize, second.DumpSize)
+	}
+
 	return nil
 }
 
@@ -102,7 +124,12 @@ func ReadRow(result *sql.Row) (data.Row, error) {
 		&row.Status,
 		&row.Message,
 		&row.Public,
-		&row.Comment)
+		&row.Comment,
+		&row.Group,
+		&row.DumpSize,
+		&row.UpdateDate,
+		&row.Extensions,
+		&row.Warned)
 	if err != nil && err != sql.ErrNoRows {
 		return row, fmt.Errorf("failed reading row: %v", err)
 	}
@@ -133,7 +160,12 @@ func ReadRows(rows *sql.Rows) (data.Row, error) {
 		&row.Status,
 		&row.Message,
 		&row.Public,
-		&row.Comment)
+		&row.Comment,
+		&row.Group,
+		&row.DumpSize,
+		&row.UpdateDate,
+		&row.Extensions,
+		&row.Warned)
 	if err != nil && err != sql.ErrNoRows {
 		return row, fmt.Errorf("failed reading row: %v", err)
 	}
@@ -157,3 +189,224 @@ func ReadSubscriptionRows(rows *sql.Rows) (webpush.Subscription, error) {
 
 	return row, nil
 }
+
+// Scanner is implemented by both *sql.Row and *sql.Rows
+type Scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// NullTime is used to read DATETIME columns that can be NULL
+type NullTime struct {
+	Time  time.Time
+	Valid bool
+}
+
+// Scan implements the sql.Scanner interface
+func (nt *NullTime) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		nt.Time, nt.Valid = time.Time{}, false
+	case time.Time:
+		nt.Time, nt.Valid = v, true
+	default:
+		return fmt.Errorf("unsupported time value: %v", value)
+	}
+
+	return nil
+}
+
+// TimeOrNull returns nil for the zero time, so that it is persisted
+// as NULL, or the time itself otherwise.
+func TimeOrNull(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+
+	return t
+}
+
+// ReadToken reads a row of the api_tokens table into a data.Token
+func ReadToken(result Scanner) (data.Token, error) {
+	var (
+		token    data.Token
+		lastUsed NullTime
+	)
+
+	err := result.Scan(
+		&token.ID,
+		&token.Name,
+		&token.Owner,
+		&token.Hash,
+		&token.Prefix,
+		&token.CreateDate,
+		&token.ExpiryDate,
+		&lastUsed)
+	if err != nil && err != sql.ErrNoRows {
+		return token, fmt.Errorf("failed reading token: %v", err)
+	}
+
+	token.LastUsed = lastUsed.Time
+
+	return token, nil
+}
+
+// ReadSession reads a row of the sessions table into a data.Session
+func ReadSession(result Scanner) (data.Session, error) {
+	var session data.Session
+
+	err := result.Scan(
+		&session.ID,
+		&session.User,
+		&session.CSRFToken,
+		&session.CreateDate,
+		&session.ExpiryDate)
+	if err != nil && err != sql.ErrNoRows {
+		return session, fmt.Errorf("failed reading session: %v", err)
+	}
+
+	return session, nil
+}
+
+// ReadAgent reads a row of the agents table into a data.Agent
+func ReadAgent(result Scanner) (data.Agent, error) {
+	var agent data.Agent
+
+	err := result.Scan(
+		&agent.ID,
+		&agent.ShortName,
+		&agent.LongName,
+		&agent.Identifier,
+		&agent.DBVendor,
+		&agent.DBAddr,
+		&agent.DBSID,
+		&agent.Version,
+		&agent.Address,
+		&agent.Token,
+		&agent.Mode)
+	if err != nil && err != sql.ErrNoRows {
+		return agent, fmt.Errorf("failed reading agent: %v", err)
+	}
+
+	return agent, nil
+}
+
+// ReadUser reads a row of the users table into a data.User
+func ReadUser(result Scanner) (data.User, error) {
+	var user data.User
+
+	err := result.Scan(
+		&user.Email,
+		&user.Role,
+		&user.UpdateDate,
+		&user.UpdatedBy)
+	if err != nil && err != sql.ErrNoRows {
+		return user, fmt.Errorf("failed reading user: %v", err)
+	}
+
+	return user, nil
+}
+
+// ReadGroup reads a row of the user_groups table into a data.Group. The
+// members are read separately.
+func ReadGroup(result Scanner) (data.Group, error) {
+	var group data.Group
+
+	err := result.Scan(
+		&group.Name,
+		&group.CreateDate,
+		&group.CreatedBy)
+	if err != nil && err != sql.ErrNoRows {
+		return group, fmt.Errorf("failed reading group: %v", err)
+	}
+
+	return group, nil
+}
+
+// ReadAuditEntry reads a row of the audit_log table into a data.AuditEntry
+func ReadAuditEntry(result Scanner) (data.AuditEntry, error) {
+	var entry data.AuditEntry
+
+	err := result.Scan(
+		&entry.ID,
+		&entry.Time,
+		&entry.Actor,
+		&entry.Action,
+		&entry.Target,
+		&entry.TargetName,
+		&entry.Agent,
+		&entry.StatusBefore,
+		&entry.StatusAfter,
+		&entry.SourceIP,
+		&entry.Details)
+	if err != nil && err != sql.ErrNoRows {
+		return entry, fmt.Errorf("failed reading audit entry: %v", err)
+	}
+
+	return entry, nil
+}
+
+// ReadJob reads a row of the jobs table into a data.Job
+func ReadJob(result Scanner) (data.Job, error) {
+	var job data.Job
+
+	err := result.Scan(
+		&job.ID,
+		&job.Kind,
+		&job.Target,
+		&job.TargetName,
+		&job.Payload,
+		&job.Creator,
+		&job.Status,
+		&job.Attempts,
+		&job.MaxAttempts,
+		&job.LastError,
+		&job.NextRun,
+		&job.CreateDate,
+		&job.UpdateDate)
+	if err != nil && err != sql.ErrNoRows {
+		return job, fmt.Errorf("failed reading job: %v", err)
+	}
+
+	return job, nil
+}
+
+// LikeContains returns the LIKE pattern that matches the values
+// containing s. The wildcards of s are escaped with a backslash.
+func LikeContains(s string) string {
+	return "%" + likeEscaper.Replace(s) + "%"
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// SortKey returns the column of the databases table the query sorts by,
+// and the value of that column in the After entry of the query, if any.
+func SortKey(query data.DatabaseQuery) (string, interface{}, error) {
+	var (
+		column string
+		value  interface{}
+	)
+
+	switch query.Sort {
+	case "", data.SortByID:
+		column = "id"
+	case data.SortByName:
+		column = "dbname"
+		if query.After != nil {
+			value = query.After.DBName
+		}
+	case data.SortByCreateDate:
+		column = "createDate"
+		if query.After != nil {
+			value = query.After.CreateDate
+		}
+	case data.SortByExpiryDate:
+		column = "expiryDate"
+		if query.After != nil {
+			value = query.After.ExpiryDate
+		}
+	default:
+		return "", nil, fmt.Errorf("unknown sort field %q", query.Sort)
+	}
+
+	return column, value, nil
+}
@@ -0,0 +1,150 @@
+package database
+
+import (
+	"fmt"
+
+	"github.com/djavorszky/ddn-api/database/data"
+	"github.com/djavorszky/ddn-api/secret"
+)
+
+// EncryptedConnection wraps a BackendConnection, encrypting the passwords
+// of the databases before they are persisted, and decrypting them when
+// they are read back. Everything else is passed to the backend as is.
+type EncryptedConnection struct {
+	BackendConnection
+
+	Keys *secret.Keyring
+}
+
+// FetchByID returns the entry associated with that ID.
+func (e *EncryptedConnection) FetchByID(ID int) (data.Row, error) {
+	return e.decrypt(e.BackendConnection.FetchByID(ID))
+}
+
+// FetchByDBNameAgent returns the entry of the database on the agent.
+func (e *EncryptedConnection) FetchByDBNameAgent(dbname, agent string) (data.Row, error) {
+	return e.decrypt(e.BackendConnection.FetchByDBNameAgent(dbname, agent))
+}
+
+// FetchByCreator returns the entries of the creator and of the groups.
+func (e *EncryptedConnection) FetchByCreator(creator string, groups ...string) ([]data.Row, error) {
+	return e.decryptAll(e.BackendConnection.FetchByCreator(creator, groups...))
+}
+
+// FetchPublic returns the public entries.
+func (e *EncryptedConnection) FetchPublic() ([]data.Row, error) {
+	return e.decryptAll(e.BackendConnection.FetchPublic())
+}
+
+// FetchAll returns every entry.
+func (e *EncryptedConnection) FetchAll() ([]data.Row, error) {
+	return e.decryptAll(e.BackendConnection.FetchAll())
+}
+
+// QueryDatabases returns the entries that match the query.
+func (e *EncryptedConnection) QueryDatabases(query data.DatabaseQuery) ([]data.Row, error) {
+	return e.decryptAll(e.BackendConnection.QueryDatabases(query))
+}
+
+// Insert persists the entry with its password encrypted. The password of
+// row itself is left in plaintext.
+func (e *EncryptedConnection) Insert(row *data.Row) error {
+	enc, err := e.encrypt(*row)
+	if err != nil {
+		return err
+	}
+
+	err = e.BackendConnection.Insert(&enc)
+	if err != nil {
+		return err
+	}
+
+	row.ID = enc.ID
+
+	return nil
+}
+
+// Update persists the entry with its password encrypted. The password of
+// row itself is left in plaintext.
+func (e *EncryptedConnection) Update(row *data.Row) error {
+	enc, err := e.encrypt(*row)
+	if err != nil {
+		return err
+	}
+
+	return e.BackendConnection.Update(&enc)
+}
+
+// EncryptCredentials encrypts the passwords that are stored in plaintext
+// and re-encrypts the ones that were encrypted with a key other than the
+// primary one. It returns the number of entries that were updated.
+func (e *EncryptedConnection) EncryptCredentials() (int, error) {
+	if !e.Keys.Enabled() {
+		return 0, nil
+	}
+
+	rows, err := e.BackendConnection.FetchAll()
+	if err != nil {
+		return 0, fmt.Errorf("failed listing databases: %v", err)
+	}
+
+	var count int
+	for _, row := range rows {
+		if e.Keys.Current(row.DBPass) {
+			continue
+		}
+
+		row.DBPass, err = e.Keys.Rotate(row.DBPass)
+		if err != nil {
+			return count, fmt.Errorf("password of database %d: %v", row.ID, err)
+		}
+
+		err = e.BackendConnection.Update(&row)
+		if err != nil {
+			return count, fmt.Errorf("failed updating database %d: %v", row.ID, err)
+		}
+
+		count++
+	}
+
+	return count, nil
+}
+
+func (e *EncryptedConnection) encrypt(row data.Row) (data.Row, error) {
+	var err error
+
+	row.DBPass, err = e.Keys.Encrypt(row.DBPass)
+	if err != nil {
+		return data.Row{}, fmt.Errorf("failed encrypting password: %v", err)
+	}
+
+	return row, nil
+}
+
+func (e *EncryptedConnection) decrypt(row data.Row, err error) (data.Row, error) {
+	if err != nil {
+		return row, err
+	}
+
+	row.DBPass, err = e.Keys.Decrypt(row.DBPass)
+	if err != nil {
+		return data.Row{}, fmt.Errorf("password of database %d: %v", row.ID, err)
+	}
+
+	return row, nil
+}
+
+func (e *EncryptedConnection) decryptAll(rows []data.Row, err error) ([]data.Row, error) {
+	if err != nil {
+		return rows, err
+	}
+
+	for i := range rows {
+		rows[i], err = e.decrypt(rows[i], nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return rows, nil
+}
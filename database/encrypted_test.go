@@ -0,0 +1,118 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/djavorszky/ddn-api/database/data"
+	"github.com/djavorszky/ddn-api/secret"
+)
+
+// rowStore is a BackendConnection that only keeps database entries.
+type rowStore struct {
+	BackendConnection
+
+	rows map[int]data.Row
+}
+
+func (s *rowStore) FetchByID(ID int) (data.Row, error) {
+	return s.rows[ID], nil
+}
+
+func (s *rowStore) FetchAll() ([]data.Row, error) {
+	var rows []data.Row
+	for _, row := range s.rows {
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+func (s *rowStore) Insert(row *data.Row) error {
+	row.ID = len(s.rows) + 1
+	s.rows[row.ID] = *row
+
+	return nil
+}
+
+func (s *rowStore) Update(row *data.Row) error {
+	s.rows[row.ID] = *row
+
+	return nil
+}
+
+func newKeyring(t *testing.T, keys ...string) *secret.Keyring {
+	k, err := secret.NewKeyring(keys...)
+	if err != nil {
+		t.Fatalf("NewKeyring() failed: %v", err)
+	}
+
+	return k
+}
+
+func TestEncryptedConnection(t *testing.T) {
+	key, _ := secret.GenerateKey()
+
+	store := &rowStore{rows: make(map[int]data.Row)}
+	conn := &EncryptedConnection{BackendConnection: store, Keys: newKeyring(t, key)}
+
+	row := data.Row{DBName: "test", DBPass: "tag_tuner"}
+
+	err := conn.Insert(&row)
+	if err != nil {
+		t.Fatalf("Insert() failed: %v", err)
+	}
+
+	if row.ID == 0 || row.DBPass != "tag_tuner" {
+		t.Errorf("Insert() left row as %+v, expected ID and plaintext password", row)
+	}
+
+	if stored := store.rows[row.ID].DBPass; !secret.IsEncrypted(stored) {
+		t.Errorf("stored password = %q, expected encrypted", stored)
+	}
+
+	got, err := conn.FetchByID(row.ID)
+	if err != nil || got.DBPass != "tag_tuner" {
+		t.Errorf("FetchByID() = %q, %v, expected decrypted password", got.DBPass, err)
+	}
+
+	row.DBPass = "air_viewer"
+	conn.Update(&row)
+
+	rows, err := conn.FetchAll()
+	if err != nil || len(rows) != 1 || rows[0].DBPass != "air_viewer" {
+		t.Errorf("FetchAll() = %+v, %v, expected updated password", rows, err)
+	}
+}
+
+func TestEncryptCredentials(t *testing.T) {
+	oldKey, _ := secret.GenerateKey()
+	newKey, _ := secret.GenerateKey()
+
+	store := &rowStore{rows: make(map[int]data.Row)}
+
+	old := &EncryptedConnection{BackendConnection: store, Keys: newKeyring(t, oldKey)}
+	old.Insert(&data.Row{DBPass: "rotated"})
+
+	plain := &EncryptedConnection{BackendConnection: store, Keys: newKeyring(t)}
+	plain.Insert(&data.Row{DBPass: "plaintext"})
+	plain.Insert(&data.Row{})
+
+	conn := &EncryptedConnection{BackendConnection: store, Keys: newKeyring(t, newKey, oldKey)}
+
+	count, err := conn.EncryptCredentials()
+	if err != nil || count != 2 {
+		t.Fatalf("EncryptCredentials() = %d, %v, expected 2", count, err)
+	}
+
+	current := &EncryptedConnection{BackendConnection: store, Keys: newKeyring(t, newKey)}
+	for ID, want := range map[int]string{1: "rotated", 2: "plaintext", 3: ""} {
+		got, err := current.FetchByID(ID)
+		if err != nil || got.DBPass != want {
+			t.Errorf("FetchByID(%d) = %q, %v, expected %q", ID, got.DBPass, err, want)
+		}
+	}
+
+	if count, _ = conn.EncryptCredentials(); count != 0 {
+		t.Errorf("second EncryptCredentials() updated %d entries, expected 0", count)
+	}
+}
@@ -1,6 +1,8 @@
 package database
 
 import (
+	"time"
+
 	"github.com/djavorszky/ddn-api/database/data"
 	"github.com/djavorszky/ddn-common/model"
 	webpush "github.com/sherclockholmes/webpush-go"
@@ -16,9 +18,11 @@ type BackendConnection interface {
 
 	FetchByID(ID int) (data.Row, error)
 	FetchByDBNameAgent(dbname, agent string) (data.Row, error)
-	FetchByCreator(creator string) ([]data.Row, error)
+	FetchByCreator(creator string, groups ...string) ([]data.Row, error)
 	FetchPublic() ([]data.Row, error)
 	FetchAll() ([]data.Row, error)
+	QueryDatabases(query data.DatabaseQuery) ([]data.Row, error)
+	FetchUsage(scope, name string) (data.Usage, error)
 
 	Insert(row *data.Row) error
 	Update(row *data.Row) error
@@ -27,4 +31,43 @@ type BackendConnection interface {
 	InsertPushSubscription(row *model.PushSubscription, subscriber string) error
 	DeletePushSubscription(row *model.PushSubscription, subscriber string) error
 	FetchUserPushSubscriptions(subscriber string) ([]webpush.Subscription, error)
+
+	InsertToken(token *data.Token) error
+	FetchTokenByHash(hash string) (data.Token, error)
+	FetchTokensByOwner(owner string) ([]data.Token, error)
+	UpdateTokenLastUsed(ID int, lastUsed time.Time) error
+	DeleteToken(token data.Token) error
+
+	InsertSession(session *data.Session) error
+	FetchSession(ID string) (data.Session, error)
+	DeleteSession(ID string) error
+	DeleteExpiredSessions(now time.Time) error
+
+	FetchAgents() ([]data.Agent, error)
+	SaveAgent(agent data.Agent) error
+	DeleteAgent(shortName string) error
+
+	FetchSequence(name string) (int, error)
+	UpdateSequence(name string, value int) error
+
+	FetchUser(email string) (data.User, error)
+	FetchUsers() ([]data.User, error)
+	SaveUser(user data.User) error
+
+	InsertGroup(group *data.Group) error
+	FetchGroup(name string) (data.Group, error)
+	FetchGroups() ([]data.Group, error)
+	FetchGroupNames(member string) ([]string, error)
+	AddGroupMember(name, member string) error
+	RemoveGroupMember(name, member string) error
+
+	InsertAuditEntry(entry *data.AuditEntry) error
+	FetchAuditEntries(filter data.AuditFilter) ([]data.AuditEntry, error)
+
+	InsertJob(job *data.Job) error
+	FetchJob(ID int) (data.Job, error)
+	FetchJobs(filter data.JobFilter) ([]data.Job, error)
+	UpdateJob(job *data.Job) error
+	CancelJob(ID int, now time.Time) (bool, error)
+	ClaimJob(now time.Time) (data.Job, error)
 }
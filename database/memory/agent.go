@@ -0,0 +1,114 @@
+package memory
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/djavorszky/ddn-api/database/data"
+	"github.com/djavorszky/sutils"
+)
+
+// FetchAgents returns all persisted agents
+func (m *DB) FetchAgents() ([]data.Agent, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if err := m.alive(); err != nil {
+		return nil, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	var agents []data.Agent
+	for _, agent := range m.agents {
+		agent.Labels = copyLabels(agent.Labels)
+		agent.LabelOverrides = copyLabels(agent.LabelOverrides)
+
+		agents = append(agents, agent)
+	}
+
+	sort.Slice(agents, func(i, j int) bool { return agents[i].ShortName < agents[j].ShortName })
+
+	return agents, nil
+}
+
+// SaveAgent persists the agent, overwriting the one with the same
+// short name if there is one.
+func (m *DB) SaveAgent(agent data.Agent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	if !sutils.Present(agent.ShortName) {
+		return fmt.Errorf("missing short name")
+	}
+
+	// The state of the agent is not persisted by the other backends
+	// either.
+	agent.Up = false
+
+	agent.Labels = copyLabels(agent.Labels)
+	agent.LabelOverrides = copyLabels(agent.LabelOverrides)
+
+	m.agents[agent.ShortName] = agent
+
+	return nil
+}
+
+// DeleteAgent removes the persisted agent
+func (m *DB) DeleteAgent(shortName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	delete(m.agents, shortName)
+
+	return nil
+}
+
+// FetchSequence returns the current value of the named sequence, or 0
+// if it was never updated.
+func (m *DB) FetchSequence(name string) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if err := m.alive(); err != nil {
+		return 0, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	return m.sequences[name], nil
+}
+
+// UpdateSequence sets the value of the named sequence
+func (m *DB) UpdateSequence(name string, value int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	m.sequences[name] = value
+
+	return nil
+}
+
+// copyLabels copies the labels, so the stored ones can't be changed from
+// the outside. No labels are kept as nil, like the other backends return
+// them.
+func copyLabels(labels map[string]string) map[string]string {
+	if len(labels) == 0 {
+		return nil
+	}
+
+	c := make(map[string]string, len(labels))
+	for k, v := range labels {
+		c[k] = v
+	}
+
+	return c
+}
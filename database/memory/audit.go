@@ -0,0 +1,79 @@
+package memory
+
+import (
+	"fmt"
+
+	"github.com/djavorszky/ddn-api/database/data"
+	"github.com/djavorszky/sutils"
+)
+
+// InsertAuditEntry appends the entry to the audit log, updating its ID.
+// Entries of the audit log are never updated or deleted.
+func (m *DB) InsertAuditEntry(entry *data.AuditEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	if !sutils.Present(entry.Actor, entry.Action) {
+		return fmt.Errorf("missing actor or action")
+	}
+
+	entry.ID = len(m.audit) + 1
+
+	m.audit = append(m.audit, *entry)
+
+	return nil
+}
+
+// FetchAuditEntries returns the entries of the audit log that match the
+// filter, newest first.
+func (m *DB) FetchAuditEntries(filter data.AuditFilter) ([]data.AuditEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if err := m.alive(); err != nil {
+		return nil, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	var (
+		entries []data.AuditEntry
+		skipped int
+	)
+
+	for i := len(m.audit) - 1; i >= 0; i-- {
+		if filter.Limit > 0 && len(entries) == filter.Limit {
+			break
+		}
+
+		entry := m.audit[i]
+		if !matches(filter, entry) {
+			continue
+		}
+
+		if filter.Limit > 0 && skipped < filter.Offset {
+			skipped++
+			continue
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+func matches(filter data.AuditFilter, entry data.AuditEntry) bool {
+	switch {
+	case filter.Actor != "" && entry.Actor != filter.Actor,
+		filter.Action != "" && entry.Action != filter.Action,
+		filter.Agent != "" && entry.Agent != filter.Agent,
+		filter.Target != 0 && entry.Target != filter.Target,
+		!filter.From.IsZero() && entry.Time.Before(filter.From),
+		!filter.To.IsZero() && !entry.Time.Before(filter.To):
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,162 @@
+package memory
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/djavorszky/ddn-api/database/data"
+	"github.com/djavorszky/sutils"
+)
+
+// InsertGroup persists a new group along with its members
+func (m *DB) InsertGroup(group *data.Group) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	if !sutils.Present(group.Name, group.CreatedBy) {
+		return fmt.Errorf("missing name or creator")
+	}
+
+	if _, ok := m.groups[group.Name]; ok {
+		return fmt.Errorf("insert failed: group %q already exists", group.Name)
+	}
+
+	stored := *group
+	stored.Members = nil
+
+	m.groups[group.Name] = stored
+
+	for _, member := range group.Members {
+		m.members[group.Name] = addMember(m.members[group.Name], member)
+	}
+
+	return nil
+}
+
+// FetchGroup returns the group with the given name along with its
+// members. If there is no such group, an empty group is returned
+// without an error.
+func (m *DB) FetchGroup(name string) (data.Group, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if err := m.alive(); err != nil {
+		return data.Group{}, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	group, ok := m.groups[name]
+	if !ok {
+		return data.Group{}, nil
+	}
+
+	return m.withMembers(group), nil
+}
+
+// FetchGroups returns all the groups along with their members
+func (m *DB) FetchGroups() ([]data.Group, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if err := m.alive(); err != nil {
+		return nil, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	var groups []data.Group
+	for _, group := range m.groups {
+		groups = append(groups, m.withMembers(group))
+	}
+
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+
+	return groups, nil
+}
+
+// FetchGroupNames returns the names of the groups the user is a member of
+func (m *DB) FetchGroupNames(member string) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if err := m.alive(); err != nil {
+		return nil, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	var names []string
+	for name, members := range m.members {
+		for _, email := range members {
+			if email == member {
+				names = append(names, name)
+			}
+		}
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// AddGroupMember adds the user to the group. Adding an existing member
+// is not an error.
+func (m *DB) AddGroupMember(name, member string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	if !sutils.Present(name, member) {
+		return fmt.Errorf("missing group or member")
+	}
+
+	m.members[name] = addMember(m.members[name], member)
+
+	return nil
+}
+
+// RemoveGroupMember removes the user from the group
+func (m *DB) RemoveGroupMember(name, member string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	var members []string
+	for _, email := range m.members[name] {
+		if email != member {
+			members = append(members, email)
+		}
+	}
+
+	m.members[name] = members
+
+	return nil
+}
+
+// addMember returns the members with member added, keeping them sorted.
+// The slice is always copied, so it can't alias one handed out before.
+func addMember(members []string, member string) []string {
+	for _, email := range members {
+		if email == member {
+			return members
+		}
+	}
+
+	members = append(append([]string(nil), members...), member)
+	sort.Strings(members)
+
+	return members
+}
+
+// withMembers returns the group along with a copy of its members. The
+// caller has to hold the lock.
+func (m *DB) withMembers(group data.Group) data.Group {
+	group.Members = append([]string(nil), m.members[group.Name]...)
+
+	return group
+}
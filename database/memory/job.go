@@ -0,0 +1,167 @@
+package memory
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/djavorszky/ddn-api/database/data"
+	"github.com/djavorszky/sutils"
+)
+
+// InsertJob persists the job, updating its ID.
+func (m *DB) InsertJob(job *data.Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	if !sutils.Present(job.Kind, job.Status) {
+		return fmt.Errorf("missing kind or status")
+	}
+
+	m.lastJobID++
+	job.ID = m.lastJobID
+
+	m.jobs[job.ID] = *job
+
+	return nil
+}
+
+// FetchJob returns the job with the ID, or an empty job if it does not
+// exist.
+func (m *DB) FetchJob(ID int) (data.Job, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if err := m.alive(); err != nil {
+		return data.Job{}, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	return m.jobs[ID], nil
+}
+
+// FetchJobs returns the jobs that match the filter, newest first.
+func (m *DB) FetchJobs(filter data.JobFilter) ([]data.Job, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if err := m.alive(); err != nil {
+		return nil, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	var jobs []data.Job
+	for _, job := range m.jobs {
+		switch {
+		case filter.Creator != "" && job.Creator != filter.Creator,
+			filter.Kind != "" && job.Kind != filter.Kind,
+			filter.Status != "" && job.Status != filter.Status,
+			filter.Target != 0 && job.Target != filter.Target:
+			continue
+		}
+
+		jobs = append(jobs, job)
+	}
+
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].ID > jobs[j].ID })
+
+	if filter.Limit > 0 {
+		if filter.Offset >= len(jobs) {
+			return nil, nil
+		}
+
+		jobs = jobs[filter.Offset:]
+		if len(jobs) > filter.Limit {
+			jobs = jobs[:filter.Limit]
+		}
+	}
+
+	return jobs, nil
+}
+
+// UpdateJob persists the changes of the job.
+func (m *DB) UpdateJob(job *data.Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	stored, ok := m.jobs[job.ID]
+	if !ok {
+		return nil
+	}
+
+	stored.Payload = job.Payload
+	stored.Status = job.Status
+	stored.Attempts = job.Attempts
+	stored.MaxAttempts = job.MaxAttempts
+	stored.LastError = job.LastError
+	stored.NextRun = job.NextRun
+	stored.UpdateDate = job.UpdateDate
+
+	m.jobs[job.ID] = stored
+
+	return nil
+}
+
+// CancelJob marks the job as cancelled, unless it isn't queued anymore.
+// It returns whether the job was cancelled.
+func (m *DB) CancelJob(ID int, now time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.alive(); err != nil {
+		return false, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	job, ok := m.jobs[ID]
+	if !ok || job.Status != data.JobQueued {
+		return false, nil
+	}
+
+	job.Status = data.JobCancelled
+	job.UpdateDate = now
+
+	m.jobs[ID] = job
+
+	return true, nil
+}
+
+// ClaimJob takes the queued job that is due the longest, marking it as
+// running and counting the attempt. It returns an empty job if none are
+// due.
+func (m *DB) ClaimJob(now time.Time) (data.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.alive(); err != nil {
+		return data.Job{}, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	var due data.Job
+	for _, job := range m.jobs {
+		if job.Status != data.JobQueued || job.NextRun.After(now) {
+			continue
+		}
+
+		if due.ID == 0 || job.NextRun.Before(due.NextRun) || job.NextRun.Equal(due.NextRun) && job.ID < due.ID {
+			due = job
+		}
+	}
+
+	if due.ID == 0 {
+		return data.Job{}, nil
+	}
+
+	due.Status = data.JobRunning
+	due.Attempts++
+	due.UpdateDate = now
+
+	m.jobs[due.ID] = due
+
+	return due, nil
+}
@@ -0,0 +1,340 @@
+// Package memory implements the BackendConnection in memory, for tests
+// that exercise the server without any external services. Nothing is
+// persisted, and all data is lost when the process exits.
+package memory
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/djavorszky/ddn-api/database/data"
+	"github.com/djavorszky/ddn-common/model"
+	vis "github.com/djavorszky/ddn-common/visibility"
+	"github.com/djavorszky/sutils"
+	webpush "github.com/sherclockholmes/webpush-go"
+)
+
+// DB implements the BackendConnection. It is safe for concurrent use.
+// The zero value is ready to be prepared with ConnectAndPrepare.
+type DB struct {
+	mu        sync.RWMutex
+	connected bool
+
+	rows          map[int]data.Row
+	subscriptions map[string][]webpush.Subscription
+	tokens        map[int]data.Token
+	sessions      map[string]data.Session
+	agents        map[string]data.Agent
+	sequences     map[string]int
+	users         map[string]data.User
+	groups        map[string]data.Group
+	members       map[string][]string
+	audit         []data.AuditEntry
+	jobs          map[int]data.Job
+
+	lastRowID, lastTokenID, lastJobID int
+}
+
+// ConnectAndPrepare initializes the tables, unless they were initialized
+// before, in which case the data is kept.
+func (m *DB) ConnectAndPrepare() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.rows == nil {
+		m.rows = make(map[int]data.Row)
+		m.subscriptions = make(map[string][]webpush.Subscription)
+		m.tokens = make(map[int]data.Token)
+		m.sessions = make(map[string]data.Session)
+		m.agents = make(map[string]data.Agent)
+		m.sequences = make(map[string]int)
+		m.users = make(map[string]data.User)
+		m.groups = make(map[string]data.Group)
+		m.members = make(map[string][]string)
+		m.jobs = make(map[int]data.Job)
+	}
+
+	m.connected = true
+
+	return nil
+}
+
+// Close disconnects from the database. The data is kept, and can be
+// accessed again after calling ConnectAndPrepare.
+func (m *DB) Close() error {
+	m.mu.Lock()
+	m.connected = false
+	m.mu.Unlock()
+
+	return nil
+}
+
+// FetchByID returns the entry associated with that ID, or
+// an empty entry if it does not exist
+func (m *DB) FetchByID(ID int) (data.Row, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if err := m.alive(); err != nil {
+		return data.Row{}, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	return m.rows[ID], nil
+}
+
+// FetchByDBNameAgent returns the entry for the database with the given name, from the given agent,
+// or an empty entry if it does not exist
+func (m *DB) FetchByDBNameAgent(dbname, agent string) (data.Row, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if err := m.alive(); err != nil {
+		return data.Row{}, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	for _, row := range m.rows {
+		if row.DBName == dbname && row.AgentName == agent {
+			return row, nil
+		}
+	}
+
+	return data.Row{}, nil
+}
+
+// FetchByCreator returns the non-public entries that were created by the
+// specified user, along with the ones shared with any of the groups.
+func (m *DB) FetchByCreator(creator string, groups ...string) ([]data.Row, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if err := m.alive(); err != nil {
+		return nil, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	return m.filterRows(func(row data.Row) bool {
+		if row.Creator == creator && row.Public != vis.Public {
+			return true
+		}
+
+		if row.Public != data.GroupVisibility {
+			return false
+		}
+
+		for _, group := range groups {
+			if row.Group == group {
+				return true
+			}
+		}
+
+		return false
+	}), nil
+}
+
+// FetchPublic returns all entries that have "Public" set to true
+func (m *DB) FetchPublic() ([]data.Row, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if err := m.alive(); err != nil {
+		return nil, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	return m.filterRows(func(row data.Row) bool {
+		return row.Public == vis.Public
+	}), nil
+}
+
+// FetchAll returns all entries.
+func (m *DB) FetchAll() ([]data.Row, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if err := m.alive(); err != nil {
+		return nil, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	return m.filterRows(func(data.Row) bool { return true }), nil
+}
+
+// FetchUserPushSubscriptions fetches the subscriptions for the specified user
+func (m *DB) FetchUserPushSubscriptions(subscriber string) ([]webpush.Subscription, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if err := m.alive(); err != nil {
+		return nil, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	if !sutils.Present(subscriber) {
+		return nil, fmt.Errorf("missing subscriber")
+	}
+
+	subs := m.subscriptions[subscriber]
+	if len(subs) == 0 {
+		return nil, nil
+	}
+
+	return append([]webpush.Subscription(nil), subs...), nil
+}
+
+// Insert adds an entry to the database, updating its ID
+func (m *DB) Insert(entry *data.Row) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	if m.taken(*entry, 0) {
+		return fmt.Errorf("insert failed: database %q already exists on agent %q", entry.DBName, entry.AgentName)
+	}
+
+	m.lastRowID++
+	entry.ID = m.lastRowID
+	entry.UpdateDate = time.Now()
+
+	m.rows[entry.ID] = *entry
+
+	return nil
+}
+
+// InsertPushSubscription adds a record to the push_subscriptions table
+func (m *DB) InsertPushSubscription(subscription *model.PushSubscription, subscriber string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	if !sutils.Present(subscriber) {
+		return fmt.Errorf("missing subscriber")
+	}
+
+	if !sutils.Present(subscription.Endpoint) {
+		return fmt.Errorf("missing endpoint")
+	}
+
+	for _, sub := range m.subscriptions[subscriber] {
+		if sub.Endpoint == subscription.Endpoint {
+			return fmt.Errorf("saving push subscription to the database failed: already subscribed")
+		}
+	}
+
+	sub := webpush.Subscription{Endpoint: subscription.Endpoint}
+	sub.Keys.P256dh = subscription.Keys.P256dh
+	sub.Keys.Auth = subscription.Keys.Auth
+
+	m.subscriptions[subscriber] = append(m.subscriptions[subscriber], sub)
+
+	return nil
+}
+
+// Update updates an already existing entry, or inserts it if it doesn't
+// exist
+func (m *DB) Update(entry *data.Row) error {
+	m.mu.Lock()
+
+	if err := m.alive(); err != nil {
+		m.mu.Unlock()
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	if _, ok := m.rows[entry.ID]; !ok {
+		m.mu.Unlock()
+		return m.Insert(entry)
+	}
+	defer m.mu.Unlock()
+
+	if m.taken(*entry, entry.ID) {
+		return fmt.Errorf("failed update: database %q already exists on agent %q", entry.DBName, entry.AgentName)
+	}
+
+	entry.UpdateDate = time.Now()
+	m.rows[entry.ID] = *entry
+
+	return nil
+}
+
+// Delete removes the entry from the database
+func (m *DB) Delete(entry data.Row) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	delete(m.rows, entry.ID)
+
+	return nil
+}
+
+// DeletePushSubscription deletes a record from the push_subscriptions table
+func (m *DB) DeletePushSubscription(subscription *model.PushSubscription, subscriber string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	if !sutils.Present(subscriber) {
+		return fmt.Errorf("missing subscriber")
+	}
+
+	if !sutils.Present(subscription.Endpoint) {
+		return fmt.Errorf("missing endpoint")
+	}
+
+	var kept []webpush.Subscription
+	for _, sub := range m.subscriptions[subscriber] {
+		if sub.Endpoint != subscription.Endpoint {
+			kept = append(kept, sub)
+		}
+	}
+
+	m.subscriptions[subscriber] = kept
+
+	return nil
+}
+
+// alive checks whether the database is connected. The caller has to hold
+// the lock.
+func (m *DB) alive() error {
+	if !m.connected {
+		return fmt.Errorf("not connected")
+	}
+
+	return nil
+}
+
+// taken returns whether an entry other than the one with the ID has the
+// same name on the same agent. The caller has to hold the lock.
+func (m *DB) taken(entry data.Row, ID int) bool {
+	for _, row := range m.rows {
+		if row.ID != ID && row.DBName == entry.DBName && row.AgentName == entry.AgentName {
+			return true
+		}
+	}
+
+	return false
+}
+
+// filterRows returns the entries that match, newest first. The caller
+// has to hold the lock.
+func (m *DB) filterRows(match func(data.Row) bool) []data.Row {
+	var entries []data.Row
+	for _, row := range m.rows {
+		if match(row) {
+			entries = append(entries, row)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ID > entries[j].ID })
+
+	return entries
+}
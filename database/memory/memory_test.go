@@ -0,0 +1,43 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/djavorszky/ddn-api/database/dbtest"
+)
+
+func TestConformance(t *testing.T) {
+	var m DB
+
+	err := m.ConnectAndPrepare()
+	if err != nil {
+		t.Fatalf("ConnectAndPrepare() failed: %v", err)
+	}
+
+	dbtest.Run(t, &m)
+}
+
+func TestClosed(t *testing.T) {
+	var m DB
+
+	_, err := m.FetchAll()
+	if err == nil {
+		t.Errorf("FetchAll() before ConnectAndPrepare() should have failed")
+	}
+
+	m.ConnectAndPrepare()
+	m.UpdateSequence("registry", 3)
+	m.Close()
+
+	_, err = m.FetchSequence("registry")
+	if err == nil {
+		t.Errorf("FetchSequence() after Close() should have failed")
+	}
+
+	m.ConnectAndPrepare()
+
+	seq, err := m.FetchSequence("registry")
+	if err != nil || seq != 3 {
+		t.Errorf("FetchSequence() after reconnecting = %d, %v, expected 3, nil", seq, err)
+	}
+}
@@ -0,0 +1,123 @@
+package memory
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/djavorszky/ddn-api/database/data"
+	vis "github.com/djavorszky/ddn-common/visibility"
+)
+
+// QueryDatabases returns the entries that match the query, in its order.
+// Names are matched regardless of case, like the SQL backends do.
+func (m *DB) QueryDatabases(query data.DatabaseQuery) ([]data.Row, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if err := m.alive(); err != nil {
+		return nil, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	compare, err := comparer(query.Sort)
+	if err != nil {
+		return nil, err
+	}
+
+	if query.Descending {
+		asc := compare
+		compare = func(a, b data.Row) int { return asc(b, a) }
+	}
+
+	min, max, ok := 0, 0, true
+	if query.StatusClass != "" {
+		min, max, ok = data.StatusRange(query.StatusClass)
+		if !ok {
+			return nil, fmt.Errorf("unknown status class %q", query.StatusClass)
+		}
+	}
+
+	name := strings.ToLower(query.NameContains)
+
+	entries := m.filterRows(func(row data.Row) bool {
+		switch {
+		case query.Restricted && !readable(query, row),
+			query.Agent != "" && row.AgentName != query.Agent,
+			query.Vendor != "" && row.DBVendor != query.Vendor,
+			query.StatusClass != "" && (row.Status < min || max != 0 && row.Status >= max),
+			query.Creator != "" && row.Creator != query.Creator,
+			!strings.Contains(strings.ToLower(row.DBName), name),
+			!query.ExpiresAfter.IsZero() && row.ExpiryDate.Before(query.ExpiresAfter),
+			!query.ExpiresBefore.IsZero() && !row.ExpiryDate.Before(query.ExpiresBefore),
+			query.After != nil && compare(row, *query.After) <= 0:
+			return false
+		}
+
+		return true
+	})
+
+	sort.Slice(entries, func(i, j int) bool { return compare(entries[i], entries[j]) < 0 })
+
+	if query.Limit > 0 && len(entries) > query.Limit {
+		entries = entries[:query.Limit]
+	}
+
+	return entries, nil
+}
+
+func readable(query data.DatabaseQuery, row data.Row) bool {
+	if row.Public == vis.Public || query.Reader != "" && row.Creator == query.Reader {
+		return true
+	}
+
+	if row.Public != data.GroupVisibility {
+		return false
+	}
+
+	for _, group := range query.ReaderGroups {
+		if row.Group == group {
+			return true
+		}
+	}
+
+	return false
+}
+
+// comparer returns the function that orders the entries ascending by the
+// sort field, then by their IDs.
+func comparer(field string) (func(a, b data.Row) int, error) {
+	var byField func(a, b data.Row) int
+
+	switch field {
+	case "", data.SortByID:
+		byField = func(a, b data.Row) int { return 0 }
+	case data.SortByName:
+		byField = func(a, b data.Row) int { return strings.Compare(a.DBName, b.DBName) }
+	case data.SortByCreateDate:
+		byField = func(a, b data.Row) int { return compareTimes(a.CreateDate, b.CreateDate) }
+	case data.SortByExpiryDate:
+		byField = func(a, b data.Row) int { return compareTimes(a.ExpiryDate, b.ExpiryDate) }
+	default:
+		return nil, fmt.Errorf("unknown sort field %q", field)
+	}
+
+	return func(a, b data.Row) int {
+		if c := byField(a, b); c != 0 {
+			return c
+		}
+
+		return a.ID - b.ID
+	}, nil
+}
+
+func compareTimes(a, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,76 @@
+package memory
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/djavorszky/ddn-api/database/data"
+	"github.com/djavorszky/sutils"
+)
+
+// InsertSession persists a new web session
+func (m *DB) InsertSession(session *data.Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	if !sutils.Present(session.ID, session.User) {
+		return fmt.Errorf("missing id or user")
+	}
+
+	if _, ok := m.sessions[session.ID]; ok {
+		return fmt.Errorf("insert failed: duplicate id")
+	}
+
+	m.sessions[session.ID] = *session
+
+	return nil
+}
+
+// FetchSession returns the session with the given ID. If there is no such
+// session, an empty session is returned without an error.
+func (m *DB) FetchSession(ID string) (data.Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if err := m.alive(); err != nil {
+		return data.Session{}, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	return m.sessions[ID], nil
+}
+
+// DeleteSession removes the session, logging its user out
+func (m *DB) DeleteSession(ID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	delete(m.sessions, ID)
+
+	return nil
+}
+
+// DeleteExpiredSessions removes all sessions that expired before now
+func (m *DB) DeleteExpiredSessions(now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	for ID, session := range m.sessions {
+		if session.ExpiryDate.Before(now) {
+			delete(m.sessions, ID)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,111 @@
+package memory
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/djavorszky/ddn-api/database/data"
+	"github.com/djavorszky/sutils"
+)
+
+// InsertToken persists a new API token, updating its ID
+func (m *DB) InsertToken(token *data.Token) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	if !sutils.Present(token.Owner, token.Hash) {
+		return fmt.Errorf("missing owner or hash")
+	}
+
+	for _, t := range m.tokens {
+		if t.Hash == token.Hash {
+			return fmt.Errorf("insert failed: duplicate hash")
+		}
+	}
+
+	m.lastTokenID++
+	token.ID = m.lastTokenID
+
+	m.tokens[token.ID] = *token
+
+	return nil
+}
+
+// FetchTokenByHash returns the token with the given hash. If there is no
+// such token, an empty token is returned without an error.
+func (m *DB) FetchTokenByHash(hash string) (data.Token, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if err := m.alive(); err != nil {
+		return data.Token{}, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	for _, token := range m.tokens {
+		if token.Hash == hash {
+			return token, nil
+		}
+	}
+
+	return data.Token{}, nil
+}
+
+// FetchTokensByOwner returns all tokens that were issued to owner
+func (m *DB) FetchTokensByOwner(owner string) ([]data.Token, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if err := m.alive(); err != nil {
+		return nil, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	var tokens []data.Token
+	for _, token := range m.tokens {
+		if token.Owner == owner {
+			tokens = append(tokens, token)
+		}
+	}
+
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].ID > tokens[j].ID })
+
+	return tokens, nil
+}
+
+// UpdateTokenLastUsed records when the token was last used
+func (m *DB) UpdateTokenLastUsed(ID int, lastUsed time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	token, ok := m.tokens[ID]
+	if !ok {
+		return nil
+	}
+
+	token.LastUsed = lastUsed
+	m.tokens[ID] = token
+
+	return nil
+}
+
+// DeleteToken revokes the token by removing it from the database
+func (m *DB) DeleteToken(token data.Token) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	delete(m.tokens, token.ID)
+
+	return nil
+}
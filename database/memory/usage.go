@@ -0,0 +1,47 @@
+package memory
+
+import (
+	"fmt"
+
+	"github.com/djavorszky/ddn-api/database/data"
+)
+
+// usageFields maps the usage scopes to the field they are counted by.
+var usageFields = map[string]func(data.Row) string{
+	data.ScopeUser:  func(row data.Row) string { return row.Creator },
+	data.ScopeGroup: func(row data.Row) string { return row.Group },
+	data.ScopeAgent: func(row data.Row) string { return row.AgentName },
+}
+
+// FetchUsage counts the databases that belong to name in the scope, the
+// imports among them that are still in progress, and the total size of
+// their dumps.
+func (m *DB) FetchUsage(scope, name string) (data.Usage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if err := m.alive(); err != nil {
+		return data.Usage{}, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	field, ok := usageFields[scope]
+	if !ok {
+		return data.Usage{}, fmt.Errorf("unknown scope %q", scope)
+	}
+
+	var usage data.Usage
+	for _, row := range m.rows {
+		if field(row) != name {
+			continue
+		}
+
+		usage.Databases++
+		usage.DumpSize += row.DumpSize
+
+		if row.InProgress() && row.Dumpfile != "" {
+			usage.Imports++
+		}
+	}
+
+	return usage, nil
+}
@@ -0,0 +1,59 @@
+package memory
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/djavorszky/ddn-api/database/data"
+	"github.com/djavorszky/sutils"
+)
+
+// FetchUser returns the user with the given email. If the user was never
+// persisted, an empty user is returned without an error.
+func (m *DB) FetchUser(email string) (data.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if err := m.alive(); err != nil {
+		return data.User{}, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	return m.users[email], nil
+}
+
+// FetchUsers returns all persisted users
+func (m *DB) FetchUsers() ([]data.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if err := m.alive(); err != nil {
+		return nil, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	var users []data.User
+	for _, user := range m.users {
+		users = append(users, user)
+	}
+
+	sort.Slice(users, func(i, j int) bool { return users[i].Email < users[j].Email })
+
+	return users, nil
+}
+
+// SaveUser persists the user, overwriting the role it had before
+func (m *DB) SaveUser(user data.User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	if !sutils.Present(user.Email, user.Role) {
+		return fmt.Errorf("missing email or role")
+	}
+
+	m.users[user.Email] = user
+
+	return nil
+}
@@ -0,0 +1,170 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Dialect is what the Migrator needs to know about the SQL of a database
+// server.
+type Dialect interface {
+	// CreateTable returns the statement that creates the
+	// schema_migrations table, unless it exists.
+	CreateTable() string
+
+	// Rebind replaces the ? placeholders and the backtick quoted
+	// identifiers of the query with the ones the driver expects.
+	Rebind(query string) string
+
+	// TransactionalDDL returns whether schema changes are rolled back
+	// along with the transaction they were made in.
+	TransactionalDDL() bool
+
+	// TryLock takes the migration lock of the database for the
+	// connection, if no other connection holds it.
+	TryLock(ctx context.Context, conn *sql.Conn) (bool, error)
+
+	// Unlock releases the lock taken with TryLock.
+	Unlock(ctx context.Context, conn *sql.Conn) error
+}
+
+// Dialects of the supported database servers.
+var (
+	MySQL    Dialect = mysqlDialect{}
+	Postgres Dialect = postgresDialect{}
+	SQLite   Dialect = sqliteDialect{}
+)
+
+// MySQL commits implicitly before and after schema changes, so those
+// can't be rolled back. The lock is a named lock of the server, which is
+// released when the connection is closed, even if the server crashes.
+type mysqlDialect struct{}
+
+func (mysqlDialect) CreateTable() string {
+	return "CREATE TABLE IF NOT EXISTS `schema_migrations` (`version` INT NOT NULL, `name` VARCHAR(255) NOT NULL, `checksum` CHAR(64) NOT NULL, `dirty` TINYINT NOT NULL DEFAULT 0, `appliedAt` DATETIME NOT NULL, PRIMARY KEY (`version`));"
+}
+
+func (mysqlDialect) Rebind(query string) string { return query }
+
+func (mysqlDialect) TransactionalDDL() bool { return false }
+
+func (mysqlDialect) TryLock(ctx context.Context, conn *sql.Conn) (bool, error) {
+	var got sql.NullInt64
+
+	err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(CONCAT('ddn_migrate_', DATABASE()), 0)").Scan(&got)
+
+	return got.Int64 == 1, err
+}
+
+func (mysqlDialect) Unlock(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, "DO RELEASE_LOCK(CONCAT('ddn_migrate_', DATABASE()))")
+
+	return err
+}
+
+// PostgreSQL rolls back schema changes with the transaction. The lock is a
+// session level advisory lock, released when the connection is closed.
+type postgresDialect struct{}
+
+func (postgresDialect) CreateTable() string {
+	return "CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER NOT NULL PRIMARY KEY, name VARCHAR(255) NOT NULL, checksum VARCHAR(64) NOT NULL, dirty INTEGER NOT NULL DEFAULT 0, appliedAt TIMESTAMPTZ NOT NULL);"
+}
+
+// Rebind numbers the placeholders, and turns the backtick quoted
+// identifiers into double quoted ones. Unquoted identifiers are folded to
+// lower case, so the quoted ones are as well, to keep naming the same
+// column. String literals are left as they are.
+func (postgresDialect) Rebind(query string) string {
+	var (
+		b               strings.Builder
+		n               int
+		literal, quoted bool
+	)
+
+	for _, r := range query {
+		switch {
+		case r == '\'' && !quoted:
+			literal = !literal
+		case literal:
+		case r == '`':
+			quoted = !quoted
+			r = '"'
+		case quoted:
+			r = unicode.ToLower(r)
+		case r == '?':
+			n++
+			fmt.Fprintf(&b, "$%d", n)
+			continue
+		}
+
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+func (postgresDialect) TransactionalDDL() bool { return true }
+
+func (postgresDialect) TryLock(ctx context.Context, conn *sql.Conn) (bool, error) {
+	var got bool
+
+	err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock(hashtext('ddn_migrate_' || current_database()))").Scan(&got)
+
+	return got, err
+}
+
+func (postgresDialect) Unlock(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock(hashtext('ddn_migrate_' || current_database()))")
+
+	return err
+}
+
+// staleLock is how old an SQLite migration lock has to be to assume that
+// the server that took it died while migrating.
+const staleLock = 10 * time.Minute
+
+// SQLite rolls back schema changes with the transaction. It has no locks
+// that outlive a transaction, so the lock is a row in the schema_lock
+// table, which is taken over once it's stale.
+type sqliteDialect struct{}
+
+func (sqliteDialect) CreateTable() string {
+	return "CREATE TABLE IF NOT EXISTS `schema_migrations` (`version` INTEGER NOT NULL PRIMARY KEY, `name` TEXT NOT NULL, `checksum` TEXT NOT NULL, `dirty` INTEGER NOT NULL DEFAULT 0, `appliedAt` DATETIME NOT NULL);"
+}
+
+func (sqliteDialect) Rebind(query string) string { return query }
+
+func (sqliteDialect) TransactionalDDL() bool { return true }
+
+func (sqliteDialect) TryLock(ctx context.Context, conn *sql.Conn) (bool, error) {
+	_, err := conn.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS `schema_lock` (`id` INTEGER NOT NULL PRIMARY KEY CHECK (`id` = 1), `acquired` INTEGER NOT NULL);")
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now()
+
+	_, err = conn.ExecContext(ctx, "DELETE FROM `schema_lock` WHERE `acquired` < ?", now.Add(-staleLock).Unix())
+	if err != nil {
+		return false, err
+	}
+
+	res, err := conn.ExecContext(ctx, "INSERT OR IGNORE INTO `schema_lock` (`id`, `acquired`) VALUES (1, ?)", now.Unix())
+	if err != nil {
+		return false, err
+	}
+
+	taken, err := res.RowsAffected()
+
+	return taken == 1, err
+}
+
+func (sqliteDialect) Unlock(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, "DELETE FROM `schema_lock`")
+
+	return err
+}
@@ -0,0 +1,534 @@
+// Package migrate applies and rolls back the versioned schema migrations
+// of the SQL backends.
+//
+// The applied migrations are recorded in the schema_migrations table along
+// with a checksum of their statements, so migrations edited after they
+// were applied are detected instead of silently diverging. A lock keeps two
+// servers from migrating the same database at once, and a migration that
+// fails halfway is left marked dirty, so the next run stops until the
+// schema is fixed by hand and the version is forced.
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/djavorszky/ddn-common/logger"
+	"github.com/djavorszky/sutils"
+)
+
+const (
+	// DefaultLockTimeout is how long a migration waits for another server
+	// to finish migrating the same database.
+	DefaultLockTimeout = time.Minute
+
+	lockPoll = 500 * time.Millisecond
+)
+
+// Migration is a numbered change of the schema. Up applies it, Down
+// reverts it. A migration without Down statements has nothing to revert,
+// unless it's Irreversible, in which case it can't be rolled back at all.
+type Migration struct {
+	Version      int
+	Name         string
+	Up           []string
+	Down         []string
+	Irreversible bool
+}
+
+// Checksum identifies the statements that apply the migration.
+func (mig Migration) Checksum() string {
+	h := sha256.New()
+	for _, stmt := range mig.Up {
+		io.WriteString(h, stmt)
+		h.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// State is a migration along with what the database knows about it.
+type State struct {
+	Migration
+
+	Applied   bool
+	AppliedAt time.Time
+
+	// Dirty is set if applying or reverting the migration failed
+	// halfway.
+	Dirty bool
+
+	// Changed is set if the migration was edited after it was applied.
+	Changed bool
+
+	// Unknown is set if the migration was applied by a newer version of
+	// the server, which is why only its version and name are known.
+	Unknown bool
+}
+
+// record is a row of the schema_migrations table.
+type record struct {
+	name      string
+	checksum  string
+	dirty     bool
+	appliedAt time.Time
+}
+
+// Migrator migrates a database with the migrations of its backend.
+type Migrator struct {
+	db         *sql.DB
+	dialect    Dialect
+	migrations []Migration
+
+	// LockTimeout is how long to wait for another server to finish
+	// migrating. Defaults to DefaultLockTimeout.
+	LockTimeout time.Duration
+}
+
+// New returns a Migrator for the database. The migrations have to be
+// numbered from 1 without gaps, in order.
+func New(db *sql.DB, dialect Dialect, migrations []Migration) (*Migrator, error) {
+	for i, mig := range migrations {
+		if mig.Version != i+1 {
+			return nil, fmt.Errorf("migration %q has version %d, expected %d", mig.Name, mig.Version, i+1)
+		}
+
+		if !sutils.Present(mig.Name) || len(mig.Up) == 0 {
+			return nil, fmt.Errorf("migration %d is missing its name or statements", mig.Version)
+		}
+	}
+
+	return &Migrator{db: db, dialect: dialect, migrations: migrations}, nil
+}
+
+// Latest returns the version of the last migration.
+func (m *Migrator) Latest() int {
+	return len(m.migrations)
+}
+
+// Status returns the state of all migrations, including the ones applied
+// by newer versions of the server.
+func (m *Migrator) Status() ([]State, error) {
+	var states []State
+
+	err := m.run(func(ctx context.Context, conn *sql.Conn, applied map[int]record) error {
+		for _, mig := range m.migrations {
+			state := State{Migration: mig}
+
+			if rec, ok := applied[mig.Version]; ok {
+				state.Applied = true
+				state.AppliedAt = rec.appliedAt
+				state.Dirty = rec.dirty
+				state.Changed = rec.checksum != mig.Checksum()
+			}
+
+			states = append(states, state)
+		}
+
+		for _, version := range unknownVersions(applied, m.Latest()) {
+			rec := applied[version]
+
+			states = append(states, State{
+				Migration: Migration{Version: version, Name: rec.name},
+				Applied:   true,
+				AppliedAt: rec.appliedAt,
+				Dirty:     rec.dirty,
+				Unknown:   true,
+			})
+		}
+
+		return nil
+	})
+
+	return states, err
+}
+
+// Up applies all migrations that were not applied yet.
+func (m *Migrator) Up() error {
+	return m.To(m.Latest())
+}
+
+// Down rolls back the last steps applied migrations.
+func (m *Migrator) Down(steps int) error {
+	if steps < 1 {
+		return fmt.Errorf("invalid number of steps: %d", steps)
+	}
+
+	return m.run(func(ctx context.Context, conn *sql.Conn, applied map[int]record) error {
+		version := current(applied) - steps
+		if version < 0 {
+			version = 0
+		}
+
+		return m.migrate(ctx, conn, applied, version)
+	})
+}
+
+// To applies or rolls back migrations until the database is at the
+// version.
+func (m *Migrator) To(version int) error {
+	return m.run(func(ctx context.Context, conn *sql.Conn, applied map[int]record) error {
+		return m.migrate(ctx, conn, applied, version)
+	})
+}
+
+// Force records the migrations up to and including the version as
+// applied and clean, and the ones after it as not applied, without
+// running any of them. It's for recovering after a failed migration was
+// completed or undone by hand.
+func (m *Migrator) Force(version int) error {
+	if version < 0 || version > m.Latest() {
+		return fmt.Errorf("unknown version %d, the latest is %d", version, m.Latest())
+	}
+
+	return m.run(func(ctx context.Context, conn *sql.Conn, applied map[int]record) error {
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("starting transaction failed: %s", sutils.TrimNL(err.Error()))
+		}
+		defer tx.Rollback()
+
+		_, err = tx.Exec(m.dialect.Rebind("DELETE FROM schema_migrations WHERE version > ?"), version)
+		if err != nil {
+			return fmt.Errorf("removing migrations after %d failed: %s", version, sutils.TrimNL(err.Error()))
+		}
+
+		for _, mig := range m.migrations[:version] {
+			if _, ok := applied[mig.Version]; ok {
+				_, err = tx.Exec(m.dialect.Rebind("UPDATE schema_migrations SET checksum = ?, dirty = 0 WHERE version = ?"), mig.Checksum(), mig.Version)
+			} else {
+				err = m.insertRecord(tx, mig)
+			}
+
+			if err != nil {
+				return fmt.Errorf("recording migration %d failed: %s", mig.Version, sutils.TrimNL(err.Error()))
+			}
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return fmt.Errorf("committing failed: %s", sutils.TrimNL(err.Error()))
+		}
+
+		logger.Info("Forced schema version %d", version)
+
+		return nil
+	})
+}
+
+// migrate checks that the applied migrations can be trusted, then applies
+// or reverts the ones needed to get to the version.
+func (m *Migrator) migrate(ctx context.Context, conn *sql.Conn, applied map[int]record, version int) error {
+	if version < 0 || version > m.Latest() {
+		return fmt.Errorf("unknown version %d, the latest is %d", version, m.Latest())
+	}
+
+	if unknown := unknownVersions(applied, m.Latest()); len(unknown) != 0 {
+		return fmt.Errorf("the database is at version %d, which is newer than the latest known version %d", unknown[len(unknown)-1], m.Latest())
+	}
+
+	for _, mig := range m.migrations {
+		rec, ok := applied[mig.Version]
+		if !ok {
+			continue
+		}
+
+		if rec.dirty {
+			return fmt.Errorf("migration %d (%q) failed before; fix the schema by hand, then force the version", mig.Version, mig.Name)
+		}
+
+		if rec.checksum != mig.Checksum() {
+			return fmt.Errorf("migration %d (%q) was changed after it was applied", mig.Version, mig.Name)
+		}
+	}
+
+	for _, mig := range m.migrations[:version] {
+		if _, ok := applied[mig.Version]; ok {
+			continue
+		}
+
+		err := m.apply(ctx, conn, mig)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := len(m.migrations) - 1; i >= version; i-- {
+		mig := m.migrations[i]
+		if _, ok := applied[mig.Version]; !ok {
+			continue
+		}
+
+		err := m.revert(ctx, conn, mig)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// apply runs the Up statements of the migration and records it. Where
+// schema changes can't be rolled back, the migration is recorded as dirty
+// before running them, and marked clean after they all succeeded.
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, mig Migration) error {
+	logger.Info("Applying migration %d %q", mig.Version, mig.Name)
+
+	transactional := m.dialect.TransactionalDDL()
+
+	if !transactional {
+		_, err := conn.ExecContext(ctx, m.dialect.Rebind("INSERT INTO schema_migrations (version, name, checksum, dirty, appliedAt) VALUES (?, ?, ?, 1, ?)"), mig.Version, mig.Name, mig.Checksum(), time.Now())
+		if err != nil {
+			return fmt.Errorf("recording migration %d failed: %s", mig.Version, sutils.TrimNL(err.Error()))
+		}
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("starting transaction failed: %s", sutils.TrimNL(err.Error()))
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range mig.Up {
+		_, err = tx.Exec(stmt)
+		if err != nil {
+			return fmt.Errorf("applying migration %d (%q) failed at %q: %s", mig.Version, mig.Name, stmt, sutils.TrimNL(err.Error()))
+		}
+	}
+
+	if transactional {
+		err = m.insertRecord(tx, mig)
+	} else {
+		_, err = tx.Exec(m.dialect.Rebind("UPDATE schema_migrations SET dirty = 0 WHERE version = ?"), mig.Version)
+	}
+
+	if err != nil {
+		return fmt.Errorf("recording migration %d failed: %s", mig.Version, sutils.TrimNL(err.Error()))
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("committing migration %d failed: %s", mig.Version, sutils.TrimNL(err.Error()))
+	}
+
+	return nil
+}
+
+// revert runs the Down statements of the migration and removes its
+// record, marking it dirty first where schema changes can't be rolled
+// back.
+func (m *Migrator) revert(ctx context.Context, conn *sql.Conn, mig Migration) error {
+	if mig.Irreversible {
+		return fmt.Errorf("migration %d (%q) can't be rolled back", mig.Version, mig.Name)
+	}
+
+	logger.Info("Rolling back migration %d %q", mig.Version, mig.Name)
+
+	if !m.dialect.TransactionalDDL() {
+		_, err := conn.ExecContext(ctx, m.dialect.Rebind("UPDATE schema_migrations SET dirty = 1 WHERE version = ?"), mig.Version)
+		if err != nil {
+			return fmt.Errorf("marking migration %d failed: %s", mig.Version, sutils.TrimNL(err.Error()))
+		}
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("starting transaction failed: %s", sutils.TrimNL(err.Error()))
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range mig.Down {
+		_, err = tx.Exec(stmt)
+		if err != nil {
+			return fmt.Errorf("rolling back migration %d (%q) failed at %q: %s", mig.Version, mig.Name, stmt, sutils.TrimNL(err.Error()))
+		}
+	}
+
+	_, err = tx.Exec(m.dialect.Rebind("DELETE FROM schema_migrations WHERE version = ?"), mig.Version)
+	if err != nil {
+		return fmt.Errorf("removing record of migration %d failed: %s", mig.Version, sutils.TrimNL(err.Error()))
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("committing rollback of migration %d failed: %s", mig.Version, sutils.TrimNL(err.Error()))
+	}
+
+	return nil
+}
+
+// insertRecord records the migration as applied and clean.
+func (m *Migrator) insertRecord(tx *sql.Tx, mig Migration) error {
+	_, err := tx.Exec(m.dialect.Rebind("INSERT INTO schema_migrations (version, name, checksum, dirty, appliedAt) VALUES (?, ?, ?, 0, ?)"), mig.Version, mig.Name, mig.Checksum(), time.Now())
+
+	return err
+}
+
+// run calls fn with a connection that holds the migration lock, and the
+// migrations applied to the database.
+func (m *Migrator) run(fn func(ctx context.Context, conn *sql.Conn, applied map[int]record) error) error {
+	ctx := context.Background()
+
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("getting connection failed: %s", sutils.TrimNL(err.Error()))
+	}
+	defer conn.Close()
+
+	err = m.lock(ctx, conn)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := m.dialect.Unlock(ctx, conn)
+		if err != nil {
+			logger.Error("releasing the migration lock failed: %v", err)
+		}
+	}()
+
+	_, err = conn.ExecContext(ctx, m.dialect.CreateTable())
+	if err != nil {
+		return fmt.Errorf("creating the schema_migrations table failed: %s", sutils.TrimNL(err.Error()))
+	}
+
+	applied, err := m.applied(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	if len(applied) == 0 {
+		applied, err = m.baseline(ctx, conn)
+		if err != nil {
+			return err
+		}
+	}
+
+	return fn(ctx, conn, applied)
+}
+
+func (m *Migrator) lock(ctx context.Context, conn *sql.Conn) error {
+	timeout := m.LockTimeout
+	if timeout == 0 {
+		timeout = DefaultLockTimeout
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		ok, err := m.dialect.TryLock(ctx, conn)
+		if err != nil {
+			return fmt.Errorf("taking the migration lock failed: %s", sutils.TrimNL(err.Error()))
+		}
+
+		if ok {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out after %s waiting for another server to finish migrating", timeout)
+		}
+
+		time.Sleep(lockPoll)
+	}
+}
+
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int]record, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, name, checksum, dirty, appliedAt FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("reading applied migrations failed: %s", sutils.TrimNL(err.Error()))
+	}
+	defer rows.Close()
+
+	applied := make(map[int]record)
+	for rows.Next() {
+		var (
+			version, dirty int
+			rec            record
+		)
+
+		err = rows.Scan(&version, &rec.name, &rec.checksum, &dirty, &rec.appliedAt)
+		if err != nil {
+			return nil, fmt.Errorf("reading applied migration failed: %s", sutils.TrimNL(err.Error()))
+		}
+		rec.dirty = dirty != 0
+
+		applied[version] = rec
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("reading applied migrations failed: %s", sutils.TrimNL(err.Error()))
+	}
+
+	return applied, nil
+}
+
+// baseline records the migrations that were applied before this package
+// was used. Back then, every executed query was a row in the version
+// table, in the order of the migrations.
+func (m *Migrator) baseline(ctx context.Context, conn *sql.Conn) (map[int]record, error) {
+	var count int
+
+	err := conn.QueryRowContext(ctx, "SELECT count(*) FROM version").Scan(&count)
+	if err != nil || count == 0 {
+		// No version table, so it's a new database.
+		return map[int]record{}, nil
+	}
+
+	if count > m.Latest() {
+		return nil, fmt.Errorf("the version table lists %d queries, but only %d migrations are known", count, m.Latest())
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("starting transaction failed: %s", sutils.TrimNL(err.Error()))
+	}
+	defer tx.Rollback()
+
+	for _, mig := range m.migrations[:count] {
+		err = m.insertRecord(tx, mig)
+		if err != nil {
+			return nil, fmt.Errorf("recording migration %d failed: %s", mig.Version, sutils.TrimNL(err.Error()))
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("committing baseline failed: %s", sutils.TrimNL(err.Error()))
+	}
+
+	logger.Info("Recorded %d migrations applied before schema_migrations existed", count)
+
+	return m.applied(ctx, conn)
+}
+
+// current returns the highest applied version.
+func current(applied map[int]record) int {
+	var version int
+	for v := range applied {
+		if v > version {
+			version = v
+		}
+	}
+
+	return version
+}
+
+// unknownVersions returns the applied versions after latest, in order.
+func unknownVersions(applied map[int]record, latest int) []int {
+	var versions []int
+	for v := range applied {
+		if v > latest {
+			versions = append(versions, v)
+		}
+	}
+
+	sort.Ints(versions)
+
+	return versions
+}
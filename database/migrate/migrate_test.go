@@ -0,0 +1,365 @@
+package migrate
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var testMigrations = []Migration{
+	{
+		Version: 1,
+		Name:    "Create the people table",
+		Up:      []string{"CREATE TABLE people (id INTEGER PRIMARY KEY, name TEXT NOT NULL);"},
+		Down:    []string{"DROP TABLE people;"},
+	},
+	{
+		Version: 2,
+		Name:    "Add an email column",
+		Up:      []string{"ALTER TABLE people ADD COLUMN email TEXT NOT NULL DEFAULT '';"},
+		Down:    []string{"ALTER TABLE people DROP COLUMN email;"},
+	},
+	{
+		Version: 3,
+		Name:    "Add a default person",
+		Up:      []string{"INSERT INTO people (name) VALUES ('nobody');"},
+	},
+}
+
+func newTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "migrate.db"))
+	if err != nil {
+		t.Fatalf("opening database failed: %v", err)
+	}
+
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func newTestMigrator(t *testing.T, db *sql.DB, dialect Dialect, migrations []Migration) *Migrator {
+	m, err := New(db, dialect, migrations)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+	m.LockTimeout = time.Second
+
+	return m
+}
+
+// applied returns the applied versions, in order.
+func applied(t *testing.T, m *Migrator) []int {
+	states, err := m.Status()
+	if err != nil {
+		t.Fatalf("Status() failed: %v", err)
+	}
+
+	var versions []int
+	for _, state := range states {
+		if state.Applied {
+			versions = append(versions, state.Version)
+		}
+	}
+
+	return versions
+}
+
+func equal(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name       string
+		migrations []Migration
+		wantErr    bool
+	}{
+		{"valid", testMigrations, false},
+		{"none", nil, false},
+		{"gap", []Migration{testMigrations[0], testMigrations[2]}, true},
+		{"missing name", []Migration{{Version: 1, Up: []string{"SELECT 1"}}}, true},
+		{"missing statements", []Migration{{Version: 1, Name: "empty"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(nil, SQLite, tt.migrations); (err != nil) != tt.wantErr {
+				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMigrator(t *testing.T) {
+	db := newTestDB(t)
+	m := newTestMigrator(t, db, SQLite, testMigrations)
+
+	steps := []struct {
+		name string
+		run  func() error
+		want []int
+	}{
+		{"up", m.Up, []int{1, 2, 3}},
+		{"up again", m.Up, []int{1, 2, 3}},
+		{"down", func() error { return m.Down(1) }, []int{1, 2}},
+		{"to 0", func() error { return m.To(0) }, nil},
+		{"to 2", func() error { return m.To(2) }, []int{1, 2}},
+		{"down past 0", func() error { return m.Down(5) }, nil},
+		{"up after down", m.Up, []int{1, 2, 3}},
+	}
+	for _, step := range steps {
+		if err := step.run(); err != nil {
+			t.Fatalf("%s failed: %v", step.name, err)
+		}
+
+		if got := applied(t, m); !equal(got, step.want) {
+			t.Errorf("%s: applied %v, want %v", step.name, got, step.want)
+		}
+	}
+
+	var count int
+	db.QueryRow("SELECT count(*) FROM people").Scan(&count)
+	if count != 1 {
+		t.Errorf("expected the default person once, got %d", count)
+	}
+
+	if err := m.To(4); err == nil {
+		t.Errorf("To() an unknown version should fail")
+	}
+}
+
+func TestChanged(t *testing.T) {
+	db := newTestDB(t)
+
+	err := newTestMigrator(t, db, SQLite, testMigrations).Up()
+	if err != nil {
+		t.Fatalf("Up() failed: %v", err)
+	}
+
+	edited := append([]Migration(nil), testMigrations...)
+	edited[1].Up = []string{"ALTER TABLE people ADD COLUMN mail TEXT NOT NULL DEFAULT '';"}
+
+	m := newTestMigrator(t, db, SQLite, edited)
+
+	states, err := m.Status()
+	if err != nil {
+		t.Fatalf("Status() failed: %v", err)
+	}
+
+	if states[0].Changed || !states[1].Changed {
+		t.Errorf("expected only migration 2 to be changed, got %+v", states)
+	}
+
+	err = m.Down(1)
+	if err == nil || !strings.Contains(err.Error(), "changed") {
+		t.Errorf("migrating with a changed migration should fail, got %v", err)
+	}
+}
+
+// nonTransactional behaves like databases that can't roll back schema
+// changes.
+type nonTransactional struct {
+	Dialect
+}
+
+func (nonTransactional) TransactionalDDL() bool { return false }
+
+func TestDirty(t *testing.T) {
+	db := newTestDB(t)
+
+	broken := append([]Migration(nil), testMigrations...)
+	broken[1].Up = []string{"ALTER TABLE people ADD COLUMN email TEXT NOT NULL DEFAULT '';", "ALTER TABLE nobody ADD COLUMN nothing TEXT;"}
+
+	m := newTestMigrator(t, db, nonTransactional{SQLite}, broken)
+
+	if err := m.Up(); err == nil {
+		t.Fatalf("Up() with a broken migration should fail")
+	}
+
+	states, err := m.Status()
+	if err != nil {
+		t.Fatalf("Status() failed: %v", err)
+	}
+
+	if !states[1].Dirty || states[2].Applied {
+		t.Fatalf("expected migration 2 to be dirty and 3 not applied, got %+v", states)
+	}
+
+	err = m.Up()
+	if err == nil || !strings.Contains(err.Error(), "failed before") {
+		t.Fatalf("migrating a dirty database should fail, got %v", err)
+	}
+
+	// Complete the migration by hand, then record it as applied.
+	if _, err := db.Exec(testMigrations[1].Up[0]); err != nil {
+		t.Fatalf("fixing the schema failed: %v", err)
+	}
+
+	m = newTestMigrator(t, db, SQLite, testMigrations)
+
+	if err := m.Force(2); err != nil {
+		t.Fatalf("Force() failed: %v", err)
+	}
+
+	if err := m.Up(); err != nil {
+		t.Fatalf("Up() after forcing failed: %v", err)
+	}
+
+	if got := applied(t, m); !equal(got, []int{1, 2, 3}) {
+		t.Errorf("applied %v after forcing", got)
+	}
+}
+
+func TestTransactional(t *testing.T) {
+	db := newTestDB(t)
+
+	broken := append([]Migration(nil), testMigrations...)
+	broken[1].Up = []string{"ALTER TABLE people ADD COLUMN email TEXT NOT NULL DEFAULT '';", "ALTER TABLE nobody ADD COLUMN nothing TEXT;"}
+
+	if err := newTestMigrator(t, db, SQLite, broken).Up(); err == nil {
+		t.Fatalf("Up() with a broken migration should fail")
+	}
+
+	// The failed migration was rolled back, so the fixed one applies.
+	m := newTestMigrator(t, db, SQLite, testMigrations)
+
+	if err := m.Up(); err != nil {
+		t.Fatalf("Up() after a rolled back failure failed: %v", err)
+	}
+}
+
+func TestIrreversible(t *testing.T) {
+	db := newTestDB(t)
+
+	migrations := append([]Migration(nil), testMigrations...)
+	migrations[2].Irreversible = true
+
+	m := newTestMigrator(t, db, SQLite, migrations)
+
+	if err := m.Up(); err != nil {
+		t.Fatalf("Up() failed: %v", err)
+	}
+
+	if err := m.Down(1); err == nil {
+		t.Errorf("rolling back an irreversible migration should fail")
+	}
+
+	if got := applied(t, m); !equal(got, []int{1, 2, 3}) {
+		t.Errorf("applied %v after failed rollback", got)
+	}
+}
+
+func TestUnknown(t *testing.T) {
+	db := newTestDB(t)
+
+	if err := newTestMigrator(t, db, SQLite, testMigrations).Up(); err != nil {
+		t.Fatalf("Up() failed: %v", err)
+	}
+
+	m := newTestMigrator(t, db, SQLite, testMigrations[:2])
+
+	if err := m.Up(); err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Errorf("migrating a newer database should fail, got %v", err)
+	}
+
+	states, err := m.Status()
+	if err != nil {
+		t.Fatalf("Status() failed: %v", err)
+	}
+
+	if len(states) != 3 || !states[2].Unknown || states[2].Name != testMigrations[2].Name {
+		t.Errorf("expected migration 3 to be listed as unknown, got %+v", states)
+	}
+}
+
+func TestBaseline(t *testing.T) {
+	db := newTestDB(t)
+
+	// The schema as the version table based migrations left it, after the
+	// first two queries.
+	for _, stmt := range []string{
+		"CREATE TABLE version (queryId INTEGER PRIMARY KEY AUTOINCREMENT, query TEXT NULL, comment TEXT NULL, date DATETIME NULL);",
+		testMigrations[0].Up[0],
+		testMigrations[1].Up[0],
+		"INSERT INTO version (query) VALUES ('first'), ('second');",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("preparing legacy schema failed: %v", err)
+		}
+	}
+
+	m := newTestMigrator(t, db, SQLite, testMigrations)
+
+	if err := m.Up(); err != nil {
+		t.Fatalf("Up() failed: %v", err)
+	}
+
+	if got := applied(t, m); !equal(got, []int{1, 2, 3}) {
+		t.Errorf("applied %v after baseline", got)
+	}
+}
+
+func TestLock(t *testing.T) {
+	db := newTestDB(t)
+	m := newTestMigrator(t, db, SQLite, testMigrations)
+	m.LockTimeout = time.Millisecond
+
+	_, err := db.Exec("CREATE TABLE schema_lock (id INTEGER NOT NULL PRIMARY KEY CHECK (id = 1), acquired INTEGER NOT NULL);")
+	if err != nil {
+		t.Fatalf("creating lock table failed: %v", err)
+	}
+
+	_, err = db.Exec("INSERT INTO schema_lock (id, acquired) VALUES (1, ?)", time.Now().Unix())
+	if err != nil {
+		t.Fatalf("taking lock failed: %v", err)
+	}
+
+	if err := m.Up(); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("migrating while locked should time out, got %v", err)
+	}
+
+	_, err = db.Exec("UPDATE schema_lock SET acquired = ?", time.Now().Add(-2*staleLock).Unix())
+	if err != nil {
+		t.Fatalf("aging lock failed: %v", err)
+	}
+
+	if err := m.Up(); err != nil {
+		t.Fatalf("a stale lock should be taken over, got %v", err)
+	}
+
+	var locks int
+	db.QueryRow("SELECT count(*) FROM schema_lock").Scan(&locks)
+	if locks != 0 {
+		t.Errorf("lock was not released")
+	}
+}
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		query, want string
+	}{
+		{"UPDATE t SET a = ? WHERE b = ? AND c = ?", "UPDATE t SET a = $1 WHERE b = $2 AND c = $3"},
+		{"SELECT `id`, `createDate` FROM `user` WHERE `time` < ?", `SELECT "id", "createdate" FROM "user" WHERE "time" < $1`},
+		{"SELECT * FROM t WHERE a LIKE ? ESCAPE '\\' AND b <> '?`x`'", `SELECT * FROM t WHERE a LIKE $1 ESCAPE '\' AND b <> '?` + "`x`" + `'`},
+	}
+
+	for _, tt := range tests {
+		if got := Postgres.Rebind(tt.query); got != tt.want {
+			t.Errorf("Rebind(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
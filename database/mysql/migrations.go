@@ -0,0 +1,178 @@
+package mysql
+
+import "github.com/djavorszky/ddn-api/database/migrate"
+
+var migrations = []migrate.Migration{
+	{
+		Version: 1,
+		Name:    "Create the version table",
+		Up:      []string{"CREATE TABLE `version` (`queryId` INT NOT NULL AUTO_INCREMENT, `query` LONGTEXT NULL, `comment` TEXT NULL, `date` DATETIME NULL, PRIMARY KEY (`queryId`));"},
+		Down:    []string{"DROP TABLE `version`;"},
+	},
+	{
+		Version: 2,
+		Name:    "Create the databases table",
+		Up:      []string{"CREATE TABLE IF NOT EXISTS `databases` ( `id` INT NOT NULL AUTO_INCREMENT, `dbname` VARCHAR(255) NULL, `dbuser` VARCHAR(255) NULL, `dbpass` VARCHAR(255) NULL, `dbsid` VARCHAR(45) NULL, `dumpfile` LONGTEXT NULL, `createDate` DATETIME NULL, `expiryDate` DATETIME NULL, `creator` VARCHAR(255) NULL, `connectorName` VARCHAR(255) NULL, `dbAddress` VARCHAR(255) NULL, `dbPort` VARCHAR(45) NULL, `dbvendor` VARCHAR(255) NULL, `status` INT,  PRIMARY KEY (`id`));"},
+		Down:    []string{"DROP TABLE `databases`;"},
+	},
+	{
+		Version: 3,
+		Name:    "Add 'visibility' to databases, default 0",
+		Up:      []string{"ALTER TABLE `databases` ADD COLUMN `visibility` INT(11) NULL DEFAULT 0 AFTER `status`;"},
+		Down:    []string{"ALTER TABLE `databases` DROP COLUMN `visibility`;"},
+	},
+	{
+		Version: 4,
+		Name:    "Add 'message' column",
+		Up:      []string{"ALTER TABLE `databases` ADD COLUMN `message` LONGTEXT AFTER `status`;"},
+		Down:    []string{"ALTER TABLE `databases` DROP COLUMN `message`;"},
+	},
+	{
+		Version: 5,
+		Name:    "Update 'message' columns to empty where null",
+		Up:      []string{"UPDATE `databases` SET `message` = '' WHERE `message` IS NULL;"},
+	},
+	{
+		Version: 6,
+		Name:    "Update 'databases' table: connectorName -> agentName",
+		Up:      []string{"ALTER TABLE `databases` CHANGE COLUMN `connectorName` `agentName` VARCHAR(255) NULL DEFAULT NULL;"},
+		Down:    []string{"ALTER TABLE `databases` CHANGE COLUMN `agentName` `connectorName` VARCHAR(255) NULL DEFAULT NULL;"},
+	},
+	{
+		Version: 7,
+		Name:    "Create the push_subscriptions table",
+		Up:      []string{"CREATE TABLE IF NOT EXISTS `push_subscriptions` ( `subscriber` VARCHAR(255) NOT NULL, `endpoint` VARCHAR(255) NOT NULL, `p256dh_key` VARCHAR(255) NOT NULL, `auth_key` VARCHAR(255) NOT NULL);"},
+		Down:    []string{"DROP TABLE `push_subscriptions`;"},
+	},
+	{
+		Version: 8,
+		Name:    "Create unique index on columns (subscriber,endpoint) for table push_subscriptions",
+		Up:      []string{"CREATE UNIQUE INDEX `push_subscription` ON `push_subscriptions` (`subscriber`, `endpoint`);"},
+		Down:    []string{"DROP INDEX `push_subscription` ON `push_subscriptions`;"},
+	},
+	{
+		Version: 9,
+		Name:    "Add 'comment' column",
+		Up:      []string{"ALTER TABLE `databases` ADD COLUMN `comment` LONGTEXT;"},
+		Down:    []string{"ALTER TABLE `databases` DROP COLUMN `comment`;"},
+	},
+	{
+		Version: 10,
+		Name:    "Update 'comment' columns to empty where null",
+		Up:      []string{"UPDATE `databases` SET `comment` = '' WHERE `comment` IS NULL;"},
+	},
+	{
+		Version: 11,
+		Name:    "Create unique index on columns (dbname, agentName) for table databases",
+		Up:      []string{"CREATE UNIQUE INDEX `agent_db_idx` ON `databases` (`dbname`, `agentName`);"},
+		Down:    []string{"DROP INDEX `agent_db_idx` ON `databases`;"},
+	},
+	{
+		Version: 12,
+		Name:    "Merge dbAddress and dbPort where needed",
+		Up:      []string{"UPDATE `databases` SET dbAddress = CONCAT(dbAddress, \":\", dbPort) WHERE dbAddress NOT LIKE \"%:%\""},
+		Down:    []string{"UPDATE `databases` SET dbPort = SUBSTRING_INDEX(dbAddress, \":\", -1), dbAddress = SUBSTRING_INDEX(dbAddress, \":\", 1) WHERE dbAddress LIKE \"%:%\""},
+	},
+	{
+		Version: 13,
+		Name:    "Create the api_tokens table",
+		Up:      []string{"CREATE TABLE IF NOT EXISTS `api_tokens` (`id` INT NOT NULL AUTO_INCREMENT, `name` VARCHAR(255) NOT NULL, `owner` VARCHAR(255) NOT NULL, `hash` CHAR(64) NOT NULL, `prefix` VARCHAR(16) NOT NULL, `createDate` DATETIME NOT NULL, `expiryDate` DATETIME NOT NULL, `lastUsed` DATETIME NULL, PRIMARY KEY (`id`), UNIQUE INDEX `api_token_hash` (`hash`), INDEX `api_token_owner` (`owner`));"},
+		Down:    []string{"DROP TABLE `api_tokens`;"},
+	},
+	{
+		Version: 14,
+		Name:    "Create the sessions table",
+		Up:      []string{"CREATE TABLE IF NOT EXISTS `sessions` (`id` CHAR(64) NOT NULL, `user` VARCHAR(255) NOT NULL, `csrfToken` VARCHAR(255) NOT NULL, `createDate` DATETIME NOT NULL, `expiryDate` DATETIME NOT NULL, PRIMARY KEY (`id`), INDEX `session_expiry` (`expiryDate`));"},
+		Down:    []string{"DROP TABLE `sessions`;"},
+	},
+	{
+		Version: 15,
+		Name:    "Create the agents table",
+		Up:      []string{"CREATE TABLE IF NOT EXISTS `agents` (`id` INT NOT NULL, `shortName` VARCHAR(255) NOT NULL, `longName` VARCHAR(255) NOT NULL, `identifier` VARCHAR(255) NOT NULL, `dbVendor` VARCHAR(255) NOT NULL, `dbAddress` VARCHAR(255) NOT NULL, `dbSID` VARCHAR(45) NOT NULL, `version` VARCHAR(45) NOT NULL, `address` VARCHAR(255) NOT NULL, `token` CHAR(64) NOT NULL, PRIMARY KEY (`shortName`));"},
+		Down:    []string{"DROP TABLE `agents`;"},
+	},
+	{
+		Version: 16,
+		Name:    "Create the sequences table",
+		Up:      []string{"CREATE TABLE IF NOT EXISTS `sequences` (`name` VARCHAR(45) NOT NULL, `value` INT NOT NULL, PRIMARY KEY (`name`));"},
+		Down:    []string{"DROP TABLE `sequences`;"},
+	},
+	{
+		Version: 17,
+		Name:    "Create the users table",
+		Up:      []string{"CREATE TABLE IF NOT EXISTS `users` (`email` VARCHAR(255) NOT NULL, `role` VARCHAR(45) NOT NULL, `updateDate` DATETIME NOT NULL, `updatedBy` VARCHAR(255) NOT NULL, PRIMARY KEY (`email`));"},
+		Down:    []string{"DROP TABLE `users`;"},
+	},
+	{
+		Version: 18,
+		Name:    "Add 'ownerGroup' column",
+		Up:      []string{"ALTER TABLE `databases` ADD COLUMN `ownerGroup` VARCHAR(255) NOT NULL DEFAULT '';"},
+		Down:    []string{"ALTER TABLE `databases` DROP COLUMN `ownerGroup`;"},
+	},
+	{
+		Version: 19,
+		Name:    "Create the user_groups table",
+		Up:      []string{"CREATE TABLE IF NOT EXISTS `user_groups` (`name` VARCHAR(255) NOT NULL, `createDate` DATETIME NOT NULL, `createdBy` VARCHAR(255) NOT NULL, PRIMARY KEY (`name`));"},
+		Down:    []string{"DROP TABLE `user_groups`;"},
+	},
+	{
+		Version: 20,
+		Name:    "Create the group_members table",
+		Up:      []string{"CREATE TABLE IF NOT EXISTS `group_members` (`groupName` VARCHAR(255) NOT NULL, `member` VARCHAR(255) NOT NULL, PRIMARY KEY (`groupName`, `member`), INDEX `group_member_idx` (`member`));"},
+		Down:    []string{"DROP TABLE `group_members`;"},
+	},
+	{
+		Version: 21,
+		Name:    "Add 'dumpSize' column",
+		Up:      []string{"ALTER TABLE `databases` ADD COLUMN `dumpSize` BIGINT NOT NULL DEFAULT 0;"},
+		Down:    []string{"ALTER TABLE `databases` DROP COLUMN `dumpSize`;"},
+	},
+	{
+		Version: 22,
+		Name:    "Create the audit_log table",
+		Up:      []string{"CREATE TABLE IF NOT EXISTS `audit_log` (`id` INT NOT NULL AUTO_INCREMENT, `time` DATETIME NOT NULL, `actor` VARCHAR(255) NOT NULL, `action` VARCHAR(64) NOT NULL, `target` INT NOT NULL DEFAULT 0, `targetName` VARCHAR(255) NOT NULL DEFAULT '', `agent` VARCHAR(255) NOT NULL DEFAULT '', `statusBefore` INT NOT NULL DEFAULT 0, `statusAfter` INT NOT NULL DEFAULT 0, `sourceIP` VARCHAR(64) NOT NULL DEFAULT '', `details` TEXT NOT NULL, PRIMARY KEY (`id`), INDEX `audit_time` (`time`), INDEX `audit_actor` (`actor`), INDEX `audit_target` (`target`));"},
+		Down:    []string{"DROP TABLE `audit_log`;"},
+	},
+	{
+		Version: 23,
+		Name:    "Make room for encrypted passwords",
+		Up:      []string{"ALTER TABLE `databases` MODIFY COLUMN `dbpass` TEXT NULL;"},
+		Down:    []string{"ALTER TABLE `databases` MODIFY COLUMN `dbpass` VARCHAR(255) NULL;"},
+	},
+	{
+		Version: 24,
+		Name:    "Create the jobs table",
+		Up:      []string{"CREATE TABLE IF NOT EXISTS `jobs` (`id` INT NOT NULL AUTO_INCREMENT, `kind` VARCHAR(32) NOT NULL, `target` INT NOT NULL DEFAULT 0, `targetName` VARCHAR(255) NOT NULL DEFAULT '', `payload` TEXT NOT NULL, `creator` VARCHAR(255) NOT NULL DEFAULT '', `status` VARCHAR(32) NOT NULL, `attempts` INT NOT NULL DEFAULT 0, `maxAttempts` INT NOT NULL DEFAULT 0, `lastError` TEXT NOT NULL, `nextRun` DATETIME NOT NULL, `createDate` DATETIME NOT NULL, `updateDate` DATETIME NOT NULL, PRIMARY KEY (`id`), INDEX `job_status_next_run` (`status`, `nextRun`), INDEX `job_target` (`target`));"},
+		Down:    []string{"DROP TABLE `jobs`;"},
+	},
+	{
+		Version: 25,
+		Name:    "Add 'updateDate' column",
+		Up:      []string{"ALTER TABLE `databases` ADD COLUMN `updateDate` DATETIME NOT NULL DEFAULT '1970-01-01 00:00:00';", "UPDATE `databases` SET `updateDate` = NOW();"},
+		Down:    []string{"ALTER TABLE `databases` DROP COLUMN `updateDate`;"},
+	},
+	{
+		Version: 26,
+		Name:    "Add 'extensions' column",
+		Up:      []string{"ALTER TABLE `databases` ADD COLUMN `extensions` INT NOT NULL DEFAULT 0;"},
+		Down:    []string{"ALTER TABLE `databases` DROP COLUMN `extensions`;"},
+	},
+	{
+		Version: 27,
+		Name:    "Add 'warned' column",
+		Up:      []string{"ALTER TABLE `databases` ADD COLUMN `warned` INT NOT NULL DEFAULT 0;"},
+		Down:    []string{"ALTER TABLE `databases` DROP COLUMN `warned`;"},
+	},
+	{
+		Version: 28,
+		Name:    "Add 'mode' column to agents",
+		Up:      []string{"ALTER TABLE `agents` ADD COLUMN `mode` VARCHAR(45) NOT NULL DEFAULT 'active';"},
+		Down:    []string{"ALTER TABLE `agents` DROP COLUMN `mode`;"},
+	},
+	{
+		Version: 29,
+		Name:    "Create the agent_labels table",
+		Up:      []string{"CREATE TABLE IF NOT EXISTS `agent_labels` (`shortName` VARCHAR(255) NOT NULL, `source` VARCHAR(16) NOT NULL, `name` VARCHAR(255) NOT NULL, `value` VARCHAR(255) NOT NULL, PRIMARY KEY (`shortName`, `source`, `name`));"},
+		Down:    []string{"DROP TABLE `agent_labels`;"},
+	},
+}
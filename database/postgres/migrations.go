@@ -0,0 +1,168 @@
+package postgres
+
+import "github.com/djavorszky/ddn-api/database/migrate"
+
+var migrations = []migrate.Migration{
+	{
+		Version: 1,
+		Name:    "Create the version table",
+		Up:      []string{"CREATE TABLE IF NOT EXISTS version (queryId SERIAL PRIMARY KEY, query TEXT NULL, comment TEXT NULL, date TIMESTAMPTZ NULL);"},
+		Down:    []string{"DROP TABLE version;"},
+	},
+	{
+		Version: 2,
+		Name:    "Create the databases table",
+		Up:      []string{"CREATE TABLE IF NOT EXISTS databases (id SERIAL PRIMARY KEY, dbname VARCHAR(255) NOT NULL DEFAULT '', dbuser VARCHAR(255) NOT NULL DEFAULT '', dbpass TEXT NOT NULL DEFAULT '', dbsid VARCHAR(255) NOT NULL DEFAULT '', dumpfile TEXT NOT NULL DEFAULT '', createDate TIMESTAMPTZ NULL, expiryDate TIMESTAMPTZ NULL, creator VARCHAR(255) NOT NULL DEFAULT '', agentName VARCHAR(255) NOT NULL DEFAULT '', dbAddress VARCHAR(255) NOT NULL DEFAULT '', dbPort VARCHAR(255) NOT NULL DEFAULT '', dbvendor VARCHAR(255) NOT NULL DEFAULT '', status INTEGER NOT NULL DEFAULT 0, message TEXT NOT NULL DEFAULT '', visibility INTEGER NOT NULL DEFAULT 0, comment TEXT NOT NULL DEFAULT '', ownerGroup VARCHAR(255) NOT NULL DEFAULT '', dumpSize BIGINT NOT NULL DEFAULT 0);"},
+		Down:    []string{"DROP TABLE databases;"},
+	},
+	{
+		Version: 3,
+		Name:    "Create unique index on columns (dbname, agentName) for table databases",
+		Up:      []string{"CREATE UNIQUE INDEX agent_db_idx ON databases (dbname, agentName);"},
+		Down:    []string{"DROP INDEX agent_db_idx;"},
+	},
+	{
+		Version: 4,
+		Name:    "Create the push_subscriptions table",
+		Up:      []string{"CREATE TABLE IF NOT EXISTS push_subscriptions (subscriber VARCHAR(255) NOT NULL, endpoint VARCHAR(512) NOT NULL, p256dh_key VARCHAR(255) NOT NULL, auth_key VARCHAR(255) NOT NULL);"},
+		Down:    []string{"DROP TABLE push_subscriptions;"},
+	},
+	{
+		Version: 5,
+		Name:    "Create unique index on columns (subscriber,endpoint) for table push_subscriptions",
+		Up:      []string{"CREATE UNIQUE INDEX push_subscription ON push_subscriptions (subscriber, endpoint);"},
+		Down:    []string{"DROP INDEX push_subscription;"},
+	},
+	{
+		Version: 6,
+		Name:    "Create the api_tokens table",
+		Up:      []string{"CREATE TABLE IF NOT EXISTS api_tokens (id SERIAL PRIMARY KEY, name VARCHAR(255) NOT NULL, owner VARCHAR(255) NOT NULL, hash VARCHAR(64) NOT NULL UNIQUE, prefix VARCHAR(16) NOT NULL, createDate TIMESTAMPTZ NOT NULL, expiryDate TIMESTAMPTZ NOT NULL, lastUsed TIMESTAMPTZ NULL);"},
+		Down:    []string{"DROP TABLE api_tokens;"},
+	},
+	{
+		Version: 7,
+		Name:    "Create index on column owner for table api_tokens",
+		Up:      []string{"CREATE INDEX api_token_owner ON api_tokens (owner);"},
+		Down:    []string{"DROP INDEX api_token_owner;"},
+	},
+	{
+		Version: 8,
+		Name:    "Create the sessions table",
+		Up:      []string{`CREATE TABLE IF NOT EXISTS sessions (id VARCHAR(64) NOT NULL PRIMARY KEY, "user" VARCHAR(255) NOT NULL, csrfToken VARCHAR(255) NOT NULL, createDate TIMESTAMPTZ NOT NULL, expiryDate TIMESTAMPTZ NOT NULL);`},
+		Down:    []string{"DROP TABLE sessions;"},
+	},
+	{
+		Version: 9,
+		Name:    "Create index on column expiryDate for table sessions",
+		Up:      []string{"CREATE INDEX session_expiry ON sessions (expiryDate);"},
+		Down:    []string{"DROP INDEX session_expiry;"},
+	},
+	{
+		Version: 10,
+		Name:    "Create the agents table",
+		Up:      []string{"CREATE TABLE IF NOT EXISTS agents (id INTEGER NOT NULL, shortName VARCHAR(255) NOT NULL PRIMARY KEY, longName VARCHAR(255) NOT NULL, identifier VARCHAR(255) NOT NULL, dbVendor VARCHAR(255) NOT NULL, dbAddress VARCHAR(255) NOT NULL, dbSID VARCHAR(255) NOT NULL, version VARCHAR(255) NOT NULL, address VARCHAR(255) NOT NULL, token VARCHAR(255) NOT NULL);"},
+		Down:    []string{"DROP TABLE agents;"},
+	},
+	{
+		Version: 11,
+		Name:    "Create the sequences table",
+		Up:      []string{"CREATE TABLE IF NOT EXISTS sequences (name VARCHAR(255) NOT NULL PRIMARY KEY, value INTEGER NOT NULL);"},
+		Down:    []string{"DROP TABLE sequences;"},
+	},
+	{
+		Version: 12,
+		Name:    "Create the users table",
+		Up:      []string{"CREATE TABLE IF NOT EXISTS users (email VARCHAR(255) NOT NULL PRIMARY KEY, role VARCHAR(45) NOT NULL, updateDate TIMESTAMPTZ NOT NULL, updatedBy VARCHAR(255) NOT NULL);"},
+		Down:    []string{"DROP TABLE users;"},
+	},
+	{
+		Version: 13,
+		Name:    "Create the user_groups table",
+		Up:      []string{"CREATE TABLE IF NOT EXISTS user_groups (name VARCHAR(255) NOT NULL PRIMARY KEY, createDate TIMESTAMPTZ NOT NULL, createdBy VARCHAR(255) NOT NULL);"},
+		Down:    []string{"DROP TABLE user_groups;"},
+	},
+	{
+		Version: 14,
+		Name:    "Create the group_members table",
+		Up:      []string{"CREATE TABLE IF NOT EXISTS group_members (groupName VARCHAR(255) NOT NULL, member VARCHAR(255) NOT NULL, PRIMARY KEY (groupName, member));"},
+		Down:    []string{"DROP TABLE group_members;"},
+	},
+	{
+		Version: 15,
+		Name:    "Create index on column member for table group_members",
+		Up:      []string{"CREATE INDEX group_member_idx ON group_members (member);"},
+		Down:    []string{"DROP INDEX group_member_idx;"},
+	},
+	{
+		Version: 16,
+		Name:    "Create the audit_log table",
+		Up:      []string{`CREATE TABLE IF NOT EXISTS audit_log (id SERIAL PRIMARY KEY, "time" TIMESTAMPTZ NOT NULL, actor VARCHAR(255) NOT NULL, action VARCHAR(64) NOT NULL, target INTEGER NOT NULL DEFAULT 0, targetName VARCHAR(255) NOT NULL DEFAULT '', agent VARCHAR(255) NOT NULL DEFAULT '', statusBefore INTEGER NOT NULL DEFAULT 0, statusAfter INTEGER NOT NULL DEFAULT 0, sourceIP VARCHAR(64) NOT NULL DEFAULT '', details TEXT NOT NULL);`},
+		Down:    []string{"DROP TABLE audit_log;"},
+	},
+	{
+		Version: 17,
+		Name:    "Create index on column time for table audit_log",
+		Up:      []string{`CREATE INDEX audit_time ON audit_log ("time");`},
+		Down:    []string{"DROP INDEX audit_time;"},
+	},
+	{
+		Version: 18,
+		Name:    "Create index on column actor for table audit_log",
+		Up:      []string{"CREATE INDEX audit_actor ON audit_log (actor);"},
+		Down:    []string{"DROP INDEX audit_actor;"},
+	},
+	{
+		Version: 19,
+		Name:    "Create index on column target for table audit_log",
+		Up:      []string{"CREATE INDEX audit_target ON audit_log (target);"},
+		Down:    []string{"DROP INDEX audit_target;"},
+	},
+	{
+		Version: 20,
+		Name:    "Create the jobs table",
+		Up:      []string{"CREATE TABLE IF NOT EXISTS jobs (id SERIAL PRIMARY KEY, kind VARCHAR(32) NOT NULL, target INTEGER NOT NULL DEFAULT 0, targetName VARCHAR(255) NOT NULL DEFAULT '', payload TEXT NOT NULL DEFAULT '', creator VARCHAR(255) NOT NULL DEFAULT '', status VARCHAR(32) NOT NULL, attempts INTEGER NOT NULL DEFAULT 0, maxAttempts INTEGER NOT NULL DEFAULT 0, lastError TEXT NOT NULL DEFAULT '', nextRun TIMESTAMPTZ NOT NULL, createDate TIMESTAMPTZ NOT NULL, updateDate TIMESTAMPTZ NOT NULL);"},
+		Down:    []string{"DROP TABLE jobs;"},
+	},
+	{
+		Version: 21,
+		Name:    "Create index on columns (status, nextRun) for table jobs",
+		Up:      []string{"CREATE INDEX job_status_next_run ON jobs (status, nextRun);"},
+		Down:    []string{"DROP INDEX job_status_next_run;"},
+	},
+	{
+		Version: 22,
+		Name:    "Create index on column target for table jobs",
+		Up:      []string{"CREATE INDEX job_target ON jobs (target);"},
+		Down:    []string{"DROP INDEX job_target;"},
+	},
+	{
+		Version: 23,
+		Name:    "Add 'updateDate' column",
+		Up:      []string{"ALTER TABLE databases ADD COLUMN updateDate TIMESTAMPTZ NOT NULL DEFAULT NOW();"},
+		Down:    []string{"ALTER TABLE databases DROP COLUMN updateDate;"},
+	},
+	{
+		Version: 24,
+		Name:    "Add 'extensions' column",
+		Up:      []string{"ALTER TABLE databases ADD COLUMN extensions INTEGER NOT NULL DEFAULT 0;"},
+		Down:    []string{"ALTER TABLE databases DROP COLUMN extensions;"},
+	},
+	{
+		Version: 25,
+		Name:    "Add 'warned' column",
+		Up:      []string{"ALTER TABLE databases ADD COLUMN warned INTEGER NOT NULL DEFAULT 0;"},
+		Down:    []string{"ALTER TABLE databases DROP COLUMN warned;"},
+	},
+	{
+		Version: 26,
+		Name:    "Add 'mode' column to agents",
+		Up:      []string{"ALTER TABLE agents ADD COLUMN mode VARCHAR(45) NOT NULL DEFAULT 'active';"},
+		Down:    []string{"ALTER TABLE agents DROP COLUMN mode;"},
+	},
+	{
+		Version: 27,
+		Name:    "Create the agent_labels table",
+		Up:      []string{"CREATE TABLE IF NOT EXISTS agent_labels (shortName VARCHAR(255) NOT NULL, source VARCHAR(16) NOT NULL, name VARCHAR(255) NOT NULL, value VARCHAR(255) NOT NULL, PRIMARY KEY (shortName, source, name));"},
+		Down:    []string{"DROP TABLE agent_labels;"},
+	},
+}
@@ -0,0 +1,122 @@
+// Package postgres implements the BackendConnection on top of a PostgreSQL
+// database, for deployments that keep the metadata of their services in
+// PostgreSQL rather than MySQL.
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/djavorszky/ddn-api/database/migrate"
+	"github.com/djavorszky/ddn-api/database/sqldb"
+	"github.com/djavorszky/sutils"
+
+	// Db
+	_ "github.com/lib/pq"
+)
+
+// DB implements the BackendConnection. SSLMode is passed to the driver
+// as is, and defaults to "require".
+type DB struct {
+	Address, User, Pass, Database, SSLMode string
+	sqldb.DB
+}
+
+// ConnectAndPrepare connects to the database and initializes the tables.
+// Unlike with MySQL, the database itself has to exist beforehand.
+func (pg *DB) ConnectAndPrepare() error {
+	err := pg.open()
+	if err != nil {
+		return err
+	}
+
+	err = pg.initTables()
+	if err != nil {
+		return fmt.Errorf("initializing tables failed: %s", err.Error())
+	}
+
+	return nil
+}
+
+// Migrator connects to the database and returns the migrator of its
+// schema, without migrating it.
+func (pg *DB) Migrator() (*migrate.Migrator, error) {
+	err := pg.open()
+	if err != nil {
+		return nil, err
+	}
+
+	return migrate.New(pg.Conn(), migrate.Postgres, migrations)
+}
+
+func (pg *DB) open() error {
+	if !sutils.Present(pg.Address, pg.User, pg.Database) {
+		return fmt.Errorf("missing address, user or database")
+	}
+
+	err := pg.connect(pg.datasource())
+	if err != nil {
+		return fmt.Errorf("couldn't connect to the database: %s", err.Error())
+	}
+
+	return nil
+}
+
+// datasource builds the connection URL, escaping the credentials.
+func (pg *DB) datasource() string {
+	sslMode := pg.SSLMode
+	if sslMode == "" {
+		sslMode = "require"
+	}
+
+	ds := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(pg.User, pg.Pass),
+		Host:     pg.Address,
+		Path:     "/" + pg.Database,
+		RawQuery: url.Values{"sslmode": {sslMode}}.Encode(),
+	}
+
+	return ds.String()
+}
+
+func (pg *DB) connect(datasource string) error {
+	db, err := sql.Open("postgres", datasource)
+	if err != nil {
+		return fmt.Errorf("creating connection pool failed: %s", err.Error())
+	}
+
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return fmt.Errorf("database ping failed: %s", sutils.TrimNL(err.Error()))
+	}
+	pg.Use(db, dialect{migrate.Postgres})
+
+	return nil
+}
+
+func (pg *DB) initTables() error {
+	migrator, err := migrate.New(pg.Conn(), migrate.Postgres, migrations)
+	if err != nil {
+		return err
+	}
+
+	return migrator.Up()
+}
+
+// dialect is the SQL of PostgreSQL. Times are stored as they are, and
+// the driver can't report the IDs of new rows, so inserts return them.
+type dialect struct {
+	migrate.Dialect
+}
+
+func (dialect) Time(t time.Time) interface{} { return t }
+
+func (dialect) ReturningID() bool { return true }
+
+func (dialect) Upsert(keys, columns []string) string { return sqldb.OnConflict(keys, columns) }
+
+func (dialect) Contains(column string) string { return column + " ILIKE ?" }
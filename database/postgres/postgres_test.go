@@ -0,0 +1,182 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/djavorszky/ddn-api/database/dbtest"
+	"github.com/djavorszky/ddn-api/database/dbutil"
+	"github.com/djavorszky/ddn-api/database/migrate"
+	"github.com/djavorszky/sutils"
+)
+
+const (
+	testAddr = "127.0.0.1:5432"
+	testUser = "postgres"
+	testPass = ""
+	testName = "unit_test"
+)
+
+var (
+	testConn *sql.DB
+
+	pg = DB{Address: testAddr, User: testUser, Pass: testPass, Database: testName, SSLMode: "disable"}
+)
+
+func TestMain(m *testing.M) {
+	// For these tests to run, a local database should be present which
+	// has a user named 'postgres' with no password authentication. If
+	// there is none, the tests are skipped.
+
+	err := setup()
+	if err != nil {
+		fmt.Printf("Skipping postgres tests, failed setup: %s\n", err.Error())
+		os.Exit(0)
+	}
+
+	res := m.Run()
+
+	err = teardown()
+	if err != nil {
+		fmt.Printf("Failed teardown: %s", err.Error())
+		os.Exit(-1)
+	}
+
+	os.Exit(res)
+}
+
+// Create an empty database on the local server
+func setup() error {
+	admin := DB{Address: testAddr, User: testUser, Pass: testPass, Database: "postgres", SSLMode: "disable"}
+
+	err := admin.connect(admin.datasource())
+	if err != nil {
+		return fmt.Errorf("failed to setup test connection: %v", err)
+	}
+	testConn = admin.Conn()
+
+	_, err = testConn.Exec(fmt.Sprintf("DROP DATABASE IF EXISTS %s;", testName))
+	if err != nil {
+		return fmt.Errorf("failed dropping leftover database: %s", sutils.TrimNL(err.Error()))
+	}
+
+	_, err = testConn.Exec(fmt.Sprintf("CREATE DATABASE %s;", testName))
+	if err != nil {
+		return fmt.Errorf("failed creating database: %s", sutils.TrimNL(err.Error()))
+	}
+
+	err = pg.connect(pg.datasource())
+	if err != nil {
+		return fmt.Errorf("failed initializing main connection: %v", err)
+	}
+
+	return nil
+}
+
+// DROP EVERYTHING!!4one
+func teardown() error {
+	pg.Close()
+
+	_, err := testConn.Exec(fmt.Sprintf("DROP DATABASE %s;", testName))
+	if err != nil {
+		return fmt.Errorf("failed dropping database: %s", sutils.TrimNL(err.Error()))
+	}
+
+	testConn.Close()
+
+	return nil
+}
+
+func TestInitTables(t *testing.T) {
+	var err error
+
+	_, err = pg.Conn().Exec("SELECT 1 FROM version LIMIT 1;")
+	if err == nil {
+		t.Errorf("Version table already exists before test even ran.")
+	}
+
+	err = pg.initTables()
+	if err != nil {
+		t.Fatalf("Failed initializing tables: %s", err.Error())
+	}
+
+	rows, err := pg.Conn().Query("SELECT version, name, checksum, dirty FROM schema_migrations ORDER BY version")
+	if err != nil {
+		t.Fatalf("schema_migrations table has not been created: %v", err)
+	}
+	defer rows.Close()
+
+	var count int
+	for rows.Next() {
+		var (
+			version, dirty int
+			name, checksum string
+		)
+
+		rows.Scan(&version, &name, &checksum, &dirty)
+
+		mig := migrations[version-1]
+		if name != mig.Name || checksum != mig.Checksum() || dirty != 0 {
+			t.Errorf("Recorded migration %d not what was expected", version)
+		}
+
+		count++
+	}
+
+	if count != len(migrations) {
+		t.Errorf("Expected %d applied migrations, got %d", len(migrations), count)
+	}
+
+	// Running them again should be a no-op
+	err = pg.initTables()
+	if err != nil {
+		t.Errorf("Failed initializing tables again: %s", err.Error())
+	}
+
+	// All of them should roll back and apply again
+	migrator, err := migrate.New(pg.Conn(), migrate.Postgres, migrations)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+
+	err = migrator.To(0)
+	if err != nil {
+		t.Fatalf("Rolling back all migrations failed: %v", err)
+	}
+
+	err = migrator.Up()
+	if err != nil {
+		t.Fatalf("Applying the migrations again failed: %v", err)
+	}
+}
+
+func TestReadRow(t *testing.T) {
+	testEntry := dbtest.TestEntry
+	testEntry.DBName = "readRow"
+
+	err := pg.Insert(&testEntry)
+	if err != nil {
+		t.Fatalf("Failed adding a entry: %s", err.Error())
+	}
+
+	row, err := dbutil.ReadRow(pg.Conn().QueryRow("SELECT * FROM databases WHERE id = $1", testEntry.ID))
+	if err != nil {
+		t.Fatalf("Failed reading row: %s", err.Error())
+	}
+
+	if err = dbutil.CompareRows(testEntry, row); err != nil {
+		t.Errorf("Persisted and read DBEntry not the same: %s", err.Error())
+	}
+
+	// cleanup
+	_, err = pg.Conn().Exec("DELETE FROM databases WHERE id = $1", testEntry.ID)
+	if err != nil {
+		t.Errorf("Could not delete created entry")
+	}
+}
+
+func TestConformance(t *testing.T) {
+	dbtest.Run(t, &pg)
+}
@@ -0,0 +1,181 @@
+package sqldb
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/djavorszky/ddn-api/database/data"
+	"github.com/djavorszky/ddn-api/database/dbutil"
+	"github.com/djavorszky/sutils"
+)
+
+// FetchAgents returns all persisted agents
+func (db *DB) FetchAgents() ([]data.Agent, error) {
+	if err := db.alive(); err != nil {
+		return nil, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	rows, err := db.query("SELECT `id`, `shortName`, `longName`, `identifier`, `dbVendor`, `dbAddress`, `dbSID`, `version`, `address`, `token`, `mode` FROM `agents` ORDER BY shortName")
+	if err != nil {
+		return nil, fmt.Errorf("couldn't execute query: %s", err.Error())
+	}
+	defer rows.Close()
+
+	var agents []data.Agent
+	for rows.Next() {
+		agent, err := dbutil.ReadAgent(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error reading result from query: %s", err.Error())
+		}
+
+		agents = append(agents, agent)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading result from query: %s", err.Error())
+	}
+
+	for i := range agents {
+		agents[i].Labels, agents[i].LabelOverrides, err = db.fetchLabels(agents[i].ShortName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return agents, nil
+}
+
+// SaveAgent persists the agent, overwriting the one with the same
+// short name if there is one.
+func (db *DB) SaveAgent(agent data.Agent) error {
+	if err := db.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	if !sutils.Present(agent.ShortName) {
+		return fmt.Errorf("missing short name")
+	}
+
+	query := "INSERT INTO `agents` (`id`, `shortName`, `longName`, `identifier`, `dbVendor`, `dbAddress`, `dbSID`, `version`, `address`, `token`, `mode`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) " +
+		db.dialect.Upsert([]string{"shortName"}, []string{"id", "longName", "identifier", "dbVendor", "dbAddress", "dbSID", "version", "address", "token", "mode"})
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("starting transaction failed: %v", err)
+	}
+
+	_, err = db.txExec(tx, query,
+		agent.ID,
+		agent.ShortName,
+		agent.LongName,
+		agent.Identifier,
+		agent.DBVendor,
+		agent.DBAddr,
+		agent.DBSID,
+		agent.Version,
+		agent.Address,
+		agent.Token,
+		agent.Mode,
+	)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("saving agent failed: %v", err)
+	}
+
+	_, err = db.txExec(tx, "DELETE FROM `agent_labels` WHERE shortName = ?", agent.ShortName)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("removing labels failed: %v", err)
+	}
+
+	for source, labels := range map[string]map[string]string{data.LabelSourceAgent: agent.Labels, data.LabelSourceAdmin: agent.LabelOverrides} {
+		for name, value := range labels {
+			_, err = db.txExec(tx, "INSERT INTO `agent_labels` (`shortName`, `source`, `name`, `value`) VALUES (?, ?, ?, ?)", agent.ShortName, source, name, value)
+			if err != nil {
+				tx.Rollback()
+				return fmt.Errorf("adding label %q failed: %v", name, err)
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DeleteAgent removes the persisted agent
+func (db *DB) DeleteAgent(shortName string) error {
+	if err := db.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	_, err := db.exec("DELETE FROM `agents` WHERE shortName = ?", shortName)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.exec("DELETE FROM `agent_labels` WHERE shortName = ?", shortName)
+
+	return err
+}
+
+// FetchSequence returns the current value of the named sequence, or 0
+// if it was never updated.
+func (db *DB) FetchSequence(name string) (int, error) {
+	if err := db.alive(); err != nil {
+		return 0, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	var value int
+
+	err := db.queryRow("SELECT `value` FROM `sequences` WHERE name = ?", name).Scan(&value)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, fmt.Errorf("failed reading sequence: %v", err)
+	}
+
+	return value, nil
+}
+
+// UpdateSequence sets the value of the named sequence
+func (db *DB) UpdateSequence(name string, value int) error {
+	if err := db.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	_, err := db.exec("INSERT INTO `sequences` (`name`, `value`) VALUES (?, ?) "+db.dialect.Upsert([]string{"name"}, []string{"value"}), name, value)
+	if err != nil {
+		return fmt.Errorf("updating sequence failed: %v", err)
+	}
+
+	return nil
+}
+
+// fetchLabels returns the labels the agent registered with, and the ones
+// the admins set.
+func (db *DB) fetchLabels(shortName string) (map[string]string, map[string]string, error) {
+	rows, err := db.query("SELECT `source`, `name`, `value` FROM `agent_labels` WHERE shortName = ? ORDER BY name", shortName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("couldn't execute query: %s", err.Error())
+	}
+	defer rows.Close()
+
+	labels := make(map[string]map[string]string)
+	for rows.Next() {
+		var source, name, value string
+
+		err = rows.Scan(&source, &name, &value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error reading result from query: %s", err.Error())
+		}
+
+		if labels[source] == nil {
+			labels[source] = make(map[string]string)
+		}
+
+		labels[source][name] = value
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error reading result from query: %s", err.Error())
+	}
+
+	return labels[data.LabelSourceAgent], labels[data.LabelSourceAdmin], nil
+}
@@ -0,0 +1,114 @@
+package sqldb
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/djavorszky/ddn-api/database/data"
+	"github.com/djavorszky/ddn-api/database/dbutil"
+	"github.com/djavorszky/sutils"
+)
+
+// InsertAuditEntry appends the entry to the audit log, updating its ID.
+// Entries of the audit log are never updated or deleted.
+func (db *DB) InsertAuditEntry(entry *data.AuditEntry) error {
+	if err := db.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	if !sutils.Present(entry.Actor, entry.Action) {
+		return fmt.Errorf("missing actor or action")
+	}
+
+	query := "INSERT INTO `audit_log` (`time`, `actor`, `action`, `target`, `targetName`, `agent`, `statusBefore`, `statusAfter`, `sourceIP`, `details`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+
+	id, err := db.insert(query,
+		entry.Time,
+		entry.Actor,
+		entry.Action,
+		entry.Target,
+		entry.TargetName,
+		entry.Agent,
+		entry.StatusBefore,
+		entry.StatusAfter,
+		entry.SourceIP,
+		entry.Details,
+	)
+	if err != nil {
+		return fmt.Errorf("insert failed: %v", err)
+	}
+
+	entry.ID = int(id)
+
+	return nil
+}
+
+// FetchAuditEntries returns the entries of the audit log that match the
+// filter, newest first.
+func (db *DB) FetchAuditEntries(filter data.AuditFilter) ([]data.AuditEntry, error) {
+	if err := db.alive(); err != nil {
+		return nil, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	var (
+		where []string
+		args  []interface{}
+	)
+
+	if filter.Actor != "" {
+		where, args = append(where, "actor = ?"), append(args, filter.Actor)
+	}
+
+	if filter.Action != "" {
+		where, args = append(where, "action = ?"), append(args, filter.Action)
+	}
+
+	if filter.Agent != "" {
+		where, args = append(where, "agent = ?"), append(args, filter.Agent)
+	}
+
+	if filter.Target != 0 {
+		where, args = append(where, "target = ?"), append(args, filter.Target)
+	}
+
+	if !filter.From.IsZero() {
+		where, args = append(where, "`time` >= ?"), append(args, filter.From)
+	}
+
+	if !filter.To.IsZero() {
+		where, args = append(where, "`time` < ?"), append(args, filter.To)
+	}
+
+	query := "SELECT `id`, `time`, `actor`, `action`, `target`, `targetName`, `agent`, `statusBefore`, `statusAfter`, `sourceIP`, `details` FROM `audit_log`"
+	if len(where) != 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY id DESC"
+
+	if filter.Limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, filter.Limit, filter.Offset)
+	}
+
+	rows, err := db.query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't execute query: %s", err.Error())
+	}
+	defer rows.Close()
+
+	var entries []data.AuditEntry
+	for rows.Next() {
+		entry, err := dbutil.ReadAuditEntry(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error reading result from query: %s", err.Error())
+		}
+
+		entries = append(entries, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading result from query: %s", err.Error())
+	}
+
+	return entries, nil
+}
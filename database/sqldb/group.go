@@ -0,0 +1,193 @@
+package sqldb
+
+import (
+	"fmt"
+
+	"github.com/djavorszky/ddn-api/database/data"
+	"github.com/djavorszky/ddn-api/database/dbutil"
+	"github.com/djavorszky/sutils"
+)
+
+// memberKeys is the primary key of the group_members table.
+var memberKeys = []string{"groupName", "member"}
+
+// InsertGroup persists a new group along with its members
+func (db *DB) InsertGroup(group *data.Group) error {
+	if err := db.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	if !sutils.Present(group.Name, group.CreatedBy) {
+		return fmt.Errorf("missing name or creator")
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("starting transaction failed: %v", err)
+	}
+
+	_, err = db.txExec(tx, "INSERT INTO `user_groups` (`name`, `createDate`, `createdBy`) VALUES (?, ?, ?)", group.Name, group.CreateDate, group.CreatedBy)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("insert failed: %v", err)
+	}
+
+	for _, member := range group.Members {
+		_, err = db.txExec(tx, "INSERT INTO `group_members` (`groupName`, `member`) VALUES (?, ?) "+db.dialect.Upsert(memberKeys, nil), group.Name, member)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("adding member %q failed: %v", member, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// FetchGroup returns the group with the given name along with its
+// members. If there is no such group, an empty group is returned
+// without an error.
+func (db *DB) FetchGroup(name string) (data.Group, error) {
+	if err := db.alive(); err != nil {
+		return data.Group{}, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	row := db.queryRow("SELECT `name`, `createDate`, `createdBy` FROM `user_groups` WHERE name = ?", name)
+	group, err := dbutil.ReadGroup(row)
+	if err != nil {
+		return data.Group{}, fmt.Errorf("failed reading result: %v", err)
+	}
+
+	if group.Name == "" {
+		return group, nil
+	}
+
+	group.Members, err = db.fetchMembers(group.Name)
+	if err != nil {
+		return data.Group{}, err
+	}
+
+	return group, nil
+}
+
+// FetchGroups returns all the groups along with their members
+func (db *DB) FetchGroups() ([]data.Group, error) {
+	if err := db.alive(); err != nil {
+		return nil, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	rows, err := db.query("SELECT `name`, `createDate`, `createdBy` FROM `user_groups` ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("couldn't execute query: %s", err.Error())
+	}
+	defer rows.Close()
+
+	var groups []data.Group
+	for rows.Next() {
+		group, err := dbutil.ReadGroup(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error reading result from query: %s", err.Error())
+		}
+
+		groups = append(groups, group)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading result from query: %s", err.Error())
+	}
+
+	for i := range groups {
+		groups[i].Members, err = db.fetchMembers(groups[i].Name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return groups, nil
+}
+
+// FetchGroupNames returns the names of the groups the user is a member of
+func (db *DB) FetchGroupNames(member string) ([]string, error) {
+	if err := db.alive(); err != nil {
+		return nil, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	rows, err := db.query("SELECT `groupName` FROM `group_members` WHERE member = ? ORDER BY groupName", member)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't execute query: %s", err.Error())
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+
+		err = rows.Scan(&name)
+		if err != nil {
+			return nil, fmt.Errorf("error reading result from query: %s", err.Error())
+		}
+
+		names = append(names, name)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading result from query: %s", err.Error())
+	}
+
+	return names, nil
+}
+
+// AddGroupMember adds the user to the group. Adding an existing member
+// is not an error.
+func (db *DB) AddGroupMember(name, member string) error {
+	if err := db.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	if !sutils.Present(name, member) {
+		return fmt.Errorf("missing group or member")
+	}
+
+	_, err := db.exec("INSERT INTO `group_members` (`groupName`, `member`) VALUES (?, ?) "+db.dialect.Upsert(memberKeys, nil), name, member)
+	if err != nil {
+		return fmt.Errorf("adding member failed: %v", err)
+	}
+
+	return nil
+}
+
+// RemoveGroupMember removes the user from the group
+func (db *DB) RemoveGroupMember(name, member string) error {
+	if err := db.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	_, err := db.exec("DELETE FROM `group_members` WHERE groupName = ? AND member = ?", name, member)
+
+	return err
+}
+
+func (db *DB) fetchMembers(name string) ([]string, error) {
+	rows, err := db.query("SELECT `member` FROM `group_members` WHERE groupName = ? ORDER BY member", name)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't execute query: %s", err.Error())
+	}
+	defer rows.Close()
+
+	var members []string
+	for rows.Next() {
+		var member string
+
+		err = rows.Scan(&member)
+		if err != nil {
+			return nil, fmt.Errorf("error reading result from query: %s", err.Error())
+		}
+
+		members = append(members, member)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading result from query: %s", err.Error())
+	}
+
+	return members, nil
+}
@@ -0,0 +1,206 @@
+package sqldb
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/djavorszky/ddn-api/database/data"
+	"github.com/djavorszky/ddn-api/database/dbutil"
+	"github.com/djavorszky/sutils"
+)
+
+const jobColumns = "`id`, `kind`, `target`, `targetName`, `payload`, `creator`, `status`, `attempts`, `maxAttempts`, `lastError`, `nextRun`, `createDate`, `updateDate`"
+
+// InsertJob persists the job, updating its ID.
+func (db *DB) InsertJob(job *data.Job) error {
+	if err := db.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	if !sutils.Present(job.Kind, job.Status) {
+		return fmt.Errorf("missing kind or status")
+	}
+
+	query := "INSERT INTO `jobs` (`kind`, `target`, `targetName`, `payload`, `creator`, `status`, `attempts`, `maxAttempts`, `lastError`, `nextRun`, `createDate`, `updateDate`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+
+	id, err := db.insert(query,
+		job.Kind,
+		job.Target,
+		job.TargetName,
+		job.Payload,
+		job.Creator,
+		job.Status,
+		job.Attempts,
+		job.MaxAttempts,
+		job.LastError,
+		job.NextRun,
+		job.CreateDate,
+		job.UpdateDate,
+	)
+	if err != nil {
+		return fmt.Errorf("insert failed: %v", err)
+	}
+
+	job.ID = int(id)
+
+	return nil
+}
+
+// FetchJob returns the job with the ID, or an empty job if it does not
+// exist.
+func (db *DB) FetchJob(ID int) (data.Job, error) {
+	if err := db.alive(); err != nil {
+		return data.Job{}, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	row := db.queryRow("SELECT "+jobColumns+" FROM `jobs` WHERE id = ?", ID)
+
+	return dbutil.ReadJob(row)
+}
+
+// FetchJobs returns the jobs that match the filter, newest first.
+func (db *DB) FetchJobs(filter data.JobFilter) ([]data.Job, error) {
+	if err := db.alive(); err != nil {
+		return nil, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	var (
+		where []string
+		args  []interface{}
+	)
+
+	if filter.Creator != "" {
+		where, args = append(where, "creator = ?"), append(args, filter.Creator)
+	}
+
+	if filter.Kind != "" {
+		where, args = append(where, "kind = ?"), append(args, filter.Kind)
+	}
+
+	if filter.Status != "" {
+		where, args = append(where, "status = ?"), append(args, filter.Status)
+	}
+
+	if filter.Target != 0 {
+		where, args = append(where, "target = ?"), append(args, filter.Target)
+	}
+
+	query := "SELECT " + jobColumns + " FROM `jobs`"
+	if len(where) != 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY id DESC"
+
+	if filter.Limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, filter.Limit, filter.Offset)
+	}
+
+	rows, err := db.query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't execute query: %s", err.Error())
+	}
+	defer rows.Close()
+
+	var jobs []data.Job
+	for rows.Next() {
+		job, err := dbutil.ReadJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error reading result from query: %s", err.Error())
+		}
+
+		jobs = append(jobs, job)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading result from query: %s", err.Error())
+	}
+
+	return jobs, nil
+}
+
+// UpdateJob persists the changes of the job.
+func (db *DB) UpdateJob(job *data.Job) error {
+	if err := db.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	query := "UPDATE `jobs` SET `payload` = ?, `status` = ?, `attempts` = ?, `maxAttempts` = ?, `lastError` = ?, `nextRun` = ?, `updateDate` = ? WHERE `id` = ?"
+
+	_, err := db.exec(query,
+		job.Payload,
+		job.Status,
+		job.Attempts,
+		job.MaxAttempts,
+		job.LastError,
+		job.NextRun,
+		job.UpdateDate,
+		job.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("update failed: %v", err)
+	}
+
+	return nil
+}
+
+// CancelJob marks the job as cancelled, unless it isn't queued anymore.
+// It returns whether the job was cancelled.
+func (db *DB) CancelJob(ID int, now time.Time) (bool, error) {
+	if err := db.alive(); err != nil {
+		return false, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	res, err := db.exec("UPDATE `jobs` SET `status` = ?, `updateDate` = ? WHERE `id` = ? AND `status` = ?",
+		data.JobCancelled, now, ID, data.JobQueued)
+	if err != nil {
+		return false, fmt.Errorf("update failed: %v", err)
+	}
+
+	cancelled, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed cancelling job: %v", err)
+	}
+
+	return cancelled == 1, nil
+}
+
+// ClaimJob takes the queued job that is due the longest, marking it as
+// running and counting the attempt. It returns an empty job if none are
+// due. A job is only ever claimed by one caller.
+func (db *DB) ClaimJob(now time.Time) (data.Job, error) {
+	if err := db.alive(); err != nil {
+		return data.Job{}, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	for {
+		row := db.queryRow("SELECT "+jobColumns+" FROM `jobs` WHERE status = ? AND nextRun <= ? ORDER BY nextRun, id LIMIT 1", data.JobQueued, now)
+
+		job, err := dbutil.ReadJob(row)
+		if err != nil || job.ID == 0 {
+			return data.Job{}, err
+		}
+
+		job.Status = data.JobRunning
+		job.Attempts++
+		job.UpdateDate = now
+
+		res, err := db.exec("UPDATE `jobs` SET `status` = ?, `attempts` = ?, `updateDate` = ? WHERE `id` = ? AND `status` = ?",
+			job.Status, job.Attempts, job.UpdateDate, job.ID, data.JobQueued)
+		if err != nil {
+			return data.Job{}, fmt.Errorf("update failed: %v", err)
+		}
+
+		claimed, err := res.RowsAffected()
+		if err != nil {
+			return data.Job{}, fmt.Errorf("failed claiming job: %v", err)
+		}
+
+		if claimed == 1 {
+			return job, nil
+		}
+
+		// Someone else claimed it in the meantime, try the next one.
+	}
+}
@@ -0,0 +1,130 @@
+package sqldb
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/djavorszky/ddn-api/database/data"
+	"github.com/djavorszky/ddn-api/database/dbutil"
+)
+
+// QueryDatabases returns the entries that match the query, in its order.
+func (db *DB) QueryDatabases(query data.DatabaseQuery) ([]data.Row, error) {
+	if err := db.alive(); err != nil {
+		return nil, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	column, after, err := dbutil.SortKey(query)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		where []string
+		args  []interface{}
+	)
+
+	if query.Restricted {
+		readable := []string{"visibility = 1"}
+
+		if query.Reader != "" {
+			readable, args = append(readable, "creator = ?"), append(args, query.Reader)
+		}
+
+		if len(query.ReaderGroups) != 0 {
+			readable = append(readable, "(visibility = 2 AND ownerGroup IN (?"+strings.Repeat(", ?", len(query.ReaderGroups)-1)+"))")
+			for _, group := range query.ReaderGroups {
+				args = append(args, group)
+			}
+		}
+
+		where = append(where, "("+strings.Join(readable, " OR ")+")")
+	}
+
+	if query.Agent != "" {
+		where, args = append(where, "agentName = ?"), append(args, query.Agent)
+	}
+
+	if query.Vendor != "" {
+		where, args = append(where, "dbvendor = ?"), append(args, query.Vendor)
+	}
+
+	if query.StatusClass != "" {
+		min, max, ok := data.StatusRange(query.StatusClass)
+		if !ok {
+			return nil, fmt.Errorf("unknown status class %q", query.StatusClass)
+		}
+
+		where, args = append(where, "status >= ?"), append(args, min)
+		if max != 0 {
+			where, args = append(where, "status < ?"), append(args, max)
+		}
+	}
+
+	if query.Creator != "" {
+		where, args = append(where, "creator = ?"), append(args, query.Creator)
+	}
+
+	if query.NameContains != "" {
+		where, args = append(where, db.dialect.Contains("dbname")), append(args, dbutil.LikeContains(query.NameContains))
+	}
+
+	if !query.ExpiresAfter.IsZero() {
+		where, args = append(where, "expiryDate >= ?"), append(args, query.ExpiresAfter)
+	}
+
+	if !query.ExpiresBefore.IsZero() {
+		where, args = append(where, "expiryDate < ?"), append(args, query.ExpiresBefore)
+	}
+
+	op, dir := ">", "ASC"
+	if query.Descending {
+		op, dir = "<", "DESC"
+	}
+
+	if query.After != nil {
+		if after == nil {
+			where, args = append(where, "id "+op+" ?"), append(args, query.After.ID)
+		} else {
+			where = append(where, fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))", column, op))
+			args = append(args, after, after, query.After.ID)
+		}
+	}
+
+	stmt := "SELECT * FROM `databases`"
+	if len(where) != 0 {
+		stmt += " WHERE " + strings.Join(where, " AND ")
+	}
+
+	stmt += " ORDER BY " + column + " " + dir
+	if column != "id" {
+		stmt += ", id " + dir
+	}
+
+	if query.Limit > 0 {
+		stmt += " LIMIT ?"
+		args = append(args, query.Limit)
+	}
+
+	rows, err := db.query(stmt, args...)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't execute query: %s", err.Error())
+	}
+	defer rows.Close()
+
+	var entries []data.Row
+	for rows.Next() {
+		row, err := dbutil.ReadRows(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error reading result from query: %s", err.Error())
+		}
+
+		entries = append(entries, row)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading result from query: %s", err.Error())
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,74 @@
+package sqldb
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/djavorszky/ddn-api/database/data"
+	"github.com/djavorszky/ddn-api/database/dbutil"
+	"github.com/djavorszky/sutils"
+)
+
+// InsertSession persists a new web session
+func (db *DB) InsertSession(session *data.Session) error {
+	if err := db.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	if !sutils.Present(session.ID, session.User) {
+		return fmt.Errorf("missing id or user")
+	}
+
+	query := "INSERT INTO `sessions` (`id`, `user`, `csrfToken`, `createDate`, `expiryDate`) VALUES (?, ?, ?, ?, ?)"
+
+	_, err := db.exec(query,
+		session.ID,
+		session.User,
+		session.CSRFToken,
+		session.CreateDate,
+		session.ExpiryDate,
+	)
+	if err != nil {
+		return fmt.Errorf("insert failed: %v", err)
+	}
+
+	return nil
+}
+
+// FetchSession returns the session with the given ID. If there is no such
+// session, an empty session is returned without an error.
+func (db *DB) FetchSession(ID string) (data.Session, error) {
+	if err := db.alive(); err != nil {
+		return data.Session{}, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	row := db.queryRow("SELECT `id`, `user`, `csrfToken`, `createDate`, `expiryDate` FROM `sessions` WHERE id = ?", ID)
+	session, err := dbutil.ReadSession(row)
+	if err != nil {
+		return data.Session{}, fmt.Errorf("failed reading result: %v", err)
+	}
+
+	return session, nil
+}
+
+// DeleteSession removes the session, logging its user out
+func (db *DB) DeleteSession(ID string) error {
+	if err := db.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	_, err := db.exec("DELETE FROM `sessions` WHERE id = ?", ID)
+
+	return err
+}
+
+// DeleteExpiredSessions removes all sessions that expired before now
+func (db *DB) DeleteExpiredSessions(now time.Time) error {
+	if err := db.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	_, err := db.exec("DELETE FROM `sessions` WHERE expiryDate < ?", now)
+
+	return err
+}
@@ -0,0 +1,433 @@
+// Package sqldb implements the BackendConnection on top of an SQL
+// database. The queries are written once, with ? placeholders and backtick
+// quoted identifiers, and the backends of the database servers embed the
+// DB and supply the Dialect for what their servers differ in.
+package sqldb
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/djavorszky/ddn-api/database/data"
+	"github.com/djavorszky/ddn-api/database/dbutil"
+	"github.com/djavorszky/ddn-api/database/migrate"
+	"github.com/djavorszky/ddn-common/model"
+	"github.com/djavorszky/sutils"
+	webpush "github.com/sherclockholmes/webpush-go"
+)
+
+// Dialect is what the queries need to know about the SQL of a database
+// server, on top of what the migrations do. Rebind has to turn the ?
+// placeholders and the backtick quoted identifiers into the ones the
+// server expects.
+type Dialect interface {
+	migrate.Dialect
+
+	// Time returns the value the time is stored as.
+	Time(t time.Time) interface{}
+
+	// ReturningID returns whether inserts report the ID of the new row
+	// with a RETURNING clause, rather than with LastInsertId.
+	ReturningID() bool
+
+	// Upsert returns the clause that, appended to an INSERT, updates the
+	// columns of the row that already exists with the same keys instead.
+	// Without columns the existing row is kept as it is.
+	Upsert(keys, columns []string) string
+
+	// Contains returns the condition matching the column against the
+	// pattern of dbutil.LikeContains, ignoring case where the server
+	// allows it.
+	Contains(column string) string
+}
+
+// OnConflict is Upsert for the servers that support the ON CONFLICT
+// clause, like PostgreSQL and SQLite.
+func OnConflict(keys, columns []string) string {
+	clause := "ON CONFLICT (" + quote(keys) + ")"
+	if len(columns) == 0 {
+		return clause + " DO NOTHING"
+	}
+
+	sets := make([]string, len(columns))
+	for i, column := range columns {
+		sets[i] = fmt.Sprintf("`%s` = excluded.`%s`", column, column)
+	}
+
+	return clause + " DO UPDATE SET " + strings.Join(sets, ", ")
+}
+
+// quote returns the columns as a backtick quoted list.
+func quote(columns []string) string {
+	return "`" + strings.Join(columns, "`, `") + "`"
+}
+
+// DB implements the BackendConnection
+type DB struct {
+	conn    *sql.DB
+	dialect Dialect
+}
+
+// Use sets the connection the queries are run on, and the dialect they
+// are written in.
+func (db *DB) Use(conn *sql.DB, dialect Dialect) {
+	db.conn, db.dialect = conn, dialect
+}
+
+// Conn returns the connection the queries are run on.
+func (db *DB) Conn() *sql.DB {
+	return db.conn
+}
+
+// Close closes the database connection
+func (db *DB) Close() error {
+	return db.conn.Close()
+}
+
+// FetchByID returns the entry associated with that ID, or
+// an error if it does not exist
+func (db *DB) FetchByID(ID int) (data.Row, error) {
+	if err := db.alive(); err != nil {
+		return data.Row{}, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	row := db.queryRow("SELECT * FROM `databases` WHERE id = ?", ID)
+	res, err := dbutil.ReadRow(row)
+	if err != nil {
+		return data.Row{}, fmt.Errorf("failed reading result: %v", err)
+	}
+
+	return res, nil
+}
+
+// FetchByDBNameAgent returns the entry for the database with the given name, from the given agent,
+// or an error if it does not exist
+func (db *DB) FetchByDBNameAgent(dbname, agent string) (data.Row, error) {
+	if err := db.alive(); err != nil {
+		return data.Row{}, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	row := db.queryRow("SELECT * FROM `databases` WHERE dbname = ? AND agentName = ?", dbname, agent)
+	res, err := dbutil.ReadRow(row)
+	if err != nil {
+		return data.Row{}, fmt.Errorf("failed reading result: %v", err)
+	}
+
+	return res, nil
+}
+
+// FetchByCreator returns the non-public entries that were created by the
+// specified user, along with the ones shared with any of the groups. An
+// empty list is returned if there are no such entries, or an error if
+// something went wrong
+func (db *DB) FetchByCreator(creator string, groups ...string) ([]data.Row, error) {
+	if err := db.alive(); err != nil {
+		return nil, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	query := "SELECT * FROM `databases` WHERE (creator = ? AND visibility <> 1)"
+	args := []interface{}{creator}
+
+	if len(groups) != 0 {
+		query += " OR (visibility = 2 AND ownerGroup IN (?" + strings.Repeat(", ?", len(groups)-1) + "))"
+		for _, group := range groups {
+			args = append(args, group)
+		}
+	}
+
+	return db.queryRows(query+" ORDER BY id DESC", args...)
+}
+
+// FetchPublic returns all entries that have "Public" set to true
+func (db *DB) FetchPublic() ([]data.Row, error) {
+	if err := db.alive(); err != nil {
+		return nil, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	return db.queryRows("SELECT * FROM `databases` WHERE visibility = 1 ORDER BY id DESC")
+}
+
+// FetchAll returns all entries.
+func (db *DB) FetchAll() ([]data.Row, error) {
+	if err := db.alive(); err != nil {
+		return nil, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	return db.queryRows("SELECT * FROM `databases` ORDER BY id DESC")
+}
+
+// FetchUserPushSubscriptions fetches the subscriptions for the specified user
+func (db *DB) FetchUserPushSubscriptions(subscriber string) ([]webpush.Subscription, error) {
+	if err := db.alive(); err != nil {
+		return nil, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	if !sutils.Present(subscriber) {
+		return nil, fmt.Errorf("missing subscriber")
+	}
+
+	var entries []webpush.Subscription
+
+	rows, err := db.query("SELECT endpoint, p256dh_key, auth_key FROM `push_subscriptions` WHERE subscriber = ?", subscriber)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't execute query: %s", err.Error())
+	}
+
+	defer rows.Close()
+	for rows.Next() {
+		row, err := dbutil.ReadSubscriptionRows(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error reading result from query: %s", err.Error())
+		}
+
+		entries = append(entries, row)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error reading result from query: %s", err.Error())
+	}
+
+	return entries, nil
+}
+
+// Insert adds an entry to the database, returning its ID
+func (db *DB) Insert(entry *data.Row) error {
+	if err := db.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	entry.UpdateDate = time.Now()
+
+	query := "INSERT INTO `databases` (`dbname`, `dbuser`, `dbpass`, `dbsid`, `dumpfile`, `createDate`, `expiryDate`, `creator`, `agentName`, `dbAddress`, `dbPort`, `dbvendor`, `status`, `message`, `visibility`, `comment`, `ownerGroup`, `dumpSize`, `updateDate`, `extensions`, `warned`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+
+	id, err := db.insert(query,
+		entry.DBName,
+		entry.DBUser,
+		entry.DBPass,
+		entry.DBSID,
+		entry.Dumpfile,
+		entry.CreateDate,
+		entry.ExpiryDate,
+		entry.Creator,
+		entry.AgentName,
+		entry.DBAddress,
+		entry.DBPort,
+		entry.DBVendor,
+		entry.Status,
+		entry.Message,
+		entry.Public,
+		entry.Comment,
+		entry.Group,
+		entry.DumpSize,
+		entry.UpdateDate,
+		entry.Extensions,
+		entry.Warned,
+	)
+	if err != nil {
+		return fmt.Errorf("insert failed: %v", err)
+	}
+
+	entry.ID = int(id)
+
+	return nil
+}
+
+// InsertPushSubscription adds a record to the push_subscriptions table
+func (db *DB) InsertPushSubscription(subscription *model.PushSubscription, subscriber string) error {
+	if err := db.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	if !sutils.Present(subscriber) {
+		return fmt.Errorf("missing subscriber")
+	}
+
+	if !sutils.Present(subscription.Endpoint) {
+		return fmt.Errorf("missing endpoint")
+	}
+
+	query := "INSERT INTO `push_subscriptions` (`subscriber`, `endpoint`, `p256dh_key`, `auth_key`) VALUES (?, ?, ?, ?)"
+
+	_, err := db.exec(query,
+		subscriber,
+		subscription.Endpoint,
+		subscription.Keys.P256dh,
+		subscription.Keys.Auth,
+	)
+	if err != nil {
+		return fmt.Errorf("saving push subscription to the database failed: %v", err)
+	}
+
+	return nil
+}
+
+// Update updates an already existing entry
+func (db *DB) Update(entry *data.Row) error {
+	if err := db.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	var count int
+
+	err := db.queryRow("SELECT count(*) FROM `databases` WHERE id = ?", entry.ID).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("failed existence check: %v", err)
+	}
+
+	if count == 0 {
+		return db.Insert(entry)
+	}
+
+	entry.UpdateDate = time.Now()
+
+	query := "UPDATE `databases` SET `dbname`= ?, `dbuser`= ?, `dbpass`= ?, `dbsid`= ?, `dumpfile`= ?, `createDate`= ?, `expiryDate`= ?, `creator`= ?, `agentName`= ?, `dbAddress`= ?, `dbPort`= ?, `dbvendor`= ?, `status`= ?, `message`= ?, `visibility`= ?, `comment` = ?, `ownerGroup` = ?, `dumpSize` = ?, `updateDate` = ?, `extensions` = ?, `warned` = ? WHERE id = ?"
+
+	_, err = db.exec(query,
+		entry.DBName,
+		entry.DBUser,
+		entry.DBPass,
+		entry.DBSID,
+		entry.Dumpfile,
+		entry.CreateDate,
+		entry.ExpiryDate,
+		entry.Creator,
+		entry.AgentName,
+		entry.DBAddress,
+		entry.DBPort,
+		entry.DBVendor,
+		entry.Status,
+		entry.Message,
+		entry.Public,
+		entry.Comment,
+		entry.Group,
+		entry.DumpSize,
+		entry.UpdateDate,
+		entry.Extensions,
+		entry.Warned,
+		entry.ID)
+	if err != nil {
+		return fmt.Errorf("failed update: %v", err)
+	}
+
+	return nil
+}
+
+// Delete removes the entry from the database
+func (db *DB) Delete(entry data.Row) error {
+	if err := db.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	_, err := db.exec("DELETE FROM `databases` WHERE id = ?", entry.ID)
+
+	return err
+}
+
+// DeletePushSubscription deletes a record from the push_subscriptions table
+func (db *DB) DeletePushSubscription(subscription *model.PushSubscription, subscriber string) error {
+	if err := db.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	if !sutils.Present(subscriber) {
+		return fmt.Errorf("missing subscriber")
+	}
+
+	if !sutils.Present(subscription.Endpoint) {
+		return fmt.Errorf("missing endpoint")
+	}
+
+	_, err := db.exec("DELETE FROM `push_subscriptions` WHERE subscriber = ? AND endpoint = ?", subscriber, subscription.Endpoint)
+
+	return err
+}
+
+// alive checks whether the connection is alive. Returns error if not.
+func (db *DB) alive() error {
+	if db.conn == nil {
+		return fmt.Errorf("not connected")
+	}
+
+	_, err := db.exec("SELECT * FROM `databases` WHERE 1 = 0")
+	if err != nil {
+		return fmt.Errorf("executing stayalive query failed: %s", sutils.TrimNL(err.Error()))
+	}
+
+	return nil
+}
+
+// args returns the arguments with the times converted to the way the
+// dialect stores them.
+func (db *DB) args(args []interface{}) []interface{} {
+	converted := make([]interface{}, len(args))
+	for i, arg := range args {
+		if t, ok := arg.(time.Time); ok {
+			arg = db.dialect.Time(t)
+		}
+
+		converted[i] = arg
+	}
+
+	return converted
+}
+
+func (db *DB) exec(query string, args ...interface{}) (sql.Result, error) {
+	return db.conn.Exec(db.dialect.Rebind(query), db.args(args)...)
+}
+
+func (db *DB) query(query string, args ...interface{}) (*sql.Rows, error) {
+	return db.conn.Query(db.dialect.Rebind(query), db.args(args)...)
+}
+
+func (db *DB) queryRow(query string, args ...interface{}) *sql.Row {
+	return db.conn.QueryRow(db.dialect.Rebind(query), db.args(args)...)
+}
+
+func (db *DB) txExec(tx *sql.Tx, query string, args ...interface{}) (sql.Result, error) {
+	return tx.Exec(db.dialect.Rebind(query), db.args(args)...)
+}
+
+// insert runs the INSERT query and returns the ID of the new row.
+func (db *DB) insert(query string, args ...interface{}) (int64, error) {
+	var id int64
+
+	if db.dialect.ReturningID() {
+		err := db.queryRow(query+" RETURNING id", args...).Scan(&id)
+
+		return id, err
+	}
+
+	res, err := db.exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.LastInsertId()
+}
+
+func (db *DB) queryRows(query string, args ...interface{}) ([]data.Row, error) {
+	rows, err := db.query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't execute query: %s", err.Error())
+	}
+	defer rows.Close()
+
+	var entries []data.Row
+	for rows.Next() {
+		row, err := dbutil.ReadRows(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error reading result from query: %s", err.Error())
+		}
+
+		entries = append(entries, row)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading result from query: %s", err.Error())
+	}
+
+	return entries, nil
+}
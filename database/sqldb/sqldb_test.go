@@ -0,0 +1,20 @@
+package sqldb
+
+import "testing"
+
+func TestOnConflict(t *testing.T) {
+	tests := []struct {
+		keys, columns []string
+		want          string
+	}{
+		{[]string{"groupName", "member"}, nil, "ON CONFLICT (`groupName`, `member`) DO NOTHING"},
+		{[]string{"name"}, []string{"value"}, "ON CONFLICT (`name`) DO UPDATE SET `value` = excluded.`value`"},
+		{[]string{"email"}, []string{"role", "updatedBy"}, "ON CONFLICT (`email`) DO UPDATE SET `role` = excluded.`role`, `updatedBy` = excluded.`updatedBy`"},
+	}
+
+	for _, tt := range tests {
+		if got := OnConflict(tt.keys, tt.columns); got != tt.want {
+			t.Errorf("OnConflict(%v, %v) = %q, want %q", tt.keys, tt.columns, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package sqldb
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/djavorszky/ddn-api/database/data"
+	"github.com/djavorszky/ddn-api/database/dbutil"
+	"github.com/djavorszky/sutils"
+)
+
+// InsertToken persists a new API token, updating its ID
+func (db *DB) InsertToken(token *data.Token) error {
+	if err := db.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	if !sutils.Present(token.Owner, token.Hash) {
+		return fmt.Errorf("missing owner or hash")
+	}
+
+	query := "INSERT INTO `api_tokens` (`name`, `owner`, `hash`, `prefix`, `createDate`, `expiryDate`, `lastUsed`) VALUES (?, ?, ?, ?, ?, ?, ?)"
+
+	id, err := db.insert(query,
+		token.Name,
+		token.Owner,
+		token.Hash,
+		token.Prefix,
+		token.CreateDate,
+		token.ExpiryDate,
+		dbutil.TimeOrNull(token.LastUsed),
+	)
+	if err != nil {
+		return fmt.Errorf("insert failed: %v", err)
+	}
+
+	token.ID = int(id)
+
+	return nil
+}
+
+// FetchTokenByHash returns the token with the given hash. If there is no
+// such token, an empty token is returned without an error.
+func (db *DB) FetchTokenByHash(hash string) (data.Token, error) {
+	if err := db.alive(); err != nil {
+		return data.Token{}, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	row := db.queryRow("SELECT `id`, `name`, `owner`, `hash`, `prefix`, `createDate`, `expiryDate`, `lastUsed` FROM `api_tokens` WHERE hash = ?", hash)
+	token, err := dbutil.ReadToken(row)
+	if err != nil {
+		return data.Token{}, fmt.Errorf("failed reading result: %v", err)
+	}
+
+	return token, nil
+}
+
+// FetchTokensByOwner returns all tokens that were issued to owner
+func (db *DB) FetchTokensByOwner(owner string) ([]data.Token, error) {
+	if err := db.alive(); err != nil {
+		return nil, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	rows, err := db.query("SELECT `id`, `name`, `owner`, `hash`, `prefix`, `createDate`, `expiryDate`, `lastUsed` FROM `api_tokens` WHERE owner = ? ORDER BY id DESC", owner)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't execute query: %s", err.Error())
+	}
+	defer rows.Close()
+
+	var tokens []data.Token
+	for rows.Next() {
+		token, err := dbutil.ReadToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error reading result from query: %s", err.Error())
+		}
+
+		tokens = append(tokens, token)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("error reading result from query: %s", err.Error())
+	}
+
+	return tokens, nil
+}
+
+// UpdateTokenLastUsed records when the token was last used
+func (db *DB) UpdateTokenLastUsed(ID int, lastUsed time.Time) error {
+	if err := db.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	_, err := db.exec("UPDATE `api_tokens` SET `lastUsed` = ? WHERE id = ?", lastUsed, ID)
+	if err != nil {
+		return fmt.Errorf("failed update: %v", err)
+	}
+
+	return nil
+}
+
+// DeleteToken revokes the token by removing it from the database
+func (db *DB) DeleteToken(token data.Token) error {
+	if err := db.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	_, err := db.exec("DELETE FROM `api_tokens` WHERE id = ?", token.ID)
+
+	return err
+}
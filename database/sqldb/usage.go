@@ -0,0 +1,39 @@
+package sqldb
+
+import (
+	"fmt"
+
+	"github.com/djavorszky/ddn-api/database/data"
+)
+
+// usageColumns maps the usage scopes to the column they are counted by.
+var usageColumns = map[string]string{
+	data.ScopeUser:  "creator",
+	data.ScopeGroup: "ownerGroup",
+	data.ScopeAgent: "agentName",
+}
+
+// FetchUsage counts the databases that belong to name in the scope, the
+// imports among them that are still in progress, and the total size of
+// their dumps.
+func (db *DB) FetchUsage(scope, name string) (data.Usage, error) {
+	if err := db.alive(); err != nil {
+		return data.Usage{}, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	column, ok := usageColumns[scope]
+	if !ok {
+		return data.Usage{}, fmt.Errorf("unknown scope %q", scope)
+	}
+
+	query := fmt.Sprintf("SELECT COUNT(*), COALESCE(SUM(CASE WHEN status < 100 AND dumpfile <> '' THEN 1 ELSE 0 END), 0), COALESCE(SUM(dumpSize), 0) FROM `databases` WHERE `%s` = ?", column)
+
+	var usage data.Usage
+
+	err := db.queryRow(query, name).Scan(&usage.Databases, &usage.Imports, &usage.DumpSize)
+	if err != nil {
+		return data.Usage{}, fmt.Errorf("failed reading result: %v", err)
+	}
+
+	return usage, nil
+}
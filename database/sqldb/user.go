@@ -0,0 +1,75 @@
+package sqldb
+
+import (
+	"fmt"
+
+	"github.com/djavorszky/ddn-api/database/data"
+	"github.com/djavorszky/ddn-api/database/dbutil"
+	"github.com/djavorszky/sutils"
+)
+
+// FetchUser returns the user with the given email. If the user was never
+// persisted, an empty user is returned without an error.
+func (db *DB) FetchUser(email string) (data.User, error) {
+	if err := db.alive(); err != nil {
+		return data.User{}, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	row := db.queryRow("SELECT `email`, `role`, `updateDate`, `updatedBy` FROM `users` WHERE email = ?", email)
+	user, err := dbutil.ReadUser(row)
+	if err != nil {
+		return data.User{}, fmt.Errorf("failed reading result: %v", err)
+	}
+
+	return user, nil
+}
+
+// FetchUsers returns all persisted users
+func (db *DB) FetchUsers() ([]data.User, error) {
+	if err := db.alive(); err != nil {
+		return nil, fmt.Errorf("database down: %s", err.Error())
+	}
+
+	rows, err := db.query("SELECT `email`, `role`, `updateDate`, `updatedBy` FROM `users` ORDER BY email")
+	if err != nil {
+		return nil, fmt.Errorf("couldn't execute query: %s", err.Error())
+	}
+	defer rows.Close()
+
+	var users []data.User
+	for rows.Next() {
+		user, err := dbutil.ReadUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error reading result from query: %s", err.Error())
+		}
+
+		users = append(users, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading result from query: %s", err.Error())
+	}
+
+	return users, nil
+}
+
+// SaveUser persists the user, overwriting the role it had before
+func (db *DB) SaveUser(user data.User) error {
+	if err := db.alive(); err != nil {
+		return fmt.Errorf("database down: %s", err.Error())
+	}
+
+	if !sutils.Present(user.Email, user.Role) {
+		return fmt.Errorf("missing email or role")
+	}
+
+	query := "INSERT INTO `users` (`email`, `role`, `updateDate`, `updatedBy`) VALUES (?, ?, ?, ?) " +
+		db.dialect.Upsert([]string{"email"}, []string{"role", "updateDate", "updatedBy"})
+
+	_, err := db.exec(query, user.Email, user.Role, user.UpdateDate, user.UpdatedBy)
+	if err != nil {
+		return fmt.Errorf("saving user failed: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,168 @@
+package sqlite
+
+import "github.com/djavorszky/ddn-api/database/migrate"
+
+var migrations = []migrate.Migration{
+	{
+		Version: 1,
+		Name:    "Create the version table",
+		Up:      []string{"CREATE TABLE `version` (`queryId` INTEGER PRIMARY KEY AUTOINCREMENT, `query` TEXT NULL, `comment` TEXT NULL, `date` DATETIME NULL);"},
+		Down:    []string{"DROP TABLE `version`;"},
+	},
+	{
+		Version: 2,
+		Name:    "Create the databases table",
+		Up:      []string{"CREATE TABLE IF NOT EXISTS `databases` (`id` INTEGER PRIMARY KEY AUTOINCREMENT, `dbname` TEXT NOT NULL DEFAULT '', `dbuser` TEXT NOT NULL DEFAULT '', `dbpass` TEXT NOT NULL DEFAULT '', `dbsid` TEXT NOT NULL DEFAULT '', `dumpfile` TEXT NOT NULL DEFAULT '', `createDate` DATETIME NULL, `expiryDate` DATETIME NULL, `creator` TEXT NOT NULL DEFAULT '', `agentName` TEXT NOT NULL DEFAULT '', `dbAddress` TEXT NOT NULL DEFAULT '', `dbPort` TEXT NOT NULL DEFAULT '', `dbvendor` TEXT NOT NULL DEFAULT '', `status` INTEGER NOT NULL DEFAULT 0, `message` TEXT NOT NULL DEFAULT '', `visibility` INTEGER NOT NULL DEFAULT 0, `comment` TEXT NOT NULL DEFAULT '', `ownerGroup` TEXT NOT NULL DEFAULT '', `dumpSize` INTEGER NOT NULL DEFAULT 0);"},
+		Down:    []string{"DROP TABLE `databases`;"},
+	},
+	{
+		Version: 3,
+		Name:    "Create unique index on columns (dbname, agentName) for table databases",
+		Up:      []string{"CREATE UNIQUE INDEX `agent_db_idx` ON `databases` (`dbname`, `agentName`);"},
+		Down:    []string{"DROP INDEX `agent_db_idx`;"},
+	},
+	{
+		Version: 4,
+		Name:    "Create the push_subscriptions table",
+		Up:      []string{"CREATE TABLE IF NOT EXISTS `push_subscriptions` (`subscriber` TEXT NOT NULL, `endpoint` TEXT NOT NULL, `p256dh_key` TEXT NOT NULL, `auth_key` TEXT NOT NULL);"},
+		Down:    []string{"DROP TABLE `push_subscriptions`;"},
+	},
+	{
+		Version: 5,
+		Name:    "Create unique index on columns (subscriber,endpoint) for table push_subscriptions",
+		Up:      []string{"CREATE UNIQUE INDEX `push_subscription` ON `push_subscriptions` (`subscriber`, `endpoint`);"},
+		Down:    []string{"DROP INDEX `push_subscription`;"},
+	},
+	{
+		Version: 6,
+		Name:    "Create the api_tokens table",
+		Up:      []string{"CREATE TABLE IF NOT EXISTS `api_tokens` (`id` INTEGER PRIMARY KEY AUTOINCREMENT, `name` TEXT NOT NULL, `owner` TEXT NOT NULL, `hash` TEXT NOT NULL UNIQUE, `prefix` TEXT NOT NULL, `createDate` DATETIME NOT NULL, `expiryDate` DATETIME NOT NULL, `lastUsed` DATETIME NULL);"},
+		Down:    []string{"DROP TABLE `api_tokens`;"},
+	},
+	{
+		Version: 7,
+		Name:    "Create index on column owner for table api_tokens",
+		Up:      []string{"CREATE INDEX `api_token_owner` ON `api_tokens` (`owner`);"},
+		Down:    []string{"DROP INDEX `api_token_owner`;"},
+	},
+	{
+		Version: 8,
+		Name:    "Create the sessions table",
+		Up:      []string{"CREATE TABLE IF NOT EXISTS `sessions` (`id` TEXT NOT NULL PRIMARY KEY, `user` TEXT NOT NULL, `csrfToken` TEXT NOT NULL, `createDate` DATETIME NOT NULL, `expiryDate` DATETIME NOT NULL);"},
+		Down:    []string{"DROP TABLE `sessions`;"},
+	},
+	{
+		Version: 9,
+		Name:    "Create index on column expiryDate for table sessions",
+		Up:      []string{"CREATE INDEX `session_expiry` ON `sessions` (`expiryDate`);"},
+		Down:    []string{"DROP INDEX `session_expiry`;"},
+	},
+	{
+		Version: 10,
+		Name:    "Create the agents table",
+		Up:      []string{"CREATE TABLE IF NOT EXISTS `agents` (`id` INTEGER NOT NULL, `shortName` TEXT NOT NULL PRIMARY KEY, `longName` TEXT NOT NULL, `identifier` TEXT NOT NULL, `dbVendor` TEXT NOT NULL, `dbAddress` TEXT NOT NULL, `dbSID` TEXT NOT NULL, `version` TEXT NOT NULL, `address` TEXT NOT NULL, `token` TEXT NOT NULL);"},
+		Down:    []string{"DROP TABLE `agents`;"},
+	},
+	{
+		Version: 11,
+		Name:    "Create the sequences table",
+		Up:      []string{"CREATE TABLE IF NOT EXISTS `sequences` (`name` TEXT NOT NULL PRIMARY KEY, `value` INTEGER NOT NULL);"},
+		Down:    []string{"DROP TABLE `sequences`;"},
+	},
+	{
+		Version: 12,
+		Name:    "Create the users table",
+		Up:      []string{"CREATE TABLE IF NOT EXISTS `users` (`email` TEXT NOT NULL PRIMARY KEY, `role` TEXT NOT NULL, `updateDate` DATETIME NOT NULL, `updatedBy` TEXT NOT NULL);"},
+		Down:    []string{"DROP TABLE `users`;"},
+	},
+	{
+		Version: 13,
+		Name:    "Create the user_groups table",
+		Up:      []string{"CREATE TABLE IF NOT EXISTS `user_groups` (`name` TEXT NOT NULL PRIMARY KEY, `createDate` DATETIME NOT NULL, `createdBy` TEXT NOT NULL);"},
+		Down:    []string{"DROP TABLE `user_groups`;"},
+	},
+	{
+		Version: 14,
+		Name:    "Create the group_members table",
+		Up:      []string{"CREATE TABLE IF NOT EXISTS `group_members` (`groupName` TEXT NOT NULL, `member` TEXT NOT NULL, PRIMARY KEY (`groupName`, `member`));"},
+		Down:    []string{"DROP TABLE `group_members`;"},
+	},
+	{
+		Version: 15,
+		Name:    "Create index on column member for table group_members",
+		Up:      []string{"CREATE INDEX `group_member_idx` ON `group_members` (`member`);"},
+		Down:    []string{"DROP INDEX `group_member_idx`;"},
+	},
+	{
+		Version: 16,
+		Name:    "Create the audit_log table",
+		Up:      []string{"CREATE TABLE IF NOT EXISTS `audit_log` (`id` INTEGER PRIMARY KEY AUTOINCREMENT, `time` DATETIME NOT NULL, `actor` TEXT NOT NULL, `action` TEXT NOT NULL, `target` INTEGER NOT NULL DEFAULT 0, `targetName` TEXT NOT NULL DEFAULT '', `agent` TEXT NOT NULL DEFAULT '', `statusBefore` INTEGER NOT NULL DEFAULT 0, `statusAfter` INTEGER NOT NULL DEFAULT 0, `sourceIP` TEXT NOT NULL DEFAULT '', `details` TEXT NOT NULL);"},
+		Down:    []string{"DROP TABLE `audit_log`;"},
+	},
+	{
+		Version: 17,
+		Name:    "Create index on column time for table audit_log",
+		Up:      []string{"CREATE INDEX `audit_time` ON `audit_log` (`time`);"},
+		Down:    []string{"DROP INDEX `audit_time`;"},
+	},
+	{
+		Version: 18,
+		Name:    "Create index on column actor for table audit_log",
+		Up:      []string{"CREATE INDEX `audit_actor` ON `audit_log` (`actor`);"},
+		Down:    []string{"DROP INDEX `audit_actor`;"},
+	},
+	{
+		Version: 19,
+		Name:    "Create index on column target for table audit_log",
+		Up:      []string{"CREATE INDEX `audit_target` ON `audit_log` (`target`);"},
+		Down:    []string{"DROP INDEX `audit_target`;"},
+	},
+	{
+		Version: 20,
+		Name:    "Create the jobs table",
+		Up:      []string{"CREATE TABLE IF NOT EXISTS `jobs` (`id` INTEGER PRIMARY KEY AUTOINCREMENT, `kind` TEXT NOT NULL, `target` INTEGER NOT NULL DEFAULT 0, `targetName` TEXT NOT NULL DEFAULT '', `payload` TEXT NOT NULL DEFAULT '', `creator` TEXT NOT NULL DEFAULT '', `status` TEXT NOT NULL, `attempts` INTEGER NOT NULL DEFAULT 0, `maxAttempts` INTEGER NOT NULL DEFAULT 0, `lastError` TEXT NOT NULL DEFAULT '', `nextRun` DATETIME NOT NULL, `createDate` DATETIME NOT NULL, `updateDate` DATETIME NOT NULL);"},
+		Down:    []string{"DROP TABLE `jobs`;"},
+	},
+	{
+		Version: 21,
+		Name:    "Create index on columns (status, nextRun) for table jobs",
+		Up:      []string{"CREATE INDEX `job_status_next_run` ON `jobs` (`status`, `nextRun`);"},
+		Down:    []string{"DROP INDEX `job_status_next_run`;"},
+	},
+	{
+		Version: 22,
+		Name:    "Create index on column target for table jobs",
+		Up:      []string{"CREATE INDEX `job_target` ON `jobs` (`target`);"},
+		Down:    []string{"DROP INDEX `job_target`;"},
+	},
+	{
+		Version: 23,
+		Name:    "Add 'updateDate' column",
+		Up:      []string{"ALTER TABLE `databases` ADD COLUMN `updateDate` DATETIME NOT NULL DEFAULT '1970-01-01 00:00:00';", "UPDATE `databases` SET `updateDate` = strftime('%Y-%m-%d %H:%M:%f', 'now');"},
+		Down:    []string{"ALTER TABLE `databases` DROP COLUMN `updateDate`;"},
+	},
+	{
+		Version: 24,
+		Name:    "Add 'extensions' column",
+		Up:      []string{"ALTER TABLE `databases` ADD COLUMN `extensions` INTEGER NOT NULL DEFAULT 0;"},
+		Down:    []string{"ALTER TABLE `databases` DROP COLUMN `extensions`;"},
+	},
+	{
+		Version: 25,
+		Name:    "Add 'warned' column",
+		Up:      []string{"ALTER TABLE `databases` ADD COLUMN `warned` INTEGER NOT NULL DEFAULT 0;"},
+		Down:    []string{"ALTER TABLE `databases` DROP COLUMN `warned`;"},
+	},
+	{
+		Version: 26,
+		Name:    "Add 'mode' column to agents",
+		Up:      []string{"ALTER TABLE `agents` ADD COLUMN `mode` TEXT NOT NULL DEFAULT 'active';"},
+		Down:    []string{"ALTER TABLE `agents` DROP COLUMN `mode`;"},
+	},
+	{
+		Version: 27,
+		Name:    "Create the agent_labels table",
+		Up:      []string{"CREATE TABLE IF NOT EXISTS `agent_labels` (`shortName` TEXT NOT NULL, `source` TEXT NOT NULL, `name` TEXT NOT NULL, `value` TEXT NOT NULL, PRIMARY KEY (`shortName`, `source`, `name`));"},
+		Down:    []string{"DROP TABLE `agent_labels`;"},
+	},
+}
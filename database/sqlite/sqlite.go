@@ -0,0 +1,113 @@
+// Package sqlite implements the BackendConnection on top of an SQLite3
+// database file, for small deployments that don't want to run a MySQL
+// server, and for tests.
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/djavorszky/ddn-api/database/migrate"
+	"github.com/djavorszky/ddn-api/database/sqldb"
+	"github.com/djavorszky/sutils"
+
+	// Db
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// timeFormat is how times are stored. SQLite has no time type, so times
+// are kept as UTC text of fixed width, which keeps comparing them in
+// queries working.
+const timeFormat = "2006-01-02 15:04:05.000000000"
+
+// DB implements the BackendConnection
+type DB struct {
+	Path string
+	sqldb.DB
+}
+
+// ConnectAndPrepare opens the database file, creating it if needed, and
+// initializes the tables.
+func (lite *DB) ConnectAndPrepare() error {
+	err := lite.open()
+	if err != nil {
+		return err
+	}
+
+	err = lite.initTables()
+	if err != nil {
+		return fmt.Errorf("initializing tables failed: %s", err.Error())
+	}
+
+	return nil
+}
+
+// Migrator opens the database file and returns the migrator of its
+// schema, without migrating it.
+func (lite *DB) Migrator() (*migrate.Migrator, error) {
+	err := lite.open()
+	if err != nil {
+		return nil, err
+	}
+
+	return migrate.New(lite.Conn(), migrate.SQLite, migrations)
+}
+
+func (lite *DB) open() error {
+	if !sutils.Present(lite.Path) {
+		return fmt.Errorf("missing database path")
+	}
+
+	err := lite.connect(lite.Path)
+	if err != nil {
+		return fmt.Errorf("couldn't open the database: %s", err.Error())
+	}
+
+	return nil
+}
+
+func (lite *DB) connect(path string) error {
+	db, err := sql.Open("sqlite3", "file:"+path+"?_foreign_keys=1&_busy_timeout=5000")
+	if err != nil {
+		return fmt.Errorf("creating connection pool failed: %s", err.Error())
+	}
+
+	// SQLite only allows one writer at a time, and an in-memory database
+	// only lives as long as its connection.
+	db.SetMaxOpenConns(1)
+
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return fmt.Errorf("database ping failed: %s", sutils.TrimNL(err.Error()))
+	}
+	lite.Use(db, dialect{migrate.SQLite})
+
+	return nil
+}
+
+func (lite *DB) initTables() error {
+	migrator, err := migrate.New(lite.Conn(), migrate.SQLite, migrations)
+	if err != nil {
+		return err
+	}
+
+	return migrator.Up()
+}
+
+// dialect is the SQL of SQLite. Times are stored in timeFormat, and the
+// driver reports the IDs of new rows.
+type dialect struct {
+	migrate.Dialect
+}
+
+func (dialect) Time(t time.Time) interface{} { return t.UTC().Format(timeFormat) }
+
+func (dialect) ReturningID() bool { return false }
+
+func (dialect) Upsert(keys, columns []string) string { return sqldb.OnConflict(keys, columns) }
+
+// Contains has to name the escape character, SQLite has no default one.
+// LIKE ignores the case of ASCII letters.
+func (dialect) Contains(column string) string { return column + " LIKE ? ESCAPE '\\'" }
@@ -0,0 +1,148 @@
+package sqlite
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/djavorszky/ddn-api/database/data"
+	"github.com/djavorszky/ddn-api/database/dbtest"
+	"github.com/djavorszky/ddn-api/database/dbutil"
+	"github.com/djavorszky/ddn-api/database/migrate"
+)
+
+func newTestDB(t *testing.T, path string) *DB {
+	lite := &DB{Path: path}
+
+	err := lite.ConnectAndPrepare()
+	if err != nil {
+		t.Fatalf("ConnectAndPrepare() failed: %v", err)
+	}
+
+	t.Cleanup(func() { lite.Close() })
+
+	return lite
+}
+
+func TestInitTables(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ddn.db")
+
+	lite := newTestDB(t, path)
+
+	rows, err := lite.Conn().Query("SELECT `version`, `name`, `checksum`, `dirty` FROM `schema_migrations` ORDER BY `version`")
+	if err != nil {
+		t.Fatalf("schema_migrations table has not been created: %v", err)
+	}
+	defer rows.Close()
+
+	var count int
+	for rows.Next() {
+		var (
+			version, dirty int
+			name, checksum string
+		)
+
+		rows.Scan(&version, &name, &checksum, &dirty)
+
+		mig := migrations[version-1]
+		if name != mig.Name || checksum != mig.Checksum() || dirty != 0 {
+			t.Errorf("Recorded migration %d not what was expected", version)
+		}
+
+		count++
+	}
+
+	if count != len(migrations) {
+		t.Errorf("Expected %d applied migrations, got %d", len(migrations), count)
+	}
+
+	lite.Close()
+
+	// Reopening the file should not run the migrations again
+	lite = newTestDB(t, path)
+
+	lite.Conn().QueryRow("SELECT count(*) FROM `schema_migrations`").Scan(&count)
+	if count != len(migrations) {
+		t.Errorf("Expected %d applied migrations after reopening, got %d", len(migrations), count)
+	}
+}
+
+func TestMigrations(t *testing.T) {
+	lite := newTestDB(t, filepath.Join(t.TempDir(), "ddn.db"))
+
+	migrator, err := migrate.New(lite.Conn(), migrate.SQLite, migrations)
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+
+	err = migrator.To(0)
+	if err != nil {
+		t.Fatalf("Rolling back all migrations failed: %v", err)
+	}
+
+	var tables int
+	lite.Conn().QueryRow("SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name NOT IN ('schema_migrations', 'schema_lock', 'sqlite_sequence')").Scan(&tables)
+	if tables != 0 {
+		t.Errorf("Expected no tables after rolling back, got %d", tables)
+	}
+
+	err = migrator.Up()
+	if err != nil {
+		t.Fatalf("Applying the migrations again failed: %v", err)
+	}
+}
+
+func TestReadRow(t *testing.T) {
+	lite := newTestDB(t, ":memory:")
+
+	testEntry := dbtest.TestEntry
+	testEntry.DBName = "readRow"
+
+	err := lite.Insert(&testEntry)
+	if err != nil {
+		t.Fatalf("Failed adding a entry: %s", err.Error())
+	}
+
+	row, err := dbutil.ReadRow(lite.Conn().QueryRow("SELECT * FROM `databases` WHERE id = ?", testEntry.ID))
+	if err != nil {
+		t.Fatalf("Failed reading row: %s", err.Error())
+	}
+
+	if err = dbutil.CompareRows(testEntry, row); err != nil {
+		t.Errorf("Persisted and read DBEntry not the same: %s", err.Error())
+	}
+}
+
+func TestTimeZones(t *testing.T) {
+	lite := newTestDB(t, ":memory:")
+
+	now := time.Now().UTC()
+	east := time.FixedZone("east", 5*60*60)
+
+	session := data.Session{
+		ID:         "east-session",
+		User:       "sessions@example.com",
+		CreateDate: now.In(east),
+		ExpiryDate: now.Add(time.Hour).In(east),
+	}
+
+	err := lite.InsertSession(&session)
+	if err != nil {
+		t.Fatalf("InsertSession() failed: %v", err)
+	}
+
+	lite.DeleteExpiredSessions(now)
+
+	read, _ := lite.FetchSession(session.ID)
+	if read.ID == "" {
+		t.Fatalf("DeleteExpiredSessions() removed a session that expires in another time zone later")
+	}
+
+	if !read.ExpiryDate.Equal(session.ExpiryDate) {
+		t.Errorf("ExpiryDate = %v, expected %v", read.ExpiryDate, session.ExpiryDate)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	dbtest.Run(t, newTestDB(t, filepath.Join(t.TempDir(), "ddn.db")))
+}
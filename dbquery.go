@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/djavorszky/ddn-api/database/data"
+)
+
+const (
+	defaultDatabasePageSize = 100
+	maxDatabasePageSize     = 1000
+)
+
+// databaseCursor is where a page of the database listing ended: the sort
+// order of the listing and the sorted field of its last entry. It's
+// handed to the clients encoded, to be sent back as is for the next page.
+type databaseCursor struct {
+	Sort       string    `json:"s"`
+	Descending bool      `json:"d"`
+	ID         int       `json:"id"`
+	Name       string    `json:"n,omitempty"`
+	Time       time.Time `json:"t"`
+}
+
+// encodeCursor returns the cursor of the page of the query that ended
+// with row.
+func encodeCursor(query data.DatabaseQuery, row data.Row) string {
+	cursor := databaseCursor{Sort: query.Sort, Descending: query.Descending, ID: row.ID}
+
+	switch query.Sort {
+	case data.SortByName:
+		cursor.Name = row.DBName
+	case data.SortByCreateDate:
+		cursor.Time = row.CreateDate
+	case data.SortByExpiryDate:
+		cursor.Time = row.ExpiryDate
+	}
+
+	b, _ := json.Marshal(cursor)
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor sets the entry the query continues after. The cursor has
+// to be from a listing with the same sort order.
+func decodeCursor(query *data.DatabaseQuery, v string) error {
+	b, err := base64.RawURLEncoding.DecodeString(v)
+	if err != nil {
+		return err
+	}
+
+	var cursor databaseCursor
+	if err := json.Unmarshal(b, &cursor); err != nil {
+		return err
+	}
+
+	if cursor.Sort != query.Sort || cursor.Descending != query.Descending {
+		return errors.New("cursor of another sort order")
+	}
+
+	query.After = &data.Row{ID: cursor.ID, DBName: cursor.Name, CreateDate: cursor.Time, ExpiryDate: cursor.Time}
+
+	return nil
+}
+
+// databaseQueryFrom reads the filters, the sort order and the page of the
+// database listing from the query parameters of the request. The limit
+// of the returned query is the size of the page.
+func databaseQueryFrom(r *http.Request) (data.DatabaseQuery, error) {
+	params := r.URL.Query()
+
+	query := data.DatabaseQuery{
+		Agent:        params.Get("agent"),
+		Vendor:       params.Get("vendor"),
+		StatusClass:  params.Get("status"),
+		Creator:      params.Get("creator"),
+		NameContains: params.Get("name"),
+		Sort:         data.SortByID,
+		Descending:   true,
+		Limit:        defaultDatabasePageSize,
+	}
+
+	var err error
+
+	if query.StatusClass != "" {
+		if _, _, ok := data.StatusRange(query.StatusClass); !ok {
+			return query, errors.New("status")
+		}
+	}
+
+	if v := params.Get("expires_after"); v != "" {
+		query.ExpiresAfter, err = parseAuditTime(v)
+		if err != nil {
+			return query, errors.New("expires_after")
+		}
+	}
+
+	if v := params.Get("expires_before"); v != "" {
+		query.ExpiresBefore, err = parseAuditTime(v)
+		if err != nil {
+			return query, errors.New("expires_before")
+		}
+	}
+
+	switch v := params.Get("sort"); v {
+	case "":
+	case data.SortByID, data.SortByName, data.SortByCreateDate, data.SortByExpiryDate:
+		query.Sort = v
+	default:
+		return query, errors.New("sort")
+	}
+
+	switch params.Get("order") {
+	case "", "desc":
+	case "asc":
+		query.Descending = false
+	default:
+		return query, errors.New("order")
+	}
+
+	if v := params.Get("per_page"); v != "" {
+		query.Limit, err = strconv.Atoi(v)
+		if err != nil || query.Limit < 1 || query.Limit > maxDatabasePageSize {
+			return query, errors.New("per_page")
+		}
+	}
+
+	if v := params.Get("cursor"); v != "" {
+		if decodeCursor(&query, v) != nil {
+			return query, errors.New("cursor")
+		}
+	}
+
+	return query, nil
+}
+
+// nextPageLink returns the value of the Link header that points to the
+// page after the one that ended with the cursor.
+func nextPageLink(r *http.Request, cursor string) string {
+	params := r.URL.Query()
+	params.Set("cursor", cursor)
+
+	return fmt.Sprintf(`<%s?%s>; rel="next"`, r.URL.Path, params.Encode())
+}
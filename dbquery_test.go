@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/djavorszky/ddn-api/database/data"
+	vis "github.com/djavorszky/ddn-common/visibility"
+)
+
+func Test_databaseQueryFrom(t *testing.T) {
+	defaults := data.DatabaseQuery{Sort: data.SortByID, Descending: true, Limit: defaultDatabasePageSize}
+
+	tests := []struct {
+		query   string
+		want    data.DatabaseQuery
+		wantErr string
+	}{
+		{"", defaults, ""},
+		{"agent=mysql-55&vendor=mysql&status=error&creator=jane@example.com&name=test", data.DatabaseQuery{
+			Agent:        "mysql-55",
+			Vendor:       "mysql",
+			StatusClass:  data.StatusClassError,
+			Creator:      "jane@example.com",
+			NameContains: "test",
+			Sort:         data.SortByID,
+			Descending:   true,
+			Limit:        defaultDatabasePageSize,
+		}, ""},
+		{"expires_after=2018-03-01&expires_before=2018-03-02T10:00:00Z", data.DatabaseQuery{
+			ExpiresAfter:  time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC),
+			ExpiresBefore: time.Date(2018, 3, 2, 10, 0, 0, 0, time.UTC),
+			Sort:          data.SortByID,
+			Descending:    true,
+			Limit:         defaultDatabasePageSize,
+		}, ""},
+		{"sort=expirydate&order=asc&per_page=20", data.DatabaseQuery{Sort: data.SortByExpiryDate, Limit: 20}, ""},
+		{"status=great", defaults, "status"},
+		{"expires_after=tomorrow", defaults, "expires_after"},
+		{"expires_before=03/01/2018", defaults, "expires_before"},
+		{"sort=size", defaults, "sort"},
+		{"order=up", defaults, "order"},
+		{"per_page=0", defaults, "per_page"},
+		{"per_page=5000", defaults, "per_page"},
+		{"cursor=nonsense", defaults, "cursor"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api/databases?"+tt.query, nil)
+
+			got, err := databaseQueryFrom(r)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("databaseQueryFrom() error = %v, expected %s", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("databaseQueryFrom() failed: %v", err)
+			}
+
+			if got.Agent != tt.want.Agent || got.Vendor != tt.want.Vendor || got.StatusClass != tt.want.StatusClass ||
+				got.Creator != tt.want.Creator || got.NameContains != tt.want.NameContains ||
+				!got.ExpiresAfter.Equal(tt.want.ExpiresAfter) || !got.ExpiresBefore.Equal(tt.want.ExpiresBefore) ||
+				got.Sort != tt.want.Sort || got.Descending != tt.want.Descending || got.Limit != tt.want.Limit || got.After != nil {
+				t.Errorf("databaseQueryFrom() = %+v, expected %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_decodeCursor(t *testing.T) {
+	expiry := time.Date(2018, 3, 1, 10, 0, 0, 0, time.UTC)
+	query := data.DatabaseQuery{Sort: data.SortByExpiryDate}
+
+	cursor := encodeCursor(query, data.Row{ID: 12, DBName: "test", ExpiryDate: expiry})
+
+	if err := decodeCursor(&query, cursor); err != nil {
+		t.Fatalf("decodeCursor() failed: %v", err)
+	}
+
+	if query.After == nil || query.After.ID != 12 || !query.After.ExpiryDate.Equal(expiry) {
+		t.Errorf("decodeCursor() continues after %+v", query.After)
+	}
+
+	other := data.DatabaseQuery{Sort: data.SortByExpiryDate, Descending: true}
+	if err := decodeCursor(&other, cursor); err == nil {
+		t.Errorf("decodeCursor() of another sort order succeeded")
+	}
+}
+
+func TestAPIDatabasesPaging(t *testing.T) {
+	ts := newTestServer(t)
+	token := ts.login("pager@example.com").issueToken("pager")
+
+	for _, row := range []data.Row{
+		{DBName: "page_one", Creator: "pager@example.com", AgentName: "mysql-55"},
+		{DBName: "page_two", Creator: "pager@example.com", AgentName: "mysql-55"},
+		{DBName: "page_three", Creator: "someone@example.com", AgentName: "mysql-55", Public: vis.Public},
+		{DBName: "page_hidden", Creator: "someone@example.com", AgentName: "mysql-55"},
+		{DBName: "page_elsewhere", Creator: "pager@example.com", AgentName: "postgres-10"},
+	} {
+		if err := ts.db.Insert(&row); err != nil {
+			t.Fatalf("Insert() failed: %v", err)
+		}
+	}
+
+	link := regexp.MustCompile(`^<(.+)>; rel="next"$`)
+
+	var names []string
+	for path := "/api/databases?agent=mysql-55&sort=dbname&order=asc&per_page=2"; path != ""; {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+
+		var page struct {
+			Data []data.Row `json:"data"`
+		}
+
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s: got %d, %v", path, resp.StatusCode, err)
+		}
+
+		for _, row := range page.Data {
+			names = append(names, row.DBName)
+		}
+
+		path = ""
+		if m := link.FindStringSubmatch(resp.Header.Get("Link")); m != nil {
+			path = m[1]
+		}
+	}
+
+	want := []string{"page_one", "page_three", "page_two"}
+	if len(names) != len(want) || names[0] != want[0] || names[1] != want[1] || names[2] != want[2] {
+		t.Errorf("paged through %v, want %v", names, want)
+	}
+
+	if code := ts.api(http.MethodGet, "/api/databases?sort=size", token, nil, nil); code != http.StatusBadRequest {
+		t.Errorf("listing with unknown sort field: got %d, want %d", code, http.StatusBadRequest)
+	}
+}
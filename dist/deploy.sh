@@ -17,7 +17,8 @@ else
     date=`date -u "+%Y-%m-%d_%H:%M:%S"`
     commit=`git log -n 1 --pretty=format:"%H"`
 
-    GOOS=linux go build -ldflags "-X main.version=${version} -X 'main.buildTime=`date`' -X main.commit=${commit}"
+    # The image is alpine based, so the binary has to be static.
+    CGO_ENABLED=0 GOOS=linux go build -ldflags "-X main.version=${version} -X 'main.buildTime=`date`' -X main.commit=${commit}"
     
     cp $rootloc/ddn-api $rootloc/dist/ddn-api
 
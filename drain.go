@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/djavorszky/ddn-api/database/data"
+	"github.com/djavorszky/ddn-api/registry"
+	"github.com/djavorszky/ddn-common/errs"
+	"github.com/djavorszky/ddn-common/inet"
+	"github.com/djavorszky/ddn-common/logger"
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultDrainTimeout = time.Minute
+	maxDrainTimeout     = time.Hour
+)
+
+// drainPollInterval is how often the work of a draining agent is checked.
+var drainPollInterval = time.Second
+
+// drainStatus tells how much work an agent has left.
+type drainStatus struct {
+	Agent      string `json:"agent"`
+	Mode       string `json:"agent_mode"`
+	InProgress int    `json:"in_progress"`
+	Drained    bool   `json:"drained"`
+}
+
+// agentWork returns the number of databases of the agent that are in
+// progress or have a job queued or running on them.
+func agentWork(shortName string) (int, error) {
+	rows, err := db.FetchAll()
+	if err != nil {
+		return 0, fmt.Errorf("failed listing databases: %v", err)
+	}
+
+	active := make(map[int]bool)
+	for _, s := range []string{data.JobQueued, data.JobRunning} {
+		jobs, err := db.FetchJobs(data.JobFilter{Status: s})
+		if err != nil {
+			return 0, fmt.Errorf("failed listing jobs: %v", err)
+		}
+
+		for _, job := range jobs {
+			active[job.Target] = true
+		}
+	}
+
+	var n int
+	for _, row := range rows {
+		if row.AgentName == shortName && (row.InProgress() || active[row.ID]) {
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// apiSetAgentMode puts the agent in the mode. Agents that are draining or
+// in maintenance take no new databases, but finish the work they have.
+func apiSetAgentMode(w http.ResponseWriter, r *http.Request) {
+	p, err := getAPIPrincipal(r)
+	if err != nil {
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return
+	}
+
+	if !p.can(actAdminister) {
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	var before string
+
+	agent, ok := registry.Update(vars["agent"], func(a *registry.Agent) {
+		before = a.Mode
+		a.Mode = vars["mode"]
+	})
+	if !ok {
+		inet.SendFailure(w, http.StatusNotFound, errs.AgentNotFound, vars["agent"])
+		return
+	}
+
+	if before == "" {
+		before = data.ModeActive
+	}
+
+	audit(r, data.AuditEntry{Actor: p.Email, Action: auditAgentMode, Agent: agent.ShortName, Details: before + " -> " + agent.Mode})
+
+	logger.Info("Agent %q is now in %s mode", agent.ShortName, agent.Mode)
+
+	inet.SendSuccess(w, http.StatusOK, redactAgent(agent))
+}
+
+// apiWaitDrained waits until the agent has no work left, or the timeout
+// passes. The timeout is a duration like 90s or 5m.
+func apiWaitDrained(w http.ResponseWriter, r *http.Request) {
+	p, err := getAPIPrincipal(r)
+	if err != nil {
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return
+	}
+
+	if !p.can(actAdminister) {
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return
+	}
+
+	name := mux.Vars(r)["agent"]
+
+	if _, ok := registry.Get(name); !ok {
+		inet.SendFailure(w, http.StatusNotFound, errs.AgentNotFound, name)
+		return
+	}
+
+	timeout := defaultDrainTimeout
+	if v := r.URL.Query().Get("timeout"); v != "" {
+		timeout, err = time.ParseDuration(v)
+		if err != nil || timeout < 0 || timeout > maxDrainTimeout {
+			inet.SendFailure(w, http.StatusBadRequest, errs.UnknownParameter, "timeout")
+			return
+		}
+	}
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for {
+		n, err := agentWork(name)
+		if err != nil {
+			inet.SendFailure(w, http.StatusInternalServerError, errs.QueryFailed, err.Error())
+
+			logger.Error("Checking work of agent %q failed: %v", name, err)
+			return
+		}
+
+		agent, _ := registry.Get(name)
+		ds := drainStatus{Agent: name, Mode: agent.Mode, InProgress: n, Drained: n == 0}
+
+		if ds.Drained {
+			inet.SendSuccess(w, http.StatusOK, ds)
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-deadline.C:
+			inet.SendFailure(w, http.StatusRequestTimeout, errAgentNotDrained, name, fmt.Sprintf("%d in progress", n))
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
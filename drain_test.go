@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/djavorszky/ddn-api/database/data"
+	"github.com/djavorszky/ddn-api/registry"
+	"github.com/djavorszky/ddn-common/model"
+	"github.com/djavorszky/ddn-common/status"
+)
+
+func TestAgentMode(t *testing.T) {
+	ts := newTestServer(t)
+
+	agent := ts.startAgent("fake-mysql")
+
+	token := ts.login("user@example.com").issueToken("mode")
+	adminToken := ts.login(testAdmin).issueToken("mode")
+
+	if code := ts.api(http.MethodPut, "/api/agents/fake-mysql/mode/draining", token, nil, nil); code != http.StatusForbidden {
+		t.Errorf("draining as a user: got %d, want %d", code, http.StatusForbidden)
+	}
+
+	var drained registry.Agent
+	if code := ts.api(http.MethodPut, "/api/agents/fake-mysql/mode/draining", adminToken, nil, &drained); code != http.StatusOK {
+		t.Fatalf("draining: got %d, want %d", code, http.StatusOK)
+	}
+
+	if drained.Mode != data.ModeDraining {
+		t.Errorf("drained agent is in mode %q", drained.Mode)
+	}
+
+	req := apiDatabaseRequest{ClientRequest: model.ClientRequest{AgentIdentifier: agent.name}}
+	if code := ts.api(http.MethodPost, "/api/databases/create", token, req, nil); code != http.StatusConflict {
+		t.Errorf("creating on a draining agent: got %d, want %d", code, http.StatusConflict)
+	}
+
+	req = apiDatabaseRequest{placementRequest: placementRequest{Vendor: "mysql"}}
+	if code := ts.api(http.MethodPost, "/api/databases/create", token, req, nil); code != http.StatusServiceUnavailable {
+		t.Errorf("placing with only a draining agent: got %d, want %d", code, http.StatusServiceUnavailable)
+	}
+
+	agents, err := ts.db.FetchAgents()
+	if err != nil || len(agents) != 1 || agents[0].Mode != data.ModeDraining {
+		t.Errorf("persisted agents = %+v, %v", agents, err)
+	}
+
+	// The agent keeps its mode when it registers again after a restart.
+	ts.startAgent("fake-mysql")
+
+	if a, _ := registry.Get("fake-mysql"); a.Mode != data.ModeDraining {
+		t.Errorf("registered agent is in mode %q, want %q", a.Mode, data.ModeDraining)
+	}
+
+	ts.api(http.MethodPut, "/api/agents/fake-mysql/mode/active", adminToken, nil, nil)
+
+	req = apiDatabaseRequest{ClientRequest: model.ClientRequest{AgentIdentifier: agent.name}}
+	if code := ts.api(http.MethodPost, "/api/databases/create", token, req, nil); code != http.StatusOK {
+		t.Errorf("creating on an active agent: got %d, want %d", code, http.StatusOK)
+	}
+}
+
+func TestWaitDrained(t *testing.T) {
+	ts := newTestServer(t)
+
+	defer func(orig time.Duration) { drainPollInterval = orig }(drainPollInterval)
+	drainPollInterval = 10 * time.Millisecond
+
+	agent := ts.startAgent("fake-mysql")
+	token := ts.login(testAdmin).issueToken("drain")
+
+	row := data.Row{DBName: "importing", AgentName: agent.name, Status: status.ImportInProgress}
+	if err := ts.db.Insert(&row); err != nil {
+		t.Fatalf("Insert() failed: %v", err)
+	}
+
+	if code := ts.api(http.MethodGet, "/api/agents/fake-mysql/drained?timeout=50ms", token, nil, nil); code != http.StatusRequestTimeout {
+		t.Errorf("waiting with an import in progress: got %d, want %d", code, http.StatusRequestTimeout)
+	}
+
+	if code := ts.api(http.MethodGet, "/api/agents/fake-mysql/drained?timeout=forever", token, nil, nil); code != http.StatusBadRequest {
+		t.Errorf("waiting with an invalid timeout: got %d, want %d", code, http.StatusBadRequest)
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+
+		row.Status = status.Success
+		ts.db.Update(&row)
+	}()
+
+	var ds drainStatus
+	if code := ts.api(http.MethodGet, "/api/agents/fake-mysql/drained?timeout=5s", token, nil, &ds); code != http.StatusOK {
+		t.Fatalf("waiting for the import to finish: got %d, want %d", code, http.StatusOK)
+	}
+
+	if !ds.Drained || ds.InProgress != 0 {
+		t.Errorf("drain status = %+v", ds)
+	}
+}
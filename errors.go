@@ -0,0 +1,19 @@
+package main
+
+// Error codes that are specific to the API server. The shared ones
+// live in ddn-common/errs.
+const (
+	errInvalidToken  = "ERR_INVALID_TOKEN"
+	errTokenExpired  = "ERR_TOKEN_EXPIRED"
+	errRoleLocked    = "ERR_ROLE_LOCKED"
+	errGroupExists   = "ERR_GROUP_EXISTS"
+	errInvalidOwner  = "ERR_INVALID_OWNER"
+	errQuotaExceeded = "ERR_QUOTA_EXCEEDED"
+
+	errAgentUnavailable = "ERR_AGENT_UNAVAILABLE"
+	errAgentNotDrained  = "ERR_AGENT_NOT_DRAINED"
+
+	errInsufficientSpace = "ERR_INSUFFICIENT_SPACE"
+	errOutsideMount      = "ERR_OUTSIDE_MOUNTED_FOLDER"
+	errInvalidLabel      = "ERR_INVALID_LABEL"
+)
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/djavorszky/ddn-api/database/data"
+	"github.com/djavorszky/ddn-common/logger"
+	"github.com/djavorszky/ddn-common/status"
+)
+
+// ExpiryPolicy sets how long the databases it matches live. Empty Agent,
+// Vendor and Role match every database, and zero values fall back to
+// the ones of defaultExpiryPolicy.
+type ExpiryPolicy struct {
+	Agent  string `toml:"agent"`
+	Vendor string `toml:"vendor"`
+	Role   string `toml:"role"`
+
+	// LifetimeDays is how long new databases live, and how long extending
+	// them from the web interface adds from now.
+	LifetimeDays int `toml:"lifetime-days"`
+
+	// MaxLifetimeDays caps the expiry date of the databases, counted from
+	// their creation. Zero means no cap.
+	MaxLifetimeDays int `toml:"max-lifetime-days"`
+
+	// MaxExtensions is how many times a database may be extended. Zero
+	// means no limit.
+	MaxExtensions int `toml:"max-extensions"`
+
+	// FailureRetentionDays is how long failed databases are kept.
+	FailureRetentionDays int `toml:"failure-retention-days"`
+
+	// WarnDays are the days before the expiry when the creator is warned.
+	WarnDays []int `toml:"warn-days"`
+}
+
+var defaultExpiryPolicy = ExpiryPolicy{
+	LifetimeDays:         30,
+	FailureRetentionDays: 2,
+	WarnDays:             []int{7, 1},
+}
+
+// expiryPolicy returns the policy of the databases on the agent of the
+// vendor, created by a user with the role. Of the configured policies
+// that match, the one that names the most of them wins, the first one
+// on a tie.
+func expiryPolicy(agent, vendor, role string) ExpiryPolicy {
+	policy, best := defaultExpiryPolicy, -1
+
+	for _, p := range config.ExpiryPolicies {
+		if (p.Agent != "" && p.Agent != agent) || (p.Vendor != "" && p.Vendor != vendor) || (p.Role != "" && p.Role != role) {
+			continue
+		}
+
+		specificity := 0
+		for _, field := range []string{p.Agent, p.Vendor, p.Role} {
+			if field != "" {
+				specificity++
+			}
+		}
+
+		if specificity > best {
+			policy, best = p, specificity
+		}
+	}
+
+	if policy.LifetimeDays <= 0 {
+		policy.LifetimeDays = defaultExpiryPolicy.LifetimeDays
+	}
+
+	if policy.FailureRetentionDays <= 0 {
+		policy.FailureRetentionDays = defaultExpiryPolicy.FailureRetentionDays
+	}
+
+	if len(policy.WarnDays) == 0 {
+		policy.WarnDays = defaultExpiryPolicy.WarnDays
+	}
+
+	return policy
+}
+
+// expiryPolicyOf returns the policy of the database, looking up the role
+// of its creator.
+func expiryPolicyOf(row data.Row) ExpiryPolicy {
+	role, err := roleOf(row.Creator)
+	if err != nil {
+		logger.Warn("Failed getting role of %q, using %q: %v", row.Creator, defaultRole(), err)
+
+		role = defaultRole()
+	}
+
+	return expiryPolicy(row.AgentName, row.DBVendor, role)
+}
+
+// expiry returns when a database created at the time expires.
+func (p ExpiryPolicy) expiry(created time.Time) time.Time {
+	return created.AddDate(0, 0, p.LifetimeDays)
+}
+
+// failureExpiry returns until when a database that failed at the time is
+// kept.
+func (p ExpiryPolicy) failureExpiry(failed time.Time) time.Time {
+	return failed.AddDate(0, 0, p.FailureRetentionDays)
+}
+
+// warning returns the number of days of the warning that is due for a
+// database that expires in left: the closest of the warning days that
+// left is within.
+func (p ExpiryPolicy) warning(left time.Duration) (int, bool) {
+	if left <= 0 {
+		return 0, false
+	}
+
+	days := append([]int(nil), p.WarnDays...)
+	sort.Ints(days)
+
+	for _, d := range days {
+		if left <= time.Duration(d)*24*time.Hour {
+			return d, true
+		}
+	}
+
+	return 0, false
+}
+
+// expired tells whether the database expired by the time.
+func expired(row data.Row, now time.Time) bool {
+	return !row.ExpiryDate.After(now)
+}
+
+// extendExpiry moves the expiry date of the database to until, capped by
+// the maximum lifetime of its policy, and counts the extension. A
+// database scheduled for removal is no longer.
+func extendExpiry(row *data.Row, until time.Time) error {
+	policy := expiryPolicyOf(*row)
+
+	if policy.MaxExtensions > 0 && row.Extensions >= policy.MaxExtensions {
+		return fmt.Errorf("database was extended the maximum %d times", policy.MaxExtensions)
+	}
+
+	if !until.After(row.ExpiryDate) {
+		return fmt.Errorf("database expires on %s already", row.ExpiryDate.Format("2006-01-02"))
+	}
+
+	if policy.MaxLifetimeDays > 0 {
+		max := row.CreateDate.AddDate(0, 0, policy.MaxLifetimeDays)
+
+		if !max.After(row.ExpiryDate) {
+			return fmt.Errorf("database reached its maximum lifetime of %d days", policy.MaxLifetimeDays)
+		}
+
+		if until.After(max) {
+			until = max
+		}
+	}
+
+	row.ExpiryDate = until
+	row.Extensions++
+	row.Warned = 0
+
+	if row.Status == status.RemovalScheduled {
+		row.Status = status.Success
+	}
+
+	return nil
+}
+
+// dayCount formats the number of days for the warnings.
+func dayCount(days int) string {
+	if days == 1 {
+		return "1 day"
+	}
+
+	return fmt.Sprintf("%d days", days)
+}
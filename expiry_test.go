@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/djavorszky/ddn-api/database/data"
+	"github.com/djavorszky/ddn-common/status"
+)
+
+func Test_expiryPolicy(t *testing.T) {
+	defer func(orig Config) { config = orig }(config)
+
+	config = Config{ExpiryPolicies: []ExpiryPolicy{
+		{Vendor: "mysql", LifetimeDays: 14},
+		{Agent: "big", Vendor: "mysql", LifetimeDays: 60, WarnDays: []int{3}},
+		{Role: roleAdmin, LifetimeDays: 90},
+		{Role: roleAdmin, LifetimeDays: 10},
+		{Agent: "short", FailureRetentionDays: 1},
+	}}
+
+	tests := []struct {
+		name                 string
+		agent, vendor, role  string
+		wantLifetime         int
+		wantFailureRetention int
+		wantWarnDays         []int
+	}{
+		{"no match", "other", "postgres", roleUser, 30, 2, []int{7, 1}},
+		{"vendor", "other", "mysql", roleUser, 14, 2, []int{7, 1}},
+		{"most specific", "big", "mysql", roleAdmin, 60, 2, []int{3}},
+		{"first on a tie", "other", "postgres", roleAdmin, 90, 2, []int{7, 1}},
+		{"defaults for zero values", "short", "oracle", roleUser, 30, 1, []int{7, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := expiryPolicy(tt.agent, tt.vendor, tt.role)
+
+			if got.LifetimeDays != tt.wantLifetime || got.FailureRetentionDays != tt.wantFailureRetention || !reflect.DeepEqual(got.WarnDays, tt.wantWarnDays) {
+				t.Errorf("expiryPolicy() = %+v", got)
+			}
+		})
+	}
+}
+
+func TestExpiryPolicy_warning(t *testing.T) {
+	p := ExpiryPolicy{WarnDays: []int{1, 7}}
+	day := 24 * time.Hour
+
+	tests := []struct {
+		left     time.Duration
+		wantDays int
+		wantOK   bool
+	}{
+		{8 * day, 0, false},
+		{7 * day, 7, true},
+		{2 * day, 7, true},
+		{day, 1, true},
+		{time.Hour, 1, true},
+		{0, 0, false},
+	}
+	for _, tt := range tests {
+		days, ok := p.warning(tt.left)
+		if days != tt.wantDays || ok != tt.wantOK {
+			t.Errorf("warning(%v) = %d, %v, want %d, %v", tt.left, days, ok, tt.wantDays, tt.wantOK)
+		}
+	}
+}
+
+func Test_extendExpiry(t *testing.T) {
+	newTestServer(t)
+	config.ExpiryPolicies = []ExpiryPolicy{{MaxLifetimeDays: 40, MaxExtensions: 2}}
+
+	created := time.Now().AddDate(0, 0, -20)
+	row := data.Row{CreateDate: created, ExpiryDate: created.AddDate(0, 0, 30), Status: status.RemovalScheduled}
+
+	if err := extendExpiry(&row, row.ExpiryDate.AddDate(0, 0, -1)); err == nil {
+		t.Errorf("extendExpiry() to an earlier date succeeded")
+	}
+
+	if err := extendExpiry(&row, row.ExpiryDate.AddDate(0, 0, 30)); err != nil {
+		t.Fatalf("extendExpiry() failed: %v", err)
+	}
+
+	if !row.ExpiryDate.Equal(created.AddDate(0, 0, 40)) {
+		t.Errorf("expiry = %v, want capped at %v", row.ExpiryDate, created.AddDate(0, 0, 40))
+	}
+
+	if row.Extensions != 1 || row.Status != status.Success {
+		t.Errorf("extensions = %d, status = %d after the extension", row.Extensions, row.Status)
+	}
+
+	if err := extendExpiry(&row, row.ExpiryDate.AddDate(0, 0, 1)); err == nil {
+		t.Errorf("extendExpiry() beyond the maximum lifetime succeeded")
+	}
+
+	config.ExpiryPolicies[0].MaxLifetimeDays = 0
+	row.Extensions = 2
+
+	if err := extendExpiry(&row, row.ExpiryDate.AddDate(0, 0, 1)); err == nil {
+		t.Errorf("extendExpiry() beyond the maximum extensions succeeded")
+	}
+}
+
+func TestExtendExpiryAPI(t *testing.T) {
+	ts := newTestServer(t)
+	config.ExpiryPolicies = []ExpiryPolicy{{Vendor: "mysql", MaxExtensions: 1}}
+
+	token := ts.login("user@example.com").issueToken("expiry")
+
+	row := data.Row{DBName: "expiring", DBVendor: "mysql", Creator: "user@example.com", Status: status.Success, CreateDate: time.Now()}
+	row.ExpiryDate = expiryPolicyOf(row).expiry(row.CreateDate)
+
+	if err := ts.db.Insert(&row); err != nil {
+		t.Fatalf("Insert() failed: %v", err)
+	}
+
+	path := fmt.Sprintf("/api/databases/%d/expiry/extend/1/years", row.ID)
+
+	if code := ts.api(http.MethodPut, path, token, nil, nil); code != http.StatusOK {
+		t.Fatalf("extending: got %d, want %d", code, http.StatusOK)
+	}
+
+	extended, _ := ts.db.FetchByID(row.ID)
+	if !extended.ExpiryDate.After(row.ExpiryDate.AddDate(0, 11, 0)) || extended.Extensions != 1 {
+		t.Errorf("extended database expires %v after %d extensions", extended.ExpiryDate, extended.Extensions)
+	}
+
+	// Clients of the old spelling of the unit keep working.
+	config.ExpiryPolicies[0].MaxExtensions = 2
+	old := fmt.Sprintf("/api/databases/%d/expiry/extend/1/year", row.ID)
+
+	if code := ts.api(http.MethodPut, old, token, nil, nil); code != http.StatusOK {
+		t.Fatalf("extending by year: got %d, want %d", code, http.StatusOK)
+	}
+
+	again, _ := ts.db.FetchByID(row.ID)
+	if !again.ExpiryDate.After(extended.ExpiryDate.AddDate(0, 11, 0)) || again.Extensions != 2 {
+		t.Errorf("database extended by year expires %v after %d extensions", again.ExpiryDate, again.Extensions)
+	}
+
+	if code := ts.api(http.MethodPut, path, token, nil, nil); code != http.StatusConflict {
+		t.Errorf("extending beyond the maximum: got %d, want %d", code, http.StatusConflict)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/djavorszky/ddn-api/database/data"
+	"github.com/djavorszky/ddn-common/model"
+	"github.com/djavorszky/ddn-common/status"
+)
+
+func TestWebFlow(t *testing.T) {
+	ts := newTestServer(t)
+	agent := ts.startAgent("fake-mysql")
+
+	user := ts.login("user@example.com")
+
+	form := url.Values{
+		"agent":    {agent.name},
+		"dbname":   {"webdb"},
+		"user":     {"webuser"},
+		"password": {"webpass"},
+	}
+
+	if code, _ := user.post("/create", form); code != http.StatusForbidden {
+		t.Fatalf("create without csrf token: got %d, want %d", code, http.StatusForbidden)
+	}
+
+	form.Set(csrfField, user.csrfToken())
+	if code, _ := user.post("/create", form); code != http.StatusSeeOther {
+		t.Fatalf("create: got %d, want %d", code, http.StatusSeeOther)
+	}
+
+	row, err := ts.db.FetchByDBNameAgent("webdb", agent.name)
+	if err != nil || row.ID == 0 {
+		t.Fatalf("created database not persisted: %+v, %v", row, err)
+	}
+
+	if row.Creator != "user@example.com" {
+		t.Errorf("creator = %q, want %q", row.Creator, "user@example.com")
+	}
+
+	reqs := agent.received("create-database")
+	if len(reqs) != 1 || reqs[0].DatabaseName != "webdb" || reqs[0].Username != "webuser" {
+		t.Fatalf("agent received create requests %+v", reqs)
+	}
+
+	if _, page := user.get("/"); !strings.Contains(page, "webdb") {
+		t.Errorf("created database is not listed on the home page")
+	}
+
+	other := ts.login("other@example.com")
+	other.get(fmt.Sprintf("/drop/%d", row.ID))
+
+	if row, _ := ts.db.FetchByID(row.ID); row.Status == status.DropInProgress {
+		t.Fatalf("database of another user was dropped")
+	}
+
+	user.get(fmt.Sprintf("/drop/%d", row.ID))
+
+	waitFor(t, "database to be dropped", func() bool {
+		row, _ := ts.db.FetchByID(row.ID)
+		return row.ID == 0
+	})
+
+	reqs = agent.received("drop-database")
+	if len(reqs) != 1 || reqs[0].DatabaseName != "webdb" {
+		t.Errorf("agent received drop requests %+v", reqs)
+	}
+}
+
+func TestAPIFlow(t *testing.T) {
+	ts := newTestServer(t)
+	agent := ts.startAgent("fake-mysql")
+	intruder := ts.startAgent("fake-postgres")
+
+	token := ts.login("api@example.com").issueToken("ci")
+	adminToken := ts.login(testAdmin).issueToken("audit")
+
+	if code := ts.api(http.MethodGet, "/api/databases", "", nil, nil); code != http.StatusForbidden {
+		t.Fatalf("listing without a token: got %d, want %d", code, http.StatusForbidden)
+	}
+
+	req := model.ClientRequest{
+		AgentIdentifier: agent.name,
+		DBRequest: model.DBRequest{
+			DatabaseName: "apidb",
+			Username:     "apiuser",
+			Password:     "apipass",
+		},
+	}
+
+	var created data.Row
+	if code := ts.api(http.MethodPost, "/api/databases/create", token, req, &created); code != http.StatusOK {
+		t.Fatalf("create: got %d, want %d", code, http.StatusOK)
+	}
+
+	if created.ID == 0 || created.Creator != "api@example.com" || created.DBPass != "" {
+		t.Fatalf("create returned %+v", created)
+	}
+
+	if reqs := agent.received("create-database"); len(reqs) != 1 || reqs[0].Password != "apipass" {
+		t.Fatalf("agent received create requests %+v", reqs)
+	}
+
+	var listed []data.Row
+	ts.api(http.MethodGet, "/api/databases", token, nil, &listed)
+
+	if len(listed) != 1 || listed[0].ID != created.ID {
+		t.Fatalf("listed %+v, want only database %d", listed, created.ID)
+	}
+
+	if code := intruder.update(created.ID, status.DropInProgress, ""); code != http.StatusForbidden {
+		t.Errorf("update from another agent: got %d, want %d", code, http.StatusForbidden)
+	}
+
+	if code := agent.update(created.ID, status.Update, "almost there"); code != http.StatusOK {
+		t.Fatalf("update: got %d, want %d", code, http.StatusOK)
+	}
+
+	var fetched data.Row
+	ts.api(http.MethodGet, fmt.Sprintf("/api/databases/%d", created.ID), token, nil, &fetched)
+
+	if fetched.Status != status.Update {
+		t.Errorf("status after update = %d, want %d", fetched.Status, status.Update)
+	}
+
+	if code := ts.api(http.MethodDelete, fmt.Sprintf("/api/databases/%d", created.ID), token, nil, nil); code != http.StatusOK {
+		t.Fatalf("delete: got %d, want %d", code, http.StatusOK)
+	}
+
+	waitFor(t, "database to be dropped", func() bool {
+		return ts.api(http.MethodGet, fmt.Sprintf("/api/databases/%d", created.ID), token, nil, nil) == http.StatusNotFound
+	})
+
+	var audit struct {
+		Entries []data.AuditEntry `json:"entries"`
+	}
+	ts.api(http.MethodGet, fmt.Sprintf("/api/audit?target=%d", created.ID), adminToken, nil, &audit)
+
+	var actions []string
+	for _, entry := range audit.Entries {
+		actions = append(actions, entry.Action)
+	}
+
+	want := []string{auditDrop, auditStatus, auditCreate}
+	if strings.Join(actions, ",") != strings.Join(want, ",") {
+		t.Errorf("audit log of the database has %v, want %v", actions, want)
+	}
+}
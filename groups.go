@@ -0,0 +1,332 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/djavorszky/ddn-api/database/data"
+	"github.com/djavorszky/ddn-common/errs"
+	"github.com/djavorszky/ddn-common/inet"
+	"github.com/djavorszky/ddn-common/logger"
+	vis "github.com/djavorszky/ddn-common/visibility"
+	"github.com/gorilla/mux"
+)
+
+// groupName is what group names must look like, so they can be used in URLs.
+var groupName = regexp.MustCompile(`^[a-zA-Z0-9-_]+$`)
+
+type groupRequest struct {
+	Name    string   `json:"name"`
+	Members []string `json:"members"`
+}
+
+// apiListGroups lists the groups of the caller, or every group for admins.
+func apiListGroups(w http.ResponseWriter, r *http.Request) {
+	p, err := getAPIPrincipal(r)
+	if err != nil {
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return
+	}
+
+	groups, err := db.FetchGroups()
+	if err != nil {
+		inet.SendFailure(w, http.StatusInternalServerError, errs.QueryFailed, err.Error())
+
+		logger.Error("Fetching groups failed: %v", err)
+		return
+	}
+
+	result := make([]data.Group, 0, len(groups))
+	for _, group := range groups {
+		if p.can(actAdminister) || group.HasMember(p.Email) {
+			result = append(result, group)
+		}
+	}
+
+	inet.SendSuccess(w, http.StatusOK, result)
+}
+
+// apiCreateGroup creates a new group. The caller is always a member of
+// the groups they create.
+func apiCreateGroup(w http.ResponseWriter, r *http.Request) {
+	p, err := getAPIPrincipal(r)
+	if err != nil {
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return
+	}
+
+	if !p.can(actCreate) {
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return
+	}
+
+	var req groupRequest
+
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		inet.SendFailure(w, http.StatusBadRequest, errs.JSONDecodeFailed, err.Error())
+
+		logger.Error("couldn't decode json request: %v", err)
+		return
+	}
+
+	if req.Name == "" {
+		inet.SendFailure(w, http.StatusBadRequest, errs.MissingParameters, "name")
+		return
+	}
+
+	if !groupName.MatchString(req.Name) {
+		inet.SendFailure(w, http.StatusBadRequest, errs.UnknownParameter, req.Name)
+		return
+	}
+
+	existing, err := db.FetchGroup(req.Name)
+	if err != nil {
+		inet.SendFailure(w, http.StatusInternalServerError, errs.QueryFailed, err.Error())
+
+		logger.Error("Fetching group failed: %v", err)
+		return
+	}
+
+	if existing.Name != "" {
+		inet.SendFailure(w, http.StatusConflict, errGroupExists, req.Name)
+		return
+	}
+
+	group := data.Group{
+		Name:       req.Name,
+		CreateDate: time.Now(),
+		CreatedBy:  p.Email,
+		Members:    []string{p.Email},
+	}
+
+	for _, member := range req.Members {
+		member = strings.TrimSpace(member)
+		if member != "" && !group.HasMember(member) {
+			group.Members = append(group.Members, member)
+		}
+	}
+
+	err = db.InsertGroup(&group)
+	if err != nil {
+		inet.SendFailure(w, http.StatusInternalServerError, errs.PersistFailed, err.Error())
+
+		logger.Error("failed inserting group: %v", err)
+		return
+	}
+
+	audit(r, data.AuditEntry{Actor: p.Email, Action: auditGroupCreate, TargetName: group.Name})
+
+	logger.Info("%s created group %q", p.Email, group.Name)
+
+	inet.SendSuccess(w, http.StatusOK, group)
+}
+
+func apiAddGroupMember(w http.ResponseWriter, r *http.Request) {
+	changeGroupMember(w, r, "added", db.AddGroupMember)
+}
+
+func apiRemoveGroupMember(w http.ResponseWriter, r *http.Request) {
+	changeGroupMember(w, r, "removed", db.RemoveGroupMember)
+}
+
+// changeGroupMember applies change to the group and member of the URL.
+// Only members of the group and admins can change its members.
+func changeGroupMember(w http.ResponseWriter, r *http.Request, verb string, change func(name, member string) error) {
+	p, err := getAPIPrincipal(r)
+	if err != nil {
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	group, errr := getGroupFrom(vars)
+	if errr.httpStatus != 0 {
+		inet.SendFailure(w, errr.httpStatus, errr.errors...)
+		return
+	}
+
+	if !p.can(actAdminister) && !(p.can(actCreate) && group.HasMember(p.Email)) {
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return
+	}
+
+	member := strings.TrimSpace(vars["email"])
+	if member == "" {
+		inet.SendFailure(w, http.StatusBadRequest, errs.MissingParameters, "email")
+		return
+	}
+
+	err = change(group.Name, member)
+	if err != nil {
+		inet.SendFailure(w, http.StatusInternalServerError, errs.UpdateFailed, err.Error())
+
+		logger.Error("failed changing members of group %q: %v", group.Name, err)
+		return
+	}
+
+	audit(r, data.AuditEntry{Actor: p.Email, Action: auditGroupMember, TargetName: group.Name, Details: verb + " " + member})
+
+	group, err = db.FetchGroup(group.Name)
+	if err != nil {
+		inet.SendFailure(w, http.StatusInternalServerError, errs.QueryFailed, err.Error())
+
+		logger.Error("Fetching group failed: %v", err)
+		return
+	}
+
+	inet.SendSuccess(w, http.StatusOK, group)
+}
+
+// apiTransferToUser makes the user the owner of the database. The group
+// of the database, if any, loses its access.
+func apiTransferToUser(w http.ResponseWriter, r *http.Request) {
+	p, err := getAPIPrincipal(r)
+	if err != nil {
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return
+	}
+
+	vars := mux.Vars(r)
+	meta, errr := getDatabaseByIDFrom(vars)
+	if errr.httpStatus != 0 {
+		inet.SendFailure(w, errr.httpStatus, errr.errors...)
+		return
+	}
+
+	if !p.canManage(meta) {
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return
+	}
+
+	owner, err := newPrincipal(strings.TrimSpace(vars["email"]))
+	if err != nil {
+		inet.SendFailure(w, http.StatusInternalServerError, errs.QueryFailed, err.Error())
+
+		logger.Error("couldn't resolve new owner: %v", err)
+		return
+	}
+
+	if owner.Email == "" || !owner.can(actCreate) {
+		inet.SendFailure(w, http.StatusBadRequest, errInvalidOwner, owner.Email)
+		return
+	}
+
+	if owner.Email != meta.Creator {
+		err = checkQuota(quotaRequest{User: owner.Email, DumpSize: meta.DumpSize})
+		if err != nil {
+			sendQuotaFailure(w, err)
+			return
+		}
+	}
+
+	before := meta.Status
+
+	meta.Creator = owner.Email
+	meta.Group = ""
+	if meta.Public == data.GroupVisibility {
+		meta.Public = vis.Private
+	}
+
+	err = db.Update(&meta)
+	if err != nil {
+		inet.SendFailure(w, http.StatusInternalServerError, errs.UpdateFailed, err.Error())
+
+		logger.Error("failed transferring database: %v", err)
+		return
+	}
+
+	auditDatabase(r, p.Email, auditTransfer, meta, before, "to user "+owner.Email)
+
+	logger.Info("%s transferred database %d to %s", p.Email, meta.ID, owner.Email)
+
+	inet.SendSuccess(w, http.StatusOK, redactDatabase(meta))
+}
+
+// apiTransferToGroup hands the database over to the group, whose members
+// can then manage it just like its creator. Callers can only transfer to
+// groups they are a member of.
+func apiTransferToGroup(w http.ResponseWriter, r *http.Request) {
+	p, err := getAPIPrincipal(r)
+	if err != nil {
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return
+	}
+
+	vars := mux.Vars(r)
+	meta, errr := getDatabaseByIDFrom(vars)
+	if errr.httpStatus != 0 {
+		inet.SendFailure(w, errr.httpStatus, errr.errors...)
+		return
+	}
+
+	if !p.canManage(meta) {
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return
+	}
+
+	group, errr := getGroupFrom(vars)
+	if errr.httpStatus != 0 {
+		inet.SendFailure(w, errr.httpStatus, errr.errors...)
+		return
+	}
+
+	if !p.can(actAdminister) && !group.HasMember(p.Email) {
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return
+	}
+
+	if group.Name != meta.Group {
+		err = checkQuota(quotaRequest{Group: group.Name, DumpSize: meta.DumpSize})
+		if err != nil {
+			sendQuotaFailure(w, err)
+			return
+		}
+	}
+
+	before := meta.Status
+
+	meta.Group = group.Name
+	if meta.Public == vis.Private {
+		meta.Public = data.GroupVisibility
+	}
+
+	err = db.Update(&meta)
+	if err != nil {
+		inet.SendFailure(w, http.StatusInternalServerError, errs.UpdateFailed, err.Error())
+
+		logger.Error("failed transferring database: %v", err)
+		return
+	}
+
+	auditDatabase(r, p.Email, auditTransfer, meta, before, "to group "+group.Name)
+
+	logger.Info("%s transferred database %d to group %q", p.Email, meta.ID, group.Name)
+
+	inet.SendSuccess(w, http.StatusOK, redactDatabase(meta))
+}
+
+func getGroupFrom(vars map[string]string) (data.Group, errResult) {
+	group, err := db.FetchGroup(vars["group"])
+	if err != nil {
+		logger.Error("Fetching group failed: %v", err)
+
+		return data.Group{}, errResult{
+			httpStatus: http.StatusInternalServerError,
+			errors:     []string{errs.QueryFailed, err.Error()},
+		}
+	}
+
+	if group.Name == "" {
+		return data.Group{}, errResult{
+			httpStatus: http.StatusNotFound,
+			errors:     []string{errs.QueryNoResults},
+		}
+	}
+
+	return group, errResult{}
+}
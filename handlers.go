@@ -14,6 +14,7 @@ import (
 	"github.com/djavorszky/ddn-api/database/data"
 	"github.com/djavorszky/ddn-api/mail"
 	"github.com/djavorszky/ddn-api/registry"
+	"github.com/djavorszky/ddn-common/errs"
 	"github.com/djavorszky/ddn-common/inet"
 	"github.com/djavorszky/ddn-common/logger"
 	"github.com/djavorszky/ddn-common/model"
@@ -23,11 +24,8 @@ import (
 	"github.com/djavorszky/notif"
 	"github.com/djavorszky/sutils"
 	"github.com/gorilla/mux"
-	"github.com/gorilla/sessions"
 )
 
-var store = sessions.NewCookieStore([]byte("veryverysecretkey"))
-
 func index(w http.ResponseWriter, r *http.Request) {
 	loadPage(w, r, "home")
 }
@@ -69,6 +67,12 @@ func prepImportAction(w http.ResponseWriter, r *http.Request) {
 	}
 	defer session.Save(r, w)
 
+	p, ok := getPrincipal(r)
+	if !ok || !p.can(actCreate) {
+		session.AddFlash("You are not allowed to import databases.", "fail")
+		return
+	}
+
 	var (
 		agent    = r.PostFormValue("agent")
 		dbname   = r.PostFormValue("dbname")
@@ -78,78 +82,54 @@ func prepImportAction(w http.ResponseWriter, r *http.Request) {
 		public   = r.PostFormValue("public")
 	)
 
-	dbID, err := doPrepImport(getUser(r), agent, dumpfile, dbname, dbuser, dbpass, public)
+	entry, err := doPrepImport(p.Email, agent, dumpfile, dbname, dbuser, dbpass, public)
 	if err != nil {
 		session.AddFlash(fmt.Sprintf("Failed preparing import: %v", err), "fail")
 		return
 	}
 
-	go doImport(int(dbID), dumpfile)
-
-	session.AddFlash("Started the import process...", "msg")
-}
+	auditDatabase(r, p.Email, auditImport, entry, 0, dumpfile)
 
-func doImport(dbID int, dumpfile string) {
-	dbe, err := db.FetchByID(dbID)
+	_, err = enqueueJob(data.JobImport, entry, p.Email, filepath.Join("/", dumpfile))
 	if err != nil {
-		logger.Error("Failed getting entry by ID: %v", err)
-		dbe.Status = status.ImportFailed
-		dbe.Message = "Server error: " + err.Error()
-		dbe.ExpiryDate = time.Now().AddDate(0, 0, 2)
-
-		db.Update(&dbe)
-
+		session.AddFlash(fmt.Sprintf("Failed starting the import: %v", err), "fail")
 		return
 	}
 
-	dbe.Status = status.CopyInProgress
-	db.Update(&dbe)
-
-	url, err := copyFile(dumpfile)
-	if err != nil {
-		logger.Error("file copy: %v", err)
-		dbe.Status = status.ImportFailed
-		dbe.Message = "Server error: " + err.Error()
-		dbe.ExpiryDate = time.Now().AddDate(0, 0, 2)
-
-		db.Update(&dbe)
-		return
-	}
-
-	dbe.Dumpfile = url
-	db.Update(&dbe)
+	session.AddFlash("Started the import process...", "msg")
+}
 
-	agent, ok := registry.Get(dbe.AgentName)
+func doPrepImport(creator, agentName, dumpfile, dbname, dbuser, dbpass, public string) (data.Row, error) {
+	agent, ok := registry.Get(agentName)
 	if !ok {
-		dbe.Status = status.ImportFailed
-		dbe.Message = "Server error: agent went offline."
-		dbe.ExpiryDate = time.Now().AddDate(0, 0, 2)
+		return data.Row{}, fmt.Errorf("agent went offline")
+	}
 
-		db.Update(&dbe)
-		return
+	if !agent.Accepting() {
+		return data.Row{}, fmt.Errorf("agent is in %s mode", agent.Mode)
 	}
 
-	_, err = agent.ImportDatabase(int(dbID), dbe.DBName, dbe.DBUser, dbe.DBPass, url)
-	if err != nil {
-		dbe.Status = status.ImportFailed
-		dbe.Message = "Server error: " + err.Error()
-		dbe.ExpiryDate = time.Now().AddDate(0, 0, 2)
+	if dbuser == "root" {
+		return data.Row{}, fmt.Errorf("user with name 'root' is not allowed")
+	}
 
-		db.Update(&dbe)
-		os.Remove(fmt.Sprintf("%s/web/dumps/%s", workdir, dumpfile))
-		return
+	if strings.HasPrefix(dumpfile, "/") {
+		_, err := mountedPath(dumpfile)
+		if err != nil {
+			return data.Row{}, err
+		}
 	}
 
-}
+	size := dumpSize(dumpfile)
 
-func doPrepImport(creator, agentName, dumpfile, dbname, dbuser, dbpass, public string) (int, error) {
-	agent, ok := registry.Get(agentName)
-	if !ok {
-		return 0, fmt.Errorf("agent went offline")
+	err := checkCapacity(agent, size)
+	if err != nil {
+		return data.Row{}, err
 	}
 
-	if dbuser == "root" {
-		return 0, fmt.Errorf("user with name 'root' is not allowed")
+	err = checkQuota(quotaRequest{User: creator, Agent: agentName, DumpSize: size, Import: true})
+	if err != nil {
+		return data.Row{}, err
 	}
 
 	ensureValues(&dbname, &dbuser, &dbpass, agent.DBVendor)
@@ -160,30 +140,37 @@ func doPrepImport(creator, agentName, dumpfile, dbname, dbuser, dbpass, public s
 		DBPass:     dbpass,
 		DBSID:      agent.DBSID,
 		CreateDate: time.Now(),
-		ExpiryDate: time.Now().AddDate(0, 1, 0),
 		AgentName:  agentName,
 		Creator:    creator,
+		DumpSize:   size,
 		DBAddress:  agent.DBAddr,
 		DBVendor:   agent.DBVendor,
 		Status:     status.Started,
 	}
 
+	entry.ExpiryDate = expiryPolicyOf(entry).expiry(entry.CreateDate)
+
 	if public == "on" {
 		entry.Public = vis.Public
 	}
 
-	err := db.Insert(&entry)
+	err = db.Insert(&entry)
 	if err != nil {
-		return 0, fmt.Errorf("database persist: %v", err)
+		return data.Row{}, fmt.Errorf("database persist: %v", err)
 	}
 
-	return entry.ID, nil
+	return entry, nil
 }
 
 func copyFile(dump string) (string, error) {
 	filename := filepath.Base(dump)
 
-	src, err := os.OpenFile(filepath.Join(config.MountLoc, dump), os.O_RDONLY, 0644)
+	path, err := mountedPath(dump)
+	if err != nil {
+		return "", err
+	}
+
+	src, err := os.OpenFile(path, os.O_RDONLY, 0644)
 	if err != nil {
 		return "", fmt.Errorf("failed opening source file: %v", err)
 
@@ -212,7 +199,7 @@ func importAction(w http.ResponseWriter, r *http.Request) {
 	defer http.Redirect(w, r, "/", http.StatusSeeOther)
 	defer r.Body.Close()
 
-	r.ParseMultipartForm(32 << 24)
+	r.ParseMultipartForm(maxUploadMemory)
 
 	var (
 		agentName = r.PostFormValue("agent")
@@ -228,6 +215,15 @@ func importAction(w http.ResponseWriter, r *http.Request) {
 	}
 	defer session.Save(r, w)
 
+	p, ok := getPrincipal(r)
+	if !ok || !p.can(actCreate) {
+		session.AddFlash("You are not allowed to import databases.", "fail")
+		if r.MultipartForm != nil {
+			r.MultipartForm.RemoveAll()
+		}
+		return
+	}
+
 	if dbuser == "root" {
 		session.AddFlash("Database user 'root' not allowed", "fail")
 		err = r.MultipartForm.RemoveAll()
@@ -237,9 +233,13 @@ func importAction(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var filename string
+	var (
+		filename string
+		size     int64
+	)
 	for _, uploadFile := range r.MultipartForm.File {
 		filename = uploadFile[0].Filename
+		size = uploadFile[0].Size
 
 		dst, err := os.OpenFile(fmt.Sprintf("%s/web/dumps/%s", workdir, filename), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
 		if err != nil {
@@ -275,6 +275,26 @@ func importAction(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !agent.Accepting() {
+		session.AddFlash(fmt.Sprintf("Failed importing database, agent %s is in %s mode", agentName, agent.Mode), "fail")
+		os.Remove(fmt.Sprintf("%s/web/dumps/%s", workdir, filename))
+		return
+	}
+
+	err = checkCapacity(agent, size)
+	if err != nil {
+		session.AddFlash(fmt.Sprintf("Failed importing database: %v", err), "fail")
+		os.Remove(fmt.Sprintf("%s/web/dumps/%s", workdir, filename))
+		return
+	}
+
+	err = checkQuota(quotaRequest{User: p.Email, Agent: agentName, DumpSize: size, Import: true})
+	if err != nil {
+		session.AddFlash(fmt.Sprintf("Failed importing database: %v", err), "fail")
+		os.Remove(fmt.Sprintf("%s/web/dumps/%s", workdir, filename))
+		return
+	}
+
 	ensureValues(&dbname, &dbuser, &dbpass, agent.DBVendor)
 
 	url := fmt.Sprintf("http://%s/dumps/%s", config.ServerHost, filename)
@@ -284,15 +304,17 @@ func importAction(w http.ResponseWriter, r *http.Request) {
 		DBPass:     dbpass,
 		DBSID:      agent.DBSID,
 		CreateDate: time.Now(),
-		ExpiryDate: time.Now().AddDate(0, 1, 0),
 		AgentName:  agentName,
-		Creator:    getUser(r),
+		Creator:    p.Email,
 		Dumpfile:   url,
+		DumpSize:   size,
 		DBAddress:  agent.DBAddr,
 		DBVendor:   agent.DBVendor,
 		Status:     status.Started,
 	}
 
+	entry.ExpiryDate = expiryPolicyOf(entry).expiry(entry.CreateDate)
+
 	if public == "on" {
 		entry.Public = vis.Public
 	}
@@ -314,6 +336,8 @@ func importAction(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	auditDatabase(r, p.Email, auditImport, entry, 0, filename)
+
 	session.AddFlash(resp, "msg")
 }
 
@@ -336,18 +360,35 @@ func createAction(w http.ResponseWriter, r *http.Request) {
 	}
 	defer session.Save(r, w)
 
+	p, ok := getPrincipal(r)
+	if !ok || !p.can(actCreate) {
+		session.AddFlash("You are not allowed to create databases.", "fail")
+		return
+	}
+
 	agent, ok := registry.Get(agentName)
 	if !ok {
 		session.AddFlash(fmt.Sprintf("Failed creating database, agent %s went offline", agentName), "fail")
 		return
 	}
 
+	if !agent.Accepting() {
+		session.AddFlash(fmt.Sprintf("Failed creating database, agent %s is in %s mode", agentName, agent.Mode), "fail")
+		return
+	}
+
 	if dbuser == "root" {
 		session.AddFlash("Database user 'root' not allowed", "fail")
 
 		return
 	}
 
+	err = checkQuota(quotaRequest{User: p.Email, Agent: agentName})
+	if err != nil {
+		session.AddFlash(fmt.Sprintf("Failed creating database: %v", err), "fail")
+		return
+	}
+
 	ensureValues(&dbname, &dbuser, &dbpass, agent.DBVendor)
 
 	entry := data.Row{
@@ -356,14 +397,15 @@ func createAction(w http.ResponseWriter, r *http.Request) {
 		DBPass:     dbpass,
 		DBSID:      agent.DBSID,
 		CreateDate: time.Now(),
-		ExpiryDate: time.Now().AddDate(0, 1, 0),
 		AgentName:  agentName,
-		Creator:    getUser(r),
+		Creator:    p.Email,
 		DBAddress:  agent.DBAddr,
 		DBVendor:   agent.DBVendor,
 		Status:     status.Success,
 	}
 
+	entry.ExpiryDate = expiryPolicyOf(entry).expiry(entry.CreateDate)
+
 	if public == "on" {
 		entry.Public = vis.Public
 	}
@@ -384,13 +426,23 @@ func createAction(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	auditDatabase(r, p.Email, auditCreate, entry, 0, "")
+
 	session.Values["id"] = entry.ID
 	session.AddFlash(resp, "success")
 }
 
 func register(w http.ResponseWriter, r *http.Request) {
-	var req model.RegisterRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
+	err := checkEnrollment(r)
+	if err != nil {
+		logger.Warn("Rejected registration from %s: %v", r.RemoteAddr, err)
+
+		inet.SendFailure(w, http.StatusUnauthorized, errs.AccessDenied)
+		return
+	}
+
+	var req registerRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
 		logger.Error("json decode: %v", err)
 
@@ -398,6 +450,19 @@ func register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if label := invalidLabel(req.Labels); label != "" {
+		inet.SendFailure(w, http.StatusBadRequest, errInvalidLabel, label)
+		return
+	}
+
+	token, err := randomString(32)
+	if err != nil {
+		logger.Error("failed generating agent token: %v", err)
+
+		inet.SendFailure(w, http.StatusInternalServerError, errs.PersistFailed)
+		return
+	}
+
 	ddnc := model.Agent{
 		ID:         registry.ID(),
 		DBVendor:   req.DBVendor,
@@ -408,30 +473,62 @@ func register(w http.ResponseWriter, r *http.Request) {
 		Identifier: req.AgentName,
 		Version:    req.Version,
 		Address:    req.Addr,
+		Token:      hashToken(token),
 		Up:         true,
 	}
 
-	registry.Store(ddnc)
+	// The mode and the labels set by the admins are kept across the
+	// restarts of the agent.
+	_, known := registry.Update(ddnc.ShortName, func(a *registry.Agent) {
+		a.Agent = ddnc
+		a.Status = registry.StatusUp
+		a.AgentLabels = req.Labels
+		a.Capacity = nil
+	})
+	if !known {
+		registry.Store(registry.Agent{Agent: ddnc, Status: registry.StatusUp, AgentLabels: req.Labels})
+	}
+
+	audit(r, data.AuditEntry{Actor: agentActor(ddnc.ShortName), Action: auditAgentRegister, Agent: ddnc.ShortName, Details: ddnc.Address})
 
 	logger.Info("Registered: %v", req.ShortName)
 
-	resp, _ := inet.JSONify(model.RegisterResponse{ID: ddnc.ID, Address: ddnc.Address})
+	go dropExpiredOf(ddnc.ShortName)
+
+	resp, _ := inet.JSONify(model.RegisterResponse{ID: ddnc.ID, Address: ddnc.Address, Token: token})
 
 	inet.WriteHeader(w, http.StatusOK)
 	w.Write(resp)
 }
 
 func unregister(w http.ResponseWriter, r *http.Request) {
+	caller, err := authenticateAgent(r)
+	if err != nil {
+		logger.Warn("Rejected unregister from %s: %v", r.RemoteAddr, err)
+
+		inet.SendFailure(w, http.StatusUnauthorized, errs.AccessDenied)
+		return
+	}
+
 	var agent model.Agent
 
-	err := json.NewDecoder(r.Body).Decode(&agent)
+	err = json.NewDecoder(r.Body).Decode(&agent)
 	if err != nil {
 		logger.Error("json encode: %v", err)
 		return
 	}
 
+	if agent.ShortName != caller.ShortName {
+		logger.Warn("Agent %s tried to unregister %s", caller.ShortName, agent.ShortName)
+
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return
+	}
+
 	registry.Remove(agent.ShortName)
 
+	audit(r, data.AuditEntry{Actor: agentActor(caller.ShortName), Action: auditAgentUnregister, Agent: agent.ShortName})
+
 	logger.Info("Unregistered: %s", agent.ShortName)
 }
 
@@ -453,31 +550,22 @@ func login(w http.ResponseWriter, r *http.Request) {
 
 	r.ParseForm()
 
-	email := r.PostFormValue("email")
-
-	cookie := http.Cookie{
-		Name:    "user",
-		Value:   email,
-		Expires: time.Now().AddDate(1, 0, 0),
-	}
-
-	http.SetCookie(w, &cookie)
+	completeLogin(w, r)
 }
 
 func logout(w http.ResponseWriter, r *http.Request) {
 	defer http.Redirect(w, r, "/", http.StatusSeeOther)
 
-	userCookie, err := r.Cookie("user")
-	if err != nil {
-		return
-	}
-
-	userCookie.Value = ""
-
-	http.SetCookie(w, userCookie)
+	endSession(w, r)
 }
 
 func extend(w http.ResponseWriter, r *http.Request) {
+	p, ok := getPrincipal(r)
+	if !ok {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
 	vars := mux.Vars(r)
 
 	ID, err := strconv.Atoi(vars["id"])
@@ -492,19 +580,37 @@ func extend(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	dbe.ExpiryDate = time.Now().AddDate(0, 0, 30)
-	dbe.Status = status.Success
-
-	err = db.Update(&dbe)
-	if err != nil {
-		http.Error(w, "Failed updating entry: "+err.Error(), http.StatusInternalServerError)
+	if !p.canManage(dbe) {
+		logger.Error("User %q tried to extend database of user %q.", p.Email, dbe.Creator)
+		http.Error(w, "You can only extend databases you manage.", http.StatusForbidden)
 		return
 	}
 
+	before := dbe.Status
+
 	session, err := store.Get(r, "user-session")
 	if err != nil {
 		http.Error(w, "Failed getting session: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = extendExpiry(&dbe, expiryPolicyOf(dbe).expiry(time.Now()))
+	if err != nil {
+		session.AddFlash(fmt.Sprintf("Failed extending database: %v", err), "fail")
+		session.Save(r, w)
+
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	err = db.Update(&dbe)
+	if err != nil {
+		http.Error(w, "Failed updating entry: "+err.Error(), http.StatusInternalServerError)
+		return
 	}
+
+	auditDatabase(r, p.Email, auditExtend, dbe, before, "expires "+dbe.ExpiryDate.Format("2006-01-02"))
+
 	session.AddFlash("Successfully extended the expiry date", "msg")
 	session.Save(r, w)
 
@@ -520,9 +626,8 @@ func drop(w http.ResponseWriter, r *http.Request) {
 	}
 	defer session.Save(r, w)
 
-	user := getUser(r)
-
-	if user == "" {
+	p, ok := getPrincipal(r)
+	if !ok {
 		logger.Error("Drop database tried without a logged in user.")
 		return
 	}
@@ -542,47 +647,33 @@ func drop(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if dbe.Creator != user {
-		logger.Error("User %q tried to drop database of user %q.", user, dbe.Creator)
-		session.AddFlash("Failed dropping database: You can only drop databases you created.", "fail")
+	if !p.canManage(dbe) {
+		logger.Error("User %q tried to drop database of user %q.", p.Email, dbe.Creator)
+		session.AddFlash("Failed dropping database: You can only drop databases you manage.", "fail")
 		return
 	}
 
-	agent, ok := registry.Get(dbe.AgentName)
+	_, ok = registry.Get(dbe.AgentName)
 	if !ok {
 		logger.Error("Agent %q is offline, can't drop database with id '%d'", dbe.AgentName, ID)
 		session.AddFlash("Unable to drop database: Agent is down.", "fail")
 		return
 	}
 
+	before := dbe.Status
 	dbe.Status = status.DropInProgress
 
 	db.Update(&dbe)
 
-	go dropAsync(agent, ID, dbe.DBName, dbe.DBUser)
+	auditDatabase(r, p.Email, auditDrop, dbe, before, "")
 
-	session.AddFlash("Started to drop the database.", "msg")
-}
-
-func dropAsync(agent model.Agent, ID int, dbname, dbuser string) {
-	dbe, err := db.FetchByID(ID)
+	_, err = enqueueJob(data.JobDrop, dbe, p.Email, "")
 	if err != nil {
-		logger.Error("couldn't fetch DB: %v", err)
+		session.AddFlash(fmt.Sprintf("Failed dropping database: %v", err), "fail")
 		return
 	}
 
-	_, err = agent.DropDatabase(ID, dbname, dbuser)
-	if err != nil {
-		dbe.Status = status.DropDatabaseFailed
-		dbe.Message = err.Error()
-
-		db.Update(&dbe)
-
-		logger.Error("couldn't drop database %q on agent %q: %s", dbname, agent.ShortName, err)
-		return
-	}
-
-	db.Delete(dbe)
+	session.AddFlash("Started to drop the database.", "msg")
 }
 
 func exportAction(w http.ResponseWriter, r *http.Request) {
@@ -594,8 +685,8 @@ func exportAction(w http.ResponseWriter, r *http.Request) {
 	}
 	defer session.Save(r, w)
 
-	user := getUser(r)
-	if user == "" {
+	p, ok := getPrincipal(r)
+	if !ok {
 		logger.Error("Export database tried without a logged in user.")
 		return
 	}
@@ -615,30 +706,33 @@ func exportAction(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if dbe.Creator != user {
-		logger.Error("User %q tried to export database of user %q.", user, dbe.Creator)
-		session.AddFlash("Failed exporting database: You can only export databases you created.", "fail")
+	if !p.canManage(dbe) {
+		logger.Error("User %q tried to export database of user %q.", p.Email, dbe.Creator)
+		session.AddFlash("Failed exporting database: You can only export databases you manage.", "fail")
 		return
 	}
 
-	agent, ok := registry.Get(dbe.AgentName)
+	_, ok = registry.Get(dbe.AgentName)
 	if !ok {
 		logger.Error("Agent %q is offline, can't export database with id '%d'", dbe.AgentName, ID)
 		session.AddFlash("Unable to export database: Agent is down.", "fail")
 		return
 	}
 
+	before := dbe.Status
 	dbe.Status = status.ExportInProgress
 
 	db.Update(&dbe)
 
-	resp, err := agent.ExportDatabase(ID, dbe.DBName, dbe.DBUser, dbe.DBPass)
+	auditDatabase(r, p.Email, auditExport, dbe, before, "")
+
+	_, err = enqueueJob(data.JobExport, dbe, p.Email, "")
 	if err != nil {
-		session.AddFlash(err.Error(), "fail")
+		session.AddFlash(fmt.Sprintf("Failed exporting database: %v", err), "fail")
 		return
 	}
 
-	session.AddFlash(resp, "msg")
+	session.AddFlash("Started to export the database.", "msg")
 }
 
 func portalext(w http.ResponseWriter, r *http.Request) {
@@ -650,9 +744,8 @@ func portalext(w http.ResponseWriter, r *http.Request) {
 	}
 	defer session.Save(r, w)
 
-	user := getUser(r)
-
-	if user == "" {
+	p, ok := getPrincipal(r)
+	if !ok {
 		logger.Error("Portal-ext request without logged in user.")
 		return
 	}
@@ -672,8 +765,8 @@ func portalext(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if dbe.Public == vis.Private && dbe.Creator != user {
-		logger.Error("User %q tried to get portalext of db created by %q.", user, dbe.Creator)
+	if !p.canRead(dbe) {
+		logger.Error("User %q tried to get portalext of db created by %q.", p.Email, dbe.Creator)
 		session.AddFlash("Failed fetching portal-ext: You can only fetch the portal-ext of public databases or ones that you created.", "fail")
 		return
 	}
@@ -691,9 +784,8 @@ func recreate(w http.ResponseWriter, r *http.Request) {
 	}
 	defer session.Save(r, w)
 
-	user := getUser(r)
-
-	if user == "" {
+	p, ok := getPrincipal(r)
+	if !ok {
 		logger.Error("Portal-ext request without logged in user.")
 		return
 	}
@@ -713,60 +805,48 @@ func recreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if dbe.Creator != user {
-		logger.Error("User %q tried to get recreate the database created by %q.", user, dbe.Creator)
-		session.AddFlash("Failed recreating database: You can only recreate database you created.", "fail")
+	if !p.canManage(dbe) {
+		logger.Error("User %q tried to get recreate the database created by %q.", p.Email, dbe.Creator)
+		session.AddFlash("Failed recreating database: You can only recreate databases you manage.", "fail")
 		return
 	}
 
-	agent, ok := registry.Get(dbe.AgentName)
+	_, ok = registry.Get(dbe.AgentName)
 	if !ok {
 		logger.Error("Agent %q is offline, can't recreate database with id '%d'", dbe.AgentName, ID)
 		session.AddFlash("Unable to recreate database: Agent is down.", "fail")
 		return
 	}
 
-	go recreateAsync(agent, dbe)
-
-	session.AddFlash("Started to recreate", "msg")
-}
-
-func recreateAsync(agent model.Agent, dbe data.Row) {
-	_, err := agent.DropDatabase(dbe.ID, dbe.DBName, dbe.DBUser)
-	if err != nil {
-		dbe.Status = status.DropDatabaseFailed
-		dbe.Message = err.Error()
-
-		db.Update(&dbe)
+	before := dbe.Status
+	dbe.Status = status.InProgress
 
-		logger.Error("Recreate: couldn't drop database %q on agent %q: %s", dbe.DBName, agent.ShortName, err)
+	db.Update(&dbe)
 
-		return
-	}
+	auditDatabase(r, p.Email, auditRecreate, dbe, before, "")
 
-	_, err = agent.CreateDatabase(dbe.ID, dbe.DBName, dbe.DBUser, dbe.DBPass)
+	_, err = enqueueJob(data.JobRecreate, dbe, p.Email, "")
 	if err != nil {
-		dbe.Status = status.CreateDatabaseFailed
-		dbe.Message = err.Error()
-
-		db.Update(&dbe)
-
-		logger.Error("Recreate: couldn't create database %q on agent %q: %s", dbe.DBName, agent.ShortName, err)
-
+		session.AddFlash(fmt.Sprintf("Failed recreating database: %v", err), "fail")
 		return
 	}
 
-	dbe.Status = status.Success
-	db.Update(&dbe)
-
-	return
+	session.AddFlash("Started to recreate", "msg")
 }
 
 // upd8 updates the status of the databases.
 func upd8(w http.ResponseWriter, r *http.Request) {
+	agent, err := authenticateAgent(r)
+	if err != nil {
+		logger.Warn("Rejected status update from %s: %v", r.RemoteAddr, err)
+
+		inet.SendFailure(w, http.StatusUnauthorized, errs.AccessDenied)
+		return
+	}
+
 	var msg notif.Msg
 
-	err := json.NewDecoder(r.Body).Decode(&msg)
+	err = json.NewDecoder(r.Body).Decode(&msg)
 	if err != nil {
 		logger.Error("json decode: %v", err)
 
@@ -780,10 +860,20 @@ func upd8(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if dbe.AgentName != agent.ShortName {
+		logger.Warn("Agent %s tried to update database %d of agent %q", agent.ShortName, msg.ID, dbe.AgentName)
+
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return
+	}
+
+	before := dbe.Status
 	dbe.Status = msg.StatusID
 
 	db.Update(&dbe)
 
+	auditDatabase(r, agentActor(agent.ShortName), auditStatus, dbe, before, msg.Message)
+
 	// Delete the dumpfile once import is started or if an error has occurred.
 	if dbe.Status == status.ImportInProgress || dbe.IsErr() {
 		loc := strings.LastIndex(dbe.Dumpfile, "/")
@@ -808,7 +898,7 @@ func upd8(w http.ResponseWriter, r *http.Request) {
 
 		// Update dbentry as well
 		dbe.Message = msg.Message
-		dbe.ExpiryDate = time.Now().AddDate(0, 0, 2)
+		dbe.ExpiryDate = expiryPolicyOf(dbe).failureExpiry(time.Now())
 
 		err = db.Update(&dbe)
 		if err != nil {
@@ -905,8 +995,13 @@ func ensureValues(dbname, dbuser, dbpass *string, vendor string) {
 
 }
 
+// getUser returns the user of the web session, or an empty string
+// if the request doesn't belong to a logged in session.
 func getUser(r *http.Request) string {
-	usr, _ := r.Cookie("user")
+	session, ok := currentSession(r)
+	if !ok {
+		return ""
+	}
 
-	return usr.Value
+	return session.User
 }
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/djavorszky/ddn-api/auth"
+	"github.com/djavorszky/ddn-api/database/memory"
+	"github.com/djavorszky/ddn-api/registry"
+	"github.com/djavorszky/ddn-common/inet"
+	"github.com/djavorszky/ddn-common/model"
+	"github.com/djavorszky/ddn-common/status"
+	"github.com/djavorszky/notif"
+)
+
+const (
+	testAdmin       = "admin@example.com"
+	testAgentSecret = "enroll-me"
+)
+
+// testServer runs the router against an in-memory backend, so the web and
+// API flows can be exercised without any external services.
+type testServer struct {
+	*httptest.Server
+
+	t  *testing.T
+	db *memory.DB
+}
+
+// newTestServer replaces the globals of the server with test values for
+// the duration of the test. Tests using it can't run in parallel.
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	mem := &memory.DB{}
+	if err := mem.ConnectAndPrepare(); err != nil {
+		t.Fatalf("preparing memory backend: %v", err)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("getting working directory: %v", err)
+	}
+
+	origDB, origConfig, origWorkdir, origAuth := db, config, workdir, authenticator
+	origStore, origCodec := store, sessionCodec
+
+	db = mem
+	config = Config{AdminEmail: []string{testAdmin}, AgentSecret: testAgentSecret}
+	workdir = wd
+	authenticator = auth.Email{}
+
+	if err := initSessions("test-secret"); err != nil {
+		t.Fatalf("initializing sessions: %v", err)
+	}
+
+	if err := registry.Load(mem); err != nil {
+		t.Fatalf("loading registry: %v", err)
+	}
+
+	ts := &testServer{Server: httptest.NewServer(Router()), t: t, db: mem}
+	stopJobs := startJobWorkers(2)
+
+	t.Cleanup(func() {
+		ts.Close()
+		stopJobs()
+
+		lastInventory = inventoryReport{}
+
+		for _, agent := range registry.List() {
+			registry.Remove(agent.ShortName)
+		}
+
+		db, config, workdir, authenticator = origDB, origConfig, origWorkdir, origAuth
+		store, sessionCodec = origStore, origCodec
+	})
+
+	return ts
+}
+
+// fakeAgent stands in for a ddn agent. It accepts every request and
+// records what it was asked to do.
+type fakeAgent struct {
+	*httptest.Server
+
+	ts    *testServer
+	name  string
+	token string
+
+	mu        sync.Mutex
+	requests  map[string][]model.DBRequest
+	databases []string
+	failing   bool
+	capacity  *registry.Capacity
+}
+
+// startAgent starts a fake agent and registers it with the server.
+func (ts *testServer) startAgent(name string) *fakeAgent {
+	ts.t.Helper()
+
+	return ts.startLabeledAgent(name, nil)
+}
+
+// startLabeledAgent starts a fake agent and registers it with the server
+// along with the labels.
+func (ts *testServer) startLabeledAgent(name string, labels map[string]string) *fakeAgent {
+	ts.t.Helper()
+
+	agent := &fakeAgent{ts: ts, name: name, requests: make(map[string][]model.DBRequest)}
+	agent.Server = httptest.NewServer(http.HandlerFunc(agent.serve))
+	ts.t.Cleanup(agent.Close)
+
+	req := registerRequest{
+		RegisterRequest: model.RegisterRequest{
+			AgentName: name,
+			ShortName: name,
+			LongName:  "Fake agent " + name,
+			DBVendor:  "mysql",
+			DBAddr:    "localhost",
+			DBPort:    "3306",
+			Version:   "test",
+			Addr:      agent.URL,
+		},
+		Labels: labels,
+	}
+
+	var resp model.RegisterResponse
+
+	code, body := ts.do(http.MethodPost, "/register", testAgentSecret, req)
+	if code != http.StatusOK {
+		ts.t.Fatalf("registering agent %s: got %d: %s", name, code, body)
+	}
+
+	if err := json.Unmarshal(body, &resp); err != nil {
+		ts.t.Fatalf("decoding registration of agent %s: %v", name, err)
+	}
+	agent.token = resp.Token
+
+	return agent
+}
+
+func (a *fakeAgent) serve(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	failing, capacity := a.failing, a.capacity
+	a.mu.Unlock()
+
+	if failing {
+		http.Error(w, "agent failing", http.StatusServiceUnavailable)
+		return
+	}
+
+	if r.URL.Path == "/heartbeat" && capacity != nil {
+		json.NewEncoder(w).Encode(heartbeatResponse{Capacity: capacity})
+		return
+	}
+
+	if r.URL.Path == "/list-databases" {
+		a.mu.Lock()
+		list := append([]string(nil), a.databases...)
+		a.mu.Unlock()
+
+		w.Write(inet.ListMessage{Status: status.Success, Message: list}.Compose())
+		return
+	}
+
+	var req model.DBRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		w.Write(inet.Message{Status: status.InvalidJSON, Message: err.Error()}.Compose())
+		return
+	}
+
+	a.mu.Lock()
+	endpoint := strings.TrimPrefix(r.URL.Path, "/")
+	a.requests[endpoint] = append(a.requests[endpoint], req)
+	a.mu.Unlock()
+
+	w.Write(inet.Message{Status: status.Success, Message: "Accepted " + endpoint}.Compose())
+}
+
+// has sets the databases the agent lists.
+func (a *fakeAgent) has(databases ...string) {
+	a.mu.Lock()
+	a.databases = databases
+	a.mu.Unlock()
+}
+
+// fail makes the agent fail every request, including its heartbeat, until
+// it's told otherwise.
+func (a *fakeAgent) fail(failing bool) {
+	a.mu.Lock()
+	a.failing = failing
+	a.mu.Unlock()
+}
+
+// reports sets the capacity the agent reports on its heartbeat.
+func (a *fakeAgent) reports(c registry.Capacity) {
+	a.mu.Lock()
+	a.capacity = &c
+	a.mu.Unlock()
+}
+
+// received returns the requests the agent received on the endpoint.
+func (a *fakeAgent) received(endpoint string) []model.DBRequest {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return append([]model.DBRequest(nil), a.requests[endpoint]...)
+}
+
+// update reports the status of a database to the server, like agents do
+// while they work on a request.
+func (a *fakeAgent) update(ID, statusID int, message string) int {
+	a.ts.t.Helper()
+
+	code, _ := a.ts.do(http.MethodPost, "/upd8", a.token, notif.Msg{ID: ID, StatusID: statusID, Message: message})
+
+	return code
+}
+
+// do sends a JSON request to the server, authenticated with the bearer
+// token if there is one, and returns the status code and body of the
+// response.
+func (ts *testServer) do(method, path, token string, body interface{}) (int, []byte) {
+	ts.t.Helper()
+
+	var payload []byte
+	if body != nil {
+		var err error
+
+		payload, err = json.Marshal(body)
+		if err != nil {
+			ts.t.Fatalf("encoding request to %s: %v", path, err)
+		}
+	}
+
+	req, err := http.NewRequest(method, ts.URL+path, bytes.NewReader(payload))
+	if err != nil {
+		ts.t.Fatalf("creating request to %s: %v", path, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		ts.t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		ts.t.Fatalf("reading response of %s %s: %v", method, path, err)
+	}
+
+	return resp.StatusCode, b
+}
+
+// api calls the API with the token and decodes the data of a successful
+// response into out, if it's not nil.
+func (ts *testServer) api(method, path, token string, body, out interface{}) int {
+	ts.t.Helper()
+
+	code, b := ts.do(method, path, token, body)
+
+	var resp struct {
+		Success bool            `json:"success"`
+		Data    json.RawMessage `json:"data"`
+		Error   []string        `json:"error"`
+	}
+
+	if err := json.Unmarshal(b, &resp); err != nil {
+		ts.t.Fatalf("decoding response of %s %s: %v: %s", method, path, err, b)
+	}
+
+	if resp.Success && out != nil {
+		if err := json.Unmarshal(resp.Data, out); err != nil {
+			ts.t.Fatalf("decoding data of %s %s: %v: %s", method, path, err, resp.Data)
+		}
+	}
+
+	return code
+}
+
+// browser is a logged in user of the web interface. It keeps the cookies
+// between requests, but doesn't follow redirects.
+type browser struct {
+	ts     *testServer
+	client *http.Client
+}
+
+var csrfMeta = regexp.MustCompile(`<meta name="csrf-token" content="([^"]+)">`)
+
+// login logs the user in through the login form.
+func (ts *testServer) login(email string) *browser {
+	ts.t.Helper()
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		ts.t.Fatalf("creating cookie jar: %v", err)
+	}
+
+	b := &browser{ts: ts, client: &http.Client{
+		Jar: jar,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}}
+
+	b.post("/login", url.Values{"email": {email}})
+
+	return b
+}
+
+func (b *browser) get(path string) (int, string) {
+	b.ts.t.Helper()
+
+	resp, err := b.client.Get(b.ts.URL + path)
+	if err != nil {
+		b.ts.t.Fatalf("GET %s: %v", path, err)
+	}
+
+	return readPage(b.ts.t, resp)
+}
+
+func (b *browser) post(path string, form url.Values) (int, string) {
+	b.ts.t.Helper()
+
+	resp, err := b.client.PostForm(b.ts.URL+path, form)
+	if err != nil {
+		b.ts.t.Fatalf("POST %s: %v", path, err)
+	}
+
+	return readPage(b.ts.t, resp)
+}
+
+// csrfToken reads the CSRF token of the session from a page.
+func (b *browser) csrfToken() string {
+	b.ts.t.Helper()
+
+	_, page := b.get("/")
+
+	match := csrfMeta.FindStringSubmatch(page)
+	if match == nil {
+		b.ts.t.Fatalf("no csrf token on the home page, is the user logged in?")
+	}
+
+	return match[1]
+}
+
+// issueToken creates an API token for the user through the session.
+func (b *browser) issueToken(name string) string {
+	b.ts.t.Helper()
+
+	body, _ := json.Marshal(tokenRequest{Name: name})
+
+	req, _ := http.NewRequest(http.MethodPost, b.ts.URL+"/api/tokens", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(csrfHeader, b.csrfToken())
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		b.ts.t.Fatalf("POST /api/tokens: %v", err)
+	}
+
+	code, page := readPage(b.ts.t, resp)
+	if code != http.StatusOK {
+		b.ts.t.Fatalf("issuing token: got %d: %s", code, page)
+	}
+
+	var issued struct {
+		Data issuedToken `json:"data"`
+	}
+
+	if err := json.Unmarshal([]byte(page), &issued); err != nil {
+		b.ts.t.Fatalf("decoding issued token: %v", err)
+	}
+
+	return issued.Data.Value
+}
+
+func readPage(t *testing.T, resp *http.Response) (int, string) {
+	t.Helper()
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading response: %v", err)
+	}
+
+	return resp.StatusCode, string(b)
+}
+
+// waitFor polls cond until it's true, for the actions that the server
+// finishes in the background.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/djavorszky/ddn-api/database/data"
+	"github.com/djavorszky/ddn-api/mail"
+	"github.com/djavorszky/ddn-api/registry"
+	"github.com/djavorszky/ddn-common/errs"
+	"github.com/djavorszky/ddn-common/inet"
+	"github.com/djavorszky/ddn-common/logger"
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultHealthInterval      = 30 * time.Second
+	defaultHealthTimeout       = 10 * time.Second
+	defaultHealthFailures      = 2
+	defaultHealthRecoveries    = 1
+	defaultHealthAlertCooldown = time.Hour
+
+	// healthHistory is the number of probes kept for each agent.
+	healthHistory = 20
+)
+
+// healthProbe is the result of asking an agent for its heartbeat.
+type healthProbe struct {
+	Time    time.Time `json:"time"`
+	OK      bool      `json:"ok"`
+	Latency int64     `json:"latency_ms"`
+	Error   string    `json:"error,omitempty"`
+
+	capacity *registry.Capacity
+}
+
+// heartbeatResponse is what agents may answer their heartbeat with. Agents
+// that don't report their capacity answer with anything else.
+type heartbeatResponse struct {
+	Capacity *registry.Capacity `json:"capacity"`
+}
+
+// probeRing keeps the latest probes, overwriting the oldest one once it's
+// full.
+type probeRing struct {
+	probes []healthProbe
+	next   int
+}
+
+func (r *probeRing) add(p healthProbe) {
+	if len(r.probes) < healthHistory {
+		r.probes = append(r.probes, p)
+		return
+	}
+
+	r.probes[r.next] = p
+	r.next = (r.next + 1) % healthHistory
+}
+
+// list returns the probes, oldest first.
+func (r *probeRing) list() []healthProbe {
+	list := make([]healthProbe, 0, len(r.probes))
+	list = append(list, r.probes[r.next:]...)
+
+	return append(list, r.probes[:r.next]...)
+}
+
+// agentHealth is what the monitor of an agent knows about its health.
+type agentHealth struct {
+	Agent     string        `json:"agent"`
+	Status    string        `json:"agent_status"`
+	Failures  int           `json:"failures"`
+	Successes int           `json:"successes"`
+	LastAlert *time.Time    `json:"last_alert,omitempty"`
+	Probes    []healthProbe `json:"probes"`
+}
+
+// healthSettings are the health-* settings of the configuration, with the
+// defaults filled in.
+type healthSettings struct {
+	interval      time.Duration
+	timeout       time.Duration
+	failures      int
+	recoveries    int
+	alertCooldown time.Duration
+}
+
+func healthConfig() healthSettings {
+	return healthSettings{
+		interval:      healthDuration("health-interval", config.HealthInterval, defaultHealthInterval),
+		timeout:       healthDuration("health-timeout", config.HealthTimeout, defaultHealthTimeout),
+		failures:      healthThreshold("health-failures", config.HealthFailures, defaultHealthFailures),
+		recoveries:    healthThreshold("health-recoveries", config.HealthRecoveries, defaultHealthRecoveries),
+		alertCooldown: healthDuration("health-alert-cooldown", config.HealthAlertCooldown, defaultHealthAlertCooldown),
+	}
+}
+
+// agentMonitor probes an agent in its own goroutine, and marks it down
+// after enough failed probes in a row, and up again after enough
+// successful ones. Admins are mailed about a disappeared agent at most
+// once per health-alert-cooldown, so a flapping agent doesn't flood them.
+type agentMonitor struct {
+	name     string
+	settings healthSettings
+	stop     chan struct{}
+
+	mu        sync.Mutex
+	ring      probeRing
+	failures  int
+	successes int
+	lastAlert time.Time
+}
+
+var (
+	monitors   = make(map[string]*agentMonitor)
+	monitorsMu sync.Mutex
+)
+
+// startHealthChecks starts monitoring every registered agent, and the
+// ones registering later. Agents that unregister are no longer monitored.
+// Stop stops all the monitors.
+func startHealthChecks() (stop func()) {
+	var (
+		done = make(chan struct{})
+		wg   sync.WaitGroup
+	)
+
+	settings := healthConfig()
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(settings.interval)
+		defer ticker.Stop()
+
+		for {
+			syncMonitors(settings, &wg)
+
+			select {
+			case <-done:
+				stopMonitors()
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+// syncMonitors starts a monitor for each agent that doesn't have one yet,
+// and stops the monitors of the agents that are no longer registered.
+func syncMonitors(settings healthSettings, wg *sync.WaitGroup) {
+	monitorsMu.Lock()
+	defer monitorsMu.Unlock()
+
+	registered := make(map[string]bool)
+
+	for _, agent := range registry.List() {
+		registered[agent.ShortName] = true
+
+		if _, ok := monitors[agent.ShortName]; ok {
+			continue
+		}
+
+		m := &agentMonitor{name: agent.ShortName, settings: settings, stop: make(chan struct{})}
+		monitors[agent.ShortName] = m
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			m.run()
+		}()
+	}
+
+	for name, m := range monitors {
+		if !registered[name] {
+			close(m.stop)
+			delete(monitors, name)
+		}
+	}
+}
+
+func stopMonitors() {
+	monitorsMu.Lock()
+	defer monitorsMu.Unlock()
+
+	for name, m := range monitors {
+		close(m.stop)
+		delete(monitors, name)
+	}
+}
+
+// run probes the agent every health-interval until the monitor is
+// stopped.
+func (m *agentMonitor) run() {
+	ticker := time.NewTicker(m.settings.interval)
+	defer ticker.Stop()
+
+	for {
+		m.check()
+
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check probes the agent and updates its status if the probes say so.
+func (m *agentMonitor) check() {
+	agent, ok := registry.Get(m.name)
+	if !ok {
+		return
+	}
+
+	p := probeAgent(agent, m.settings.timeout)
+
+	if p.capacity != nil {
+		p.capacity.Reported = p.Time
+
+		registry.SetCapacity(m.name, *p.capacity)
+	}
+
+	// The agent may have changed while it was probed.
+	agent, ok = registry.Get(m.name)
+	if !ok {
+		return
+	}
+
+	m.mu.Lock()
+	next := m.observe(agent, p)
+	alert := next == registry.StatusDown && agent.Status != registry.StatusUnknown && m.alertDue(agent, p.Time)
+	m.mu.Unlock()
+
+	switch next {
+	case registry.StatusUp:
+		logger.Debug("Agent %q back online", agent.ShortName)
+
+		registry.Update(m.name, func(a *registry.Agent) {
+			a.Up = true
+			a.Status = registry.StatusUp
+		})
+
+		go dropExpiredOf(agent.ShortName)
+	case registry.StatusDown:
+		if agent.Status == registry.StatusUnknown {
+			logger.Debug("Agent %q did not come back after restart", agent.ShortName)
+		} else {
+			logger.Debug("Agent %q disappeared", agent.ShortName)
+		}
+
+		registry.Update(m.name, func(a *registry.Agent) {
+			a.Up = false
+			a.Status = registry.StatusDown
+		})
+
+		if alert {
+			for _, addr := range config.AdminEmail {
+				mail.Send(addr, "[Cloud DB] Agent disappeared without trace",
+					fmt.Sprintf("Agent %q at %q no longer exists.", agent.ShortName, agent.Address))
+			}
+		}
+	}
+}
+
+// observe records the probe and returns the status the agent changes to,
+// or an empty string if it stays as it is.
+func (m *agentMonitor) observe(agent registry.Agent, p healthProbe) string {
+	m.ring.add(p)
+
+	if p.OK {
+		m.failures = 0
+		m.successes++
+
+		if !agent.Up && m.successes >= m.settings.recoveries {
+			return registry.StatusUp
+		}
+
+		return ""
+	}
+
+	m.successes = 0
+	m.failures++
+
+	if (agent.Up || agent.Status == registry.StatusUnknown) && m.failures >= m.settings.failures {
+		return registry.StatusDown
+	}
+
+	return ""
+}
+
+// alertDue tells whether the admins are to be mailed about the agent
+// disappearing, and remembers the time if so. Agents in maintenance are
+// expected to go away.
+func (m *agentMonitor) alertDue(agent registry.Agent, now time.Time) bool {
+	if agent.Mode == data.ModeMaintenance {
+		return false
+	}
+
+	if !m.lastAlert.IsZero() && now.Sub(m.lastAlert) < m.settings.alertCooldown {
+		logger.Debug("Agent %q is flapping, not alerting again", agent.ShortName)
+		return false
+	}
+
+	m.lastAlert = now
+
+	return true
+}
+
+// health returns the latest probes of the agent, along with its status.
+func (m *agentMonitor) health(agent registry.Agent) agentHealth {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h := agentHealth{
+		Agent:     agent.ShortName,
+		Status:    agent.Status,
+		Failures:  m.failures,
+		Successes: m.successes,
+		Probes:    m.ring.list(),
+	}
+
+	if !m.lastAlert.IsZero() {
+		t := m.lastAlert
+		h.LastAlert = &t
+	}
+
+	return h
+}
+
+// probeAgent asks the agent for its heartbeat, giving up after the
+// timeout. The capacity of the agent is read from the response, if it
+// reported it.
+func probeAgent(agent registry.Agent, timeout time.Duration) healthProbe {
+	client := http.Client{Timeout: timeout}
+
+	start := time.Now()
+
+	p := healthProbe{Time: start}
+
+	resp, err := client.Get(fmt.Sprintf("%s/heartbeat", agent.Address))
+
+	p.Latency = int64(time.Since(start) / time.Millisecond)
+
+	switch {
+	case err != nil:
+		p.Error = err.Error()
+	case resp.StatusCode != http.StatusOK:
+		p.Error = resp.Status
+	default:
+		p.OK = true
+
+		var hr heartbeatResponse
+		if json.NewDecoder(resp.Body).Decode(&hr) == nil {
+			p.capacity = hr.Capacity
+		}
+	}
+
+	if resp != nil {
+		resp.Body.Close()
+	}
+
+	return p
+}
+
+func healthDuration(name, value string, def time.Duration) time.Duration {
+	if value == "" {
+		return def
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		logger.Warn("Invalid %s %q, using %s", name, value, def)
+
+		return def
+	}
+
+	return d
+}
+
+func healthThreshold(name string, value, def int) int {
+	if value < 0 {
+		logger.Warn("Invalid %s %d, using %d", name, value, def)
+	}
+
+	if value <= 0 {
+		return def
+	}
+
+	return value
+}
+
+// apiAgentHealth returns the latest probes of the agent.
+func apiAgentHealth(w http.ResponseWriter, r *http.Request) {
+	p, err := getAPIPrincipal(r)
+	if err != nil {
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return
+	}
+
+	if !p.can(actListAgents) {
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return
+	}
+
+	name := mux.Vars(r)["agent"]
+
+	agent, ok := registry.Get(name)
+	if !ok {
+		inet.SendFailure(w, http.StatusNotFound, errs.AgentNotFound, name)
+		return
+	}
+
+	monitorsMu.Lock()
+	m, ok := monitors[name]
+	monitorsMu.Unlock()
+
+	if !ok {
+		inet.SendSuccess(w, http.StatusOK, agentHealth{Agent: name, Status: agent.Status, Probes: make([]healthProbe, 0)})
+		return
+	}
+
+	inet.SendSuccess(w, http.StatusOK, m.health(agent))
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/djavorszky/ddn-api/database/data"
+	"github.com/djavorszky/ddn-api/registry"
+)
+
+func Test_probeRing(t *testing.T) {
+	var ring probeRing
+
+	for i := 0; i < healthHistory+5; i++ {
+		ring.add(healthProbe{Latency: int64(i)})
+	}
+
+	probes := ring.list()
+	if len(probes) != healthHistory {
+		t.Fatalf("ring has %d probes, want %d", len(probes), healthHistory)
+	}
+
+	for i, p := range probes {
+		if p.Latency != int64(i+5) {
+			t.Errorf("probe %d has latency %d, want %d", i, p.Latency, i+5)
+		}
+	}
+}
+
+func Test_observe(t *testing.T) {
+	m := agentMonitor{settings: healthSettings{failures: 2, recoveries: 2}}
+
+	up := registry.Agent{Status: registry.StatusUp}
+	up.Up = true
+	down := registry.Agent{Status: registry.StatusDown}
+	unknown := registry.Agent{Status: registry.StatusUnknown}
+
+	tests := []struct {
+		name  string
+		agent registry.Agent
+		ok    bool
+		want  string
+	}{
+		{"first failure", up, false, ""},
+		{"second failure", up, false, registry.StatusDown},
+		{"first recovery", down, true, ""},
+		{"failure resets recoveries", down, false, ""},
+		{"recovery again", down, true, ""},
+		{"second recovery", down, true, registry.StatusUp},
+		{"unknown fails once", unknown, false, ""},
+		{"unknown fails twice", unknown, false, registry.StatusDown},
+	}
+	for _, tt := range tests {
+		if got := m.observe(tt.agent, healthProbe{OK: tt.ok}); got != tt.want {
+			t.Errorf("%s: observe() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHealthChecks(t *testing.T) {
+	ts := newTestServer(t)
+
+	config.HealthInterval = "10ms"
+	config.HealthTimeout = "1s"
+	config.HealthFailures = 2
+
+	agent := ts.startAgent("fake-mysql")
+	token := ts.login("user@example.com").issueToken("health")
+
+	stop := startHealthChecks()
+	defer stop()
+
+	isUp := func(want bool) func() bool {
+		return func() bool {
+			a, _ := registry.Get(agent.name)
+			return a.Up == want
+		}
+	}
+
+	health := func() agentHealth {
+		var h agentHealth
+
+		if code := ts.api(http.MethodGet, "/api/agents/fake-mysql/health", token, nil, &h); code != http.StatusOK {
+			t.Fatalf("getting health: got %d, want %d", code, http.StatusOK)
+		}
+
+		return h
+	}
+
+	waitFor(t, "the first probe", func() bool { return len(health().Probes) > 0 })
+
+	agent.fail(true)
+	waitFor(t, "the agent to go down", isUp(false))
+
+	h := health()
+	if h.Status != registry.StatusDown || h.Failures < 2 || h.LastAlert == nil {
+		t.Fatalf("health of the disappeared agent = %+v", h)
+	}
+
+	if last := h.Probes[len(h.Probes)-1]; last.OK || last.Error == "" {
+		t.Errorf("last probe of the disappeared agent = %+v", last)
+	}
+
+	alerted := *h.LastAlert
+
+	// The agent coming and going within the cooldown doesn't alert again.
+	agent.fail(false)
+	waitFor(t, "the agent to come back", isUp(true))
+
+	agent.fail(true)
+	waitFor(t, "the agent to go down again", isUp(false))
+
+	if h := health(); h.LastAlert == nil || !h.LastAlert.Equal(alerted) {
+		t.Errorf("flapping agent alerted at %v, want only at %v", h.LastAlert, alerted)
+	}
+
+	if code := ts.api(http.MethodGet, "/api/agents/nonexistent/health", token, nil, nil); code != http.StatusNotFound {
+		t.Errorf("health of unknown agent: got %d, want %d", code, http.StatusNotFound)
+	}
+}
+
+func TestHealthChecksMaintenance(t *testing.T) {
+	ts := newTestServer(t)
+
+	config.HealthInterval = "10ms"
+
+	agent := ts.startAgent("fake-mysql")
+
+	a, _ := registry.Get(agent.name)
+	a.Mode = data.ModeMaintenance
+	registry.Store(a)
+
+	stop := startHealthChecks()
+	defer stop()
+
+	agent.fail(true)
+	waitFor(t, "the agent to go down", func() bool {
+		a, _ := registry.Get(agent.name)
+		return !a.Up
+	})
+
+	monitorsMu.Lock()
+	m := monitors[agent.name]
+	monitorsMu.Unlock()
+
+	if h := m.health(a); h.LastAlert != nil {
+		t.Errorf("agent in maintenance alerted at %v", h.LastAlert)
+	}
+
+	// Unregistered agents are no longer monitored.
+	registry.Remove(agent.name)
+
+	waitFor(t, "the monitor to stop", func() bool {
+		monitorsMu.Lock()
+		defer monitorsMu.Unlock()
+
+		_, ok := monitors[agent.name]
+		return !ok
+	})
+}
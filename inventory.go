@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/djavorszky/ddn-api/database/data"
+	"github.com/djavorszky/ddn-api/registry"
+	"github.com/djavorszky/ddn-common/errs"
+	"github.com/djavorszky/ddn-common/inet"
+	"github.com/djavorszky/ddn-common/logger"
+)
+
+// inventoryInterval is how often the databases on the agents are compared
+// with the ones the server knows about.
+const inventoryInterval = time.Hour
+
+// systemDatabases are the databases of the vendors themselves, which are
+// never orphans.
+var systemDatabases = []string{
+	"information_schema", "mysql", "performance_schema", "sys",
+	"postgres", "template0", "template1",
+	"master", "model", "msdb", "tempdb",
+}
+
+// inventoryGhost is a database the server knows about, but its agent
+// doesn't have.
+type inventoryGhost struct {
+	ID      int    `json:"id"`
+	DBName  string `json:"dbname"`
+	Creator string `json:"creator"`
+	Status  int    `json:"status"`
+	Removed bool   `json:"removed"`
+}
+
+// agentInventory compares the databases of an agent with the ones the
+// server knows about. Orphans are the databases of the agent that the
+// server doesn't know about.
+type agentInventory struct {
+	Agent   string           `json:"agent"`
+	Error   string           `json:"error,omitempty"`
+	Orphans []string         `json:"orphans"`
+	Ghosts  []inventoryGhost `json:"ghosts"`
+}
+
+type inventoryReport struct {
+	Time   time.Time        `json:"time"`
+	Agents []agentInventory `json:"agents"`
+}
+
+var (
+	lastInventory inventoryReport
+	inventoryMu   sync.Mutex
+)
+
+// checkInventory compares the databases of the agents with the ones the
+// server knows about every hour.
+//
+// CheckInventory should always be ran in a goroutine.
+func checkInventory() {
+	ticker := time.NewTicker(inventoryInterval)
+
+	for range ticker.C {
+		if _, err := takeInventory(); err != nil {
+			logger.Error("Failed taking inventory of the agents: %v", err)
+		}
+	}
+}
+
+// takeInventory lists the databases of every agent that is up, compares
+// them with the ones the server knows about, and keeps the report. Ghosts
+// that were ghosts in the previous report too are removed if
+// inventory-remove-ghosts is enabled.
+func takeInventory() (inventoryReport, error) {
+	inventoryMu.Lock()
+	defer inventoryMu.Unlock()
+
+	rows, err := db.FetchAll()
+	if err != nil {
+		return inventoryReport{}, fmt.Errorf("failed listing databases: %v", err)
+	}
+
+	byAgent := make(map[string][]data.Row)
+	for _, row := range rows {
+		byAgent[row.AgentName] = append(byAgent[row.AgentName], row)
+	}
+
+	previous := make(map[int]bool)
+	for _, agent := range lastInventory.Agents {
+		for _, ghost := range agent.Ghosts {
+			previous[ghost.ID] = !ghost.Removed
+		}
+	}
+
+	report := inventoryReport{Time: time.Now()}
+
+	for _, agent := range registry.List() {
+		if !agent.Up {
+			continue
+		}
+
+		inv := agentInventory{Agent: agent.ShortName, Orphans: make([]string, 0), Ghosts: make([]inventoryGhost, 0)}
+
+		names, err := agent.ListDatabases()
+		if err != nil {
+			inv.Error = err.Error()
+			report.Agents = append(report.Agents, inv)
+			continue
+		}
+
+		orphans, ghosts := compareInventory(byAgent[agent.ShortName], names)
+
+		inv.Orphans = append(inv.Orphans, orphans...)
+
+		for _, row := range ghosts {
+			ghost := inventoryGhost{ID: row.ID, DBName: row.DBName, Creator: row.Creator, Status: row.Status}
+
+			if config.InventoryRemoveGhosts && previous[row.ID] {
+				ghost.Removed = removeGhost(row)
+			}
+
+			inv.Ghosts = append(inv.Ghosts, ghost)
+		}
+
+		if len(inv.Orphans) != 0 || len(inv.Ghosts) != 0 {
+			logger.Warn("Agent %q has %d orphaned databases and misses %d", agent.ShortName, len(inv.Orphans), len(inv.Ghosts))
+		}
+
+		report.Agents = append(report.Agents, inv)
+	}
+
+	lastInventory = report
+
+	return report, nil
+}
+
+// compareInventory returns the databases of the agent that aren't among
+// the rows, and the rows that should exist on the agent but don't. Rows
+// in progress or failed are not expected to exist.
+func compareInventory(rows []data.Row, names []string) (orphans []string, ghosts []data.Row) {
+	known := make(map[string]bool)
+	for _, row := range rows {
+		known[row.DBName] = true
+	}
+
+	listed := make(map[string]bool)
+	for _, name := range names {
+		listed[name] = true
+
+		if !known[name] && !contains(systemDatabases, name) && !contains(config.InventoryIgnore, name) {
+			orphans = append(orphans, name)
+		}
+	}
+
+	for _, row := range rows {
+		if _, inProgress := statusPhases[row.Status]; inProgress || row.IsErr() {
+			continue
+		}
+
+		if !listed[row.DBName] {
+			ghosts = append(ghosts, row)
+		}
+	}
+
+	sort.Strings(orphans)
+
+	return orphans, ghosts
+}
+
+// removeGhost removes the database that doesn't exist on its agent, and
+// returns whether it did.
+func removeGhost(row data.Row) bool {
+	busy, err := hasActiveJob(row.ID)
+	if err != nil || busy {
+		return false
+	}
+
+	if err := db.Delete(row); err != nil {
+		logger.Error("failed removing missing database %d: %v", row.ID, err)
+		return false
+	}
+
+	auditDatabase(nil, systemActor, auditInventory, row, row.Status, "missing on the agent")
+
+	err = sendUserNotifications(row.Creator, fmt.Sprintf("Database %s no longer exists on %s and was removed.", row.DBName, row.AgentName))
+	if err != nil {
+		logger.Error("failed notifying user: %v", err)
+	}
+
+	return true
+}
+
+// apiInventory returns the latest inventory of the agents.
+func apiInventory(w http.ResponseWriter, r *http.Request) {
+	p, err := getAPIPrincipal(r)
+	if err != nil {
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return
+	}
+
+	if !p.can(actAdminister) {
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return
+	}
+
+	inventoryMu.Lock()
+	report := lastInventory
+	inventoryMu.Unlock()
+
+	if report.Agents == nil {
+		report.Agents = make([]agentInventory, 0)
+	}
+
+	inet.SendSuccess(w, http.StatusOK, report)
+}
+
+// apiTakeInventory takes the inventory of the agents right away.
+func apiTakeInventory(w http.ResponseWriter, r *http.Request) {
+	p, err := getAPIPrincipal(r)
+	if err != nil {
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return
+	}
+
+	if !p.can(actAdminister) {
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return
+	}
+
+	report, err := takeInventory()
+	if err != nil {
+		inet.SendFailure(w, http.StatusInternalServerError, errs.QueryFailed, err.Error())
+
+		logger.Error("Taking inventory failed: %v", err)
+		return
+	}
+
+	if report.Agents == nil {
+		report.Agents = make([]agentInventory, 0)
+	}
+
+	inet.SendSuccess(w, http.StatusOK, report)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/djavorszky/ddn-api/database/data"
+	"github.com/djavorszky/ddn-common/status"
+)
+
+func Test_compareInventory(t *testing.T) {
+	defer func(orig Config) { config = orig }(config)
+
+	config = Config{InventoryIgnore: []string{"scratch"}}
+
+	rows := []data.Row{
+		{ID: 1, DBName: "present", Status: status.Success},
+		{ID: 2, DBName: "gone", Status: status.Success},
+		{ID: 3, DBName: "expiring", Status: status.RemovalScheduled},
+		{ID: 4, DBName: "creating", Status: status.InProgress},
+		{ID: 5, DBName: "failed", Status: status.CreateDatabaseFailed},
+	}
+	names := []string{"present", "mysql", "scratch", "zombie", "forgotten"}
+
+	orphans, ghosts := compareInventory(rows, names)
+
+	if strings.Join(orphans, ",") != "forgotten,zombie" {
+		t.Errorf("compareInventory() orphans = %v, want [forgotten zombie]", orphans)
+	}
+
+	if len(ghosts) != 2 || ghosts[0].ID != 2 || ghosts[1].ID != 3 {
+		t.Errorf("compareInventory() ghosts = %+v, want databases 2 and 3", ghosts)
+	}
+}
+
+func TestInventory(t *testing.T) {
+	ts := newTestServer(t)
+	config.InventoryRemoveGhosts = true
+
+	agent := ts.startAgent("fake-mysql")
+	agent.has("kept", "orphan")
+
+	token := ts.login("user@example.com").issueToken("inventory")
+	adminToken := ts.login(testAdmin).issueToken("inventory")
+
+	kept := data.Row{DBName: "kept", Creator: "user@example.com", AgentName: agent.name, Status: status.Success}
+	ghost := data.Row{DBName: "ghost", Creator: "user@example.com", AgentName: agent.name, Status: status.Success}
+
+	for _, row := range []*data.Row{&kept, &ghost} {
+		if err := ts.db.Insert(row); err != nil {
+			t.Fatalf("Insert() failed: %v", err)
+		}
+	}
+
+	if code := ts.api(http.MethodPost, "/api/inventory", token, nil, nil); code != http.StatusForbidden {
+		t.Errorf("taking inventory as a user: got %d, want %d", code, http.StatusForbidden)
+	}
+
+	var report inventoryReport
+
+	// Ghosts are only removed when they were ghosts the last time too.
+	for i := 0; i < 2; i++ {
+		if code := ts.api(http.MethodPost, "/api/inventory", adminToken, nil, &report); code != http.StatusOK {
+			t.Fatalf("taking inventory: got %d, want %d", code, http.StatusOK)
+		}
+
+		if len(report.Agents) != 1 {
+			t.Fatalf("inventory of %d agents, want 1", len(report.Agents))
+		}
+
+		inv := report.Agents[0]
+		if len(inv.Orphans) != 1 || inv.Orphans[0] != "orphan" || len(inv.Ghosts) != 1 || inv.Ghosts[0].ID != ghost.ID {
+			t.Fatalf("inventory %d = %+v", i, inv)
+		}
+
+		if removed := inv.Ghosts[0].Removed; removed != (i == 1) {
+			t.Errorf("inventory %d removed the ghost: %v", i, removed)
+		}
+	}
+
+	if row, _ := ts.db.FetchByID(ghost.ID); row.ID != 0 {
+		t.Errorf("ghost was not removed: %+v", row)
+	}
+
+	if row, _ := ts.db.FetchByID(kept.ID); row.ID == 0 {
+		t.Errorf("existing database was removed")
+	}
+
+	var latest inventoryReport
+	ts.api(http.MethodGet, "/api/inventory", adminToken, nil, &latest)
+
+	if !latest.Time.Equal(report.Time) {
+		t.Errorf("latest inventory from %v, want %v", latest.Time, report.Time)
+	}
+}
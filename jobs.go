@@ -0,0 +1,660 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/djavorszky/ddn-api/database/data"
+	"github.com/djavorszky/ddn-api/registry"
+	"github.com/djavorszky/ddn-common/errs"
+	"github.com/djavorszky/ddn-common/inet"
+	"github.com/djavorszky/ddn-common/logger"
+	"github.com/djavorszky/ddn-common/status"
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultJobWorkers  = 2
+	defaultJobAttempts = 5
+
+	// jobPollInterval is how often idle workers look for jobs whose retry
+	// became due.
+	jobPollInterval = 5 * time.Second
+
+	// The wait before retrying a failed job doubles with every attempt,
+	// from minJobBackoff up to maxJobBackoff.
+	minJobBackoff = 30 * time.Second
+	maxJobBackoff = 30 * time.Minute
+
+	defaultJobPageSize = 50
+	maxJobPageSize     = 1000
+)
+
+// recreateDropped is the payload of recreate jobs that dropped the
+// database already, so that retries only create it.
+const recreateDropped = "dropped"
+
+// expiredDrop is the payload of drop jobs of expired databases, whose
+// creators are notified once they are dropped.
+const expiredDrop = "expired"
+
+// jobKind is what a kind of job does to its database.
+type jobKind struct {
+	// status is the status of the database while the job is queued or
+	// running, set again when it's retried.
+	status int
+
+	// failed is the status of the database once the job failed for good
+	// or was cancelled.
+	failed int
+
+	run func(job *data.Job, row data.Row) error
+}
+
+var jobKinds = map[string]jobKind{
+	data.JobImport:   {status.Started, status.ImportFailed, runImport},
+	data.JobDrop:     {status.DropInProgress, status.DropDatabaseFailed, runDrop},
+	data.JobRecreate: {status.InProgress, status.CreateDatabaseFailed, runRecreate},
+	data.JobExport:   {status.ExportInProgress, status.ExportFailed, runExport},
+}
+
+// permanentError is an error that retrying the job won't fix.
+type permanentError struct {
+	error
+}
+
+// jobWake wakes up an idle worker when a job is queued.
+var jobWake = make(chan struct{}, 1)
+
+func wakeJobWorker() {
+	select {
+	case jobWake <- struct{}{}:
+	default:
+	}
+}
+
+// enqueueJob queues a job of the kind on the database, to be run as soon
+// as a worker is free. If the job can't be queued, the database is
+// marked as failed.
+func enqueueJob(kind string, row data.Row, creator, payload string) (data.Job, error) {
+	now := time.Now()
+
+	job := data.Job{
+		Kind:        kind,
+		Target:      row.ID,
+		TargetName:  row.DBName,
+		Payload:     payload,
+		Creator:     creator,
+		Status:      data.JobQueued,
+		MaxAttempts: jobAttempts(),
+		NextRun:     now,
+		CreateDate:  now,
+		UpdateDate:  now,
+	}
+
+	err := db.InsertJob(&job)
+	if err != nil {
+		failDatabase(job, fmt.Sprintf("Server error: failed queueing %s: %v", kind, err))
+
+		return job, fmt.Errorf("failed queueing %s: %v", kind, err)
+	}
+
+	wakeJobWorker()
+
+	return job, nil
+}
+
+// startJobWorkers starts the workers that run the queued jobs, and returns
+// the function that stops them, waiting for the jobs they are running.
+func startJobWorkers(n int) (stop func()) {
+	var (
+		done = make(chan struct{})
+		wg   sync.WaitGroup
+	)
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			ticker := time.NewTicker(jobPollInterval)
+			defer ticker.Stop()
+
+			for {
+				for runNextJob() {
+					select {
+					case <-done:
+						return
+					default:
+					}
+				}
+
+				select {
+				case <-done:
+					return
+				case <-jobWake:
+				case <-ticker.C:
+				}
+			}
+		}()
+	}
+
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+// runNextJob runs the job that is due the longest. It returns false if
+// there was none.
+func runNextJob() bool {
+	job, err := db.ClaimJob(time.Now())
+	if err != nil {
+		logger.Error("failed claiming job: %v", err)
+		return false
+	}
+
+	if job.ID == 0 {
+		return false
+	}
+
+	// There may be more, let another worker look.
+	wakeJobWorker()
+
+	finishJob(job, executeJob(&job))
+
+	return true
+}
+
+func executeJob(job *data.Job) error {
+	kind, ok := jobKinds[job.Kind]
+	if !ok {
+		return permanentError{fmt.Errorf("unknown kind of job %q", job.Kind)}
+	}
+
+	row, err := db.FetchByID(job.Target)
+	if err != nil {
+		return err
+	}
+
+	if row.ID == 0 {
+		if job.Kind == data.JobDrop {
+			return nil
+		}
+
+		return permanentError{errors.New("the database no longer exists")}
+	}
+
+	return kind.run(job, row)
+}
+
+// finishJob records the outcome of the attempt of the job, scheduling the
+// next attempt if it failed and may be retried.
+func finishJob(job data.Job, err error) {
+	now := time.Now()
+	job.UpdateDate = now
+
+	var permanent permanentError
+
+	switch {
+	case err == nil:
+		job.Status, job.LastError = data.JobSucceeded, ""
+	case errors.As(err, &permanent) || job.Attempts >= job.MaxAttempts:
+		job.Status, job.LastError = data.JobFailed, err.Error()
+
+		failDatabase(job, "Server error: "+err.Error())
+	default:
+		job.Status, job.LastError = data.JobQueued, err.Error()
+		job.NextRun = now.Add(jobBackoff(job.Attempts))
+
+		logger.Warn("%s of database %d failed, retrying at %s: %v", job.Kind, job.Target, job.NextRun.Format(time.RFC3339), err)
+	}
+
+	if err := db.UpdateJob(&job); err != nil {
+		logger.Error("failed updating job %d: %v", job.ID, err)
+	}
+}
+
+// failDatabase sets the database of the job to the failed status of its
+// kind.
+func failDatabase(job data.Job, message string) {
+	row, err := db.FetchByID(job.Target)
+	if err != nil || row.ID == 0 {
+		return
+	}
+
+	before := row.Status
+	row.Status = jobKinds[job.Kind].failed
+	row.Message = message
+
+	if job.Kind == data.JobImport {
+		row.ExpiryDate = expiryPolicyOf(row).failureExpiry(time.Now())
+
+		if strings.HasPrefix(job.Payload, "/") {
+			os.Remove(filepath.Join(workdir, "web", "dumps", filepath.Base(job.Payload)))
+		}
+	}
+
+	if err := db.Update(&row); err != nil {
+		logger.Error("failed updating database %d: %v", row.ID, err)
+	}
+
+	auditDatabase(nil, systemActor, auditStatus, row, before, fmt.Sprintf("job %d: %s", job.ID, message))
+}
+
+// recoverJobs queues the jobs again that were running when the server
+// stopped. Their attempt was counted already. Jobs are only run by a
+// single server, any job still running at startup was interrupted.
+func recoverJobs() error {
+	jobs, err := db.FetchJobs(data.JobFilter{Status: data.JobRunning})
+	if err != nil {
+		return err
+	}
+
+	for _, job := range jobs {
+		job.Status = data.JobQueued
+		job.LastError = "interrupted by a restart of the server"
+		job.NextRun = time.Now()
+		job.UpdateDate = job.NextRun
+
+		if err := db.UpdateJob(&job); err != nil {
+			return err
+		}
+	}
+
+	if len(jobs) != 0 {
+		logger.Info("Queued %d interrupted jobs again", len(jobs))
+	}
+
+	return nil
+}
+
+func jobBackoff(attempts int) time.Duration {
+	backoff := minJobBackoff
+	for i := 1; i < attempts && backoff < maxJobBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > maxJobBackoff {
+		return maxJobBackoff
+	}
+
+	return backoff
+}
+
+func jobWorkers() int {
+	if config.JobWorkers > 0 {
+		return config.JobWorkers
+	}
+
+	return defaultJobWorkers
+}
+
+func jobAttempts() int {
+	if config.JobAttempts > 0 {
+		return config.JobAttempts
+	}
+
+	return defaultJobAttempts
+}
+
+func jobAgent(row data.Row) (registry.Agent, error) {
+	agent, ok := registry.Get(row.AgentName)
+	if !ok {
+		return agent, fmt.Errorf("agent %q is offline", row.AgentName)
+	}
+
+	return agent, nil
+}
+
+// runImport copies the dump from the mounted folder if the payload is a
+// path, then asks the agent to import it. Once the agent accepted the
+// import, the status of the database moves on, and retries don't ask it
+// again.
+func runImport(job *data.Job, row data.Row) error {
+	if !importPending(row) {
+		return nil
+	}
+
+	url := job.Payload
+
+	if strings.HasPrefix(job.Payload, "/") {
+		row.Status = status.CopyInProgress
+
+		err := db.Update(&row)
+		if err != nil {
+			return err
+		}
+
+		url, err = copyFile(job.Payload)
+		if err != nil {
+			return err
+		}
+
+		row.Dumpfile = url
+
+		err = db.Update(&row)
+		if err != nil {
+			return err
+		}
+	}
+
+	agent, err := jobAgent(row)
+	if err != nil {
+		return err
+	}
+
+	_, err = agent.ImportDatabase(row.ID, row.DBName, row.DBUser, row.DBPass, url)
+	if err != nil {
+		return err
+	}
+
+	// The agent may have reported its progress already, which is not
+	// overwritten. Failing to record the import is not retried, as that
+	// would import it again.
+	current, err := db.FetchByID(row.ID)
+	if err != nil {
+		logger.Error("failed fetching database %d: %v", row.ID, err)
+		return nil
+	}
+
+	if !importPending(current) {
+		return nil
+	}
+
+	current.Status = status.ImportInProgress
+
+	if err := db.Update(&current); err != nil {
+		logger.Error("failed updating database %d: %v", row.ID, err)
+	}
+
+	return nil
+}
+
+// importPending returns whether the import of the database was not
+// accepted by its agent yet.
+func importPending(row data.Row) bool {
+	return row.Status == status.Started || row.Status == status.CopyInProgress
+}
+
+func runDrop(job *data.Job, row data.Row) error {
+	agent, err := jobAgent(row)
+	if err != nil {
+		return err
+	}
+
+	_, err = agent.DropDatabase(row.ID, row.DBName, row.DBUser)
+	if err != nil {
+		return err
+	}
+
+	err = db.Delete(row)
+	if err != nil {
+		return err
+	}
+
+	if job.Payload == expiredDrop {
+		notifyDropped(row)
+	}
+
+	return nil
+}
+
+func runRecreate(job *data.Job, row data.Row) error {
+	agent, err := jobAgent(row)
+	if err != nil {
+		return err
+	}
+
+	if job.Payload != recreateDropped {
+		_, err = agent.DropDatabase(row.ID, row.DBName, row.DBUser)
+		if err != nil {
+			return err
+		}
+
+		job.Payload = recreateDropped
+		db.UpdateJob(job)
+	}
+
+	_, err = agent.CreateDatabase(row.ID, row.DBName, row.DBUser, row.DBPass)
+	if err != nil {
+		return err
+	}
+
+	row.Status = status.Success
+
+	return db.Update(&row)
+}
+
+func runExport(job *data.Job, row data.Row) error {
+	agent, err := jobAgent(row)
+	if err != nil {
+		return err
+	}
+
+	_, err = agent.ExportDatabase(row.ID, row.DBName, row.DBUser, row.DBPass)
+
+	return err
+}
+
+// apiListJobs lists the jobs of the caller, or everyone's for admins,
+// filtered and paged by the query parameters.
+func apiListJobs(w http.ResponseWriter, r *http.Request) {
+	p, err := getAPIPrincipal(r)
+	if err != nil {
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return
+	}
+
+	filter, page, err := jobFilterFrom(r)
+	if err != nil {
+		inet.SendFailure(w, http.StatusBadRequest, errs.UnknownParameter, err.Error())
+		return
+	}
+
+	if p.Role != roleAdmin {
+		filter.Creator = p.Email
+	}
+
+	jobs, err := db.FetchJobs(filter)
+	if err != nil {
+		inet.SendFailure(w, http.StatusInternalServerError, errs.QueryFailed, err.Error())
+
+		logger.Error("Fetching jobs failed: %v", err)
+		return
+	}
+
+	if jobs == nil {
+		jobs = make([]data.Job, 0)
+	}
+
+	inet.SendSuccess(w, http.StatusOK, struct {
+		Page    int        `json:"page"`
+		PerPage int        `json:"per_page"`
+		Jobs    []data.Job `json:"jobs"`
+	}{page, filter.Limit, jobs})
+}
+
+// jobFilterFrom reads the filter and the page of the job listing from the
+// query parameters of the request.
+func jobFilterFrom(r *http.Request) (data.JobFilter, int, error) {
+	query := r.URL.Query()
+
+	filter := data.JobFilter{
+		Creator: query.Get("creator"),
+		Kind:    query.Get("kind"),
+		Status:  query.Get("status"),
+		Limit:   defaultJobPageSize,
+	}
+
+	var err error
+
+	if v := query.Get("target"); v != "" {
+		filter.Target, err = strconv.Atoi(v)
+		if err != nil {
+			return filter, 0, errors.New("target")
+		}
+	}
+
+	if v := query.Get("per_page"); v != "" {
+		filter.Limit, err = strconv.Atoi(v)
+		if err != nil || filter.Limit < 1 || filter.Limit > maxJobPageSize {
+			return filter, 0, errors.New("per_page")
+		}
+	}
+
+	page := 1
+	if v := query.Get("page"); v != "" {
+		page, err = strconv.Atoi(v)
+		if err != nil || page < 1 {
+			return filter, 0, errors.New("page")
+		}
+	}
+
+	filter.Offset = (page - 1) * filter.Limit
+
+	return filter, page, nil
+}
+
+func apiGetJob(w http.ResponseWriter, r *http.Request) {
+	_, job, ok := requireJob(w, r)
+	if !ok {
+		return
+	}
+
+	inet.SendSuccess(w, http.StatusOK, job)
+}
+
+// apiCancelJob cancels a job that is waiting for its first run or for a
+// retry. Running jobs can't be cancelled.
+func apiCancelJob(w http.ResponseWriter, r *http.Request) {
+	p, job, ok := requireJob(w, r)
+	if !ok {
+		return
+	}
+
+	if job.Status != data.JobQueued {
+		inet.SendFailure(w, http.StatusConflict, errs.UpdateFailed, "job is "+job.Status)
+		return
+	}
+
+	// The job may have been claimed since it was fetched, so it's only
+	// cancelled if it's still queued.
+	now := time.Now()
+
+	cancelled, err := db.CancelJob(job.ID, now)
+	if err != nil {
+		inet.SendFailure(w, http.StatusInternalServerError, errs.UpdateFailed, err.Error())
+
+		logger.Error("Cancelling job %d failed: %v", job.ID, err)
+		return
+	}
+
+	if !cancelled {
+		inet.SendFailure(w, http.StatusConflict, errs.UpdateFailed, "job is not queued anymore")
+		return
+	}
+
+	job.Status, job.UpdateDate = data.JobCancelled, now
+
+	failDatabase(job, fmt.Sprintf("The %s was cancelled by %s", job.Kind, p.Email))
+
+	audit(r, data.AuditEntry{Actor: p.Email, Action: auditJobCancel, Target: job.Target, TargetName: job.TargetName, Details: fmt.Sprintf("job %d", job.ID)})
+
+	inet.SendSuccess(w, http.StatusOK, job)
+}
+
+// apiRetryJob queues a failed or cancelled job again, with all of its
+// attempts.
+func apiRetryJob(w http.ResponseWriter, r *http.Request) {
+	p, job, ok := requireJob(w, r)
+	if !ok {
+		return
+	}
+
+	if job.Status != data.JobFailed && job.Status != data.JobCancelled {
+		inet.SendFailure(w, http.StatusConflict, errs.UpdateFailed, "job is "+job.Status)
+		return
+	}
+
+	row, err := db.FetchByID(job.Target)
+	if err != nil {
+		inet.SendFailure(w, http.StatusInternalServerError, errs.QueryFailed, err.Error())
+		return
+	}
+
+	if row.ID == 0 {
+		inet.SendFailure(w, http.StatusConflict, errs.QueryNoResults, "database no longer exists")
+		return
+	}
+
+	now := time.Now()
+
+	job.Status = data.JobQueued
+	job.Attempts = 0
+	job.MaxAttempts = jobAttempts()
+	job.NextRun = now
+	job.UpdateDate = now
+
+	if err := db.UpdateJob(&job); err != nil {
+		inet.SendFailure(w, http.StatusInternalServerError, errs.UpdateFailed, err.Error())
+
+		logger.Error("Retrying job %d failed: %v", job.ID, err)
+		return
+	}
+
+	before := row.Status
+	row.Status = jobKinds[job.Kind].status
+	row.Message = ""
+	db.Update(&row)
+
+	auditDatabase(r, p.Email, auditJobRetry, row, before, fmt.Sprintf("job %d", job.ID))
+
+	wakeJobWorker()
+
+	inet.SendSuccess(w, http.StatusOK, job)
+}
+
+// requireJob returns the job of the request, if the caller may manage it.
+// Otherwise it sends the failure and returns false.
+func requireJob(w http.ResponseWriter, r *http.Request) (principal, data.Job, bool) {
+	p, err := getAPIPrincipal(r)
+	if err != nil {
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return p, data.Job{}, false
+	}
+
+	ID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		inet.SendFailure(w, http.StatusBadRequest, errs.InvalidURL, err.Error())
+		return p, data.Job{}, false
+	}
+
+	job, err := db.FetchJob(ID)
+	if err != nil {
+		inet.SendFailure(w, http.StatusInternalServerError, errs.QueryFailed, err.Error())
+
+		logger.Error("Fetching job %d failed: %v", ID, err)
+		return p, data.Job{}, false
+	}
+
+	if job.ID == 0 {
+		inet.SendFailure(w, http.StatusNotFound, errs.QueryNoResults)
+		return p, data.Job{}, false
+	}
+
+	if !p.canManageJob(job) {
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return p, data.Job{}, false
+	}
+
+	return p, job, true
+}
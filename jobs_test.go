@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/djavorszky/ddn-api/database/data"
+	"github.com/djavorszky/ddn-common/status"
+)
+
+func Test_jobBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, minJobBackoff},
+		{1, minJobBackoff},
+		{2, 2 * minJobBackoff},
+		{3, 4 * minJobBackoff},
+		{10, maxJobBackoff},
+		{100, maxJobBackoff},
+	}
+	for _, tt := range tests {
+		if got := jobBackoff(tt.attempts); got != tt.want {
+			t.Errorf("jobBackoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func Test_jobFilterFrom(t *testing.T) {
+	tests := []struct {
+		query    string
+		want     data.JobFilter
+		wantPage int
+		wantErr  string
+	}{
+		{"", data.JobFilter{Limit: defaultJobPageSize}, 1, ""},
+		{"kind=drop&status=failed&target=12&creator=jane@example.com", data.JobFilter{
+			Creator: "jane@example.com",
+			Kind:    data.JobDrop,
+			Status:  data.JobFailed,
+			Target:  12,
+			Limit:   defaultJobPageSize,
+		}, 1, ""},
+		{"per_page=10&page=3", data.JobFilter{Offset: 20, Limit: 10}, 3, ""},
+		{"target=db", data.JobFilter{}, 0, "target"},
+		{"per_page=0", data.JobFilter{}, 0, "per_page"},
+		{"per_page=5000", data.JobFilter{}, 0, "per_page"},
+		{"page=0", data.JobFilter{}, 0, "page"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api/jobs?"+tt.query, nil)
+
+			got, page, err := jobFilterFrom(r)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("jobFilterFrom() error = %v, expected %s", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("jobFilterFrom() failed: %v", err)
+			}
+
+			if got != tt.want || page != tt.wantPage {
+				t.Errorf("jobFilterFrom() = %+v, page %d, expected %+v, page %d", got, page, tt.want, tt.wantPage)
+			}
+		})
+	}
+}
+
+func TestJobRetryAndCancel(t *testing.T) {
+	ts := newTestServer(t)
+	config.JobAttempts = 1
+
+	token := ts.login("jobs@example.com").issueToken("jobs")
+	otherToken := ts.login("other@example.com").issueToken("other")
+
+	row := data.Row{DBName: "jobdb", DBUser: "jobuser", Creator: "jobs@example.com", AgentName: "late-agent", Status: status.Success}
+	if err := ts.db.Insert(&row); err != nil {
+		t.Fatalf("Insert() failed: %v", err)
+	}
+
+	// The agent of the database is offline, so the drop fails for good
+	// after its only attempt.
+	job, err := enqueueJob(data.JobDrop, row, "jobs@example.com", "")
+	if err != nil {
+		t.Fatalf("enqueueJob() failed: %v", err)
+	}
+
+	path := fmt.Sprintf("/api/jobs/%d", job.ID)
+
+	waitFor(t, "drop to fail", func() bool {
+		ts.api(http.MethodGet, path, token, nil, &job)
+		return job.Status == data.JobFailed
+	})
+
+	if job.Attempts != 1 || job.LastError == "" {
+		t.Errorf("failed job = %+v", job)
+	}
+
+	if row, _ := ts.db.FetchByID(row.ID); row.Status != status.DropDatabaseFailed {
+		t.Errorf("status of the database = %d, want %d", row.Status, status.DropDatabaseFailed)
+	}
+
+	if code := ts.api(http.MethodGet, path, otherToken, nil, nil); code != http.StatusForbidden {
+		t.Errorf("getting the job of another user: got %d, want %d", code, http.StatusForbidden)
+	}
+
+	var listed struct {
+		Jobs []data.Job `json:"jobs"`
+	}
+	ts.api(http.MethodGet, "/api/jobs", otherToken, nil, &listed)
+
+	if len(listed.Jobs) != 0 {
+		t.Errorf("another user listed %+v", listed.Jobs)
+	}
+
+	if code := ts.api(http.MethodPut, path+"/cancel", token, nil, nil); code != http.StatusConflict {
+		t.Errorf("cancelling a failed job: got %d, want %d", code, http.StatusConflict)
+	}
+
+	ts.startAgent("late-agent")
+
+	if code := ts.api(http.MethodPut, path+"/retry", token, nil, nil); code != http.StatusOK {
+		t.Fatalf("retry: got %d, want %d", code, http.StatusOK)
+	}
+
+	waitFor(t, "database to be dropped", func() bool {
+		row, _ := ts.db.FetchByID(row.ID)
+		return row.ID == 0
+	})
+
+	// Jobs waiting for a retry can be cancelled.
+	row = data.Row{DBName: "laterdb", DBUser: "jobuser", Creator: "jobs@example.com", AgentName: "late-agent", Status: status.DropInProgress}
+	if err := ts.db.Insert(&row); err != nil {
+		t.Fatalf("Insert() failed: %v", err)
+	}
+
+	job = data.Job{Kind: data.JobDrop, Target: row.ID, TargetName: row.DBName, Creator: "jobs@example.com", Status: data.JobQueued, MaxAttempts: 1, NextRun: time.Now().Add(time.Hour)}
+	if err := ts.db.InsertJob(&job); err != nil {
+		t.Fatalf("InsertJob() failed: %v", err)
+	}
+
+	path = fmt.Sprintf("/api/jobs/%d", job.ID)
+
+	if code := ts.api(http.MethodPut, path+"/cancel", otherToken, nil, nil); code != http.StatusForbidden {
+		t.Errorf("cancelling the job of another user: got %d, want %d", code, http.StatusForbidden)
+	}
+
+	if code := ts.api(http.MethodPut, path+"/cancel", token, nil, &job); code != http.StatusOK || job.Status != data.JobCancelled {
+		t.Fatalf("cancel: got %d, job %+v", code, job)
+	}
+
+	if row, _ := ts.db.FetchByID(row.ID); row.Status != status.DropDatabaseFailed {
+		t.Errorf("status of the database after cancelling = %d, want %d", row.Status, status.DropDatabaseFailed)
+	}
+}
+
+func TestImportJobRetry(t *testing.T) {
+	ts := newTestServer(t)
+	agent := ts.startAgent("fake-mysql")
+
+	importJob := func(name string, statusID int) data.Job {
+		row := data.Row{DBName: name, DBUser: "user", DBPass: "pass", Creator: "jobs@example.com", AgentName: agent.name, Status: statusID}
+		if err := ts.db.Insert(&row); err != nil {
+			t.Fatalf("Insert() failed: %v", err)
+		}
+
+		job, err := enqueueJob(data.JobImport, row, "jobs@example.com", "http://example.com/"+name+".sql")
+		if err != nil {
+			t.Fatalf("enqueueJob() failed: %v", err)
+		}
+
+		waitFor(t, "import of "+name, func() bool {
+			job, _ = ts.db.FetchJob(job.ID)
+			return job.Status == data.JobSucceeded
+		})
+
+		return job
+	}
+
+	job := importJob("importdb", status.Started)
+
+	if reqs := agent.received("import-database"); len(reqs) != 1 {
+		t.Fatalf("agent received %d imports, want 1", len(reqs))
+	}
+
+	if row, _ := ts.db.FetchByID(job.Target); row.Status != status.ImportInProgress {
+		t.Errorf("status of the imported database = %d, want %d", row.Status, status.ImportInProgress)
+	}
+
+	// The agent reported progress on the import, so it has it already,
+	// even if its answer got lost.
+	importJob("accepteddb", status.DownloadInProgress)
+
+	if reqs := agent.received("import-database"); len(reqs) != 1 {
+		t.Errorf("agent received %d imports, want the accepted one not to be sent again", len(reqs))
+	}
+}
+
+func TestExportResponse(t *testing.T) {
+	ts := newTestServer(t)
+	agent := ts.startAgent("fake-mysql")
+	token := ts.login("exporter@example.com").issueToken("export")
+
+	row := data.Row{DBName: "exportdb", DBUser: "user", DBPass: "pass", Creator: "exporter@example.com", AgentName: agent.name, Status: status.Success}
+	if err := ts.db.Insert(&row); err != nil {
+		t.Fatalf("Insert() failed: %v", err)
+	}
+
+	code, b := ts.do(http.MethodPut, fmt.Sprintf("/api/databases/%d/export", row.ID), token, nil)
+	if code != http.StatusOK {
+		t.Fatalf("export: got %d, want %d: %s", code, http.StatusOK, b)
+	}
+
+	// Clients of the message the export used to answer with only check
+	// the success of it.
+	var resp struct {
+		Success bool   `json:"success"`
+		Data    string `json:"data"`
+		JobID   int    `json:"job_id"`
+	}
+	if err := json.Unmarshal(b, &resp); err != nil {
+		t.Fatalf("decoding export response: %v: %s", err, b)
+	}
+
+	if !resp.Success || resp.Data == "" {
+		t.Errorf("export response = %s, want a success message", b)
+	}
+
+	job, err := ts.db.FetchJob(resp.JobID)
+	if err != nil || job.Kind != data.JobExport || job.Target != row.ID {
+		t.Errorf("job %d of the export = %+v, %v, want the export of %d", resp.JobID, job, err, row.ID)
+	}
+}
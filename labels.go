@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/djavorszky/ddn-api/database/data"
+	"github.com/djavorszky/ddn-api/registry"
+	"github.com/djavorszky/ddn-common/errs"
+	"github.com/djavorszky/ddn-common/inet"
+	"github.com/djavorszky/ddn-common/logger"
+	"github.com/djavorszky/ddn-common/model"
+	"github.com/gorilla/mux"
+)
+
+// maxLabelValue is the longest value a label can have.
+const maxLabelValue = 255
+
+// labelName is what the names of the labels look like, e.g. "purpose" or
+// "disk.size".
+var labelName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._/-]{0,62}$`)
+
+// registerRequest is the registration of an agent, along with the labels
+// it describes itself with.
+type registerRequest struct {
+	model.RegisterRequest
+
+	Labels map[string]string `json:"labels"`
+}
+
+// invalidLabel returns the name of the first label that has an invalid
+// name or a too long value, or an empty string if all of them are valid.
+func invalidLabel(labels map[string]string) string {
+	for _, name := range sortedLabels(labels) {
+		if !labelName.MatchString(name) || len(labels[name]) > maxLabelValue {
+			return name
+		}
+	}
+
+	return ""
+}
+
+// hasLabels tells whether the agent has all the labels of the selector.
+func hasLabels(agent registry.Agent, selector map[string]string) bool {
+	for k, v := range selector {
+		if agent.Labels[k] != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// parseSelector reads selectors of the "key=value" form into a map.
+// Malformed ones are ignored.
+func parseSelector(selectors []string) map[string]string {
+	selector := make(map[string]string)
+
+	for _, s := range selectors {
+		kv := strings.SplitN(s, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			continue
+		}
+
+		selector[kv[0]] = kv[1]
+	}
+
+	return selector
+}
+
+func sortedLabels(labels map[string]string) []string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// formAgents returns the agents the web forms offer, which are up, take
+// new databases and match the selector. The labels of all the agents the
+// forms could offer are returned as well, in the "key=value" form, to
+// filter by.
+func formAgents(agents []registry.Agent, selector map[string]string) ([]registry.Agent, []string) {
+	var (
+		offered []registry.Agent
+		labels  []string
+		seen    = make(map[string]bool)
+	)
+
+	for _, agent := range agents {
+		if !agent.Up || !agent.Accepting() {
+			continue
+		}
+
+		for _, name := range sortedLabels(agent.Labels) {
+			label := name + "=" + agent.Labels[name]
+			if !seen[label] {
+				seen[label] = true
+				labels = append(labels, label)
+			}
+		}
+
+		if hasLabels(agent, selector) {
+			offered = append(offered, agent)
+		}
+	}
+
+	sort.Strings(labels)
+
+	return offered, labels
+}
+
+// apiSetAgentLabels overrides the labels of the agent. Labels set to a
+// value override the ones the agent registered with, labels set to an
+// empty value remove them, and labels set to null drop the override.
+func apiSetAgentLabels(w http.ResponseWriter, r *http.Request) {
+	p, err := getAPIPrincipal(r)
+	if err != nil {
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return
+	}
+
+	if !p.can(actAdminister) {
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return
+	}
+
+	var req map[string]*string
+
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		inet.SendFailure(w, http.StatusBadRequest, errs.JSONDecodeFailed, err.Error())
+		return
+	}
+
+	var (
+		labels  = make(map[string]string)
+		changes []string
+	)
+
+	for _, k := range sortedKeys(req) {
+		v := req[k]
+
+		switch {
+		case v == nil:
+			labels[k] = ""
+			changes = append(changes, k+" reset")
+		case *v == "":
+			labels[k] = ""
+			changes = append(changes, k+" removed")
+		default:
+			labels[k] = *v
+			changes = append(changes, k+"="+*v)
+		}
+	}
+
+	if label := invalidLabel(labels); label != "" {
+		inet.SendFailure(w, http.StatusBadRequest, errInvalidLabel, label)
+		return
+	}
+
+	name := mux.Vars(r)["agent"]
+
+	agent, ok := registry.Update(name, func(a *registry.Agent) {
+		overrides := make(map[string]string)
+		for k, v := range a.LabelOverrides {
+			overrides[k] = v
+		}
+
+		for k, v := range req {
+			if v == nil {
+				delete(overrides, k)
+				continue
+			}
+
+			overrides[k] = *v
+		}
+
+		a.LabelOverrides = overrides
+		if len(overrides) == 0 {
+			a.LabelOverrides = nil
+		}
+	})
+	if !ok {
+		inet.SendFailure(w, http.StatusNotFound, errs.AgentNotFound, name)
+		return
+	}
+
+	audit(r, data.AuditEntry{Actor: p.Email, Action: auditAgentLabels, Agent: agent.ShortName, Details: strings.Join(changes, ", ")})
+
+	logger.Info("Labels of agent %q are now %v", agent.ShortName, agent.Labels)
+
+	inet.SendSuccess(w, http.StatusOK, redactAgent(agent))
+}
+
+func sortedKeys(m map[string]*string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
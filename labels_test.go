@@ -0,0 +1,137 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/djavorszky/ddn-api/database/data"
+	"github.com/djavorszky/ddn-api/registry"
+)
+
+func Test_invalidLabel(t *testing.T) {
+	tests := []struct {
+		labels map[string]string
+		want   string
+	}{
+		{nil, ""},
+		{map[string]string{"purpose": "ci", "disk.size": "large", "team/owner": ""}, ""},
+		{map[string]string{"purpose": "ci", "bad label": "x"}, "bad label"},
+		{map[string]string{"": "x"}, ""},
+		{map[string]string{"-dash": "x"}, "-dash"},
+		{map[string]string{"long": strings.Repeat("x", maxLabelValue+1)}, "long"},
+	}
+	for _, tt := range tests {
+		if got := invalidLabel(tt.labels); got != tt.want {
+			t.Errorf("invalidLabel(%v) = %q, want %q", tt.labels, got, tt.want)
+		}
+	}
+}
+
+func Test_parseSelector(t *testing.T) {
+	got := parseSelector([]string{"purpose=ci", "url=a=b", "malformed", "=x", ""})
+	want := map[string]string{"purpose": "ci", "url": "a=b"}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseSelector() = %v, want %v", got, want)
+	}
+}
+
+func Test_formAgents(t *testing.T) {
+	agent := func(name string, up bool, mode string, labels map[string]string) registry.Agent {
+		a := registry.Agent{Mode: mode, Labels: labels}
+		a.ShortName, a.Up = name, up
+
+		return a
+	}
+
+	agents := []registry.Agent{
+		agent("ci", true, data.ModeActive, map[string]string{"purpose": "ci"}),
+		agent("repro", true, "", map[string]string{"purpose": "repro", "disk": "large"}),
+		agent("down", false, data.ModeActive, map[string]string{"purpose": "down"}),
+		agent("draining", true, data.ModeDraining, map[string]string{"purpose": "draining"}),
+	}
+
+	offered, labels := formAgents(agents, nil)
+	if len(offered) != 2 || offered[0].ShortName != "ci" || offered[1].ShortName != "repro" {
+		t.Errorf("formAgents() offered %v, want ci and repro", offered)
+	}
+
+	if want := []string{"disk=large", "purpose=ci", "purpose=repro"}; !reflect.DeepEqual(labels, want) {
+		t.Errorf("formAgents() labels = %v, want %v", labels, want)
+	}
+
+	offered, _ = formAgents(agents, map[string]string{"disk": "large"})
+	if len(offered) != 1 || offered[0].ShortName != "repro" {
+		t.Errorf("formAgents() with selector offered %v, want repro", offered)
+	}
+}
+
+func TestAgentLabels(t *testing.T) {
+	ts := newTestServer(t)
+
+	ts.startLabeledAgent("fake-ci", map[string]string{"purpose": "ci"})
+	ts.startLabeledAgent("fake-repro", map[string]string{"purpose": "repro"})
+
+	user := ts.login("user@example.com")
+	token := user.issueToken("labels")
+	adminToken := ts.login(testAdmin).issueToken("labels")
+
+	var agent registry.Agent
+	if ts.api(http.MethodGet, "/api/agents/fake-ci", token, nil, &agent); agent.Labels["purpose"] != "ci" {
+		t.Fatalf("registered labels = %v", agent.Labels)
+	}
+
+	labels := map[string]interface{}{"disk": "large", "purpose": ""}
+
+	if code := ts.api(http.MethodPut, "/api/agents/fake-ci/labels", token, labels, nil); code != http.StatusForbidden {
+		t.Errorf("setting labels as a user: got %d, want %d", code, http.StatusForbidden)
+	}
+
+	if code := ts.api(http.MethodPut, "/api/agents/fake-ci/labels", adminToken, map[string]string{"bad label": "x"}, nil); code != http.StatusBadRequest {
+		t.Errorf("setting an invalid label: got %d, want %d", code, http.StatusBadRequest)
+	}
+
+	// Decode into a fresh agent, json merges into the maps of the old one.
+	agent = registry.Agent{}
+	if code := ts.api(http.MethodPut, "/api/agents/fake-ci/labels", adminToken, labels, &agent); code != http.StatusOK {
+		t.Fatalf("setting labels: got %d, want %d", code, http.StatusOK)
+	}
+
+	if want := map[string]string{"disk": "large"}; !reflect.DeepEqual(agent.Labels, want) {
+		t.Errorf("overridden labels = %v, want %v", agent.Labels, want)
+	}
+
+	// The overrides survive the agent registering again.
+	ts.startLabeledAgent("fake-ci", map[string]string{"purpose": "ci", "team": "qa"})
+
+	if a, _ := registry.Get("fake-ci"); !reflect.DeepEqual(a.Labels, map[string]string{"disk": "large", "team": "qa"}) {
+		t.Errorf("labels after registering again = %v", a.Labels)
+	}
+
+	persisted, err := ts.db.FetchAgents()
+	if err != nil || len(persisted) != 2 || persisted[0].LabelOverrides["disk"] != "large" {
+		t.Errorf("persisted agents = %+v, %v", persisted, err)
+	}
+
+	var row data.Row
+	req := apiDatabaseRequest{placementRequest: placementRequest{Vendor: "mysql", Labels: map[string]string{"disk": "large"}}}
+	if code := ts.api(http.MethodPost, "/api/databases/create", token, req, &row); code != http.StatusOK || row.AgentName != "fake-ci" {
+		t.Errorf("placing by label: got %d on %q, want %d on %q", code, row.AgentName, http.StatusOK, "fake-ci")
+	}
+
+	// Resetting an override brings back the label the agent registered
+	// with.
+	agent = registry.Agent{}
+	ts.api(http.MethodPut, "/api/agents/fake-ci/labels", adminToken, map[string]interface{}{"purpose": nil}, &agent)
+
+	if want := map[string]string{"purpose": "ci", "disk": "large", "team": "qa"}; !reflect.DeepEqual(agent.Labels, want) {
+		t.Errorf("labels after resetting the override = %v", agent.Labels)
+	}
+
+	_, page := user.get("/createdb?label=purpose=repro")
+	if !strings.Contains(page, `value="fake-repro"`) || strings.Contains(page, `value="fake-ci"`) {
+		t.Errorf("create form filtered by purpose=repro doesn't offer only fake-repro:\n%s", page)
+	}
+}
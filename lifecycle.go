@@ -0,0 +1,296 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/djavorszky/ddn-api/database/data"
+	"github.com/djavorszky/ddn-api/mail"
+	"github.com/djavorszky/ddn-api/registry"
+	"github.com/djavorszky/ddn-common/errs"
+	"github.com/djavorszky/ddn-common/inet"
+	"github.com/djavorszky/ddn-common/logger"
+	"github.com/djavorszky/ddn-common/status"
+)
+
+// defaultMaintenanceTime is the time of the day when the expiry of the
+// databases is checked, unless maintenance-time says otherwise.
+const defaultMaintenanceTime = "03:00"
+
+// What the maintenance does with a database.
+const (
+	lifecycleDrop = "drop"
+	lifecycleWarn = "warn"
+	lifecycleWait = "wait"
+)
+
+// lifecycleAction is what the maintenance does with a database. Expired
+// databases of agents that are down wait for their agent to come back.
+type lifecycleAction struct {
+	Action     string    `json:"action"`
+	ID         int       `json:"id"`
+	DBName     string    `json:"dbname"`
+	Creator    string    `json:"creator"`
+	Agent      string    `json:"agent"`
+	ExpiryDate time.Time `json:"expirydate"`
+	Days       int       `json:"days,omitempty"`
+}
+
+type lifecyclePlan struct {
+	Time    time.Time         `json:"time"`
+	Actions []lifecycleAction `json:"actions"`
+}
+
+// lifecycleMu keeps the maintenance and the drops of the agents that came
+// back from working on the same databases.
+var lifecycleMu sync.Mutex
+
+// maintain checks the databases about when they will expire each day at
+// the maintenance time.
+//
+// The creators are warned on the days set by the expiry policy of the
+// database, and expired databases are dropped.
+//
+// Maintain should always be ran in a goroutine.
+func maintain() {
+	for {
+		next := nextMaintenance(time.Now())
+
+		time.Sleep(time.Until(next))
+
+		runLifecycle(time.Now())
+	}
+}
+
+// nextMaintenance returns the first maintenance time after now.
+func nextMaintenance(now time.Time) time.Time {
+	at, err := time.Parse("15:04", config.MaintenanceTime)
+	if err != nil {
+		if config.MaintenanceTime != "" {
+			logger.Warn("Invalid maintenance-time %q, using %q", config.MaintenanceTime, defaultMaintenanceTime)
+		}
+
+		at, _ = time.Parse("15:04", defaultMaintenanceTime)
+	}
+
+	next := time.Date(now.Year(), now.Month(), now.Day(), at.Hour(), at.Minute(), 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+
+	return next
+}
+
+// planLifecycle returns what the maintenance would do at the time.
+func planLifecycle(now time.Time) (lifecyclePlan, error) {
+	rows, err := db.FetchAll()
+	if err != nil {
+		return lifecyclePlan{}, fmt.Errorf("failed listing databases: %v", err)
+	}
+
+	plan := lifecyclePlan{Time: now, Actions: make([]lifecycleAction, 0)}
+
+	for _, row := range rows {
+		if action, ok := lifecycleActionOf(row, now); ok {
+			plan.Actions = append(plan.Actions, action)
+		}
+	}
+
+	return plan, nil
+}
+
+// lifecycleActionOf returns what the maintenance does with the database at
+// the time, if anything. Warnings are sent once: a warning is only due if
+// it's closer to the expiry than the last one sent.
+func lifecycleActionOf(row data.Row, now time.Time) (lifecycleAction, bool) {
+	action := lifecycleAction{
+		ID:         row.ID,
+		DBName:     row.DBName,
+		Creator:    row.Creator,
+		Agent:      row.AgentName,
+		ExpiryDate: row.ExpiryDate,
+	}
+
+	if expired(row, now) {
+		if row.Status == status.DropInProgress {
+			return action, false
+		}
+
+		action.Action = lifecycleDrop
+
+		agent, ok := registry.Get(row.AgentName)
+		if !ok || !agent.Up {
+			action.Action = lifecycleWait
+		}
+
+		return action, true
+	}
+
+	days, ok := expiryPolicyOf(row).warning(row.ExpiryDate.Sub(now))
+	if !ok || (row.Warned != 0 && row.Warned <= days) {
+		return action, false
+	}
+
+	action.Action = lifecycleWarn
+	action.Days = days
+
+	return action, true
+}
+
+// runLifecycle warns the creators of the databases that expire soon, and
+// drops the expired databases.
+func runLifecycle(now time.Time) {
+	lifecycleMu.Lock()
+	defer lifecycleMu.Unlock()
+
+	plan, err := planLifecycle(now)
+	if err != nil {
+		logger.Error("Failed planning maintenance: %v", err)
+		return
+	}
+
+	for _, action := range plan.Actions {
+		row, err := db.FetchByID(action.ID)
+		if err != nil || row.ID == 0 {
+			continue
+		}
+
+		switch action.Action {
+		case lifecycleDrop:
+			dropExpired(row)
+		case lifecycleWarn:
+			warnExpiry(row, action.Days, now)
+		case lifecycleWait:
+			logger.Warn("Expired database %q waits for agent %q to come back", row.DBName, row.AgentName)
+		}
+	}
+}
+
+// dropExpiredOf drops the expired databases of the agent, once it came
+// back.
+func dropExpiredOf(agentName string) {
+	lifecycleMu.Lock()
+	defer lifecycleMu.Unlock()
+
+	rows, err := db.FetchAll()
+	if err != nil {
+		logger.Error("Failed listing databases: %v", err)
+		return
+	}
+
+	now := time.Now()
+
+	for _, row := range rows {
+		if row.AgentName != agentName {
+			continue
+		}
+
+		if action, ok := lifecycleActionOf(row, now); ok && action.Action == lifecycleDrop {
+			dropExpired(row)
+		}
+	}
+}
+
+// warnExpiry tells the creator of the database that it will be dropped,
+// and remembers the warning of the days was sent.
+func warnExpiry(dbe data.Row, days int, now time.Time) {
+	before := dbe.Status
+	left := dayCount(int(math.Ceil(dbe.ExpiryDate.Sub(now).Hours() / 24)))
+
+	if dbe.Status == status.Success {
+		dbe.Status = status.RemovalScheduled
+	}
+
+	dbe.Warned = days
+
+	err := db.Update(&dbe)
+	if err != nil {
+		logger.Error("failed updating database %d: %v", dbe.ID, err)
+		return
+	}
+
+	if dbe.Status != before {
+		auditDatabase(nil, systemActor, auditStatus, dbe, before, "removal scheduled")
+	}
+
+	mail.Send(dbe.Creator, fmt.Sprintf("[Cloud DB] Database %q to be removed in %s", dbe.DBName, left), fmt.Sprintf(`
+<h3>Database removal scheduled</h3>
+
+<p>This is to inform you that the database %q will be removed in %s.</p>
+<p>If you'd like to extend it, please visit <a href="http://cloud-db.liferay.int">Cloud DB</a>.</p>
+<p>Cheers</p>`, dbe.DBName, left))
+
+	err = sendUserNotifications(dbe.Creator, fmt.Sprintf("Database %s to be removed in %s.", dbe.DBName, left))
+	if err != nil {
+		logger.Error("failed notifying user: %v", err)
+	}
+}
+
+// dropExpired queues the drop of the expired database. Its creator is
+// notified once it's dropped.
+func dropExpired(dbe data.Row) {
+	agent, ok := registry.Get(dbe.AgentName)
+	if !ok || !agent.Up {
+		logger.Error("drop database %q - agent %q offline", dbe.DBName, dbe.AgentName)
+		return
+	}
+
+	before := dbe.Status
+	dbe.Status = status.DropInProgress
+
+	err := db.Update(&dbe)
+	if err != nil {
+		logger.Error("failed updating database %d: %v", dbe.ID, err)
+		return
+	}
+
+	auditDatabase(nil, systemActor, auditExpire, dbe, before, "")
+
+	_, err = enqueueJob(data.JobDrop, dbe, systemActor, expiredDrop)
+	if err != nil {
+		logger.Error("failed dropping database: %v", err)
+	}
+}
+
+// notifyDropped tells the creator of the expired database that it was
+// dropped.
+func notifyDropped(dbe data.Row) {
+	mail.Send(dbe.Creator, fmt.Sprintf("[Cloud DB] Database %q dropped", dbe.DBName), fmt.Sprintf(`
+<h3>Database dropped</h3>
+
+<p>This is to inform you that the database %q has been dropped.</p>
+<p>Thank you for using <a href="http://cloud-db.liferay.int">Cloud DB</a>.</p>`, dbe.DBName))
+
+	err := sendUserNotifications(dbe.Creator, fmt.Sprintf("Database %s has been dropped.", dbe.DBName))
+	if err != nil {
+		logger.Error("failed notifying user: %v", err)
+	}
+}
+
+// apiLifecycle returns what the next maintenance would do, without doing
+// it.
+func apiLifecycle(w http.ResponseWriter, r *http.Request) {
+	p, err := getAPIPrincipal(r)
+	if err != nil {
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return
+	}
+
+	if !p.can(actAdminister) {
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return
+	}
+
+	plan, err := planLifecycle(nextMaintenance(time.Now()))
+	if err != nil {
+		inet.SendFailure(w, http.StatusInternalServerError, errs.QueryFailed, err.Error())
+
+		logger.Error("Planning maintenance failed: %v", err)
+		return
+	}
+
+	inet.SendSuccess(w, http.StatusOK, plan)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/djavorszky/ddn-api/database/data"
+	"github.com/djavorszky/ddn-common/status"
+)
+
+func Test_nextMaintenance(t *testing.T) {
+	defer func(orig Config) { config = orig }(config)
+
+	newYearsEve := time.Date(2017, time.December, 31, 22, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		at   string
+		now  time.Time
+		want time.Time
+	}{
+		{"", newYearsEve, time.Date(2018, time.January, 1, 3, 0, 0, 0, time.UTC)},
+		{"23:30", newYearsEve, time.Date(2017, time.December, 31, 23, 30, 0, 0, time.UTC)},
+		{"22:00", newYearsEve, time.Date(2018, time.January, 1, 22, 0, 0, 0, time.UTC)},
+		{"noon", newYearsEve, time.Date(2018, time.January, 1, 3, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		config.MaintenanceTime = tt.at
+
+		if got := nextMaintenance(tt.now); !got.Equal(tt.want) {
+			t.Errorf("nextMaintenance() at %q = %v, want %v", tt.at, got, tt.want)
+		}
+	}
+}
+
+func TestLifecycle(t *testing.T) {
+	ts := newTestServer(t)
+
+	agent := ts.startAgent("fake-mysql")
+	token := ts.login(testAdmin).issueToken("lifecycle")
+
+	// The plan is for the next maintenance.
+	now := nextMaintenance(time.Now())
+
+	rows := map[string]*data.Row{
+		"expired_db":  {ExpiryDate: now.Add(-time.Hour), AgentName: agent.name},
+		"waiting_db":  {ExpiryDate: time.Now().Add(-time.Hour), AgentName: "gone-agent"},
+		"week_db":     {ExpiryDate: now.AddDate(0, 0, 6), AgentName: agent.name},
+		"tomorrow_db": {ExpiryDate: now.Add(20 * time.Hour), AgentName: agent.name, Warned: 7},
+		"warned_db":   {ExpiryDate: now.AddDate(0, 0, 5), AgentName: agent.name, Warned: 7},
+		"later_db":    {ExpiryDate: now.AddDate(0, 1, 0), AgentName: agent.name},
+	}
+
+	for name, row := range rows {
+		row.DBName, row.DBUser, row.Creator, row.Status = name, "user", "user@example.com", status.Success
+
+		if err := ts.db.Insert(row); err != nil {
+			t.Fatalf("Insert() failed: %v", err)
+		}
+	}
+
+	var plan lifecyclePlan
+	if code := ts.api(http.MethodGet, "/api/lifecycle", token, nil, &plan); code != http.StatusOK {
+		t.Fatalf("planning: got %d, want %d", code, http.StatusOK)
+	}
+
+	want := map[string]string{
+		"expired_db":  lifecycleDrop,
+		"waiting_db":  lifecycleWait,
+		"week_db":     lifecycleWarn,
+		"tomorrow_db": lifecycleWarn,
+	}
+
+	got := make(map[string]string)
+	for _, action := range plan.Actions {
+		got[action.DBName] = action.Action
+	}
+
+	for name, action := range want {
+		if got[name] != action {
+			t.Errorf("plan for %s = %q, want %q", name, got[name], action)
+		}
+	}
+
+	if len(got) != len(want) {
+		t.Errorf("plan = %v, want %v", got, want)
+	}
+
+	// The plan doesn't change anything.
+	if row, _ := ts.db.FetchByID(rows["week_db"].ID); row.Warned != 0 || row.Status != status.Success {
+		t.Errorf("planning warned %s: %+v", row.DBName, row)
+	}
+
+	runLifecycle(now)
+
+	waitFor(t, "the drop of the expired database", func() bool {
+		row, _ := ts.db.FetchByID(rows["expired_db"].ID)
+		return row.ID == 0
+	})
+
+	if jobs, _ := ts.db.FetchJobs(data.JobFilter{Target: rows["expired_db"].ID}); len(jobs) != 1 || jobs[0].Kind != data.JobDrop || jobs[0].Creator != systemActor {
+		t.Errorf("jobs of the expired database = %+v, want one drop queued by %s", jobs, systemActor)
+	}
+
+	if row, _ := ts.db.FetchByID(rows["week_db"].ID); row.Warned != 7 || row.Status != status.RemovalScheduled {
+		t.Errorf("warned database has warned = %d, status = %d", row.Warned, row.Status)
+	}
+
+	if row, _ := ts.db.FetchByID(rows["tomorrow_db"].ID); row.Warned != 1 {
+		t.Errorf("warned database has warned = %d, want 1", row.Warned)
+	}
+
+	// A second run sends no warnings again.
+	plan, err := planLifecycle(now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("planLifecycle() failed: %v", err)
+	}
+
+	if len(plan.Actions) != 1 || plan.Actions[0].DBName != "waiting_db" {
+		t.Errorf("second plan = %+v, want only waiting_db", plan.Actions)
+	}
+
+	ts.startAgent("gone-agent")
+
+	waitFor(t, "the drop of the waiting database", func() bool {
+		row, _ := ts.db.FetchByID(rows["waiting_db"].ID)
+		return row.ID == 0
+	})
+
+	if reqs := agent.received("drop-database"); len(reqs) != 1 {
+		t.Errorf("agent received %d drops, want 1", len(reqs))
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/djavorszky/ddn-api/auth"
+	"github.com/djavorszky/ddn-common/logger"
+)
+
+const loginStateSession = "login-state"
+
+// authenticator resolves the users of the web interface and the API. It is
+// set up by initAuthenticator.
+var authenticator auth.Authenticator
+
+// initAuthenticator sets up the identity provider selected by the
+// auth-provider configuration. The email provider lets anyone log in as
+// anyone, admins included, so it has to be enabled explicitly.
+func initAuthenticator() error {
+	switch config.AuthProvider {
+	case "":
+		return fmt.Errorf("auth-provider is required")
+	case "email":
+		if !config.InsecureEmailLogin {
+			return fmt.Errorf("the email auth-provider doesn't check any credentials, set insecure-email-login to use it anyway")
+		}
+
+		authenticator = auth.Email{}
+	case "ldap":
+		if config.LDAPAddr == "" || config.LDAPUserDN == "" {
+			return fmt.Errorf("ldap-addr and ldap-user-dn are required for ldap authentication")
+		}
+
+		authenticator = &auth.LDAP{
+			Addr:     config.LDAPAddr,
+			TLS:      config.LDAPTLS,
+			UserDN:   config.LDAPUserDN,
+			MailAttr: config.LDAPMailAttr,
+		}
+	case "oidc":
+		if config.OIDCIssuer == "" || config.OIDCClientID == "" || config.OIDCRedirectURL == "" {
+			return fmt.Errorf("oidc-issuer, oidc-client-id and oidc-redirect-url are required for oidc authentication")
+		}
+
+		provider := &auth.OIDC{
+			Issuer:       config.OIDCIssuer,
+			ClientID:     config.OIDCClientID,
+			ClientSecret: config.OIDCClientSecret,
+			RedirectURL:  config.OIDCRedirectURL,
+		}
+
+		err := provider.Discover()
+		if err != nil {
+			return err
+		}
+
+		authenticator = provider
+	default:
+		return fmt.Errorf("unknown auth-provider %q", config.AuthProvider)
+	}
+
+	return nil
+}
+
+// loginMode tells the login page what to ask from the user.
+func loginMode() string {
+	switch {
+	case authenticator.LoginURL("") != "":
+		return "redirect"
+	case authenticator.Name() == "email":
+		return "email"
+	default:
+		return "password"
+	}
+}
+
+// loginRedirect sends the browser to the identity provider, remembering
+// a random state that has to come back on the callback.
+func loginRedirect(w http.ResponseWriter, r *http.Request) {
+	state, err := randomString(32)
+	if err != nil {
+		logger.Error("failed generating login state: %v", err)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	target := authenticator.LoginURL(state)
+	if target == "" {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	session, _ := store.Get(r, loginStateSession)
+	session.Values["state"] = state
+	session.Save(r, w)
+
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// loginCallback completes a login that went through the identity provider.
+func loginCallback(w http.ResponseWriter, r *http.Request) {
+	defer http.Redirect(w, r, "/", http.StatusSeeOther)
+
+	session, _ := store.Get(r, loginStateSession)
+	expected, _ := session.Values["state"].(string)
+
+	delete(session.Values, "state")
+	session.Save(r, w)
+
+	if !validCSRF(expected, r.FormValue("state")) {
+		logger.Warn("Rejected login callback with invalid state")
+		return
+	}
+
+	completeLogin(w, r)
+}
+
+// completeLogin resolves the user of the login request through the
+// authenticator and starts a session for them.
+func completeLogin(w http.ResponseWriter, r *http.Request) {
+	identity, err := authenticator.Login(r)
+	if err != nil {
+		logger.Warn("Failed %s login: %v", authenticator.Name(), err)
+		return
+	}
+
+	// Drop whatever session the browser had before, so that a session
+	// id planted before logging in can't be reused afterwards.
+	endSession(w, r)
+
+	err = startSession(w, identity.Email)
+	if err != nil {
+		logger.Error("failed starting session for %s: %v", identity.Email, err)
+	}
+}
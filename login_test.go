@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/djavorszky/ddn-api/auth"
+)
+
+func Test_initAuthenticator(t *testing.T) {
+	defer func(orig Config, origAuth auth.Authenticator) {
+		config, authenticator = orig, origAuth
+	}(config, authenticator)
+
+	tests := []struct {
+		provider string
+		insecure bool
+		wantErr  bool
+	}{
+		{"", false, true},
+		{"", true, true},
+		{"email", false, true},
+		{"email", true, false},
+		{"ldap", false, true},
+		{"unknown", false, true},
+	}
+	for _, tt := range tests {
+		config = Config{AuthProvider: tt.provider, InsecureEmailLogin: tt.insecure}
+
+		err := initAuthenticator()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("initAuthenticator() with %q, insecure %v: error = %v, wantErr %v", tt.provider, tt.insecure, err, tt.wantErr)
+		}
+	}
+}
@@ -15,7 +15,10 @@ import (
 	"github.com/BurntSushi/toml"
 	"github.com/djavorszky/ddn-api/database"
 	"github.com/djavorszky/ddn-api/database/mysql"
+	"github.com/djavorszky/ddn-api/database/postgres"
 	"github.com/djavorszky/ddn-api/mail"
+	"github.com/djavorszky/ddn-api/registry"
+	"github.com/djavorszky/ddn-api/secret"
 	"github.com/djavorszky/ddn-common/brwsr"
 	"github.com/djavorszky/ddn-common/logger"
 	"github.com/djavorszky/sutils"
@@ -56,9 +59,26 @@ func main() {
 	var err error
 	confLocation := flag.String("p", "env", "Specify whether to read a configuration from a file (e.g. server.conf) or from environment variables.")
 	logname := flag.String("l", "std", "Specify the log's filename. By default, logs to the terminal.")
+	keygen := flag.Bool("gen-credential-key", false, "Print a new key to be used in credential-keys and exit.")
 
 	flag.Parse()
 
+	if *keygen {
+		key, err := secret.GenerateKey()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		fmt.Println(key)
+		return
+	}
+
+	if flag.Arg(0) == "migrate" && (flag.NArg() == 1 || flag.Arg(1) == "help") {
+		fmt.Print(migrateUsage)
+		return
+	}
+
 	if *logname != "std" {
 		if _, err = os.Stat(*logname); err == nil {
 			rotated := fmt.Sprintf("%s.%s", *logname, time.Now().Format("2006-01-02_03:04"))
@@ -90,6 +110,16 @@ func main() {
 
 	logger.Level = logLevel
 
+	if flag.Arg(0) == "migrate" {
+		err = runMigrate(flag.Args()[1:], os.Stdout)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
 	logger.Info("Version: %s", version)
 
 	logger.Info("Starting with properties:")
@@ -107,13 +137,26 @@ func main() {
 		}
 	}
 
-	db = &mysql.DB{
-		Address:  config.DBAddress,
-		User:     config.DBUser,
-		Pass:     config.DBPass,
-		Database: config.DBName,
+	keys, err := secret.NewKeyring(config.CredentialKeys...)
+	if err != nil {
+		logger.Fatal("Invalid credential-keys: %v", err)
+	}
+
+	if !keys.Enabled() {
+		logger.Warn("No credential-keys configured, database passwords are stored in plaintext.")
+	}
+
+	backend, err := newBackend()
+	if err != nil {
+		logger.Fatal("Invalid database configuration: %v", err)
 	}
 
+	store := &database.EncryptedConnection{
+		BackendConnection: backend,
+		Keys:              keys,
+	}
+	db = store
+
 	if config.StartupDelay != "" {
 		d, err := time.ParseDuration(config.StartupDelay)
 		if err != nil {
@@ -133,6 +176,38 @@ func main() {
 
 	logger.Info("Database connection established")
 
+	encrypted, err := store.EncryptCredentials()
+	if err != nil {
+		logger.Fatal("Failed to encrypt stored credentials: %v", err)
+	}
+
+	if encrypted != 0 {
+		logger.Info("Encrypted the credentials of %d databases", encrypted)
+	}
+
+	err = registry.Load(db)
+	if err != nil {
+		logger.Fatal("Failed to load agent registry: %v", err)
+	}
+
+	err = initSessions(config.SessionSecret)
+	if err != nil {
+		logger.Fatal("Failed to initialize sessions: %v", err)
+	}
+
+	err = initAuthenticator()
+	if err != nil {
+		logger.Fatal("Failed to initialize %s authentication: %v", config.AuthProvider, err)
+	}
+
+	if authenticator.Name() == "email" {
+		logger.Warn("Using the insecure email login, anyone can log in as anyone, including the admins. Never use it outside of development.")
+	}
+
+	if config.AgentSecret == "" {
+		logger.Fatal("agent-secret is required, agents can't register without it")
+	}
+
 	if config.SMTPAddr != "" {
 		if config.SMTPUser != "" {
 			err = mail.Init(config.SMTPAddr, config.SMTPUser, config.SMTPPass, config.EmailSender)
@@ -159,8 +234,24 @@ func main() {
 	// Start maintenance goroutine
 	go maintain()
 
-	// Start agent checker goroutine
-	go checkAgents()
+	// Start health checks of the agents
+	startHealthChecks()
+
+	// Start expired session cleaner goroutine
+	go cleanSessions()
+
+	// Run the jobs interrupted by the last shutdown, along with the new ones
+	if err := recoverJobs(); err != nil {
+		logger.Error("Failed recovering interrupted jobs: %v", err)
+	}
+
+	startJobWorkers(jobWorkers())
+
+	// Start reconciler of databases stuck in progress goroutine
+	go reconcile()
+
+	// Start inventory of the databases on the agents goroutine
+	go checkInventory()
 
 	port := strings.Split(config.ServerHost, ":")[1]
 
@@ -177,6 +268,47 @@ func main() {
 	}
 }
 
+// newSQLite returns the SQLite backend. Its driver needs cgo, so it's only
+// set when built with the sqlite tag.
+var newSQLite func(path string) database.BackendConnection
+
+// newBackend returns the BackendConnection selected by db-provider.
+func newBackend() (database.BackendConnection, error) {
+	switch config.DBProvider {
+	case "", "mysql":
+		if !sutils.Present(config.DBUser, config.DBName) {
+			return nil, fmt.Errorf("db-username and db-name are required for mysql")
+		}
+
+		return &mysql.DB{
+			Address:  config.DBAddress,
+			User:     config.DBUser,
+			Pass:     config.DBPass,
+			Database: config.DBName,
+		}, nil
+	case "postgres":
+		if !sutils.Present(config.DBUser, config.DBName) {
+			return nil, fmt.Errorf("db-username and db-name are required for postgres")
+		}
+
+		return &postgres.DB{
+			Address:  config.DBAddress,
+			User:     config.DBUser,
+			Pass:     config.DBPass,
+			Database: config.DBName,
+			SSLMode:  config.DBSSLMode,
+		}, nil
+	case "sqlite":
+		if newSQLite == nil {
+			return nil, fmt.Errorf("sqlite is not supported by this build, build it with -tags sqlite")
+		}
+
+		return newSQLite(config.DBAddress), nil
+	}
+
+	return nil, fmt.Errorf("unknown db-provider %q", config.DBProvider)
+}
+
 func loadProperties(confLocation string) error {
 	if confLocation != "env" {
 		return loadPropertiesFromFile(confLocation)
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/djavorszky/ddn-api/database/migrate"
+)
+
+const migrateUsage = `Usage: ddn-api [-p config] migrate <command>
+
+Commands:
+  status          list the migrations and whether they are applied
+  up              apply all pending migrations
+  to VERSION      apply or roll back migrations until the schema is at VERSION
+  down [STEPS]    roll back the last STEPS migrations, 1 by default
+  force VERSION   record VERSION as the current, clean version of the schema
+                  without running anything, after fixing a failed migration
+`
+
+// migratable is implemented by the backends whose schema is versioned
+// with the migrate package.
+type migratable interface {
+	Migrator() (*migrate.Migrator, error)
+	Close() error
+}
+
+// runMigrate runs the migrate command with its arguments, writing what it
+// has to say to out.
+func runMigrate(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing migrate command\n\n%s", migrateUsage)
+	}
+
+	backend, err := newBackend()
+	if err != nil {
+		return fmt.Errorf("invalid database configuration: %v", err)
+	}
+
+	mb, ok := backend.(migratable)
+	if !ok {
+		return fmt.Errorf("the %q database provider has no migrations", config.DBProvider)
+	}
+
+	migrator, err := mb.Migrator()
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %v", err)
+	}
+	defer mb.Close()
+
+	return migrateCommand(migrator, args, out)
+}
+
+func migrateCommand(migrator *migrate.Migrator, args []string, out io.Writer) error {
+	cmd, args := args[0], args[1:]
+
+	switch {
+	case cmd == "status" && len(args) == 0:
+		return printMigrationStatus(migrator, out)
+	case cmd == "up" && len(args) == 0:
+		return migrator.Up()
+	case cmd == "to" && len(args) == 1:
+		version, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid version %q", args[0])
+		}
+
+		return migrator.To(version)
+	case cmd == "down" && len(args) <= 1:
+		steps := 1
+		if len(args) == 1 {
+			var err error
+
+			steps, err = strconv.Atoi(args[0])
+			if err != nil {
+				return fmt.Errorf("invalid number of steps %q", args[0])
+			}
+		}
+
+		return migrator.Down(steps)
+	case cmd == "force" && len(args) == 1:
+		version, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid version %q", args[0])
+		}
+
+		return migrator.Force(version)
+	default:
+		return fmt.Errorf("invalid migrate command\n\n%s", migrateUsage)
+	}
+}
+
+func printMigrationStatus(migrator *migrate.Migrator, out io.Writer) error {
+	states, err := migrator.Status()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tSTATE\tAPPLIED AT\tNAME")
+
+	for _, state := range states {
+		var appliedAt string
+		if state.Applied {
+			appliedAt = state.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", state.Version, migrationState(state), appliedAt, state.Name)
+	}
+
+	return w.Flush()
+}
+
+func migrationState(state migrate.State) string {
+	switch {
+	case state.Dirty:
+		return "dirty"
+	case state.Unknown:
+		return "unknown"
+	case state.Changed:
+		return "changed"
+	case state.Applied:
+		return "applied"
+	default:
+		return "pending"
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_runMigrate(t *testing.T) {
+	if newSQLite == nil {
+		t.Skip("built without sqlite")
+	}
+
+	defer func(orig Config) { config = orig }(config)
+	config = Config{DBProvider: "sqlite", DBAddress: filepath.Join(t.TempDir(), "ddn.db")}
+
+	tests := []struct {
+		args    []string
+		want    string
+		wantErr bool
+	}{
+		{[]string{"status"}, "pending", false},
+		{[]string{"up"}, "", false},
+		{[]string{"status"}, "applied", false},
+		{[]string{"down", "2"}, "", false},
+		{[]string{"to", "1"}, "", false},
+		{[]string{"force", "2"}, "", false},
+		{[]string{"to", "x"}, "", true},
+		{[]string{"up", "now"}, "", true},
+		{[]string{"sideways"}, "", true},
+		{nil, "", true},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+
+		err := runMigrate(tt.args, &out)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("runMigrate(%v) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+		}
+
+		if !strings.Contains(out.String(), tt.want) {
+			t.Errorf("runMigrate(%v) printed %q, want it to contain %q", tt.args, out.String(), tt.want)
+		}
+	}
+}
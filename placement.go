@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/djavorszky/ddn-api/database/data"
+	"github.com/djavorszky/ddn-api/registry"
+	"github.com/djavorszky/ddn-common/errs"
+	"github.com/djavorszky/ddn-common/logger"
+)
+
+// Strategies of placing new databases that don't name their agent.
+const (
+	placeLeastDatabases = "least-databases"
+	placeRoundRobin     = "round-robin"
+	placeMostFree       = "most-free"
+)
+
+// placementRequest describes the agent a new database needs. Version is a
+// constraint like "5.7", ">=10" or "<12.1" on the server version of the
+// agent, and the agent needs to have all the labels.
+type placementRequest struct {
+	Vendor   string            `json:"vendor"`
+	Version  string            `json:"version"`
+	Labels   map[string]string `json:"labels"`
+	Strategy string            `json:"placement"`
+
+	DumpSize int64 `json:"-"`
+	Import   bool  `json:"-"`
+}
+
+// placementStrategy picks one of the candidates, which are all up and
+// match the request.
+type placementStrategy func(candidates []registry.Agent, req placementRequest) (registry.Agent, error)
+
+var placementStrategies = map[string]placementStrategy{
+	placeLeastDatabases: placeOnLeastDatabases,
+	placeRoundRobin:     placeRoundRobinly,
+	placeMostFree:       placeOnMostFree,
+}
+
+var (
+	placementTurn int
+	placementMu   sync.Mutex
+)
+
+// placeDatabase picks the agent of the new database, using the strategy
+// of the request, or the configured one.
+func placeDatabase(req placementRequest) (registry.Agent, errResult) {
+	if req.Vendor == "" {
+		return registry.Agent{}, errResult{
+			httpStatus: http.StatusBadRequest,
+			errors:     []string{errs.MissingParameters, "agent_identifier", "vendor"},
+		}
+	}
+
+	name := req.Strategy
+	if name == "" {
+		name = placementStrategyName()
+	}
+
+	strategy, ok := placementStrategies[name]
+	if !ok {
+		return registry.Agent{}, errResult{
+			httpStatus: http.StatusBadRequest,
+			errors:     []string{errs.UnknownParameter, name},
+		}
+	}
+
+	var candidates []registry.Agent
+	for _, agent := range registry.List() {
+		if placeable(agent, req) {
+			candidates = append(candidates, agent)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return registry.Agent{}, errResult{
+			httpStatus: http.StatusServiceUnavailable,
+			errors:     []string{errs.NoAgentsAvailable},
+		}
+	}
+
+	agent, err := strategy(candidates, req)
+	if err != nil {
+		logger.Error("Placing database failed: %v", err)
+
+		return registry.Agent{}, errResult{
+			httpStatus: http.StatusInternalServerError,
+			errors:     []string{errs.QueryFailed, err.Error()},
+		}
+	}
+
+	return agent, errResult{}
+}
+
+// placeable tells whether the agent can take the database of the request.
+func placeable(agent registry.Agent, req placementRequest) bool {
+	if !agent.Up || !agent.Accepting() || agent.DBVendor != req.Vendor || !matchVersion(req.Version, agent.ServerVersion()) {
+		return false
+	}
+
+	if req.Import && exceedsCapacity(agent, req.DumpSize) && refusingCapacity() {
+		return false
+	}
+
+	if !hasLabels(agent, req.Labels) {
+		return false
+	}
+
+	return checkQuota(quotaRequest{Agent: agent.ShortName, DumpSize: req.DumpSize, Import: req.Import}) == nil
+}
+
+func placementStrategyName() string {
+	if config.Placement == "" {
+		return placeLeastDatabases
+	}
+
+	return config.Placement
+}
+
+// placeOnLeastDatabases picks the candidate with the fewest databases.
+func placeOnLeastDatabases(candidates []registry.Agent, req placementRequest) (registry.Agent, error) {
+	return pickMost(candidates, func(agent registry.Agent, usage data.Usage) int64 {
+		return -int64(usage.Databases)
+	})
+}
+
+// placeRoundRobinly picks the candidates in turns.
+func placeRoundRobinly(candidates []registry.Agent, req placementRequest) (registry.Agent, error) {
+	placementMu.Lock()
+	defer placementMu.Unlock()
+
+	agent := candidates[placementTurn%len(candidates)]
+	placementTurn++
+
+	return agent, nil
+}
+
+// placeOnMostFree picks the candidate with the most disk free, if all of
+// them reported it. Otherwise it picks the one with the most room left
+// under the agent quotas: the dump size if it's limited, the number of
+// databases otherwise.
+func placeOnMostFree(candidates []registry.Agent, req placementRequest) (registry.Agent, error) {
+	limits := quotaLimits(data.ScopeAgent)
+
+	reported := true
+	for _, agent := range candidates {
+		if agent.Capacity == nil {
+			reported = false
+		}
+	}
+
+	return pickMost(candidates, func(agent registry.Agent, usage data.Usage) int64 {
+		switch {
+		case reported:
+			return agent.Capacity.DiskFree
+		case limits.DumpSize > 0:
+			return limits.DumpSize - usage.DumpSize
+		case limits.Databases > 0:
+			return int64(limits.Databases - usage.Databases)
+		}
+
+		return -int64(usage.Databases)
+	})
+}
+
+// pickMost returns the candidate whose usage scores the most, the first
+// one on a tie.
+func pickMost(candidates []registry.Agent, score func(registry.Agent, data.Usage) int64) (registry.Agent, error) {
+	var (
+		best      registry.Agent
+		bestScore int64
+	)
+
+	for i, agent := range candidates {
+		usage, err := db.FetchUsage(data.ScopeAgent, agent.ShortName)
+		if err != nil {
+			return registry.Agent{}, fmt.Errorf("fetching usage of agent %s: %v", agent.ShortName, err)
+		}
+
+		if s := score(agent, usage); i == 0 || s > bestScore {
+			best, bestScore = agent, s
+		}
+	}
+
+	return best, nil
+}
+
+// matchVersion tells whether the version satisfies the constraint. A
+// constraint without an operator matches the versions it is a prefix of,
+// so "5.7" matches "5.7.21" but not "5.70".
+func matchVersion(constraint, version string) bool {
+	constraint = strings.TrimSpace(constraint)
+	if constraint == "" {
+		return true
+	}
+
+	for _, op := range []string{">=", "<=", ">", "<", "="} {
+		if !strings.HasPrefix(constraint, op) {
+			continue
+		}
+
+		c := compareVersions(version, strings.TrimSpace(strings.TrimPrefix(constraint, op)))
+
+		switch op {
+		case ">=":
+			return c >= 0
+		case "<=":
+			return c <= 0
+		case ">":
+			return c > 0
+		case "<":
+			return c < 0
+		default:
+			return c == 0
+		}
+	}
+
+	return version == constraint || strings.HasPrefix(version, constraint+".")
+}
+
+// compareVersions compares the dot separated versions numerically, and
+// returns -1, 0 or 1 if a is lower, equal or higher than b. Missing parts
+// count as zero, and parts that are not numbers as their leading digits.
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		x, y := versionPart(as, i), versionPart(bs, i)
+
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+
+	return 0
+}
+
+func versionPart(parts []string, i int) int {
+	if i >= len(parts) {
+		return 0
+	}
+
+	digits := strings.IndexFunc(parts[i], func(r rune) bool { return r < '0' || r > '9' })
+	if digits == -1 {
+		digits = len(parts[i])
+	}
+
+	n, _ := strconv.Atoi(parts[i][:digits])
+
+	return n
+}
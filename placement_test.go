@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/djavorszky/ddn-api/database/data"
+	"github.com/djavorszky/ddn-api/registry"
+	"github.com/djavorszky/ddn-common/status"
+)
+
+func Test_matchVersion(t *testing.T) {
+	tests := []struct {
+		constraint, version string
+		want                bool
+	}{
+		{"", "5.7.21", true},
+		{"5.7", "5.7.21", true},
+		{"5.7", "5.7", true},
+		{"5.7", "5.70.1", false},
+		{">=10", "10.2.12-MariaDB", true},
+		{">=10", "5.7.21", false},
+		{"<12.1", "12.0.3", true},
+		{"<12.1", "12.1", false},
+		{"> 9.6", "10", true},
+		{"<=9.6", "9.6.0", true},
+		{"=11", "11.0.0", true},
+	}
+	for _, tt := range tests {
+		if got := matchVersion(tt.constraint, tt.version); got != tt.want {
+			t.Errorf("matchVersion(%q, %q) = %v, want %v", tt.constraint, tt.version, got, tt.want)
+		}
+	}
+}
+
+func TestPlacement(t *testing.T) {
+	ts := newTestServer(t)
+
+	token := ts.login("placement@example.com").issueToken("placement")
+
+	for name, version := range map[string]string{"mysql-55": "5.5.58", "mysql-57": "5.7.21", "mysql-57b": "5.7.20"} {
+		ts.startAgent(name)
+
+		agent, _ := registry.Get(name)
+		agent.Version = version
+		if name == "mysql-57b" {
+			agent.AgentLabels = map[string]string{"purpose": "ci"}
+		}
+		registry.Store(agent)
+	}
+
+	busy := data.Row{DBName: "busy", AgentName: "mysql-57", Status: status.Success}
+	if err := ts.db.Insert(&busy); err != nil {
+		t.Fatalf("Insert() failed: %v", err)
+	}
+
+	create := func(req placementRequest) (data.Row, int) {
+		var row data.Row
+
+		code := ts.api(http.MethodPost, "/api/databases/create", token, apiDatabaseRequest{placementRequest: req}, &row)
+
+		return row, code
+	}
+
+	tests := []struct {
+		name      string
+		req       placementRequest
+		wantCode  int
+		wantAgent string
+	}{
+		{"least databases", placementRequest{Vendor: "mysql", Version: "5.7"}, http.StatusOK, "mysql-57b"},
+		{"labels", placementRequest{Vendor: "mysql", Labels: map[string]string{"purpose": "ci"}}, http.StatusOK, "mysql-57b"},
+		{"version", placementRequest{Vendor: "mysql", Version: "<5.6"}, http.StatusOK, "mysql-55"},
+		{"no vendor", placementRequest{}, http.StatusBadRequest, ""},
+		{"no agent of the vendor", placementRequest{Vendor: "postgres"}, http.StatusServiceUnavailable, ""},
+		{"no agent of the version", placementRequest{Vendor: "mysql", Version: ">=8"}, http.StatusServiceUnavailable, ""},
+		{"unknown strategy", placementRequest{Vendor: "mysql", Strategy: "random"}, http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		row, code := create(tt.req)
+
+		if code != tt.wantCode || row.AgentName != tt.wantAgent {
+			t.Errorf("%s: got %d on %q, want %d on %q", tt.name, code, row.AgentName, tt.wantCode, tt.wantAgent)
+		}
+	}
+
+	placed := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		row, _ := create(placementRequest{Vendor: "mysql", Version: "5.7", Strategy: placeRoundRobin})
+
+		placed[row.AgentName] = true
+	}
+
+	if !placed["mysql-57"] || !placed["mysql-57b"] {
+		t.Errorf("round robin placed on %v, want both 5.7 agents", placed)
+	}
+}
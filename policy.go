@@ -0,0 +1,277 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/djavorszky/ddn-api/database/data"
+	"github.com/djavorszky/ddn-common/logger"
+	vis "github.com/djavorszky/ddn-common/visibility"
+)
+
+// Roles of the users. Admins can manage every database and the server
+// itself, users manage their own databases and viewers can only read
+// the public ones.
+const (
+	roleAdmin  = "admin"
+	roleUser   = "user"
+	roleViewer = "viewer"
+)
+
+// action is something a principal can do that isn't tied to a database.
+type action int
+
+const (
+	// actCreate is creating or importing databases, and browsing the
+	// files that can be imported.
+	actCreate action = iota
+
+	// actListAgents is seeing the agents and their details.
+	actListAgents
+
+	// actAdminister is changing the server: log level, agents and roles.
+	actAdminister
+)
+
+// principal is an authenticated user along with their role and the
+// groups they are a member of. All the authorization decisions are made
+// through its methods.
+type principal struct {
+	Email  string
+	Role   string
+	Groups []string
+}
+
+func validRole(role string) bool {
+	return role == roleAdmin || role == roleUser || role == roleViewer
+}
+
+// can tells whether the principal may perform the action.
+func (p principal) can(act action) bool {
+	switch act {
+	case actCreate, actListAgents:
+		return p.Role == roleAdmin || p.Role == roleUser
+	case actAdminister:
+		return p.Role == roleAdmin
+	}
+
+	return false
+}
+
+// canRead tells whether the principal may see the database, including
+// its credentials.
+func (p principal) canRead(row data.Row) bool {
+	switch p.Role {
+	case roleAdmin:
+		return true
+	case roleUser:
+		return row.Public == vis.Public || p.owns(row) || p.shares(row)
+	case roleViewer:
+		return row.Public == vis.Public || p.shares(row)
+	}
+
+	return false
+}
+
+// canManage tells whether the principal may change the database: drop,
+// recreate, export, extend or change its visibility.
+func (p principal) canManage(row data.Row) bool {
+	switch p.Role {
+	case roleAdmin:
+		return true
+	case roleUser:
+		return p.owns(row) || p.shares(row)
+	}
+
+	return false
+}
+
+// canManageJob tells whether the principal may see, cancel and retry the
+// job. Jobs the server queued on its own are only managed by admins.
+func (p principal) canManageJob(job data.Job) bool {
+	return p.Role == roleAdmin || p.Email != "" && job.Creator == p.Email
+}
+
+func (p principal) owns(row data.Row) bool {
+	return p.Email != "" && row.Creator == p.Email
+}
+
+// shares tells whether the database is shared with one of the groups of
+// the principal. Members of the group of a database have the same rights
+// as its creator, unless the database was made private.
+func (p principal) shares(row data.Row) bool {
+	return row.Public != vis.Private && p.inGroup(row.Group)
+}
+
+func (p principal) inGroup(group string) bool {
+	if group == "" {
+		return false
+	}
+
+	for _, g := range p.Groups {
+		if g == group {
+			return true
+		}
+	}
+
+	return false
+}
+
+// restrict limits the query to the databases the principal may see.
+func (p principal) restrict(query *data.DatabaseQuery) {
+	if p.Role == roleAdmin {
+		return
+	}
+
+	query.Restricted = true
+	query.ReaderGroups = p.Groups
+
+	if p.Role == roleUser {
+		query.Reader = p.Email
+	}
+}
+
+// visibleDatabases returns the databases the principal may see. The first
+// list holds the principal's own databases, the second everyone else's,
+// including the ones shared with the groups of the principal.
+func (p principal) visibleDatabases() ([]data.Row, []data.Row, error) {
+	var (
+		rows []data.Row
+		err  error
+	)
+
+	switch p.Role {
+	case roleAdmin:
+		rows, err = db.FetchAll()
+	case roleUser, roleViewer:
+		var own, public []data.Row
+
+		own, err = db.FetchByCreator(p.Email, p.Groups...)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		public, err = db.FetchPublic()
+		rows = append(own, public...)
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		own    []data.Row
+		others []data.Row
+		seen   = make(map[int]bool)
+	)
+
+	for _, row := range rows {
+		if seen[row.ID] || !p.canRead(row) {
+			continue
+		}
+		seen[row.ID] = true
+
+		if p.owns(row) {
+			own = append(own, row)
+		} else {
+			others = append(others, row)
+		}
+	}
+
+	return own, others, nil
+}
+
+// roleOf returns the role of the user. Admin emails of the configuration
+// are always admins, otherwise the persisted role is used, falling back
+// to the configured default role.
+func roleOf(email string) (string, error) {
+	if isConfiguredAdmin(email) {
+		return roleAdmin, nil
+	}
+
+	user, err := db.FetchUser(email)
+	if err != nil {
+		return "", fmt.Errorf("fetching user: %v", err)
+	}
+
+	if user.Role != "" {
+		return user.Role, nil
+	}
+
+	return defaultRole(), nil
+}
+
+func isConfiguredAdmin(email string) bool {
+	for _, admin := range config.AdminEmail {
+		if strings.EqualFold(admin, email) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func defaultRole() string {
+	if config.DefaultRole == "" {
+		return roleUser
+	}
+
+	if !validRole(config.DefaultRole) {
+		logger.Warn("Invalid default-role %q, using %q", config.DefaultRole, roleUser)
+
+		return roleUser
+	}
+
+	return config.DefaultRole
+}
+
+// getPrincipal returns the principal of the web session. The second
+// return value is false if there is no logged in user.
+func getPrincipal(r *http.Request) (principal, bool) {
+	user := getUser(r)
+	if user == "" {
+		return principal{}, false
+	}
+
+	p, err := newPrincipal(user)
+	if err != nil {
+		logger.Error("couldn't resolve principal %s: %v", user, err)
+
+		return principal{}, false
+	}
+
+	return p, true
+}
+
+// getAPIPrincipal returns the principal of an API request.
+func getAPIPrincipal(r *http.Request) (principal, error) {
+	user, err := getAPIUser(r)
+	if err != nil {
+		return principal{}, err
+	}
+
+	p, err := newPrincipal(user)
+	if err != nil {
+		logger.Error("couldn't resolve principal %s: %v", user, err)
+
+		return principal{}, fmt.Errorf("unauthorized request")
+	}
+
+	return p, nil
+}
+
+// newPrincipal looks up the role and the groups of the user.
+func newPrincipal(email string) (principal, error) {
+	role, err := roleOf(email)
+	if err != nil {
+		return principal{}, err
+	}
+
+	groups, err := db.FetchGroupNames(email)
+	if err != nil {
+		return principal{}, fmt.Errorf("fetching groups: %v", err)
+	}
+
+	return principal{Email: email, Role: role, Groups: groups}, nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/djavorszky/ddn-api/database/data"
+	vis "github.com/djavorszky/ddn-common/visibility"
+)
+
+func Test_principalCan(t *testing.T) {
+	tests := []struct {
+		role string
+		act  action
+		want bool
+	}{
+		{roleAdmin, actCreate, true},
+		{roleAdmin, actListAgents, true},
+		{roleAdmin, actAdminister, true},
+		{roleUser, actCreate, true},
+		{roleUser, actListAgents, true},
+		{roleUser, actAdminister, false},
+		{roleViewer, actCreate, false},
+		{roleViewer, actListAgents, false},
+		{roleViewer, actAdminister, false},
+		{"", actCreate, false},
+	}
+	for _, tt := range tests {
+		p := principal{Email: "someone@example.com", Role: tt.role}
+
+		if got := p.can(tt.act); got != tt.want {
+			t.Errorf("principal{Role: %q}.can(%d) = %v, want %v", tt.role, tt.act, got, tt.want)
+		}
+	}
+}
+
+func Test_principalDatabases(t *testing.T) {
+	var (
+		own     = data.Row{ID: 1, Creator: "me@example.com", Public: vis.Private}
+		public  = data.Row{ID: 2, Creator: "other@example.com", Public: vis.Public}
+		private = data.Row{ID: 3, Creator: "other@example.com", Public: vis.Private}
+	)
+
+	tests := []struct {
+		name       string
+		role       string
+		row        data.Row
+		wantRead   bool
+		wantManage bool
+	}{
+		{"admin own", roleAdmin, own, true, true},
+		{"admin public", roleAdmin, public, true, true},
+		{"admin private", roleAdmin, private, true, true},
+		{"user own", roleUser, own, true, true},
+		{"user public", roleUser, public, true, false},
+		{"user private", roleUser, private, false, false},
+		{"viewer own", roleViewer, own, false, false},
+		{"viewer public", roleViewer, public, true, false},
+		{"viewer private", roleViewer, private, false, false},
+		{"unknown role", "root", public, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := principal{Email: "me@example.com", Role: tt.role}
+
+			if got := p.canRead(tt.row); got != tt.wantRead {
+				t.Errorf("canRead() = %v, want %v", got, tt.wantRead)
+			}
+
+			if got := p.canManage(tt.row); got != tt.wantManage {
+				t.Errorf("canManage() = %v, want %v", got, tt.wantManage)
+			}
+		})
+	}
+}
+
+func Test_principalOwnsWithoutEmail(t *testing.T) {
+	p := principal{Role: roleUser}
+
+	if p.canManage(data.Row{ID: 1}) {
+		t.Errorf("principal without email can manage database without creator")
+	}
+}
+
+func Test_defaultRole(t *testing.T) {
+	defer func(role string) { config.DefaultRole = role }(config.DefaultRole)
+
+	tests := []struct {
+		configured string
+		want       string
+	}{
+		{"", roleUser},
+		{roleViewer, roleViewer},
+		{roleAdmin, roleAdmin},
+		{"superuser", roleUser},
+	}
+	for _, tt := range tests {
+		config.DefaultRole = tt.configured
+
+		if got := defaultRole(); got != tt.want {
+			t.Errorf("defaultRole() with %q = %q, want %q", tt.configured, got, tt.want)
+		}
+	}
+}
+
+func Test_principalGroups(t *testing.T) {
+	var (
+		shared  = data.Row{ID: 1, Creator: "other@example.com", Group: "team", Public: data.GroupVisibility}
+		public  = data.Row{ID: 2, Creator: "other@example.com", Group: "team", Public: vis.Public}
+		private = data.Row{ID: 3, Creator: "other@example.com", Group: "team", Public: vis.Private}
+		foreign = data.Row{ID: 4, Creator: "other@example.com", Group: "others", Public: data.GroupVisibility}
+	)
+
+	tests := []struct {
+		name       string
+		role       string
+		row        data.Row
+		wantRead   bool
+		wantManage bool
+	}{
+		{"user shared", roleUser, shared, true, true},
+		{"user public", roleUser, public, true, true},
+		{"user private", roleUser, private, false, false},
+		{"user other group", roleUser, foreign, false, false},
+		{"viewer shared", roleViewer, shared, true, false},
+		{"viewer other group", roleViewer, foreign, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := principal{Email: "me@example.com", Role: tt.role, Groups: []string{"team"}}
+
+			if got := p.canRead(tt.row); got != tt.wantRead {
+				t.Errorf("canRead() = %v, want %v", got, tt.wantRead)
+			}
+
+			if got := p.canManage(tt.row); got != tt.wantManage {
+				t.Errorf("canManage() = %v, want %v", got, tt.wantManage)
+			}
+		})
+	}
+}
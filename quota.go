@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/djavorszky/ddn-api/database/data"
+	"github.com/djavorszky/ddn-api/registry"
+	"github.com/djavorszky/ddn-common/errs"
+	"github.com/djavorszky/ddn-common/inet"
+	"github.com/djavorszky/ddn-common/logger"
+)
+
+const megabyte = 1 << 20
+
+// Limits are the quotas of a single user, group or agent. Zero means
+// that there is no limit. The dump size is in bytes.
+type Limits struct {
+	Databases int   `json:"databases"`
+	Imports   int   `json:"imports"`
+	DumpSize  int64 `json:"dump_size"`
+}
+
+// quotaRequest describes the database a request is about to add, and who
+// it is going to belong to.
+type quotaRequest struct {
+	User, Group, Agent string
+
+	DumpSize int64
+	Import   bool
+}
+
+// quotaScope is a user, group or agent that has limits.
+type quotaScope struct {
+	scope, name string
+}
+
+// quotaError is returned if a request would exceed one of the limits.
+type quotaError struct {
+	Scope, Name, Limit string
+	Max                int64
+}
+
+func (e quotaError) Error() string {
+	switch e.Limit {
+	case "imports":
+		return fmt.Sprintf("%s %s reached its limit of %d imports in progress", e.Scope, e.Name, e.Max)
+	case "dump_size":
+		return fmt.Sprintf("%s %s would exceed its limit of %d MB of dumps", e.Scope, e.Name, e.Max/megabyte)
+	default:
+		return fmt.Sprintf("%s %s reached its limit of %d databases", e.Scope, e.Name, e.Max)
+	}
+}
+
+func quotaLimits(scope string) Limits {
+	switch scope {
+	case data.ScopeUser:
+		return Limits{config.QuotaUserDatabases, config.QuotaUserImports, config.QuotaUserDumpSize * megabyte}
+	case data.ScopeGroup:
+		return Limits{config.QuotaGroupDatabases, config.QuotaGroupImports, config.QuotaGroupDumpSize * megabyte}
+	case data.ScopeAgent:
+		return Limits{config.QuotaAgentDatabases, config.QuotaAgentImports, config.QuotaAgentDumpSize * megabyte}
+	}
+
+	return Limits{}
+}
+
+// exceeded returns the quotaError of the first limit that adding the
+// database of the request would exceed, or nil.
+func (l Limits) exceeded(usage data.Usage, req quotaRequest) *quotaError {
+	switch {
+	case l.Databases > 0 && usage.Databases+1 > l.Databases:
+		return &quotaError{Limit: "databases", Max: int64(l.Databases)}
+	case req.Import && l.Imports > 0 && usage.Imports+1 > l.Imports:
+		return &quotaError{Limit: "imports", Max: int64(l.Imports)}
+	case req.DumpSize > 0 && l.DumpSize > 0 && usage.DumpSize+req.DumpSize > l.DumpSize:
+		return &quotaError{Limit: "dump_size", Max: l.DumpSize}
+	}
+
+	return nil
+}
+
+// checkQuota verifies that the user, the group and the agent of the
+// request all stay within their limits if the database is added.
+func checkQuota(req quotaRequest) error {
+	scopes := []quotaScope{
+		{data.ScopeUser, req.User},
+		{data.ScopeGroup, req.Group},
+		{data.ScopeAgent, req.Agent},
+	}
+
+	for _, s := range scopes {
+		limits := quotaLimits(s.scope)
+		if s.name == "" || limits == (Limits{}) {
+			continue
+		}
+
+		usage, err := db.FetchUsage(s.scope, s.name)
+		if err != nil {
+			return fmt.Errorf("fetching usage of %s %s: %v", s.scope, s.name, err)
+		}
+
+		if qe := limits.exceeded(usage, req); qe != nil {
+			qe.Scope, qe.Name = s.scope, s.name
+
+			return *qe
+		}
+	}
+
+	return nil
+}
+
+// sendQuotaFailure responds to an API request that failed checkQuota.
+func sendQuotaFailure(w http.ResponseWriter, err error) {
+	if qe, ok := err.(quotaError); ok {
+		inet.SendFailure(w, http.StatusForbidden, errQuotaExceeded, qe.Scope, qe.Name, qe.Limit)
+		return
+	}
+
+	inet.SendFailure(w, http.StatusInternalServerError, errs.QueryFailed, err.Error())
+
+	logger.Error("quota check failed: %v", err)
+}
+
+// dumpSize returns the size of the dump at loc, which is either a file in
+// the mounted folder or a URL. Zero is returned if the size can't be told.
+func dumpSize(loc string) int64 {
+	if strings.HasPrefix(loc, "http://") || strings.HasPrefix(loc, "https://") {
+		client := http.Client{Timeout: 10 * time.Second}
+
+		resp, err := client.Head(loc)
+		if err != nil {
+			logger.Debug("couldn't tell size of %s: %v", loc, err)
+			return 0
+		}
+		resp.Body.Close()
+
+		if resp.ContentLength < 0 {
+			return 0
+		}
+
+		return resp.ContentLength
+	}
+
+	path, err := mountedPath(loc)
+	if err != nil {
+		return 0
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+
+	return info.Size()
+}
+
+// mountedPath returns where the location is in the mounted folder, or an
+// error if it points outside of it, e.g. through "..".
+func mountedPath(loc string) (string, error) {
+	if config.MountLoc == "" {
+		return "", fmt.Errorf("no folder mounted")
+	}
+
+	path := filepath.Join(config.MountLoc, loc)
+
+	rel, err := filepath.Rel(config.MountLoc, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside of the mounted folder", loc)
+	}
+
+	return path, nil
+}
+
+type quotaStatus struct {
+	Scope  string     `json:"scope"`
+	Name   string     `json:"name"`
+	Usage  data.Usage `json:"usage"`
+	Limits Limits     `json:"limits"`
+}
+
+// apiQuota shows the usage of the caller, their groups and, if they can
+// see them, the agents against their limits.
+func apiQuota(w http.ResponseWriter, r *http.Request) {
+	p, err := getAPIPrincipal(r)
+	if err != nil {
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return
+	}
+
+	scopes := []quotaScope{{data.ScopeUser, p.Email}}
+
+	for _, group := range p.Groups {
+		scopes = append(scopes, quotaScope{data.ScopeGroup, group})
+	}
+
+	if p.can(actListAgents) {
+		for _, agent := range registry.List() {
+			scopes = append(scopes, quotaScope{data.ScopeAgent, agent.ShortName})
+		}
+	}
+
+	result := make([]quotaStatus, 0, len(scopes))
+	for _, s := range scopes {
+		usage, err := db.FetchUsage(s.scope, s.name)
+		if err != nil {
+			inet.SendFailure(w, http.StatusInternalServerError, errs.QueryFailed, err.Error())
+
+			logger.Error("Fetching usage failed: %v", err)
+			return
+		}
+
+		result = append(result, quotaStatus{
+			Scope:  s.scope,
+			Name:   s.name,
+			Usage:  usage,
+			Limits: quotaLimits(s.scope),
+		})
+	}
+
+	inet.SendSuccess(w, http.StatusOK, result)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/djavorszky/ddn-api/database/data"
+	"github.com/djavorszky/ddn-common/model"
+)
+
+func Test_limitsExceeded(t *testing.T) {
+	limits := Limits{Databases: 3, Imports: 1, DumpSize: 10 * megabyte}
+
+	tests := []struct {
+		name  string
+		usage data.Usage
+		req   quotaRequest
+		want  string
+	}{
+		{"within limits", data.Usage{Databases: 1}, quotaRequest{}, ""},
+		{"databases", data.Usage{Databases: 3}, quotaRequest{}, "databases"},
+		{"imports", data.Usage{Databases: 1, Imports: 1}, quotaRequest{Import: true}, "imports"},
+		{"imports ignored on create", data.Usage{Databases: 1, Imports: 1}, quotaRequest{}, ""},
+		{"dump size", data.Usage{DumpSize: 6 * megabyte}, quotaRequest{DumpSize: 5 * megabyte, Import: true}, "dump_size"},
+		{"dump size fits", data.Usage{DumpSize: 5 * megabyte}, quotaRequest{DumpSize: 5 * megabyte, Import: true}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := limits.exceeded(tt.usage, tt.req)
+
+			switch {
+			case tt.want == "" && got != nil:
+				t.Errorf("exceeded() = %v, expected nil", got)
+			case tt.want != "" && (got == nil || got.Limit != tt.want):
+				t.Errorf("exceeded() = %v, expected %s", got, tt.want)
+			}
+		})
+	}
+
+	if got := (Limits{}).exceeded(data.Usage{Databases: 1000, Imports: 1000}, quotaRequest{Import: true, DumpSize: 1}); got != nil {
+		t.Errorf("exceeded() of zero limits = %v, expected nil", got)
+	}
+}
+
+func Test_quotaLimits(t *testing.T) {
+	defer func(c Config) { config = c }(config)
+
+	config.QuotaUserDatabases = 5
+	config.QuotaGroupImports = 2
+	config.QuotaAgentDumpSize = 100
+
+	if got := quotaLimits(data.ScopeUser); got != (Limits{Databases: 5}) {
+		t.Errorf("quotaLimits(user) = %+v", got)
+	}
+
+	if got := quotaLimits(data.ScopeGroup); got != (Limits{Imports: 2}) {
+		t.Errorf("quotaLimits(group) = %+v", got)
+	}
+
+	if got := quotaLimits(data.ScopeAgent); got != (Limits{DumpSize: 100 * megabyte}) {
+		t.Errorf("quotaLimits(agent) = %+v", got)
+	}
+}
+
+func Test_quotaErrorMessage(t *testing.T) {
+	err := quotaError{Scope: data.ScopeUser, Name: "jane@example.com", Limit: "dump_size", Max: 10 * megabyte}
+
+	want := "user jane@example.com would exceed its limit of 10 MB of dumps"
+	if got := err.Error(); got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+}
+
+func Test_mountedPath(t *testing.T) {
+	defer func(orig string) { config.MountLoc = orig }(config.MountLoc)
+
+	config.MountLoc = "/mnt/dumps"
+
+	tests := []struct {
+		loc     string
+		want    string
+		wantErr bool
+	}{
+		{"/dump.sql", "/mnt/dumps/dump.sql", false},
+		{"/folder/../dump.sql", "/mnt/dumps/dump.sql", false},
+		{"/..dump.sql", "/mnt/dumps/..dump.sql", false},
+		{"/../etc/passwd", "", true},
+		{"/folder/../../etc/passwd", "", true},
+		{"/..", "", true},
+	}
+	for _, tt := range tests {
+		got, err := mountedPath(tt.loc)
+		if (err != nil) != tt.wantErr || got != filepath.FromSlash(tt.want) {
+			t.Errorf("mountedPath(%q) = %q, %v, want %q, wantErr %v", tt.loc, got, err, tt.want, tt.wantErr)
+		}
+	}
+
+	config.MountLoc = ""
+
+	if _, err := mountedPath("/dump.sql"); err == nil {
+		t.Errorf("mountedPath() without a mounted folder returned no error")
+	}
+}
+
+func TestImportOutsideMount(t *testing.T) {
+	ts := newTestServer(t)
+
+	config.MountLoc = t.TempDir()
+
+	ts.startAgent("fake")
+
+	token := ts.login("user@example.com").issueToken("mount")
+
+	req := apiDatabaseRequest{ClientRequest: model.ClientRequest{AgentIdentifier: "fake", DumpLocation: "/../../etc/passwd"}}
+	if code := ts.api(http.MethodPost, "/api/databases/import", token, req, nil); code != http.StatusBadRequest {
+		t.Errorf("importing from outside the mounted folder: got %d, want %d", code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/djavorszky/ddn-api/database/data"
+	"github.com/djavorszky/ddn-api/mail"
+	"github.com/djavorszky/ddn-api/registry"
+	"github.com/djavorszky/ddn-common/logger"
+	"github.com/djavorszky/ddn-common/status"
+)
+
+// reconcileInterval is how often the databases are checked for being
+// stuck in progress.
+const reconcileInterval = 5 * time.Minute
+
+// Phases of the work on a database. The statuses within a phase share
+// the timeout after which a database is considered stuck.
+const (
+	phaseCreate = "create"
+	phaseCopy   = "copy"
+	phaseImport = "import"
+	phaseExport = "export"
+	phaseDrop   = "drop"
+)
+
+var statusPhases = map[int]string{
+	status.InProgress:         phaseCreate,
+	status.CopyInProgress:     phaseCopy,
+	status.Started:            phaseImport,
+	status.DownloadInProgress: phaseImport,
+	status.ExtractingArchive:  phaseImport,
+	status.ValidatingDump:     phaseImport,
+	status.ImportInProgress:   phaseImport,
+	status.ExportInProgress:   phaseExport,
+	status.UploadInProgress:   phaseExport,
+	status.ArchivingDump:      phaseExport,
+	status.DropInProgress:     phaseDrop,
+}
+
+// phaseFailed is the status of the databases that failed in the phase.
+var phaseFailed = map[string]int{
+	phaseCreate: status.CreateDatabaseFailed,
+	phaseCopy:   status.ImportFailed,
+	phaseImport: status.ImportFailed,
+	phaseExport: status.ExportFailed,
+	phaseDrop:   status.DropDatabaseFailed,
+}
+
+var defaultStuckTimeouts = map[string]time.Duration{
+	phaseCreate: time.Hour,
+	phaseCopy:   2 * time.Hour,
+	phaseImport: 12 * time.Hour,
+	phaseExport: 12 * time.Hour,
+	phaseDrop:   time.Hour,
+}
+
+// reconcile checks for stuck databases when the server starts, then
+// periodically.
+//
+// Reconcile should always be ran in a goroutine.
+func reconcile() {
+	reconcileStuck(time.Now())
+
+	ticker := time.NewTicker(reconcileInterval)
+
+	for now := range ticker.C {
+		reconcileStuck(now)
+	}
+}
+
+// reconcileStuck settles the databases that have been in progress longer
+// than the timeout of their phase, without a job working on them. The
+// agent of the database is asked whether it has the database, and the
+// database is set to failed or succeeded accordingly.
+func reconcileStuck(now time.Time) {
+	rows, err := db.FetchAll()
+	if err != nil {
+		logger.Error("Failed listing databases: %v", err)
+		return
+	}
+
+	// The databases of each agent, listed once per pass.
+	listed := make(map[string][]string)
+	listErrs := make(map[string]error)
+
+	for _, row := range rows {
+		phase, ok := statusPhases[row.Status]
+		if !ok || now.Sub(row.UpdateDate) < stuckTimeout(phase) {
+			continue
+		}
+
+		busy, err := hasActiveJob(row.ID)
+		if err != nil {
+			logger.Error("failed fetching jobs of database %d: %v", row.ID, err)
+			continue
+		}
+
+		if busy {
+			continue
+		}
+
+		names, ok := listed[row.AgentName]
+		if !ok && listErrs[row.AgentName] == nil {
+			names, err = listAgentDatabases(row.AgentName)
+			if err != nil {
+				listErrs[row.AgentName] = err
+			} else {
+				listed[row.AgentName] = names
+			}
+		}
+
+		// The agent may have reported progress while it was asked.
+		current, err := db.FetchByID(row.ID)
+		if err != nil || current.ID == 0 || current.Status != row.Status || !current.UpdateDate.Equal(row.UpdateDate) {
+			continue
+		}
+
+		settleStuck(current, phase, contains(names, row.DBName), listErrs[row.AgentName])
+	}
+}
+
+// settleStuck sets the stuck database in the phase to its outcome and
+// notifies its creator. exists tells whether the agent has the database,
+// unless the agent could not be asked.
+func settleStuck(row data.Row, phase string, exists bool, agentErr error) {
+	before := row.Status
+	stuck := stuckTimeout(phase)
+
+	switch {
+	case agentErr != nil:
+		row.Status = phaseFailed[phase]
+		row.Message = fmt.Sprintf("Reconciler: no progress for %s, and agent %q could not be asked: %v", stuck, row.AgentName, agentErr)
+	case phase == phaseCreate && exists:
+		row.Status = status.Success
+		row.Message = "Reconciler: the database exists on the agent"
+	case phase == phaseDrop && !exists:
+		if err := db.Delete(row); err != nil {
+			logger.Error("failed removing dropped database %d: %v", row.ID, err)
+			return
+		}
+
+		auditDatabase(nil, systemActor, auditReconcile, row, before, "dropped on the agent")
+
+		notifyReconciled(row, fmt.Sprintf("Database %s has been dropped.", row.DBName))
+		return
+	case exists:
+		row.Status = phaseFailed[phase]
+		row.Message = fmt.Sprintf("Reconciler: no progress for %s, the %s of the database is incomplete", stuck, phase)
+	default:
+		row.Status = phaseFailed[phase]
+		row.Message = fmt.Sprintf("Reconciler: no progress for %s, and the database does not exist on the agent", stuck)
+	}
+
+	if err := db.Update(&row); err != nil {
+		logger.Error("failed updating stuck database %d: %v", row.ID, err)
+		return
+	}
+
+	auditDatabase(nil, systemActor, auditReconcile, row, before, row.Message)
+
+	logger.Warn("Database %d was stuck: %s", row.ID, row.Message)
+
+	notifyReconciled(row, fmt.Sprintf("Database %s: %s", row.DBName, row.StatusLabel()))
+}
+
+// notifyReconciled tells the creator of the database what the reconciler
+// decided.
+func notifyReconciled(row data.Row, message string) {
+	mail.Send(row.Creator, fmt.Sprintf("[Cloud DB] Database %q: %s", row.DBName, row.StatusLabel()), fmt.Sprintf(`
+<h3>Database stopped making progress</h3>
+
+<p>The database %q made no progress for a while, so its status was checked on agent %q.</p>
+<p>%s</p>
+<p>Visit <a href="http://cloud-db.liferay.int">Cloud DB</a> for the details.</p>`, row.DBName, row.AgentName, message))
+
+	err := sendUserNotifications(row.Creator, message)
+	if err != nil {
+		logger.Error("failed notifying user: %v", err)
+	}
+}
+
+// listAgentDatabases returns the databases the agent has, if it's up.
+func listAgentDatabases(shortName string) ([]string, error) {
+	agent, ok := registry.Get(shortName)
+	if !ok {
+		return nil, fmt.Errorf("agent is not registered")
+	}
+
+	if !agent.Up {
+		return nil, fmt.Errorf("agent is down")
+	}
+
+	return agent.ListDatabases()
+}
+
+// hasActiveJob returns true if a job is queued or running on the
+// database. Those are settled by the job.
+func hasActiveJob(ID int) (bool, error) {
+	jobs, err := db.FetchJobs(data.JobFilter{Target: ID})
+	if err != nil {
+		return false, err
+	}
+
+	for _, job := range jobs {
+		if !job.Done() {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func stuckTimeout(phase string) time.Duration {
+	v, ok := config.StuckTimeouts[phase]
+	if !ok {
+		return defaultStuckTimeouts[phase]
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		logger.Warn("Invalid stuck-timeouts %s = %q, using default", phase, v)
+
+		return defaultStuckTimeouts[phase]
+	}
+
+	return d
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
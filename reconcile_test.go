@@ -0,0 +1,102 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/djavorszky/ddn-api/database/data"
+	"github.com/djavorszky/ddn-common/status"
+)
+
+func Test_stuckTimeout(t *testing.T) {
+	defer func(orig Config) { config = orig }(config)
+
+	config = Config{StuckTimeouts: map[string]string{phaseImport: "30m", phaseExport: "soon", phaseDrop: "-1h"}}
+
+	tests := []struct {
+		phase string
+		want  time.Duration
+	}{
+		{phaseImport, 30 * time.Minute},
+		{phaseExport, defaultStuckTimeouts[phaseExport]},
+		{phaseDrop, defaultStuckTimeouts[phaseDrop]},
+		{phaseCreate, defaultStuckTimeouts[phaseCreate]},
+	}
+	for _, tt := range tests {
+		if got := stuckTimeout(tt.phase); got != tt.want {
+			t.Errorf("stuckTimeout(%q) = %v, want %v", tt.phase, got, tt.want)
+		}
+	}
+}
+
+func TestReconcileStuck(t *testing.T) {
+	ts := newTestServer(t)
+	config.StuckTimeouts = map[string]string{phaseImport: "1m"}
+
+	agent := ts.startAgent("fake-mysql")
+	agent.has("created_db", "importing_db")
+
+	rows := map[string]*data.Row{
+		"created_db":   {Status: status.InProgress},
+		"missing_db":   {Status: status.InProgress},
+		"importing_db": {Status: status.ImportInProgress},
+		"dropped_db":   {Status: status.DropInProgress},
+		"exporting_db": {Status: status.ExportInProgress},
+		"queued_db":    {Status: status.InProgress},
+		"orphan_db":    {Status: status.ImportInProgress, AgentName: "gone-agent"},
+	}
+
+	for name, row := range rows {
+		row.DBName, row.DBUser, row.Creator = name, "user", "stuck@example.com"
+		if row.AgentName == "" {
+			row.AgentName = agent.name
+		}
+
+		if err := ts.db.Insert(row); err != nil {
+			t.Fatalf("Insert() failed: %v", err)
+		}
+	}
+
+	job := data.Job{Kind: data.JobRecreate, Target: rows["queued_db"].ID, Status: data.JobQueued, NextRun: time.Now().Add(time.Hour)}
+	if err := ts.db.InsertJob(&job); err != nil {
+		t.Fatalf("InsertJob() failed: %v", err)
+	}
+
+	reconcileStuck(time.Now().Add(90 * time.Minute))
+
+	want := map[string]int{
+		"created_db":   status.Success,
+		"missing_db":   status.CreateDatabaseFailed,
+		"importing_db": status.ImportFailed,
+		"exporting_db": status.ExportInProgress,
+		"queued_db":    status.InProgress,
+		"orphan_db":    status.ImportFailed,
+	}
+
+	for name, wantStatus := range want {
+		row, _ := ts.db.FetchByID(rows[name].ID)
+
+		if row.Status != wantStatus {
+			t.Errorf("status of %s = %d, want %d", name, row.Status, wantStatus)
+		}
+
+		reconciled := wantStatus != rows[name].Status
+		if reconciled != strings.HasPrefix(row.Message, "Reconciler: ") {
+			t.Errorf("message of %s = %q", name, row.Message)
+		}
+	}
+
+	if row, _ := ts.db.FetchByID(rows["dropped_db"].ID); row.ID != 0 {
+		t.Errorf("database dropped on the agent was not removed: %+v", row)
+	}
+
+	entries, err := ts.db.FetchAuditEntries(data.AuditFilter{Action: auditReconcile})
+	if err != nil {
+		t.Fatalf("FetchAuditEntries() failed: %v", err)
+	}
+
+	if len(entries) != 5 {
+		t.Errorf("audit log has %d reconciled databases, want 5", len(entries))
+	}
+}
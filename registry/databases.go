@@ -0,0 +1,43 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/djavorszky/ddn-common/inet"
+	"github.com/djavorszky/ddn-common/status"
+)
+
+// client is used for the requests the registry sends to the agents.
+var client = &http.Client{Timeout: 30 * time.Second}
+
+// ListDatabases asks the agent for the names of the databases it has.
+func (a Agent) ListDatabases() ([]string, error) {
+	dest := fmt.Sprintf("%s/list-databases", a.Address)
+
+	if !strings.HasPrefix(dest, "http://") && !strings.HasPrefix(dest, "https://") {
+		dest = fmt.Sprintf("http://%s", dest)
+	}
+
+	resp, err := client.Get(dest)
+	if err != nil {
+		return nil, fmt.Errorf("listing databases failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var msg inet.ListMessage
+
+	err = json.NewDecoder(resp.Body).Decode(&msg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid response to listing databases: %v", err)
+	}
+
+	if msg.Status != status.Success {
+		return nil, fmt.Errorf("agent issue: listing databases returned status %d", msg.Status)
+	}
+
+	return msg.Message, nil
+}
@@ -0,0 +1,49 @@
+package registry
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/djavorszky/ddn-common/inet"
+	"github.com/djavorszky/ddn-common/model"
+	"github.com/djavorszky/ddn-common/status"
+)
+
+func TestListDatabases(t *testing.T) {
+	var reply inet.JSONMessage
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/list-databases" {
+			t.Errorf("agent got %s %s", r.Method, r.URL.Path)
+		}
+
+		w.Write(reply.Compose())
+	}))
+	defer srv.Close()
+
+	agent := Agent{Agent: model.Agent{ShortName: name1, Address: srv.URL}}
+
+	reply = inet.ListMessage{Status: status.Success, Message: []string{"first", "second"}}
+
+	names, err := agent.ListDatabases()
+	if err != nil {
+		t.Fatalf("ListDatabases() failed: %v", err)
+	}
+
+	if len(names) != 2 || names[0] != "first" || names[1] != "second" {
+		t.Errorf("ListDatabases() = %v, should be [first second]", names)
+	}
+
+	reply = inet.Message{Status: status.ListDatabaseFailed, Message: "no connection"}
+
+	if _, err := agent.ListDatabases(); err == nil {
+		t.Errorf("ListDatabases() succeeded when the agent failed listing")
+	}
+
+	srv.Close()
+
+	if _, err := agent.ListDatabases(); err == nil {
+		t.Errorf("ListDatabases() succeeded with the agent gone")
+	}
+}
@@ -1,35 +1,245 @@
 package registry
 
 import (
+	"fmt"
 	"sort"
 	"sync"
+	"time"
 
+	"github.com/djavorszky/ddn-api/database/data"
+	"github.com/djavorszky/ddn-common/logger"
 	"github.com/djavorszky/ddn-common/model"
 )
 
+// Statuses of the agents in the registry.
+const (
+	// StatusUnknown is the status of agents that were loaded from the
+	// backend, until the first heartbeat check tells whether they're up.
+	StatusUnknown = "unknown"
+	StatusUp      = "up"
+	StatusDown    = "down"
+)
+
+// idSequence is the name of the sequence the IDs are persisted under.
+const idSequence = "registry"
+
+// Agent is a registered agent along with the state the server keeps
+// about it.
+type Agent struct {
+	model.Agent
+
+	Status string `json:"agent_status"`
+
+	// Mode tells whether the agent takes new databases. Agents that are
+	// draining or in maintenance don't.
+	Mode string `json:"agent_mode"`
+
+	// Labels describe the agent beyond its vendor, and new databases can
+	// be placed by them. They are the AgentLabels the agent registered
+	// with, overridden by the LabelOverrides of the admins, and are worked
+	// out when the agent is stored.
+	Labels         map[string]string `json:"labels,omitempty"`
+	AgentLabels    map[string]string `json:"agent_labels,omitempty"`
+	LabelOverrides map[string]string `json:"label_overrides,omitempty"`
+
+	// Capacity is what the agent last reported about its database server,
+	// if it ever did.
+	Capacity *Capacity `json:"capacity,omitempty"`
+}
+
+// Capacity is what an agent reports about its database server along with
+// its heartbeat. DiskFree is in bytes.
+type Capacity struct {
+	DiskFree      int64     `json:"disk_free"`
+	Databases     int       `json:"databases"`
+	ActiveImports int       `json:"active_imports"`
+	ServerVersion string    `json:"server_version"`
+	Reported      time.Time `json:"reported"`
+}
+
+// Accepting tells whether the agent takes new databases.
+func (a Agent) Accepting() bool {
+	return a.Mode == "" || a.Mode == data.ModeActive
+}
+
+// Backend persists the agents and the ID sequence of the registry, so
+// they survive restarts of the server.
+type Backend interface {
+	FetchAgents() ([]data.Agent, error)
+	SaveAgent(agent data.Agent) error
+	DeleteAgent(shortName string) error
+
+	FetchSequence(name string) (int, error)
+	UpdateSequence(name string, value int) error
+}
+
 var (
 	curID    = 0
-	ids      = make(chan int)
-	registry = make(map[string]model.Agent)
+	registry = make(map[string]Agent)
+	backend  Backend
+
+	rw   sync.RWMutex
+	idMu sync.Mutex
 
-	rw sync.RWMutex
+	// persistMu is held while a change is made and persisted, so that the
+	// changes reach the backend in the same order as the registry.
+	persistMu sync.Mutex
 )
 
-func init() {
-	go inc()
+// Load reads the persisted agents and ID sequence from b, and persists
+// all later changes to it. The loaded agents are marked as down with an
+// unknown status until they are checked.
+func Load(b Backend) error {
+	agents, err := b.FetchAgents()
+	if err != nil {
+		return fmt.Errorf("fetching agents: %v", err)
+	}
+
+	seq, err := b.FetchSequence(idSequence)
+	if err != nil {
+		return fmt.Errorf("fetching id sequence: %v", err)
+	}
+
+	rw.Lock()
+	for _, agent := range agents {
+		agent.Up = false
+
+		registry[agent.ShortName] = Agent{
+			Agent:          agent.Agent,
+			Status:         StatusUnknown,
+			Mode:           agent.Mode,
+			Labels:         mergeLabels(agent.Labels, agent.LabelOverrides),
+			AgentLabels:    agent.Labels,
+			LabelOverrides: agent.LabelOverrides,
+		}
+	}
+	backend = b
+	rw.Unlock()
+
+	idMu.Lock()
+	if seq > curID {
+		curID = seq
+	}
+	idMu.Unlock()
+
+	return nil
 }
 
 // Store registers the agent in the registry, or overwrites
 // if agent already in.
-func Store(agent model.Agent) {
+func Store(agent Agent) {
+	persistMu.Lock()
+	defer persistMu.Unlock()
+
+	agent.Labels = mergeLabels(agent.AgentLabels, agent.LabelOverrides)
+
 	rw.Lock()
 	registry[agent.ShortName] = agent
+	b := backend
 	rw.Unlock()
+
+	persist(b, agent)
+}
+
+// Update changes the agent registered with shortName through fn, and
+// returns the changed agent, or false if there's no such agent. Unlike
+// getting, changing and storing the agent, changes made at the same time
+// by others are not lost. fn must not call the registry, and must replace
+// the maps of the agent instead of changing them.
+func Update(shortName string, fn func(*Agent)) (Agent, bool) {
+	persistMu.Lock()
+	defer persistMu.Unlock()
+
+	agent, ok := update(shortName, fn)
+	if !ok {
+		return Agent{}, false
+	}
+
+	rw.RLock()
+	b := backend
+	rw.RUnlock()
+
+	persist(b, agent)
+
+	return agent, true
+}
+
+// update changes the agent in the registry through fn, without persisting
+// it.
+func update(shortName string, fn func(*Agent)) (Agent, bool) {
+	rw.Lock()
+	defer rw.Unlock()
+
+	agent, ok := registry[shortName]
+	if !ok {
+		return Agent{}, false
+	}
+
+	fn(&agent)
+
+	agent.Labels = mergeLabels(agent.AgentLabels, agent.LabelOverrides)
+	registry[shortName] = agent
+
+	return agent, true
+}
+
+func persist(b Backend, agent Agent) {
+	if b == nil {
+		return
+	}
+
+	err := b.SaveAgent(data.Agent{Agent: agent.Agent, Mode: agent.Mode, Labels: agent.AgentLabels, LabelOverrides: agent.LabelOverrides})
+	if err != nil {
+		logger.Error("failed persisting agent %q: %v", agent.ShortName, err)
+	}
+}
+
+// mergeLabels returns the labels with the overrides applied. An override
+// with an empty value removes the label.
+func mergeLabels(labels, overrides map[string]string) map[string]string {
+	merged := make(map[string]string)
+
+	for k, v := range labels {
+		merged[k] = v
+	}
+
+	for k, v := range overrides {
+		if v == "" {
+			delete(merged, k)
+			continue
+		}
+
+		merged[k] = v
+	}
+
+	if len(merged) == 0 {
+		return nil
+	}
+
+	return merged
+}
+
+// SetCapacity keeps the capacity the agent reported. It's not persisted, as
+// agents report it again on each heartbeat.
+func SetCapacity(shortName string, c Capacity) {
+	update(shortName, func(agent *Agent) {
+		agent.Capacity = &c
+	})
+}
+
+// ServerVersion returns the version of the database server the agent
+// reported, or the version it registered with if it didn't report one.
+func (a Agent) ServerVersion() string {
+	if a.Capacity != nil && a.Capacity.ServerVersion != "" {
+		return a.Capacity.ServerVersion
+	}
+
+	return a.Version
 }
 
 // Get returns the agent associated with the shortName, or
 // an error if no agent are registered with that name
-func Get(shortName string) (model.Agent, bool) {
+func Get(shortName string) (Agent, bool) {
 	rw.RLock()
 	agent, ok := registry[shortName]
 	rw.RUnlock()
@@ -40,14 +250,25 @@ func Get(shortName string) (model.Agent, bool) {
 // Remove removes the agent added with shortName. Does not error
 // if agent not in registry.
 func Remove(shortName string) {
+	persistMu.Lock()
+	defer persistMu.Unlock()
+
 	rw.Lock()
 	delete(registry, shortName)
+	b := backend
 	rw.Unlock()
+
+	if b != nil {
+		err := b.DeleteAgent(shortName)
+		if err != nil {
+			logger.Error("failed removing persisted agent %q: %v", shortName, err)
+		}
+	}
 }
 
 // List returns the list of agents as a slice
-func List() []model.Agent {
-	var agents []model.Agent
+func List() []Agent {
+	var agents []Agent
 
 	rw.RLock()
 	for _, c := range registry {
@@ -70,21 +291,31 @@ func Exists(shortName string) bool {
 	return ok
 }
 
-// ID returns a new ID that is unique
+// ID returns a new ID that is unique, even across restarts
+// if the registry was loaded from a backend.
 func ID() int {
-	return <-ids
-}
+	idMu.Lock()
+	defer idMu.Unlock()
+
+	curID++
 
-func inc() int {
-	for {
-		curID++
-		ids <- curID
+	rw.RLock()
+	b := backend
+	rw.RUnlock()
+
+	if b != nil {
+		err := b.UpdateSequence(idSequence, curID)
+		if err != nil {
+			logger.Error("failed persisting id sequence: %v", err)
+		}
 	}
+
+	return curID
 }
 
-// ByName implements sort.Interface for []model.Agent based on
+// ByName implements sort.Interface for []Agent based on
 // the ShortName field
-type ByName []model.Agent
+type ByName []Agent
 
 func (a ByName) Len() int           { return len(a) }
 func (a ByName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
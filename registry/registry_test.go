@@ -1,10 +1,13 @@
 package registry
 
 import (
+	"reflect"
+	"sync"
 	"testing"
 
 	"sort"
 
+	"github.com/djavorszky/ddn-api/database/data"
 	"github.com/djavorszky/ddn-common/model"
 )
 
@@ -22,9 +25,9 @@ const (
 )
 
 var (
-	c1 = model.Agent{ShortName: name1, LongName: long1}
-	c2 = model.Agent{ShortName: name2, LongName: long2}
-	c3 = model.Agent{ShortName: name3, LongName: long3}
+	c1 = Agent{Agent: model.Agent{ShortName: name1, LongName: long1}}
+	c2 = Agent{Agent: model.Agent{ShortName: name2, LongName: long2}}
+	c3 = Agent{Agent: model.Agent{ShortName: name3, LongName: long3}}
 )
 
 func setup() {
@@ -99,6 +102,88 @@ func TestStore(t *testing.T) {
 	}
 }
 
+func TestSetCapacity(t *testing.T) {
+	setup()
+	defer teardown()
+
+	SetCapacity(missing, Capacity{DiskFree: 1})
+
+	if _, ok := registry[missing]; ok {
+		t.Errorf("SetCapacity(%q) added an agent", missing)
+	}
+
+	if v := registry[name1].ServerVersion(); v != "" {
+		t.Errorf("ServerVersion() before a report = %q, want empty", v)
+	}
+
+	SetCapacity(name1, Capacity{DiskFree: 1024, ServerVersion: "5.7.21"})
+
+	c := registry[name1]
+	if c.Capacity == nil || c.Capacity.DiskFree != 1024 {
+		t.Errorf("SetCapacity(%q) kept %+v", name1, c.Capacity)
+	}
+
+	if v := c.ServerVersion(); v != "5.7.21" {
+		t.Errorf("ServerVersion() = %q, want %q", v, "5.7.21")
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	setup()
+	defer teardown()
+
+	if _, ok := Update(missing, func(*Agent) {}); ok {
+		t.Errorf("Update(%q) returned true", missing)
+	}
+
+	if _, ok := registry[missing]; ok {
+		t.Errorf("Update(%q) added an agent", missing)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+			Update(name1, func(a *Agent) { a.Up = !a.Up })
+		}()
+
+		go func() {
+			defer wg.Done()
+			Update(name1, func(a *Agent) {
+				a.Mode = data.ModeDraining
+				a.LabelOverrides = map[string]string{"purpose": "ci"}
+			})
+		}()
+	}
+	wg.Wait()
+
+	c := registry[name1]
+	if c.Mode != data.ModeDraining || c.Up || c.Labels["purpose"] != "ci" {
+		t.Errorf("Update(%q) lost changes, got mode %q, up %v, labels %v", name1, c.Mode, c.Up, c.Labels)
+	}
+}
+
+func Test_mergeLabels(t *testing.T) {
+	tests := []struct {
+		name              string
+		labels, overrides map[string]string
+		want              map[string]string
+	}{
+		{"none", nil, nil, nil},
+		{"registered", map[string]string{"purpose": "ci"}, nil, map[string]string{"purpose": "ci"}},
+		{"added", map[string]string{"purpose": "ci"}, map[string]string{"disk": "large"}, map[string]string{"purpose": "ci", "disk": "large"}},
+		{"overridden", map[string]string{"purpose": "ci"}, map[string]string{"purpose": "repro"}, map[string]string{"purpose": "repro"}},
+		{"removed", map[string]string{"purpose": "ci"}, map[string]string{"purpose": ""}, nil},
+	}
+	for _, tt := range tests {
+		if got := mergeLabels(tt.labels, tt.overrides); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: mergeLabels() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
 func TestList(t *testing.T) {
 	setup()
 	defer teardown()
@@ -144,7 +229,7 @@ func TestID(t *testing.T) {
 }
 
 func TestSort(t *testing.T) {
-	var list = []model.Agent{c3, c2, c1}
+	var list = []Agent{c3, c2, c1}
 
 	if list[0].ShortName < list[1].ShortName && list[1].ShortName < list[2].ShortName {
 		t.Errorf("List is sorted to begin with")
@@ -157,3 +242,131 @@ func TestSort(t *testing.T) {
 	}
 
 }
+
+type memBackend struct {
+	agents  map[string]data.Agent
+	seq     int
+	deleted []string
+}
+
+func (m *memBackend) FetchAgents() ([]data.Agent, error) {
+	var agents []data.Agent
+	for _, a := range m.agents {
+		agents = append(agents, a)
+	}
+
+	return agents, nil
+}
+
+func (m *memBackend) SaveAgent(agent data.Agent) error {
+	m.agents[agent.ShortName] = agent
+	return nil
+}
+
+func (m *memBackend) DeleteAgent(shortName string) error {
+	delete(m.agents, shortName)
+	m.deleted = append(m.deleted, shortName)
+	return nil
+}
+
+func (m *memBackend) FetchSequence(name string) (int, error) {
+	return m.seq, nil
+}
+
+func (m *memBackend) UpdateSequence(name string, value int) error {
+	m.seq = value
+	return nil
+}
+
+func TestLoad(t *testing.T) {
+	defer func(id int) {
+		backend = nil
+		curID = id
+		teardown()
+	}(curID)
+
+	b := &memBackend{
+		agents: map[string]data.Agent{name1: {
+			Agent:          model.Agent{ShortName: name1, LongName: long1, Up: true},
+			Mode:           data.ModeDraining,
+			Labels:         map[string]string{"purpose": "ci", "disk": "small"},
+			LabelOverrides: map[string]string{"disk": "large"},
+		}},
+		seq: 100,
+	}
+
+	err := Load(b)
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+
+	agent, ok := Get(name1)
+	if !ok {
+		t.Fatalf("Load() did not load agent %q", name1)
+	}
+
+	if agent.Up || agent.Status != StatusUnknown {
+		t.Errorf("Loaded agent should be down with unknown status, got up=%v status=%q", agent.Up, agent.Status)
+	}
+
+	if agent.Mode != data.ModeDraining || agent.Accepting() {
+		t.Errorf("Loaded agent should keep its mode %q, got %q", data.ModeDraining, agent.Mode)
+	}
+
+	if agent.Labels["purpose"] != "ci" || agent.Labels["disk"] != "large" {
+		t.Errorf("Loaded agent should have its labels overridden, got %v", agent.Labels)
+	}
+
+	if id := ID(); id != 101 {
+		t.Errorf("ID() after Load() = %d, should continue from 101", id)
+	}
+
+	if b.seq != 101 {
+		t.Errorf("ID() did not persist the sequence, backend has %d", b.seq)
+	}
+
+	Store(c2)
+	if _, ok := b.agents[name2]; !ok {
+		t.Errorf("Store(%q) did not persist the agent", name2)
+	}
+
+	Remove(name2)
+	if _, ok := b.agents[name2]; ok {
+		t.Errorf("Remove(%q) did not remove the persisted agent", name2)
+	}
+}
+
+func TestPersistOrder(t *testing.T) {
+	defer func() {
+		backend = nil
+		teardown()
+	}()
+
+	b := &memBackend{agents: make(map[string]data.Agent)}
+	if err := Load(b); err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+			Store(c1)
+		}()
+
+		go func() {
+			defer wg.Done()
+			Remove(name1)
+		}()
+	}
+	wg.Wait()
+
+	_, stored := registry[name1]
+	_, persisted := b.agents[name1]
+
+	if stored != persisted {
+		t.Errorf("agent in the registry: %v, persisted: %v", stored, persisted)
+	}
+}
@@ -53,7 +53,7 @@ var routes = Routes{
 		"create",
 		http.MethodPost,
 		"/create",
-		createAction,
+		csrfProtected(createAction),
 	},
 	route{
 		"createdb",
@@ -65,13 +65,13 @@ var routes = Routes{
 		"import",
 		http.MethodPost,
 		"/import",
-		importAction,
+		csrfProtected(importAction),
 	},
 	route{
 		"prepimport",
 		http.MethodPost,
 		"/prepimport",
-		prepImportAction,
+		csrfProtected(prepImportAction),
 	},
 	route{
 		"importdb",
@@ -109,6 +109,18 @@ var routes = Routes{
 		"/login",
 		login,
 	},
+	route{
+		"loginRedirect",
+		http.MethodGet,
+		"/login/redirect",
+		loginRedirect,
+	},
+	route{
+		"loginCallback",
+		http.MethodGet,
+		"/login/callback",
+		loginCallback,
+	},
 	route{
 		"logout",
 		http.MethodGet,
@@ -182,6 +194,30 @@ var routes = Routes{
 		"/api/agents/{agent:[a-zA-Z0-9-_]+}",
 		getAPIAgentByName,
 	},
+	route{
+		"api/agents/$agent-name/mode",
+		http.MethodPut,
+		"/api/agents/{agent:[a-zA-Z0-9-_]+}/mode/{mode:active|draining|maintenance}",
+		apiSetAgentMode,
+	},
+	route{
+		"api/agents/$agent-name/labels",
+		http.MethodPut,
+		"/api/agents/{agent:[a-zA-Z0-9-_]+}/labels",
+		apiSetAgentLabels,
+	},
+	route{
+		"api/agents/$agent-name/health",
+		http.MethodGet,
+		"/api/agents/{agent:[a-zA-Z0-9-_]+}/health",
+		apiAgentHealth,
+	},
+	route{
+		"api/agents/$agent-name/drained",
+		http.MethodGet,
+		"/api/agents/{agent:[a-zA-Z0-9-_]+}/drained",
+		apiWaitDrained,
+	},
 	route{
 		"api/databases",
 		http.MethodGet,
@@ -245,13 +281,13 @@ var routes = Routes{
 	route{
 		"api/databases/visibility",
 		http.MethodPut,
-		"/api/databases/{id:[0-9]+}/visibility/{visibility:public|private}",
+		"/api/databases/{id:[0-9]+}/visibility/{visibility:public|private|group}",
 		apiSetVisibility,
 	},
 	route{
 		"api/databases/expiry",
 		http.MethodPut,
-		"/api/databases/{id:[0-9]+}/expiry/extend/{amount:[0-9]+}/{unit:days|months|years}",
+		"/api/databases/{id:[0-9]+}/expiry/extend/{amount:[0-9]+}/{unit:days|months|years|year}",
 		apiExtendExpiry,
 	},
 	route{
@@ -266,6 +302,126 @@ var routes = Routes{
 		"/api/databases/{agent:[a-zA-Z][a-zA-Z0-9-_]+}/{dbname:[a-zA-Z0-9-_]+}/accessinfo",
 		apiAccessInfoByAgentDB,
 	},
+	route{
+		"api/tokens",
+		http.MethodGet,
+		"/api/tokens",
+		apiListTokens,
+	},
+	route{
+		"api/tokens/create",
+		http.MethodPost,
+		"/api/tokens",
+		apiCreateToken,
+	},
+	route{
+		"api/tokens/id",
+		http.MethodDelete,
+		"/api/tokens/{id:[0-9]+}",
+		apiRevokeToken,
+	},
+	route{
+		"api/databases/id/owner/user",
+		http.MethodPut,
+		"/api/databases/{id:[0-9]+}/owner/user/{email}",
+		apiTransferToUser,
+	},
+	route{
+		"api/databases/id/owner/group",
+		http.MethodPut,
+		"/api/databases/{id:[0-9]+}/owner/group/{group:[a-zA-Z0-9-_]+}",
+		apiTransferToGroup,
+	},
+	route{
+		"api/groups",
+		http.MethodGet,
+		"/api/groups",
+		apiListGroups,
+	},
+	route{
+		"api/groups/create",
+		http.MethodPost,
+		"/api/groups",
+		apiCreateGroup,
+	},
+	route{
+		"api/groups/group/members/email",
+		http.MethodPut,
+		"/api/groups/{group:[a-zA-Z0-9-_]+}/members/{email}",
+		apiAddGroupMember,
+	},
+	route{
+		"api/groups/group/members/email",
+		http.MethodDelete,
+		"/api/groups/{group:[a-zA-Z0-9-_]+}/members/{email}",
+		apiRemoveGroupMember,
+	},
+	route{
+		"api/quota",
+		http.MethodGet,
+		"/api/quota",
+		apiQuota,
+	},
+	route{
+		"api/audit",
+		http.MethodGet,
+		"/api/audit",
+		apiAudit,
+	},
+	route{
+		"api/jobs",
+		http.MethodGet,
+		"/api/jobs",
+		apiListJobs,
+	},
+	route{
+		"api/jobs/id",
+		http.MethodGet,
+		"/api/jobs/{id:[0-9]+}",
+		apiGetJob,
+	},
+	route{
+		"api/jobs/id/cancel",
+		http.MethodPut,
+		"/api/jobs/{id:[0-9]+}/cancel",
+		apiCancelJob,
+	},
+	route{
+		"api/jobs/id/retry",
+		http.MethodPut,
+		"/api/jobs/{id:[0-9]+}/retry",
+		apiRetryJob,
+	},
+	route{
+		"api/users",
+		http.MethodGet,
+		"/api/users",
+		apiListUsers,
+	},
+	route{
+		"api/users/email/role",
+		http.MethodPut,
+		"/api/users/{email}/role/{role:admin|user|viewer}",
+		apiSetRole,
+	},
+	route{
+		"api/inventory",
+		http.MethodGet,
+		"/api/inventory",
+		apiInventory,
+	},
+	route{
+		"api/inventory/take",
+		http.MethodPost,
+		"/api/inventory",
+		apiTakeInventory,
+	},
+	route{
+		"api/lifecycle",
+		http.MethodGet,
+		"/api/lifecycle",
+		apiLifecycle,
+	},
 	route{
 		"api/loglevel",
 		http.MethodPut,
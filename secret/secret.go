@@ -0,0 +1,270 @@
+// Package secret encrypts the credentials that are kept in the metadata
+// store, so that a copy of the database doesn't give away the passwords
+// of every database created through the server.
+//
+// Values are encrypted with envelope encryption: every value gets its own
+// random data key, which is in turn encrypted with a key of the Keyring.
+// Rotating a key only needs the data keys to be encrypted again.
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// prefix marks the values that were encrypted by a Keyring. It is
+// followed by the ID of the key, the encrypted data key and the
+// encrypted value, separated by colons.
+const prefix = "enc:v1:"
+
+const dataKeySize = 32
+
+var (
+	// ErrUnknownKey is returned when decrypting a value that was encrypted
+	// with a key the Keyring doesn't have.
+	ErrUnknownKey = errors.New("value encrypted with unknown key")
+
+	// ErrMalformed is returned when decrypting a value that looks encrypted
+	// but can't be parsed.
+	ErrMalformed = errors.New("malformed encrypted value")
+)
+
+var encoding = base64.RawURLEncoding
+
+// Keyring holds the keys used to encrypt and decrypt values. New values
+// are always encrypted with the primary key, the rest of the keys are
+// only kept around to decrypt values encrypted before a rotation.
+type Keyring struct {
+	primary string
+	keys    map[string]cipher.AEAD
+}
+
+// NewKeyring creates a Keyring from base64 encoded AES keys, the first of
+// which is the primary one. A Keyring without keys leaves values as they
+// are.
+func NewKeyring(keys ...string) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string]cipher.AEAD)}
+
+	for i, key := range keys {
+		raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+		if err != nil {
+			return nil, fmt.Errorf("key %d is not base64 encoded: %v", i+1, err)
+		}
+
+		aead, err := newAEAD(raw)
+		if err != nil {
+			return nil, fmt.Errorf("key %d: %v", i+1, err)
+		}
+
+		id := keyID(raw)
+		if i == 0 {
+			k.primary = id
+		}
+
+		k.keys[id] = aead
+	}
+
+	return k, nil
+}
+
+// GenerateKey returns a new random key in the form NewKeyring expects.
+func GenerateKey() (string, error) {
+	key := make([]byte, 32)
+
+	_, err := io.ReadFull(rand.Reader, key)
+	if err != nil {
+		return "", fmt.Errorf("failed generating key: %v", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// Enabled returns whether the Keyring has a key to encrypt with.
+func (k *Keyring) Enabled() bool {
+	return k.primary != ""
+}
+
+// Encrypt encrypts the value with a new data key, which is encrypted with
+// the primary key. Empty values, and every value if the Keyring has no
+// keys, are returned as they are.
+func (k *Keyring) Encrypt(value string) (string, error) {
+	if value == "" || !k.Enabled() {
+		return value, nil
+	}
+
+	dataKey := make([]byte, dataKeySize)
+
+	_, err := io.ReadFull(rand.Reader, dataKey)
+	if err != nil {
+		return "", fmt.Errorf("failed generating data key: %v", err)
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	sealedKey, err := seal(k.keys[k.primary], dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	sealedValue, err := seal(aead, []byte(value))
+	if err != nil {
+		return "", err
+	}
+
+	return k.format(sealedKey, sealedValue), nil
+}
+
+// Decrypt returns the plaintext of the value. Values that were never
+// encrypted are returned as they are.
+func (k *Keyring) Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+
+	dataKey, sealedValue, err := k.open(value)
+	if err != nil {
+		return "", err
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	plain, err := unseal(aead, sealedValue)
+	if err != nil {
+		return "", err
+	}
+
+	return string(plain), nil
+}
+
+// Current returns whether the value is stored the way Encrypt would store
+// it now, i.e. it doesn't need to be encrypted or rotated.
+func (k *Keyring) Current(value string) bool {
+	if value == "" || !k.Enabled() {
+		return true
+	}
+
+	return IsEncrypted(value) && strings.HasPrefix(value, prefix+k.primary+":")
+}
+
+// Rotate encrypts the data key of the value with the primary key, leaving
+// the encrypted value itself untouched. Values that were never encrypted
+// are encrypted.
+func (k *Keyring) Rotate(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return k.Encrypt(value)
+	}
+
+	if k.Current(value) {
+		return value, nil
+	}
+
+	dataKey, sealedValue, err := k.open(value)
+	if err != nil {
+		return "", err
+	}
+
+	sealedKey, err := seal(k.keys[k.primary], dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	return k.format(sealedKey, sealedValue), nil
+}
+
+// IsEncrypted returns whether the value was encrypted by a Keyring.
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// format puts together a value encrypted with the primary key.
+func (k *Keyring) format(sealedKey, sealedValue []byte) string {
+	return prefix + k.primary + ":" + encoding.EncodeToString(sealedKey) + ":" + encoding.EncodeToString(sealedValue)
+}
+
+// open parses the value and decrypts its data key.
+func (k *Keyring) open(value string) ([]byte, []byte, error) {
+	parts := strings.Split(strings.TrimPrefix(value, prefix), ":")
+	if len(parts) != 3 {
+		return nil, nil, ErrMalformed
+	}
+
+	aead, ok := k.keys[parts[0]]
+	if !ok {
+		return nil, nil, ErrUnknownKey
+	}
+
+	sealedKey, err := encoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, nil, ErrMalformed
+	}
+
+	sealedValue, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, nil, ErrMalformed
+	}
+
+	dataKey, err := unseal(aead, sealedKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return dataKey, sealedValue, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts the plaintext with a random nonce, which is prepended to
+// the result.
+func seal(aead cipher.AEAD, plain []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+
+	_, err := io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return nil, fmt.Errorf("failed generating nonce: %v", err)
+	}
+
+	return aead.Seal(nonce, nonce, plain, nil), nil
+}
+
+func unseal(aead cipher.AEAD, sealed []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrMalformed
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+
+	plain, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed decrypting value: %v", err)
+	}
+
+	return plain, nil
+}
+
+// keyID identifies a key without giving it away.
+func keyID(key []byte) string {
+	sum := sha256.Sum256(key)
+
+	return hex.EncodeToString(sum[:4])
+}
@@ -0,0 +1,155 @@
+package secret
+
+import (
+	"strings"
+	"testing"
+)
+
+func newTestKeyring(t *testing.T, keys ...string) *Keyring {
+	k, err := NewKeyring(keys...)
+	if err != nil {
+		t.Fatalf("NewKeyring() failed: %v", err)
+	}
+
+	return k
+}
+
+func generateKey(t *testing.T) string {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey() failed: %v", err)
+	}
+
+	return key
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	k := newTestKeyring(t, generateKey(t))
+
+	for _, value := range []string{"", "tag_tuner", "p@ss:word with spaces", strings.Repeat("x", 200)} {
+		enc, err := k.Encrypt(value)
+		if err != nil {
+			t.Fatalf("Encrypt(%q) failed: %v", value, err)
+		}
+
+		if value != "" && (enc == value || !IsEncrypted(enc) || strings.Contains(enc, value)) {
+			t.Errorf("Encrypt(%q) = %q, expected encrypted value", value, enc)
+		}
+
+		dec, err := k.Decrypt(enc)
+		if err != nil || dec != value {
+			t.Errorf("Decrypt(Encrypt(%q)) = %q, %v", value, dec, err)
+		}
+	}
+
+	a, _ := k.Encrypt("same")
+	b, _ := k.Encrypt("same")
+	if a == b {
+		t.Errorf("Encrypt() returned the same value twice")
+	}
+}
+
+func TestDecryptPlaintext(t *testing.T) {
+	k := newTestKeyring(t, generateKey(t))
+
+	dec, err := k.Decrypt("plain")
+	if err != nil || dec != "plain" {
+		t.Errorf("Decrypt() of plaintext = %q, %v", dec, err)
+	}
+
+	if k.Current("plain") {
+		t.Errorf("Current() of plaintext = true, expected false")
+	}
+}
+
+func TestDisabledKeyring(t *testing.T) {
+	k := newTestKeyring(t)
+
+	if k.Enabled() {
+		t.Errorf("Enabled() of empty keyring = true")
+	}
+
+	enc, err := k.Encrypt("plain")
+	if err != nil || enc != "plain" {
+		t.Errorf("Encrypt() = %q, %v, expected value as is", enc, err)
+	}
+
+	other := newTestKeyring(t, generateKey(t))
+	enc, _ = other.Encrypt("secret")
+
+	if _, err = k.Decrypt(enc); err != ErrUnknownKey {
+		t.Errorf("Decrypt() without keys = %v, expected %v", err, ErrUnknownKey)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	oldKey, newKey := generateKey(t), generateKey(t)
+
+	old := newTestKeyring(t, oldKey)
+	enc, _ := old.Encrypt("secret")
+
+	k := newTestKeyring(t, newKey, oldKey)
+
+	dec, err := k.Decrypt(enc)
+	if err != nil || dec != "secret" {
+		t.Fatalf("Decrypt() with old key = %q, %v", dec, err)
+	}
+
+	if k.Current(enc) {
+		t.Errorf("Current() of value encrypted with old key = true")
+	}
+
+	rotated, err := k.Rotate(enc)
+	if err != nil {
+		t.Fatalf("Rotate() failed: %v", err)
+	}
+
+	if !k.Current(rotated) {
+		t.Errorf("Current() of rotated value = false")
+	}
+
+	if enc[strings.LastIndex(enc, ":"):] != rotated[strings.LastIndex(rotated, ":"):] {
+		t.Errorf("Rotate() changed the encrypted value, expected only the data key to change")
+	}
+
+	if _, err = newTestKeyring(t, newKey).Decrypt(rotated); err != nil {
+		t.Errorf("Decrypt() of rotated value without old key failed: %v", err)
+	}
+
+	if _, err = newTestKeyring(t, newKey).Decrypt(enc); err != ErrUnknownKey {
+		t.Errorf("Decrypt() without old key = %v, expected %v", err, ErrUnknownKey)
+	}
+}
+
+func TestDecryptTampered(t *testing.T) {
+	k := newTestKeyring(t, generateKey(t))
+
+	enc, _ := k.Encrypt("secret")
+
+	tests := map[string]string{
+		"missing part": enc[:strings.LastIndex(enc, ":")],
+		"bad encoding": enc + "!",
+		"flipped byte": enc[:len(enc)-2] + flip(enc[len(enc)-2:]),
+	}
+	for name, value := range tests {
+		if _, err := k.Decrypt(value); err == nil {
+			t.Errorf("Decrypt() of %s succeeded", name)
+		}
+	}
+}
+
+func TestNewKeyringInvalid(t *testing.T) {
+	for _, key := range []string{"not base64!", "c2hvcnQ="} {
+		if _, err := NewKeyring(key); err == nil {
+			t.Errorf("NewKeyring(%q) succeeded", key)
+		}
+	}
+}
+
+func flip(s string) string {
+	if s[0] == 'A' {
+		return "B" + s[1:]
+	}
+
+	return "A" + s[1:]
+}
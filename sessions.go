@@ -0,0 +1,242 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/djavorszky/ddn-api/database/data"
+	"github.com/djavorszky/ddn-common/logger"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	sessionCookie          = "ddn-session"
+	csrfField              = "csrf_token"
+	csrfHeader             = "X-CSRF-Token"
+	defaultSessionLifetime = 30 * 24 * time.Hour
+
+	// maxUploadMemory is the amount of a multipart upload kept in memory,
+	// the rest is stored in temporary files.
+	maxUploadMemory = 32 << 24
+)
+
+var (
+	// store holds the short lived flash messages shown after redirects.
+	store *sessions.CookieStore
+
+	// sessionCodec signs and encrypts the session cookie.
+	sessionCodec *securecookie.SecureCookie
+)
+
+// initSessions sets up the cookie store and the session cookie codec using
+// keys derived from the session-secret configuration.
+func initSessions(secret string) error {
+	if secret == "" {
+		logger.Warn("No session-secret configured, generating a random one. Users will be logged out on restart.")
+
+		secret = string(securecookie.GenerateRandomKey(32))
+	}
+
+	keys := make([][]byte, 4)
+	for i, info := range []string{"flash-hash", "flash-block", "session-hash", "session-block"} {
+		keys[i] = make([]byte, 32)
+
+		_, err := io.ReadFull(hkdf.New(sha256.New, []byte(secret), nil, []byte(info)), keys[i])
+		if err != nil {
+			return fmt.Errorf("deriving %s key: %v", info, err)
+		}
+	}
+
+	store = sessions.NewCookieStore(keys[0], keys[1])
+	store.Options.HttpOnly = true
+
+	sessionCodec = securecookie.New(keys[2], keys[3])
+	sessionCodec.MaxAge(int(sessionLifetime().Seconds()))
+
+	return nil
+}
+
+// startSession creates a new server side session for user and sets the
+// cookie that refers to it.
+func startSession(w http.ResponseWriter, user string) error {
+	value, err := randomString(32)
+	if err != nil {
+		return fmt.Errorf("generating session id: %v", err)
+	}
+
+	csrf, err := randomString(32)
+	if err != nil {
+		return fmt.Errorf("generating csrf token: %v", err)
+	}
+
+	now := time.Now()
+	session := data.Session{
+		ID:         hashToken(value),
+		User:       user,
+		CSRFToken:  csrf,
+		CreateDate: now,
+		ExpiryDate: now.Add(sessionLifetime()),
+	}
+
+	err = db.InsertSession(&session)
+	if err != nil {
+		return fmt.Errorf("persisting session: %v", err)
+	}
+
+	encoded, err := sessionCodec.Encode(sessionCookie, value)
+	if err != nil {
+		return fmt.Errorf("encoding session cookie: %v", err)
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    encoded,
+		Path:     "/",
+		Expires:  session.ExpiryDate,
+		HttpOnly: true,
+		Secure:   config.SecureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return nil
+}
+
+// currentSession returns the session the request belongs to. The second
+// return value is false if there's no valid, unexpired session.
+func currentSession(r *http.Request) (data.Session, bool) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return data.Session{}, false
+	}
+
+	var value string
+
+	err = sessionCodec.Decode(sessionCookie, cookie.Value, &value)
+	if err != nil {
+		logger.Debug("invalid session cookie: %v", err)
+		return data.Session{}, false
+	}
+
+	session, err := db.FetchSession(hashToken(value))
+	if err != nil {
+		logger.Error("Fetching session failed: %v", err)
+		return data.Session{}, false
+	}
+
+	if session.ID == "" || session.Expired() {
+		return data.Session{}, false
+	}
+
+	return session, true
+}
+
+// endSession invalidates the session of the request on the server and
+// removes the cookie from the browser.
+func endSession(w http.ResponseWriter, r *http.Request) {
+	session, ok := currentSession(r)
+	if ok {
+		err := db.DeleteSession(session.ID)
+		if err != nil {
+			logger.Error("failed deleting session: %v", err)
+		}
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   config.SecureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// csrfProtected rejects form submissions that do not carry the CSRF token
+// of the session they were sent with.
+func csrfProtected(inner http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+			r.ParseMultipartForm(maxUploadMemory)
+		}
+
+		session, ok := currentSession(r)
+		if !ok || !validCSRF(session.CSRFToken, submittedCSRF(r)) {
+			logger.Warn("Rejected %s %s: missing or invalid CSRF token", r.Method, r.URL.Path)
+
+			if r.MultipartForm != nil {
+				r.MultipartForm.RemoveAll()
+			}
+
+			http.Error(w, "Invalid or missing CSRF token, please reload the page and try again.", http.StatusForbidden)
+			return
+		}
+
+		inner(w, r)
+	}
+}
+
+func submittedCSRF(r *http.Request) string {
+	if token := r.Header.Get(csrfHeader); token != "" {
+		return token
+	}
+
+	return r.PostFormValue(csrfField)
+}
+
+func validCSRF(expected, got string) bool {
+	if expected == "" || got == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(got)) == 1
+}
+
+// cleanSessions periodically removes expired sessions from the database.
+//
+// cleanSessions should always be ran in a goroutine.
+func cleanSessions() {
+	ticker := time.NewTicker(time.Hour)
+
+	for range ticker.C {
+		err := db.DeleteExpiredSessions(time.Now())
+		if err != nil {
+			logger.Error("failed removing expired sessions: %v", err)
+		}
+	}
+}
+
+func sessionLifetime() time.Duration {
+	if config.SessionLifetime == "" {
+		return defaultSessionLifetime
+	}
+
+	d, err := time.ParseDuration(config.SessionLifetime)
+	if err != nil || d <= 0 {
+		logger.Warn("Invalid session-lifetime %q, using default", config.SessionLifetime)
+
+		return defaultSessionLifetime
+	}
+
+	return d
+}
+
+func randomString(length int) (string, error) {
+	b := make([]byte, length)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
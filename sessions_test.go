@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func Test_validCSRF(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+		got      string
+		want     bool
+	}{
+		{"match", "abc", "abc", true},
+		{"mismatch", "abc", "abd", false},
+		{"missing", "abc", "", false},
+		{"no session token", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validCSRF(tt.expected, tt.got); got != tt.want {
+				t.Errorf("validCSRF() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_initSessions(t *testing.T) {
+	err := initSessions("some secret")
+	if err != nil {
+		t.Fatalf("initSessions() failed: %v", err)
+	}
+
+	encoded, err := sessionCodec.Encode(sessionCookie, "value")
+	if err != nil {
+		t.Fatalf("Encode() failed: %v", err)
+	}
+
+	err = initSessions("another secret")
+	if err != nil {
+		t.Fatalf("initSessions() failed: %v", err)
+	}
+
+	var value string
+	if sessionCodec.Decode(sessionCookie, encoded, &value) == nil {
+		t.Errorf("cookie signed with a different secret was accepted")
+	}
+
+	err = initSessions("some secret")
+	if err != nil {
+		t.Fatalf("initSessions() failed: %v", err)
+	}
+
+	err = sessionCodec.Decode(sessionCookie, encoded, &value)
+	if err != nil || value != "value" {
+		t.Errorf("Decode() = %q, %v, expected to read back the value", value, err)
+	}
+}
+
+func TestSessionCookie(t *testing.T) {
+	ts := newTestServer(t)
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	for _, secure := range []bool{false, true} {
+		config.SecureCookies = secure
+
+		resp, err := client.PostForm(ts.URL+"/login", url.Values{"email": {"user@example.com"}})
+		if err != nil {
+			t.Fatalf("POST /login: %v", err)
+		}
+		resp.Body.Close()
+
+		var cookie *http.Cookie
+		for _, c := range resp.Cookies() {
+			if c.Name == sessionCookie {
+				cookie = c
+			}
+		}
+
+		if cookie == nil {
+			t.Fatalf("login set no session cookie")
+		}
+
+		if !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode || cookie.Secure != secure {
+			t.Errorf("session cookie with secure-cookies = %t: HttpOnly = %t, SameSite = %v, Secure = %t", secure, cookie.HttpOnly, cookie.SameSite, cookie.Secure)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+//go:build sqlite
+// +build sqlite
+
+package main
+
+import (
+	"github.com/djavorszky/ddn-api/database"
+	"github.com/djavorszky/ddn-api/database/sqlite"
+)
+
+func init() {
+	newSQLite = func(path string) database.BackendConnection {
+		return &sqlite.DB{Path: path}
+	}
+}
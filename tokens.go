@@ -0,0 +1,269 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/djavorszky/ddn-api/auth"
+	"github.com/djavorszky/ddn-api/database/data"
+	"github.com/djavorszky/ddn-common/errs"
+	"github.com/djavorszky/ddn-common/inet"
+	"github.com/djavorszky/ddn-common/logger"
+	"github.com/gorilla/mux"
+)
+
+const (
+	tokenPrefix          = "ddn_"
+	defaultTokenLifetime = 90 * 24 * time.Hour
+)
+
+type tokenRequest struct {
+	Name       string `json:"name"`
+	ExpiryDays int    `json:"expiry_days"`
+}
+
+type issuedToken struct {
+	data.Token
+	Value string `json:"token"`
+}
+
+// apiCreateToken issues a new API token to the caller. As a token is needed
+// to call the API in the first place, the first token of a user can only be
+// created from a logged in browser session. Requests of the session have
+// to carry its CSRF token in the header, as any site could send them.
+func apiCreateToken(w http.ResponseWriter, r *http.Request) {
+	user, err := getAPIUser(r)
+	if err != nil {
+		session, ok := currentSession(r)
+		if ok && validCSRF(session.CSRFToken, r.Header.Get(csrfHeader)) {
+			user = session.User
+		}
+	}
+
+	if user == "" {
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return
+	}
+
+	var req tokenRequest
+
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		inet.SendFailure(w, http.StatusBadRequest, errs.JSONDecodeFailed, err.Error())
+
+		logger.Error("couldn't decode json request: %v", err)
+		return
+	}
+
+	if req.Name == "" {
+		inet.SendFailure(w, http.StatusBadRequest, errs.MissingParameters, "name")
+		return
+	}
+
+	lifetime := tokenLifetime()
+	if req.ExpiryDays > 0 {
+		lifetime = time.Duration(req.ExpiryDays) * 24 * time.Hour
+	}
+
+	value, hash, err := newToken()
+	if err != nil {
+		inet.SendFailure(w, http.StatusInternalServerError, errs.PersistFailed, err.Error())
+
+		logger.Error("failed generating token: %v", err)
+		return
+	}
+
+	now := time.Now()
+	token := data.Token{
+		Name:       req.Name,
+		Owner:      user,
+		Hash:       hash,
+		Prefix:     value[:len(tokenPrefix)+4],
+		CreateDate: now,
+		ExpiryDate: now.Add(lifetime),
+	}
+
+	err = db.InsertToken(&token)
+	if err != nil {
+		inet.SendFailure(w, http.StatusInternalServerError, errs.PersistFailed, err.Error())
+
+		logger.Error("failed inserting token: %v", err)
+		return
+	}
+
+	audit(r, data.AuditEntry{Actor: user, Action: auditTokenCreate, TargetName: token.Name, Details: token.Prefix})
+
+	logger.Info("Issued API token %q to %s", token.Name, user)
+
+	inet.SendSuccess(w, http.StatusOK, issuedToken{Token: token, Value: value})
+}
+
+func apiListTokens(w http.ResponseWriter, r *http.Request) {
+	user, err := getAPIUser(r)
+	if err != nil {
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return
+	}
+
+	tokens, err := db.FetchTokensByOwner(user)
+	if err != nil {
+		inet.SendFailure(w, http.StatusInternalServerError, errs.QueryFailed, err.Error())
+
+		logger.Error("Fetching tokens failed: %v", err)
+		return
+	}
+
+	if tokens == nil {
+		tokens = make([]data.Token, 0)
+	}
+
+	inet.SendSuccess(w, http.StatusOK, tokens)
+}
+
+func apiRevokeToken(w http.ResponseWriter, r *http.Request) {
+	user, err := getAPIUser(r)
+	if err != nil {
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		inet.SendFailure(w, http.StatusBadRequest, errs.InvalidURL)
+		return
+	}
+
+	tokens, err := db.FetchTokensByOwner(user)
+	if err != nil {
+		inet.SendFailure(w, http.StatusInternalServerError, errs.QueryFailed, err.Error())
+
+		logger.Error("Fetching tokens failed: %v", err)
+		return
+	}
+
+	for _, token := range tokens {
+		if token.ID != id {
+			continue
+		}
+
+		err = db.DeleteToken(token)
+		if err != nil {
+			inet.SendFailure(w, http.StatusInternalServerError, errs.DropFailed, err.Error())
+
+			logger.Error("failed deleting token: %v", err)
+			return
+		}
+
+		audit(r, data.AuditEntry{Actor: user, Action: auditTokenRevoke, TargetName: token.Name, Details: token.Prefix})
+
+		inet.SendSuccess(w, http.StatusOK, "Token revoked")
+		return
+	}
+
+	inet.SendFailure(w, http.StatusNotFound, errs.QueryNoResults)
+}
+
+// getAPIUser resolves the user that owns the bearer token of the request.
+// Requests without an API token are handed to the authenticator, which
+// may accept credentials of its identity provider instead.
+func getAPIUser(r *http.Request) (string, error) {
+	value, err := bearerToken(r)
+	if err != nil {
+		identity, authErr := authenticator.Verify(r)
+		if authErr == auth.ErrNoCredentials {
+			return "", err
+		}
+
+		if authErr != nil {
+			logger.Debug("%s credentials rejected: %v", authenticator.Name(), authErr)
+
+			return "", fmt.Errorf("unauthorized request")
+		}
+
+		return identity.Email, nil
+	}
+
+	token, err := db.FetchTokenByHash(hashToken(value))
+	if err != nil {
+		logger.Error("Fetching token failed: %v", err)
+
+		return "", fmt.Errorf("unauthorized request")
+	}
+
+	if token.ID == 0 {
+		return "", fmt.Errorf(errInvalidToken)
+	}
+
+	if token.Expired() {
+		return "", fmt.Errorf(errTokenExpired)
+	}
+
+	err = db.UpdateTokenLastUsed(token.ID, time.Now())
+	if err != nil {
+		logger.Warn("failed updating last use of token %d: %v", token.ID, err)
+	}
+
+	return token.Owner, nil
+}
+
+// bearerToken returns the token from an "Authorization: Bearer <token>" header.
+func bearerToken(r *http.Request) (string, error) {
+	auth := r.Header.Get("Authorization")
+	if auth == "" {
+		return "", fmt.Errorf("unauthorized request")
+	}
+
+	parts := strings.SplitN(auth, " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		return "", fmt.Errorf(errInvalidToken)
+	}
+
+	value := strings.TrimSpace(parts[1])
+	if !strings.HasPrefix(value, tokenPrefix) {
+		return "", fmt.Errorf(errInvalidToken)
+	}
+
+	return value, nil
+}
+
+// newToken generates a random token, returning both its plaintext value
+// and the hash that should be persisted.
+func newToken() (string, string, error) {
+	random, err := randomString(32)
+	if err != nil {
+		return "", "", fmt.Errorf("reading random bytes: %v", err)
+	}
+
+	value := tokenPrefix + random
+
+	return value, hashToken(value), nil
+}
+
+// hashToken returns the hex encoded SHA-256 hash of the token. The tokens
+// are random with 256 bits of entropy, so there's no need for a slow hash.
+func hashToken(value string) string {
+	sum := sha256.Sum256([]byte(value))
+
+	return hex.EncodeToString(sum[:])
+}
+
+func tokenLifetime() time.Duration {
+	if config.APITokenLifetime == "" {
+		return defaultTokenLifetime
+	}
+
+	d, err := time.ParseDuration(config.APITokenLifetime)
+	if err != nil || d <= 0 {
+		logger.Warn("Invalid api-token-lifetime %q, using default", config.APITokenLifetime)
+
+		return defaultTokenLifetime
+	}
+
+	return d
+}
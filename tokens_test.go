@@ -0,0 +1,141 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/djavorszky/ddn-api/auth"
+)
+
+func Test_newToken(t *testing.T) {
+	value, hash, err := newToken()
+	if err != nil {
+		t.Fatalf("newToken() failed: %v", err)
+	}
+
+	if !strings.HasPrefix(value, tokenPrefix) {
+		t.Errorf("newToken() = %q, missing prefix %q", value, tokenPrefix)
+	}
+
+	if hash != hashToken(value) {
+		t.Errorf("newToken() returned hash that doesn't match the value")
+	}
+
+	if hash == value || strings.Contains(hash, value) {
+		t.Errorf("newToken() hash contains the plaintext value")
+	}
+
+	other, _, _ := newToken()
+	if other == value {
+		t.Errorf("newToken() returned the same token twice")
+	}
+}
+
+func Test_bearerToken(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		want    string
+		wantErr bool
+	}{
+		{"valid", "Bearer ddn_abc", "ddn_abc", false},
+		{"lowercase scheme", "bearer ddn_abc", "ddn_abc", false},
+		{"missing", "", "", true},
+		{"email", "someone@example.com", "", true},
+		{"basic", "Basic ZGRuOmRkbg==", "", true},
+		{"wrong prefix", "Bearer abc", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := http.NewRequest(http.MethodGet, "/api/databases", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+
+			got, err := bearerToken(r)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("bearerToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("bearerToken() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+type stubAuthenticator struct {
+	auth.Email
+}
+
+func (stubAuthenticator) Verify(r *http.Request) (auth.Identity, error) {
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return auth.Identity{}, auth.ErrNoCredentials
+	}
+
+	if user != "jdoe" || pass != "secret" {
+		return auth.Identity{}, auth.ErrInvalidCredentials
+	}
+
+	return auth.Identity{Email: "john.doe@example.com"}, nil
+}
+
+func Test_getAPIUserAuthenticator(t *testing.T) {
+	defer func(orig auth.Authenticator) { authenticator = orig }(authenticator)
+	authenticator = stubAuthenticator{}
+
+	tests := []struct {
+		name    string
+		user    string
+		pass    string
+		want    string
+		wantErr bool
+	}{
+		{"valid", "jdoe", "secret", "john.doe@example.com", false},
+		{"invalid", "jdoe", "wrong", "", true},
+		{"none", "", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := http.NewRequest(http.MethodGet, "/api/databases", nil)
+			if tt.user != "" {
+				r.SetBasicAuth(tt.user, tt.pass)
+			}
+
+			got, err := getAPIUser(r)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("getAPIUser() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("getAPIUser() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateTokenCSRF(t *testing.T) {
+	ts := newTestServer(t)
+	user := ts.login("user@example.com")
+
+	// A cross-site form can send the session cookie, but not the header.
+	resp, err := user.client.Post(ts.URL+"/api/tokens", "text/plain", strings.NewReader(`{"name":"stolen"}`))
+	if err != nil {
+		t.Fatalf("POST /api/tokens: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("issuing a token without csrf token: got %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+
+	if tokens, _ := ts.db.FetchTokensByOwner("user@example.com"); len(tokens) != 0 {
+		t.Errorf("tokens issued without csrf token: %+v", tokens)
+	}
+
+	if token := user.issueToken("browser"); token == "" {
+		t.Errorf("issuing a token with csrf token returned no token")
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/djavorszky/ddn-api/database/data"
+	"github.com/djavorszky/ddn-common/errs"
+	"github.com/djavorszky/ddn-common/inet"
+	"github.com/djavorszky/ddn-common/logger"
+	"github.com/gorilla/mux"
+)
+
+// apiListUsers lists the users whose role was set explicitly, along with
+// the role that new users get by default.
+func apiListUsers(w http.ResponseWriter, r *http.Request) {
+	p, err := getAPIPrincipal(r)
+	if err != nil {
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return
+	}
+
+	if !p.can(actAdminister) {
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return
+	}
+
+	users, err := db.FetchUsers()
+	if err != nil {
+		inet.SendFailure(w, http.StatusInternalServerError, errs.QueryFailed, err.Error())
+
+		logger.Error("Fetching users failed: %v", err)
+		return
+	}
+
+	if users == nil {
+		users = make([]data.User, 0)
+	}
+
+	inet.SendSuccess(w, http.StatusOK, struct {
+		DefaultRole string      `json:"default_role"`
+		Users       []data.User `json:"users"`
+	}{defaultRole(), users})
+}
+
+// apiSetRole changes the role of a user. Admins of the configuration
+// can't be demoted through the API.
+func apiSetRole(w http.ResponseWriter, r *http.Request) {
+	p, err := getAPIPrincipal(r)
+	if err != nil {
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return
+	}
+
+	if !p.can(actAdminister) {
+		inet.SendFailure(w, http.StatusForbidden, errs.AccessDenied)
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	email, role := strings.TrimSpace(vars["email"]), vars["role"]
+	if email == "" {
+		inet.SendFailure(w, http.StatusBadRequest, errs.MissingParameters, "email")
+		return
+	}
+
+	if !validRole(role) {
+		inet.SendFailure(w, http.StatusBadRequest, errs.UnknownParameter, role)
+		return
+	}
+
+	if isConfiguredAdmin(email) && role != roleAdmin {
+		inet.SendFailure(w, http.StatusConflict, errRoleLocked, email)
+		return
+	}
+
+	user := data.User{
+		Email:      email,
+		Role:       role,
+		UpdateDate: time.Now(),
+		UpdatedBy:  p.Email,
+	}
+
+	err = db.SaveUser(user)
+	if err != nil {
+		inet.SendFailure(w, http.StatusInternalServerError, errs.UpdateFailed, err.Error())
+
+		logger.Error("failed saving user: %v", err)
+		return
+	}
+
+	audit(r, data.AuditEntry{Actor: p.Email, Action: auditRoleChange, TargetName: email, Details: role})
+
+	logger.Info("%s changed the role of %s to %s", p.Email, email, role)
+
+	inet.SendSuccess(w, http.StatusOK, user)
+}
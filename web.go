@@ -10,7 +10,6 @@ import (
 	"github.com/djavorszky/ddn-api/registry"
 	"github.com/djavorszky/ddn-common/brwsr"
 	"github.com/djavorszky/ddn-common/logger"
-	"github.com/djavorszky/ddn-common/model"
 	"github.com/djavorszky/liferay"
 	"github.com/gorilla/mux"
 )
@@ -18,14 +17,18 @@ import (
 // Page is a struct holding the data to be displayed on the welcome page.
 type Page struct {
 	UseCDN                 bool
-	Agents                 []model.Agent
+	Agents                 []registry.Agent
 	AnyOnline              bool
+	FormAgents             []registry.Agent
+	AgentLabels            []string
+	LabelFilter            string
 	Title                  string
 	Pages                  map[string]string
 	ActivePage             string
 	Message                string
 	MessageType            string
 	User                   string
+	Role                   string
 	HasUser                bool
 	HasEntry               bool
 	PrivateDatabases       []data.Row
@@ -43,6 +46,10 @@ type Page struct {
 	Commit                 string
 	GoogleAnalyticsEnabled bool
 	GoogleAnalyticsID      string
+	CSRFToken              string
+	LoginMode              string
+
+	principal principal
 }
 
 func loadPage(w http.ResponseWriter, r *http.Request, pages ...string) {
@@ -58,6 +65,7 @@ func loadPage(w http.ResponseWriter, r *http.Request, pages ...string) {
 		Commit:                 commit,
 		GoogleAnalyticsEnabled: config.GoogleAnalyticsID != "",
 		GoogleAnalyticsID:      config.GoogleAnalyticsID,
+		LoginMode:              loginMode(),
 	}
 
 	for _, agent := range registry.List() {
@@ -67,9 +75,11 @@ func loadPage(w http.ResponseWriter, r *http.Request, pages ...string) {
 		}
 	}
 
-	userCookie, err := r.Cookie("user")
-	if err != nil || userCookie.Value == "" {
-		// if there's an err, it can only happen if there is no cookie.
+	page.LabelFilter = r.URL.Query().Get("label")
+	page.FormAgents, page.AgentLabels = formAgents(page.Agents, parseSelector(r.URL.Query()["label"]))
+
+	userSession, ok := currentSession(r)
+	if !ok {
 		toLoad := []string{"base", "nav", "login"}
 		tmpl, err := buildTemplate(toLoad...)
 		if err != nil {
@@ -83,8 +93,22 @@ func loadPage(w http.ResponseWriter, r *http.Request, pages ...string) {
 		return
 	}
 
-	page.User = userCookie.Value
+	page.User = userSession.User
 	page.HasUser = true
+	page.CSRFToken = userSession.CSRFToken
+
+	p, ok := getPrincipal(r)
+	if !ok {
+		http.Error(w, "Failed resolving user role", http.StatusInternalServerError)
+		return
+	}
+	page.Role = p.Role
+	page.principal = p
+
+	if !p.can(actCreate) {
+		delete(page.Pages, "/createdb")
+		delete(page.Pages, "/importdb")
+	}
 
 	session, err := store.Get(r, "user-session")
 	if err != nil {
@@ -148,7 +172,7 @@ func loadPage(w http.ResponseWriter, r *http.Request, pages ...string) {
 	*/
 	session.Save(r, w)
 
-	if pages[0] == "browse" && page.HasMountedFolder {
+	if pages[0] == "browse" && page.HasMountedFolder && p.can(actCreate) {
 		loc := mux.Vars(r)["loc"]
 
 		files, err := brwsr.List(loc)
@@ -168,7 +192,7 @@ func loadPage(w http.ResponseWriter, r *http.Request, pages ...string) {
 	if pages[0] == "home" {
 		pages = append(pages, "databases")
 
-		privateDBs, err := db.FetchByCreator(page.User)
+		privateDBs, publicDBs, err := p.visibleDatabases()
 		if err != nil {
 			logger.Error("couldn't list databases: %v", err)
 		}
@@ -178,11 +202,6 @@ func loadPage(w http.ResponseWriter, r *http.Request, pages ...string) {
 			page.HasPrivateDBs = true
 		}
 
-		publicDBs, err := db.FetchPublic()
-		if err != nil {
-			logger.Error("couldn't list databases: %v", err)
-		}
-
 		if len(publicDBs) != 0 {
 			page.PublicDatabases = publicDBs
 			page.HasPublicDBs = true
@@ -203,6 +222,12 @@ func loadPage(w http.ResponseWriter, r *http.Request, pages ...string) {
 	}
 }
 
+// CanManage tells the templates whether the user of the page may change
+// the database.
+func (page Page) CanManage(row data.Row) bool {
+	return page.principal.canManage(row)
+}
+
 func buildTemplate(pages ...string) (*template.Template, error) {
 	var templates []string
 	for _, page := range pages {